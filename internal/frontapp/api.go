@@ -241,9 +241,13 @@ func (srv *HTTPService) ServeHTTP() {
 		// jobs
 
 		verified.POST("/jobs", srv.jobsHandler)
+		verified.POST("/jobs/validate", srv.jobValidateHandler)
+		verified.DELETE("/jobs", srv.jobsHandler)
 		verified.GET("/fetch-resources", srv.handleFetchResources) // we want to allow users as well
 		verified.GET("/fetch-volumes", srv.handleFetchVolumes)
 		verified.GET("/fetch-jobs", srv.jobsHandler)
+		verified.GET("/job-events", srv.jobEventsHandler)
+		verified.GET("/job-attach", srv.jobAttachHandler)
 		verified.GET("/fetch-apps", srv.appsHandler)
 
 		admin := verified.Group("/admin")
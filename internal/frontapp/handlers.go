@@ -1500,6 +1500,13 @@ func (srv *HTTPService) jobsHandler(c *gin.Context) {
 
 			return
 		}
+		// the primary group is accounted for the job
+		groupIDs := c.GetString("groupIDs")
+		job.GID, err = strconv.Atoi(strings.TrimSpace(strings.Split(groupIDs, ",")[0]))
+		if err != nil {
+			log.Printf("failed to atoi gid value: %v", err)
+			job.GID = 0
+		}
 		jobJSON, err := json.Marshal(job)
 		if err != nil {
 			log.Printf("failed to marshal job: %v", err)
@@ -1515,6 +1522,8 @@ func (srv *HTTPService) jobsHandler(c *gin.Context) {
 			return
 		}
 		jobReq.Header.Set("X-Service-Secret", string(srv.Config.ServiceSecretKey))
+		// the job runs as us, it may only touch what we may
+		jobReq.Header.Set("Access-Target", fmt.Sprintf("0::/ %v:%v", uid, groupIDs))
 
 		client := &http.Client{Timeout: 10 * time.Second}
 		response, err := client.Do(jobReq)
@@ -1543,6 +1552,12 @@ func (srv *HTTPService) jobsHandler(c *gin.Context) {
 				log.Printf("failed to close response body: %v", err)
 			}
 		}()
+		// rejected (e.g. limits reached), pass the reason along
+		if response.StatusCode != http.StatusOK {
+			c.Data(response.StatusCode, "application/json", body)
+
+			return
+		}
 		err = json.Unmarshal(body, &resp)
 		if err != nil {
 			log.Printf("failed to unmarshal response body: %v", err)
@@ -1552,11 +1567,220 @@ func (srv *HTTPService) jobsHandler(c *gin.Context) {
 		}
 
 		c.JSON(http.StatusOK, gin.H{"jid": resp.JID, "status": resp.Status, "output": job.Output})
+	// cancel one of our jobs
+	case http.MethodDelete:
+		jid := c.Query("jid")
+		if jid == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a jid"})
+
+			return
+		}
+		uid, _ := c.Get("userID")
+		groupIDs, _ := c.Get("groupIDs")
+
+		jobReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, apiServiceURL+"/api/v1/job?jid="+url.QueryEscape(jid), nil)
+		if err != nil {
+			log.Printf("failed to create request: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+
+			return
+		}
+		jobReq.Header.Set("X-Service-Secret", string(srv.Config.ServiceSecretKey))
+		jobReq.Header.Set("Access-Target", fmt.Sprintf("0::/ %v:%v", uid, groupIDs))
+
+		client := &http.Client{Timeout: 10 * time.Second}
+		response, err := client.Do(jobReq)
+		if err != nil {
+			log.Printf("failed to make request: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to cancel job"})
+
+			return
+		}
+		defer func() {
+			err := response.Body.Close()
+			if err != nil {
+				log.Printf("failed to close response body: %v", err)
+			}
+		}()
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			log.Printf("failed to read response body: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response body"})
+
+			return
+		}
+		c.Data(response.StatusCode, "application/json", body)
 	default:
 		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "method not supported"})
 	}
 }
 
+// jobValidateHandler dry-runs the job of the submission form, answering with the problems found, if any
+func (srv *HTTPService) jobValidateHandler(c *gin.Context) {
+	var job ut.Job
+	err := c.ShouldBind(&job)
+	if err != nil {
+		log.Printf("failed to bind json body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind"})
+
+		return
+	}
+	uid := c.GetString("userID")
+	job.UID, _ = strconv.Atoi(uid)
+	groupIDs := c.GetString("groupIDs")
+	job.GID, _ = strconv.Atoi(strings.TrimSpace(strings.Split(groupIDs, ",")[0]))
+
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("failed to marshal job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to marshal job"})
+
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiServiceURL+"/api/v1/job/validate",
+		bytes.NewBuffer(jobJSON))
+	if err != nil {
+		log.Printf("failed to create request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Service-Secret", string(srv.Config.ServiceSecretKey))
+	req.Header.Set("Access-Target", fmt.Sprintf("0::/ %v:%v", uid, groupIDs))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(req)
+	if err != nil {
+		log.Printf("failed to make request: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to validate the job"})
+
+		return
+	}
+	defer func() {
+		err := response.Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Printf("failed to read response body: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response body"})
+
+		return
+	}
+	c.Data(response.StatusCode, "application/json", body)
+}
+
+// jobEventsHandler fetches the timeline of a job, its status transitions in order
+func (srv *HTTPService) jobEventsHandler(c *gin.Context) {
+	jid := strings.TrimSpace(c.Query("jid"))
+	if jid == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a jid"})
+
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		apiServiceURL+"/api/v1/job/events?jid="+url.QueryEscape(jid), nil)
+	if err != nil {
+		log.Printf("failed to create request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+
+		return
+	}
+	req.Header.Set("X-Service-Secret", string(srv.Config.ServiceSecretKey))
+	req.Header.Set("Access-Target", "0::/ 0:0")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(req)
+	if err != nil {
+		log.Printf("failed to make request: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch the job events"})
+
+		return
+	}
+	defer func() {
+		err := response.Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+
+	var eventsResp struct {
+		Content []ut.JobEvent `json:"content"`
+		Status  string        `json:"status"`
+		Error   string        `json:"error"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&eventsResp)
+	if err != nil {
+		log.Printf("failed to unmarshal response: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to parse response"})
+
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		c.JSON(response.StatusCode, gin.H{"error": eventsResp.Error})
+
+		return
+	}
+
+	respondInFormat(c, c.Query("format"), eventsResp, "job_events_template.html")
+}
+
+// jobAttachHandler fetches the socket and token letting the caller feed the stdin of their interactive job
+func (srv *HTTPService) jobAttachHandler(c *gin.Context) {
+	jid := strings.TrimSpace(c.Query("jid"))
+	if jid == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a jid"})
+
+		return
+	}
+	uid, _ := c.Get("userID")
+	groupIDs, _ := c.Get("groupIDs")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		apiServiceURL+"/api/v1/job/attach?jid="+url.QueryEscape(jid), nil)
+	if err != nil {
+		log.Printf("failed to create request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+
+		return
+	}
+	req.Header.Set("X-Service-Secret", string(srv.Config.ServiceSecretKey))
+	req.Header.Set("Access-Target", fmt.Sprintf("0::/ %v:%v", uid, groupIDs))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(req)
+	if err != nil {
+		log.Printf("failed to make request: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to attach to the job"})
+
+		return
+	}
+	defer func() {
+		err := response.Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Printf("failed to read response body: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response body"})
+
+		return
+	}
+	c.Data(response.StatusCode, "application/json", body)
+}
+
 func (srv *HTTPService) jobAdminHandler(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*3)
 	defer cancel()
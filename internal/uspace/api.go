@@ -102,6 +102,14 @@ func NewUService(conf string) UService {
 	// storage shipment will panic if its not working
 	srv.storage = storage
 
+	// database (init)
+	// must be ready before the dispatcher starts, since it rebuilds its queue from it
+	jdbh := ut.NewDBHandler(cfg.UspaceJobsDB, cfg.UspaceJobsDBPath, cfg.UspaceJobsDBDriver)
+	srv.jdbh = jdbh
+	srv.jdbh.Init(initSQLJobs, cfg.UspaceJobsDBMaxOpenConns, cfg.UspaceJobsDBMaxIdleConns, cfg.UspaceJobsDBMaxLifetime)
+	// deliveries left pending by a previous run
+	go srv.resumeWebhookDeliveries()
+
 	// dispatcher system (constructing)
 	jdp, err := DispatcherShipment(strings.ToLower(cfg.UspaceDispatcher), &srv)
 	if err != nil {
@@ -114,11 +122,6 @@ func NewUService(conf string) UService {
 	srv.jdp = jdp
 	jdp.Start() // start "master" worker (the one that spawns other workers)
 
-	// database (init)
-	jdbh := ut.NewDBHandler(cfg.UspaceJobsDB, cfg.UspaceJobsDBPath, cfg.UspaceJobsDBDriver)
-	srv.jdbh = jdbh
-	srv.jdbh.Init(initSQLJobs, cfg.UspaceJobsDBMaxOpenConns, cfg.UspaceJobsDBMaxIdleConns, cfg.UspaceJobsDBMaxLifetime)
-
 	// fsl for storing and enforcing files securly
 	copyCfg := cfg.DeepCopy()
 	copyCfg.FslLocality = false
@@ -216,10 +219,41 @@ func (srv *UService) RegisterRoutes() {
 		// jobs can be run from anyone
 		// job related
 		apiV1.Match(
-			[]string{"GET", "POST"},
+			[]string{"GET", "POST", "DELETE"},
 			"/job",
 			srv.handleJob,
 		)
+		apiV1.GET("/job/logs", srv.handleJobLogs)
+		apiV1.GET("/job/events", srv.handleJobEvents)
+		apiV1.GET("/job/attach", srv.handleJobAttach)
+		apiV1.POST("/job/validate", srv.handleJobValidate)
+		apiV1.Match(
+			[]string{"GET", "POST", "DELETE"},
+			"/job/array",
+			srv.handleJobArray,
+		)
+		apiV1.GET("/job/array/summary", srv.handleJobArraySummary)
+		apiV1.Match(
+			[]string{"GET", "POST", "PUT", "DELETE"},
+			"/job/template",
+			srv.handleJobTemplate,
+		)
+		apiV1.POST("/job/template/submit", srv.handleJobTemplateSubmit)
+		apiV1.Match(
+			[]string{"GET", "POST", "DELETE"},
+			"/job/webhook",
+			srv.handleWebhook,
+		)
+		apiV1.Match(
+			[]string{"GET", "POST"},
+			"/workflow",
+			srv.handleWorkflow,
+		)
+		apiV1.Match(
+			[]string{"GET", "POST", "PATCH", "DELETE"},
+			"/schedule",
+			srv.handleSchedule,
+		)
 		apiV1.Match(
 			[]string{"GET", "POST"},
 			"/app",
@@ -254,10 +288,12 @@ func (srv *UService) RegisterRoutes() {
 			srv.handleVolumes,
 		)
 		admin.Match(
-			[]string{"DELETE", "PUT"},
+			[]string{"DELETE", "PUT", "PATCH"},
 			"/job",
 			srv.handleJobAdmin,
 		)
+		admin.GET("/job/usage", srv.handleJobUsage)
+		admin.GET("/job/webhook/deliveries", srv.handleWebhookDeliveries)
 		admin.Match(
 			[]string{"GET", "POST", "PATCH", "DELETE"},
 			"/user/volume",
@@ -269,6 +305,11 @@ func (srv *UService) RegisterRoutes() {
 			"/app",
 			srv.handleAppsAdmin,
 		)
+		admin.Match(
+			[]string{"GET", "POST", "DELETE"},
+			"/runtime",
+			srv.handleRuntimes,
+		)
 		// system, metrics, conf
 		{
 			admin.Match(
@@ -0,0 +1,231 @@
+package uspace
+
+/*
+	http api handlers for the uspace service
+	"job array" related endpoints, a job template expanded into many jobs
+*/
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleJobArray handles job array submission (POST), querying (GET) and cancellation (DELETE)
+//
+// @Summary     Get, submit or cancel job arrays
+// @Description GET retrieves a job array with the status of each of its jobs by aid, the arrays of a uid, or all of them.
+// @Description POST submits a job array: the job template is expanded into a job per input, per combination of
+// @Description parameter values or per index of a range. The output is templated per job with {index}, {input},
+// @Description {name} (the base name of the input) and {<param>}, e.g. "bucket/out/{index}.csv".
+// @Description Every job of the array is checked as a job submitted by the caller would be (see /job).
+// @Description DELETE cancels every unfinished job of an array, only its owner (or root) may cancel it.
+// @Tags        jobs
+// @Accept      json
+// @Produce     json
+//
+// @Param       aid      query     int          false  "Job array ID"
+// @Param       uid      query     int          false  "User ID whose job arrays to list"
+// @Param       Access-Target header string false "Access target of the caller (POST, DELETE), e.g. '0::/ 1000:1000'"
+// @Param       array    body      ut.JobArray  true   "Job array (POST)"
+//
+// @Success     200      {object}  map[string]interface{}
+// @Failure     400      {object}  map[string]string
+// @Failure     403      {object}  map[string]string
+// @Failure     405      {object}  map[string]string
+// @Failure     409      {object}  map[string]string
+// @Failure     429      {object}  map[string]string "Per user/group job limit reached"
+// @Failure     500      {object}  map[string]string
+//
+// @Router      /job/array [get]
+// @Router      /job/array [post]
+// @Router      /job/array [delete]
+func (srv *UService) handleJobArray(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet:
+		aid, _ := c.GetQuery("aid")
+		if aid != "" {
+			aidInt, err := strconv.ParseInt(strings.TrimSpace(aid), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi aid"})
+
+				return
+			}
+			array, err := srv.getJobArray(aidInt)
+			if err != nil {
+				log.Printf("failed to retrieve job array %d: %v", aidInt, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job array"})
+
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"content": array})
+
+			return
+		}
+
+		uidInt := -1
+		uid, _ := c.GetQuery("uid")
+		if uid != "" {
+			var err error
+			uidInt, err = strconv.Atoi(strings.TrimSpace(uid))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi uid"})
+
+				return
+			}
+		}
+		arrays, err := srv.getJobArrays(uidInt)
+		if err != nil {
+			log.Printf("failed to retrieve job arrays: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job arrays"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"content": arrays})
+
+	case http.MethodPost:
+		ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+		if err != nil {
+			log.Printf("failed to bind access-target: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+			return
+		}
+		var array ut.JobArray
+		err = c.BindJSON(&array)
+		if err != nil {
+			log.Printf("failed to bind job array: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind job array"})
+
+			return
+		}
+
+		err = bindJobOwner(ac, &array.UID, &array.GID)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		err = array.Validate(int(srv.config.UspaceJobArrayMaxSize))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+		// the children are checked upon expansion
+		children, err := array.Expand()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+		for i := range children {
+			if !srv.admitJobOrReject(c, &children[i], ac) {
+				return
+			}
+		}
+
+		aid, err := srv.submitJobArray(array, children)
+		if err != nil {
+			log.Printf("failed to submit the job array: %v", err)
+			if errors.Is(err, errJobLimit) {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "aid": aid})
+
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit the job array"})
+
+			return
+		}
+		jids := make([]int64, 0, len(children))
+		for _, child := range children {
+			jids = append(jids, child.JID)
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "job array submitted", "aid": aid, "jids": jids})
+
+	case http.MethodDelete:
+		ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+		if err != nil {
+			log.Printf("failed to bind access-target: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+			return
+		}
+		aid, err := strconv.ParseInt(strings.TrimSpace(c.Query("aid")), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid aid"})
+
+			return
+		}
+		array, err := srv.getJobArray(aid)
+		if err != nil {
+			log.Printf("failed to retrieve job array %d: %v", aid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job array"})
+
+			return
+		}
+		// root may cancel anything
+		if ac.UID != "0" && ac.UID != strconv.Itoa(array.UID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the owner of the job array can cancel it"})
+
+			return
+		}
+		if array.Status != "running" {
+			c.JSON(http.StatusConflict, gin.H{"error": "job array is already " + array.Status})
+
+			return
+		}
+
+		canceled, err := srv.cancelJobArray(array)
+		if err != nil {
+			log.Printf("failed to cancel job array %d: %v", aid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel some jobs of the array", "canceled": canceled})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "job array canceled", "aid": aid, "canceled": canceled})
+
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{
+			"error": "method not allowed",
+		})
+	}
+}
+
+// handleJobArraySummary serves the aggregate state of a job array
+//
+// @Summary     Get the summary of a job array
+// @Description Returns the status of a job array along with the amount of its jobs per status.
+// @Tags        jobs
+// @Produce     json
+//
+// @Param       aid     query     int   true   "Job array ID"
+//
+// @Success     200     {object}  map[string]interface{} "content: the summary"
+// @Failure     400     {object}  map[string]string
+// @Failure     500     {object}  map[string]string
+//
+// @Router      /job/array/summary [get]
+func (srv *UService) handleJobArraySummary(c *gin.Context) {
+	aid, err := strconv.ParseInt(strings.TrimSpace(c.Query("aid")), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid aid"})
+
+		return
+	}
+	summary, err := srv.getJobArraySummary(aid)
+	if err != nil {
+		log.Printf("failed to retrieve the summary of job array %d: %v", aid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job array summary"})
+
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"content": summary})
+}
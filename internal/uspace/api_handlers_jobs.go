@@ -10,12 +10,18 @@ package uspace
 */
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	ut "kyri56xcaesar/kuspace/internal/utils"
 
@@ -23,10 +29,15 @@ import (
 )
 
 // Api call Handlers
-// HandleJob handles job creation (POST) and job querying (GET)
+// HandleJob handles job creation (POST), job querying (GET) and job cancellation (DELETE)
 //
-// @Summary     Get or submit jobs
-// @Description GET retrieves jobs by uid(s), jid, or returns all. POST submits one or multiple jobs.
+// @Summary     Get, submit or cancel jobs
+// @Description GET retrieves jobs by uid(s), jid, or returns all. A single job comes with the outcome of each of its attempts.
+// @Description POST submits one or multiple jobs, a job may carry a retry policy for failed executions.
+// @Description A job is rejected unless the caller may read each of its inputs and write its output, it runs as the caller (runAs).
+// @Description A job is owned by the caller, its uid and gid (the primary group of the caller) are taken from the Access-Target header.
+// @Description A job identical to a completed one reuses its output and is marked "cached" (J_CACHE), unless it sets noCache.
+// @Description DELETE cancels a queued or running job, only its owner (or root) may cancel it.
 // @Tags        jobs
 // @Accept      json
 // @Produce     json
@@ -35,17 +46,23 @@ import (
 // @Param       offset  query     string  false  "Offset for pagination"
 // @Param       uids    query     string  false  "Comma-separated list of user IDs"
 // @Param       jids    query     string  false  "Job ID or '*' for all jobs"
+// @Param       jid     query     int     false  "Job ID to cancel (DELETE)"
+// @Param       Access-Target header string false "Access target of the caller (POST, DELETE), e.g. '0::/ 1000:1000'"
 //
 // @Param       job     body      ut.Job     true  "Single job"      default({"uid":1,"input":"...","meta":"..."})
 // @Param       jobs    body      []ut.Job   true  "Multiple jobs"   default([{"uid":1},{"uid":2}])
 //
 // @Success     200     {object}  map[string]interface{}
 // @Failure     400     {object}  map[string]string
+// @Failure     403     {object}  map[string]string
 // @Failure     405     {object}  map[string]string
+// @Failure     409     {object}  map[string]string
+// @Failure     429     {object}  map[string]string "Per user/group job limit reached"
 // @Failure     500     {object}  map[string]string
 //
 // @Router      /job [get]
 // @Router      /job [post]
+// @Router      /job [delete]
 func (srv *UService) handleJob(c *gin.Context) {
 	var (
 		job  ut.Job
@@ -128,9 +145,24 @@ func (srv *UService) handleJob(c *gin.Context) {
 
 			return
 		}
+		job.AttemptHistory, err = srv.getJobAttempts(job.JID)
+		if err != nil {
+			log.Printf("failed to retrieve the attempts of job %d: %v", job.JID, err)
+		}
+		job.Artifacts, err = srv.getJobArtifacts(job.JID)
+		if err != nil {
+			log.Printf("failed to retrieve the artifacts of job %d: %v", job.JID, err)
+		}
 		c.JSON(http.StatusOK, gin.H{"content": job})
 
 	case http.MethodPost:
+		ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+		if err != nil {
+			log.Printf("failed to bind access-target: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+			return
+		}
 		body, err := io.ReadAll(c.Request.Body)
 		if err != nil {
 			log.Printf("failed to read request body: %v", err)
@@ -147,6 +179,11 @@ func (srv *UService) handleJob(c *gin.Context) {
 				return
 			}
 			// check for job validity.s
+			for i := range jobs {
+				if !srv.admitJobOrReject(c, &jobs[i], ac) {
+					return
+				}
+			}
 			// save jobs (insert in DB)
 			// also acquire jids
 			err := srv.insertJobs(jobs)
@@ -161,6 +198,12 @@ func (srv *UService) handleJob(c *gin.Context) {
 			err = srv.jdp.PublishJobs(jobs)
 			if err != nil {
 				log.Printf("failed to publish the jobs: %v", err)
+				srv.rejectPendingJobs(jobs)
+				if errors.Is(err, errJobLimit) {
+					c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+
+					return
+				}
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to publish jobs"})
 
 				return
@@ -176,6 +219,9 @@ func (srv *UService) handleJob(c *gin.Context) {
 		// log.Printf("job: %v", job)
 		// handle single job
 		// check for job validity.
+		if !srv.admitJobOrReject(c, &job, ac) {
+			return
+		}
 
 		// save job (insert in DB)
 		jid, err := srv.insertJob(job)
@@ -191,6 +237,12 @@ func (srv *UService) handleJob(c *gin.Context) {
 		err = srv.jdp.PublishJob(job)
 		if err != nil {
 			log.Printf("failed to publish the job: %v", err)
+			srv.rejectPendingJobs([]ut.Job{job})
+			if errors.Is(err, errJobLimit) {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to publish job"})
 
 			return
@@ -202,6 +254,49 @@ func (srv *UService) handleJob(c *gin.Context) {
 			"jid":    jid,
 		})
 
+	// cancel a queued or running job
+	case http.MethodDelete:
+		ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+		if err != nil {
+			log.Printf("failed to bind access-target: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+			return
+		}
+		jid, err := strconv.Atoi(strings.TrimSpace(c.Query("jid")))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid jid"})
+
+			return
+		}
+		job, err = srv.getJobByID(jid)
+		if err != nil {
+			log.Printf("failed to retrieve the job: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job"})
+
+			return
+		}
+		// root may cancel anything
+		if ac.UID != "0" && ac.UID != strconv.Itoa(job.UID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the owner of the job can cancel it"})
+
+			return
+		}
+
+		err = srv.jdp.RemoveJob(jid)
+		if err != nil {
+			log.Printf("failed to cancel job %d: %v", jid, err)
+			if errors.Is(err, errJobNotActive) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel the job"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "job canceled", "jid": jid})
+
 	default:
 		c.JSON(http.StatusMethodNotAllowed, gin.H{
 			"error": "method not allowed",
@@ -209,6 +304,298 @@ func (srv *UService) handleJob(c *gin.Context) {
 	}
 }
 
+// admitJobOrReject admits a job on behalf of the caller (see admitJob),
+// responds with the reason it was rejected otherwise
+func (srv *UService) admitJobOrReject(c *gin.Context, job *ut.Job, ac ut.AccessClaim) bool {
+	err := srv.admitJob(job, ac)
+	if err == nil {
+		return true
+	}
+	log.Printf("rejected job of user %d: %v", job.UID, err)
+	if errors.Is(err, errJobAccess) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+	return false
+}
+
+// handleJobValidate dry-runs a job
+//
+// @Summary     Validate a job without running it
+// @Description Puts a job through every check its executor would run, without scheduling anything:
+// @Description the form limits, the logic, the application behind it, the inputs, the output volume and
+// @Description the resource quantities. Returns every problem found, each with the field at fault.
+// @Tags        jobs
+// @Accept      json
+// @Produce     json
+//
+// @Param       job     body      ut.Job  true  "Job to validate"
+//
+// @Success     200     {object}  map[string]interface{} "valid: bool, problems: []ut.JobProblem"
+// @Failure     400     {object}  map[string]string
+//
+// @Router      /job/validate [post]
+func (srv *UService) handleJobValidate(c *gin.Context) {
+	var job ut.Job
+	err := c.ShouldBindJSON(&job)
+	if err != nil {
+		log.Printf("failed to bind job: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind job"})
+
+		return
+	}
+
+	problems := srv.validateJob(job)
+	if problems == nil {
+		problems = []ut.JobProblem{}
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": len(problems) == 0, "problems": problems})
+}
+
+// how often a followed job log is checked for new entries
+const logFollowInterval = time.Second
+
+// how long a followed job log is waited for to be stored, once the job is over
+const logStoreWait = 10 * time.Second
+
+// handleJobLogs serves the persisted output of a job
+//
+// @Summary     Get the logs of a job
+// @Description Returns the persisted stdout/stderr of a job (and the executor's messages about it), with timestamps.
+// @Description The log of a finished job is read from where it was stored (see the log of the job).
+// @Description offset skips the given amount of entries, tail keeps only the last ones.
+// @Description With follow=true the entries are streamed as newline delimited JSON until the job finishes.
+// @Tags        jobs
+// @Produce     json
+// @Produce     application/x-ndjson
+//
+// @Param       jid     query     int   true   "Job ID"
+// @Param       tail    query     int   false  "Amount of most recent entries to return"
+// @Param       offset  query     int   false  "Amount of entries to skip"
+// @Param       follow  query     bool  false  "Keep streaming new entries until the job finishes"
+//
+// @Success     200     {object}  map[string]interface{} "content: the entries, offset: the offset of the next entry"
+// @Failure     400     {object}  map[string]string
+// @Failure     404     {object}  map[string]string
+// @Failure     500     {object}  map[string]string
+//
+// @Router      /job/logs [get]
+func (srv *UService) handleJobLogs(c *gin.Context) {
+	jid, err := strconv.Atoi(strings.TrimSpace(c.Query("jid")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid jid"})
+
+		return
+	}
+	var tail, offset int
+	for name, target := range map[string]*int{"tail": &tail, "offset": &offset} {
+		value := strings.TrimSpace(c.Query(name))
+		if value == "" {
+			continue
+		}
+		*target, err = strconv.Atoi(value)
+		if err != nil || *target < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+
+			return
+		}
+	}
+	follow, _ := strconv.ParseBool(c.Query("follow"))
+
+	job, err := srv.getJobByID(jid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+
+			return
+		}
+		log.Printf("failed to retrieve the job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job"})
+
+		return
+	}
+
+	if !follow {
+		entries, next, err := srv.jobLog(job, offset, tail)
+		if err != nil {
+			log.Printf("failed to read the logs of job %d: %v", jid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read the job logs"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"content": entries, "offset": next})
+
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	var finishedAt time.Time
+	for {
+		entries, next, err := srv.jobLog(job, offset, tail)
+		if err != nil {
+			log.Printf("failed to read the logs of job %d: %v", jid, err)
+
+			return
+		}
+		for _, entry := range entries {
+			err = encoder.Encode(entry)
+			if err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+		offset, tail = next, 0
+
+		// the rest of the log of a finished job is read once stored, so that nothing is missed
+		if !jobActive(job.Status) {
+			if finishedAt.IsZero() {
+				finishedAt = time.Now()
+			}
+			if job.Log != "" || time.Since(finishedAt) > logStoreWait {
+				return
+			}
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-time.After(logFollowInterval):
+		}
+		job, err = srv.getJobByID(jid)
+		if err != nil {
+			log.Printf("failed to retrieve the job: %v", err)
+
+			return
+		}
+	}
+}
+
+// handleJobEvents serves the state transitions of a job
+//
+// @Summary     Get the timeline of a job
+// @Description Returns every status transition of a job in order, from its submission on:
+// @Description the previous and new status, the actor behind it (user, scheduler or executor), why and when.
+// @Tags        jobs
+// @Produce     json
+//
+// @Param       jid     query     int   true   "Job ID"
+//
+// @Success     200     {object}  map[string]interface{} "content: []ut.JobEvent"
+// @Failure     400     {object}  map[string]string
+// @Failure     404     {object}  map[string]string
+// @Failure     500     {object}  map[string]string
+//
+// @Router      /job/events [get]
+func (srv *UService) handleJobEvents(c *gin.Context) {
+	jid, err := strconv.Atoi(strings.TrimSpace(c.Query("jid")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid jid"})
+
+		return
+	}
+
+	job, err := srv.getJobByID(jid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+
+			return
+		}
+		log.Printf("failed to retrieve the job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job"})
+
+		return
+	}
+
+	events, err := srv.getJobEvents(job.JID)
+	if err != nil {
+		log.Printf("failed to retrieve the events of job %d: %v", jid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job events"})
+
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"content": events, "status": job.Status})
+}
+
+// handleJobAttach hands the owner of an interactive job what it takes to feed its stdin
+//
+// @Summary     Attach to the stdin of an interactive job
+// @Description Returns the socket of an interactive job, along with a token valid for a few minutes:
+// @Description connecting to it as a producer with the token, every message sent is written to the stdin
+// @Description of the job (a line each) and its output is streamed back over the same connection.
+// @Description Only the owner of the job may attach.
+// @Tags        jobs
+// @Produce     json
+//
+// @Param       jid            query     int     true   "Job ID"
+// @Param       Access-Target  header    string  true   "uid:gids of the caller"
+//
+// @Success     200     {object}  map[string]interface{} "content: url, token and expiry"
+// @Failure     400     {object}  map[string]string
+// @Failure     403     {object}  map[string]string
+// @Failure     404     {object}  map[string]string
+// @Failure     409     {object}  map[string]string "Job is not interactive, or already over"
+// @Failure     500     {object}  map[string]string
+//
+// @Router      /job/attach [get]
+func (srv *UService) handleJobAttach(c *gin.Context) {
+	ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+	if err != nil {
+		log.Printf("failed to bind access-target: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+		return
+	}
+	jid, err := strconv.Atoi(strings.TrimSpace(c.Query("jid")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid jid"})
+
+		return
+	}
+
+	job, err := srv.getJobByID(jid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+
+			return
+		}
+		log.Printf("failed to retrieve the job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the job"})
+
+		return
+	}
+	// not even root, the input is the owner's
+	if ac.UID != strconv.Itoa(job.UID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the owner of the job may attach to it"})
+
+		return
+	}
+	if !job.Interactive {
+		c.JSON(http.StatusConflict, gin.H{"error": "job is not interactive"})
+
+		return
+	}
+	if !slices.Contains(liveJobStatuses, job.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "job is already " + job.Status})
+
+		return
+	}
+
+	expires := time.Now().Add(jobAttachTokenTTL)
+	token := ut.SignJobAttach(srv.config.ServiceSecretKey, job.JID, job.UID, expires)
+	c.JSON(http.StatusOK, gin.H{"content": gin.H{
+		"url": fmt.Sprintf("ws://%s/get-session?jid=%d&role=producer&token=%s",
+			srv.config.WssAddress, job.JID, url.QueryEscape(token)),
+		"token":   token,
+		"expires": expires.Format(time.RFC3339),
+	}})
+}
+
 // handleJobAdmin handles administrative operations on jobs.
 //
 // @Summary Admin job endpoint
@@ -221,7 +608,8 @@ func (srv *UService) handleJob(c *gin.Context) {
 // @Param uid query int false "Filter jobs by single user ID"
 // @Param uids query string false "Comma-separated list of user IDs to filter jobs"
 // @Param jids query string false "Comma-separated list of job IDs to retrieve or delete"
-// @Param jid query int false "Single job ID to retrieve or delete"
+// @Param jid query int false "Single job ID to retrieve, delete or reprioritize"
+// @Param priority query int false "New priority of a queued job (PATCH)"
 // @Param limit query string false "Pagination limit for job list"
 // @Param offset query string false "Pagination offset for job list"
 //
@@ -230,12 +618,14 @@ func (srv *UService) handleJob(c *gin.Context) {
 //
 // @Success 200 {object} map[string]interface{} "Success with job(s) content or status message"
 // @Failure 400 {object} map[string]string "Bad request (e.g., parse error)"
+// @Failure 409 {object} map[string]string "Job is not waiting in the queue (PATCH)"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Failure 405 {object} map[string]string "Method not allowed"
 //
 // @Router /admin/job [get]
 // @Router /admin/job [post]
 // @Router /admin/job [put]
+// @Router /admin/job [patch]
 // @Router /admin/job [delete]
 func (srv *UService) handleJobAdmin(c *gin.Context) {
 	var (
@@ -371,6 +761,12 @@ func (srv *UService) handleJobAdmin(c *gin.Context) {
 		err = srv.jdp.PublishJob(job)
 		if err != nil {
 			log.Printf("failed to publish the job: %v", err)
+			srv.rejectPendingJobs([]ut.Job{job})
+			if errors.Is(err, errJobLimit) {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to publish job"})
 
 			return
@@ -396,14 +792,50 @@ func (srv *UService) handleJobAdmin(c *gin.Context) {
 			return
 		}
 
+		// a status change has to be a legal transition, nothing is updated otherwise
 		err = srv.updateJob(job)
 		if err != nil {
+			log.Printf("failed to update job %d: %v", job.JID, err)
+			if errors.Is(err, errIllegalTransition) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update the job"})
 
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"status": "update success"})
 
+	// change the priority of a job still waiting in the queue
+	case http.MethodPatch:
+		jid, err := strconv.ParseInt(strings.TrimSpace(c.Query("jid")), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid jid"})
+
+			return
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(c.Query("priority")))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid priority"})
+
+			return
+		}
+
+		err = srv.jdp.SetJobPriority(jid, priority)
+		if err != nil {
+			log.Printf("failed to set job %d priority: %v", jid, err)
+			if errors.Is(err, errJobNotInQueue) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set job priority"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "priority updated", "jid": jid, "priority": priority})
+
 	case http.MethodDelete:
 		jids, _ := c.GetQuery("jids")
 		if jids != "" {
@@ -413,12 +845,18 @@ func (srv *UService) handleJobAdmin(c *gin.Context) {
 
 				return
 			}
+			for _, jid := range jidsInt {
+				srv.cancelActiveJob(jid)
+			}
 			err = srv.removeJobs(jidsInt)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete the jobs"})
 
 				return
 			}
+			for _, jid := range jidsInt {
+				removeJobLog(int64(jid))
+			}
 			c.JSON(http.StatusOK, gin.H{"status": "job(s) deleted successfully"})
 
 			return
@@ -432,12 +870,14 @@ func (srv *UService) handleJobAdmin(c *gin.Context) {
 
 				return
 			}
+			srv.cancelActiveJob(jidInt)
 			err = srv.removeJob(jidInt)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete the job"})
 
 				return
 			}
+			removeJobLog(int64(jidInt))
 			c.JSON(http.StatusOK, gin.H{"status": "job deleted successfully"})
 
 			return
@@ -450,3 +890,78 @@ func (srv *UService) handleJobAdmin(c *gin.Context) {
 		})
 	}
 }
+
+// default window of a usage report, ending now
+const usageReportWindow = 30 * 24 * time.Hour
+
+// handleJobUsage serves the resources consumed by jobs, aggregated per user, group or app
+//
+// @Summary     Job usage report
+// @Description Aggregates the cpu seconds, peak memory, bytes read/written and duration of the jobs created within [from, to),
+// @Description per user (uid), group (gid) or app (the logic of the job), heaviest cpu consumers first.
+// @Description from and to are RFC3339 timestamps or dates (2006-01-02), the last 30 days by default.
+// @Tags        admin, jobs
+// @Produce     json
+//
+// @Param       by    query     string  false  "user, group or app (default user)"
+// @Param       from  query     string  false  "Start of the window, inclusive"
+// @Param       to    query     string  false  "End of the window, exclusive"
+//
+// @Success     200   {object}  map[string]interface{} "content: []ut.UsageReport"
+// @Failure     400   {object}  map[string]string
+// @Failure     500   {object}  map[string]string
+//
+// @Router      /admin/job/usage [get]
+func (srv *UService) handleJobUsage(c *gin.Context) {
+	by := strings.ToLower(strings.TrimSpace(c.DefaultQuery("by", "user")))
+	if _, ok := usageKeys[by]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "by must be one of user, group or app"})
+
+		return
+	}
+
+	to, from := time.Now(), time.Now().Add(-usageReportWindow)
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := strings.TrimSpace(c.Query(name))
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, value)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ", expected RFC3339 or 2006-01-02"})
+
+			return
+		}
+		*target = t
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+
+		return
+	}
+
+	reports, err := srv.getUsageReport(by, from, to)
+	if err != nil {
+		log.Printf("failed to aggregate job usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to aggregate the job usage"})
+
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"content": reports,
+		"by":      by,
+		"from":    from.UTC().Format(time.RFC3339),
+		"to":      to.UTC().Format(time.RFC3339),
+	})
+}
+
+// cancelActiveJob stops a job which is about to be deleted, if it is still queued or running
+func (srv *UService) cancelActiveJob(jid int) {
+	err := srv.jdp.RemoveJob(jid)
+	if err != nil && !errors.Is(err, errJobNotActive) {
+		log.Printf("failed to cancel job %d before deletion: %v", jid, err)
+	}
+}
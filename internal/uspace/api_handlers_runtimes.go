@@ -0,0 +1,125 @@
+package uspace
+
+/*
+	http api handlers for the uspace service
+	"runtime" related endpoints, the registry of the languages the code of a job may be written in
+*/
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleRuntimes handles the language runtime registry
+//
+// @Summary     Get, add or remove language runtimes
+// @Description GET lists the runtimes the code of a job may be written in, the built-in ones included.
+// @Description POST adds a runtime, replacing the one of the same name if any (a built-in one included).
+// @Description Its skeleton and command are text/templates, see ut.Runtime, checked before it is added.
+// @Description DELETE removes an added runtime, a built-in one it replaced is back in use.
+// @Tags        jobs, runtimes
+// @Accept      json
+// @Produce     json
+//
+// @Param       name          query     string      false  "Runtime name (DELETE)"
+// @Param       runtime       body      ut.Runtime  true   "Runtime (POST)"
+//
+// @Success     200           {object}  map[string]interface{}
+// @Failure     400           {object}  map[string]string
+// @Failure     404           {object}  map[string]string
+// @Failure     405           {object}  map[string]string
+// @Failure     409           {object}  map[string]string
+// @Failure     500           {object}  map[string]string
+//
+// @Router      /admin/runtime [get]
+// @Router      /admin/runtime [post]
+// @Router      /admin/runtime [delete]
+func (srv *UService) handleRuntimes(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet:
+		runtimes, err := srv.getRuntimes()
+		if err != nil {
+			log.Printf("failed to retrieve runtimes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the runtimes"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"content": runtimes})
+
+	case http.MethodPost:
+		var runtime ut.Runtime
+		err := c.BindJSON(&runtime)
+		if err != nil {
+			log.Printf("failed to bind runtime: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind runtime"})
+
+			return
+		}
+		err = runtime.Validate()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		// the names of a runtime may not be taken by another one
+		runtimes, err := srv.getRuntimes()
+		if err != nil {
+			log.Printf("failed to retrieve runtimes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the runtimes"})
+
+			return
+		}
+		for _, r := range runtimes {
+			if r.Name == runtime.Name {
+				continue
+			}
+			for _, name := range runtime.Names() {
+				if slices.Contains(r.Names(), name) {
+					c.JSON(http.StatusConflict, gin.H{"error": "name " + name + " is taken by runtime " + r.Name})
+
+					return
+				}
+			}
+		}
+
+		err = srv.upsertRuntime(runtime)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save the runtime"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "runtime added", "name": runtime.Name})
+
+	case http.MethodDelete:
+		name := strings.ToLower(strings.TrimSpace(c.Query("name")))
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a runtime name"})
+
+			return
+		}
+		err := srv.deleteRuntime(name)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "no added runtime of that name, built-in ones cannot be removed"})
+
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove the runtime"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "runtime removed", "name": name})
+
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "method not allowed"})
+	}
+}
@@ -0,0 +1,232 @@
+package uspace
+
+/*
+	http api handlers for the uspace service
+	"schedule" related endpoints, recurring jobs
+*/
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// default amount of runs returned along with a schedule
+const defaultScheduleRuns = 50
+
+// handleSchedule handles recurring jobs
+//
+// @Summary     Get, create, pause or delete schedules
+// @Description GET retrieves a schedule with its run history by sid, the schedules of a uid, or all of them.
+// @Description POST creates a schedule: the job template is submitted every time the cron expression (UTC) matches.
+// @Description The template is checked as a job submitted by the caller would be (see /job), and once more on every run.
+// @Description PATCH pauses (paused=true) or resumes (paused=false) a schedule, DELETE removes it.
+// @Description PATCH and DELETE are only allowed to the owner of the schedule (or root), as given by the Access-Target header.
+// @Tags        schedules
+// @Accept      json
+// @Produce     json
+//
+// @Param       sid           query     int          false  "Schedule ID"
+// @Param       uid           query     int          false  "User ID whose schedules to list"
+// @Param       runs          query     int          false  "Amount of most recent runs to return (GET by sid)"
+// @Param       paused        query     bool         false  "Pause or resume (PATCH)"
+// @Param       Access-Target header    string       false  "vid:vname:target uid:gids (POST, PATCH, DELETE)"
+// @Param       schedule      body      ut.Schedule  true   "Schedule (POST)"
+//
+// @Success     200           {object}  map[string]interface{}
+// @Failure     400           {object}  map[string]string
+// @Failure     403           {object}  map[string]string
+// @Failure     405           {object}  map[string]string
+// @Failure     500           {object}  map[string]string
+//
+// @Router      /schedule [get]
+// @Router      /schedule [post]
+// @Router      /schedule [patch]
+// @Router      /schedule [delete]
+func (srv *UService) handleSchedule(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet:
+		if c.Query("sid") != "" {
+			sid, err := strconv.ParseInt(strings.TrimSpace(c.Query("sid")), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi sid"})
+
+				return
+			}
+			limit := defaultScheduleRuns
+			if c.Query("runs") != "" {
+				limit, err = strconv.Atoi(strings.TrimSpace(c.Query("runs")))
+				if err != nil || limit < 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi runs"})
+
+					return
+				}
+			}
+
+			schedule, err := srv.getSchedule(sid)
+			if err != nil {
+				log.Printf("failed to retrieve schedule %d: %v", sid, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the schedule"})
+
+				return
+			}
+			schedule.Runs, err = srv.getScheduleRuns(sid, limit)
+			if err != nil {
+				log.Printf("failed to retrieve the runs of schedule %d: %v", sid, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the schedule runs"})
+
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"content": schedule})
+
+			return
+		}
+
+		uid := -1
+		if c.Query("uid") != "" {
+			var err error
+			uid, err = strconv.Atoi(strings.TrimSpace(c.Query("uid")))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi uid"})
+
+				return
+			}
+		}
+		schedules, err := srv.getSchedules(uid)
+		if err != nil {
+			log.Printf("failed to retrieve schedules: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the schedules"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"content": schedules})
+
+	case http.MethodPost:
+		ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+		if err != nil {
+			log.Printf("failed to bind access-target: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+			return
+		}
+		// schedules are enabled unless stated otherwise
+		schedule := ut.Schedule{Enabled: true}
+		err = c.BindJSON(&schedule)
+		if err != nil {
+			log.Printf("failed to bind schedule: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind schedule"})
+
+			return
+		}
+
+		err = schedule.Validate()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+		err = bindJobOwner(ac, &schedule.UID, &schedule.GID)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+
+			return
+		}
+		// the template is admitted as the job it runs as, which records the identity on the template
+		schedule.Job.UID = schedule.UID
+		schedule.Job.GID = schedule.GID
+		if !srv.admitJobOrReject(c, &schedule.Job, ac) {
+			return
+		}
+
+		sid, err := srv.createSchedule(schedule)
+		if err != nil {
+			log.Printf("failed to create the schedule: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create the schedule"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "schedule created", "sid": sid})
+
+	case http.MethodPatch:
+		schedule, ok := srv.ownedSchedule(c)
+		if !ok {
+			return
+		}
+		paused, err := strconv.ParseBool(strings.TrimSpace(c.Query("paused")))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide paused=true|false"})
+
+			return
+		}
+
+		err = srv.pauseSchedule(schedule, paused)
+		if err != nil {
+			log.Printf("failed to update schedule %d: %v", schedule.SID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update the schedule"})
+
+			return
+		}
+		status := "schedule resumed"
+		if paused {
+			status = "schedule paused"
+		}
+		c.JSON(http.StatusOK, gin.H{"status": status, "sid": schedule.SID})
+
+	case http.MethodDelete:
+		schedule, ok := srv.ownedSchedule(c)
+		if !ok {
+			return
+		}
+
+		err := srv.deleteSchedule(schedule.SID)
+		if err != nil {
+			log.Printf("failed to delete schedule %d: %v", schedule.SID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete the schedule"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "schedule deleted", "sid": schedule.SID})
+
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{
+			"error": "method not allowed",
+		})
+	}
+}
+
+// ownedSchedule retrieves the schedule of the "sid" query if the caller owns it (or is root),
+// responds with the appropriate error otherwise
+func (srv *UService) ownedSchedule(c *gin.Context) (ut.Schedule, bool) {
+	ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+	if err != nil {
+		log.Printf("failed to bind access-target: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+		return ut.Schedule{}, false
+	}
+	sid, err := strconv.ParseInt(strings.TrimSpace(c.Query("sid")), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid sid"})
+
+		return ut.Schedule{}, false
+	}
+	schedule, err := srv.getSchedule(sid)
+	if err != nil {
+		log.Printf("failed to retrieve the schedule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the schedule"})
+
+		return ut.Schedule{}, false
+	}
+	if ac.UID != "0" && ac.UID != strconv.Itoa(schedule.UID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the owner of the schedule can modify it"})
+
+		return ut.Schedule{}, false
+	}
+
+	return schedule, true
+}
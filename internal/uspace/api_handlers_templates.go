@@ -0,0 +1,331 @@
+package uspace
+
+/*
+	http api handlers for the uspace service
+	"job template" related endpoints, named and versioned job definitions
+*/
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleJobTemplate handles saved job templates
+//
+// @Summary     Get, create, edit or delete job templates
+// @Description GET retrieves a template by tid (its latest version, the given version, or all of them with versions=true),
+// @Description or lists the latest version of every template the caller may use: its own and those shared with its groups.
+// @Description POST creates a template, PUT saves a new version of it (previous versions are kept), DELETE removes all of its versions.
+// @Description The caller is given by the Access-Target header, PUT and DELETE are only allowed to the owner (or root).
+// @Tags        jobs, templates
+// @Accept      json
+// @Produce     json
+//
+// @Param       tid           query     int             false  "Template ID"
+// @Param       version       query     int             false  "Template version (GET), the latest by default"
+// @Param       versions      query     bool            false  "Return every version of the template (GET)"
+// @Param       Access-Target header    string          true   "vid:vname:target uid:gids"
+// @Param       template      body      ut.JobTemplate  true   "Template (POST, PUT)"
+//
+// @Success     200           {object}  map[string]interface{}
+// @Failure     400           {object}  map[string]string
+// @Failure     403           {object}  map[string]string
+// @Failure     404           {object}  map[string]string
+// @Failure     405           {object}  map[string]string
+// @Failure     500           {object}  map[string]string
+//
+// @Router      /job/template [get]
+// @Router      /job/template [post]
+// @Router      /job/template [put]
+// @Router      /job/template [delete]
+func (srv *UService) handleJobTemplate(c *gin.Context) {
+	ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+	if err != nil {
+		log.Printf("failed to bind access-target: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		if c.Query("tid") == "" {
+			uid, err := strconv.Atoi(strings.TrimSpace(ac.UID))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi uid"})
+
+				return
+			}
+			// root sees every template
+			if uid == 0 {
+				uid = -1
+			}
+			gids, err := ut.SplitToInt(strings.TrimSpace(ac.Gids), ",")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi gids"})
+
+				return
+			}
+			templates, err := srv.getJobTemplates(uid, gids)
+			if err != nil {
+				log.Printf("failed to retrieve templates: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the templates"})
+
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"content": templates})
+
+			return
+		}
+
+		version := 0
+		if c.Query("version") != "" {
+			version, err = strconv.Atoi(strings.TrimSpace(c.Query("version")))
+			if err != nil || version <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi version"})
+
+				return
+			}
+		}
+		template, ok := srv.usableJobTemplate(c, ac, version)
+		if !ok {
+			return
+		}
+		if all, _ := strconv.ParseBool(c.Query("versions")); all {
+			versions, err := srv.getJobTemplateVersions(template.TID)
+			if err != nil {
+				log.Printf("failed to retrieve the versions of template %d: %v", template.TID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the template versions"})
+
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"content": versions})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"content": template})
+
+	case http.MethodPost:
+		var template ut.JobTemplate
+		err := c.BindJSON(&template)
+		if err != nil {
+			log.Printf("failed to bind template: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind template"})
+
+			return
+		}
+		err = bindJobOwner(ac, &template.UID, &template.GID)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+
+			return
+		}
+		err = template.Validate()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		tid, err := srv.insertJobTemplate(template)
+		if err != nil {
+			log.Printf("failed to create the template: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create the template"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "template created", "tid": tid, "version": 1})
+
+	case http.MethodPut:
+		current, ok := srv.ownedJobTemplate(c, ac)
+		if !ok {
+			return
+		}
+		var template ut.JobTemplate
+		err := c.BindJSON(&template)
+		if err != nil {
+			log.Printf("failed to bind template: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind template"})
+
+			return
+		}
+		err = template.Validate()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+		// a new version of the same template, the owner stays the same
+		template.TID, template.UID = current.TID, current.UID
+
+		version, err := srv.insertJobTemplateVersion(template)
+		if err != nil {
+			log.Printf("failed to save a new version of template %d: %v", current.TID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update the template"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "template updated", "tid": current.TID, "version": version})
+
+	case http.MethodDelete:
+		template, ok := srv.ownedJobTemplate(c, ac)
+		if !ok {
+			return
+		}
+		err := srv.deleteJobTemplate(template.TID)
+		if err != nil {
+			log.Printf("failed to delete template %d: %v", template.TID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete the template"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "template deleted", "tid": template.TID})
+
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{
+			"error": "method not allowed",
+		})
+	}
+}
+
+// handleJobTemplateSubmit submits a job out of a template
+//
+// @Summary     Submit a job out of a template
+// @Description Submits the job of the given template version (the latest by default), with the set fields of overrides applied.
+// @Description The job records the template and version it came from. The caller must be allowed to use the template.
+// @Tags        jobs, templates
+// @Accept      json
+// @Produce     json
+//
+// @Param       Access-Target header    string                 true  "vid:vname:target uid:gids"
+// @Param       submission    body      ut.TemplateSubmission  true  "Template and overrides"
+//
+// @Success     200           {object}  map[string]interface{}
+// @Failure     400           {object}  map[string]string
+// @Failure     403           {object}  map[string]string
+// @Failure     404           {object}  map[string]string
+// @Failure     429           {object}  map[string]string "Per user/group job limit reached"
+// @Failure     500           {object}  map[string]string
+//
+// @Router      /job/template/submit [post]
+func (srv *UService) handleJobTemplateSubmit(c *gin.Context) {
+	ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+	if err != nil {
+		log.Printf("failed to bind access-target: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+		return
+	}
+	var submission ut.TemplateSubmission
+	err = c.BindJSON(&submission)
+	if err != nil {
+		log.Printf("failed to bind template submission: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind template submission"})
+
+		return
+	}
+
+	template, err := srv.getJobTemplate(submission.TID, submission.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the template"})
+
+		return
+	}
+	if !template.Usable(ac) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "template is neither owned by nor shared with the caller"})
+
+		return
+	}
+
+	job := template.Instantiate(submission.Overrides)
+	job.UID, job.GID = submission.UID, submission.GID
+	if !srv.admitJobOrReject(c, &job, ac) {
+		return
+	}
+
+	jid, err := srv.insertJob(job)
+	if err != nil {
+		log.Printf("failed to insert the job in the db: %+v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to insert into db"})
+
+		return
+	}
+	job.JID = jid
+	err = srv.jdp.PublishJob(job)
+	if err != nil {
+		log.Printf("failed to publish the job: %v", err)
+		srv.rejectPendingJobs([]ut.Job{job})
+		if errors.Is(err, errJobLimit) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to publish job"})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "job published",
+		"jid":     jid,
+		"tid":     template.TID,
+		"version": template.Version,
+	})
+}
+
+// usableJobTemplate retrieves the given version of the template of the "tid" query if the caller may use it,
+// responds with the appropriate error otherwise
+func (srv *UService) usableJobTemplate(c *gin.Context, ac ut.AccessClaim, version int) (ut.JobTemplate, bool) {
+	tid, err := strconv.ParseInt(strings.TrimSpace(c.Query("tid")), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid tid"})
+
+		return ut.JobTemplate{}, false
+	}
+	template, err := srv.getJobTemplate(tid, version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+
+			return ut.JobTemplate{}, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the template"})
+
+		return ut.JobTemplate{}, false
+	}
+	if !template.Usable(ac) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "template is neither owned by nor shared with the caller"})
+
+		return ut.JobTemplate{}, false
+	}
+
+	return template, true
+}
+
+// ownedJobTemplate retrieves the latest version of the template of the "tid" query if the caller owns it (or is root)
+func (srv *UService) ownedJobTemplate(c *gin.Context, ac ut.AccessClaim) (ut.JobTemplate, bool) {
+	template, ok := srv.usableJobTemplate(c, ac, 0)
+	if !ok {
+		return template, false
+	}
+	if ac.UID != "0" && ac.UID != strconv.Itoa(template.UID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the owner of the template can modify it"})
+
+		return ut.JobTemplate{}, false
+	}
+
+	return template, true
+}
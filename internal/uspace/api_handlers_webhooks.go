@@ -0,0 +1,209 @@
+package uspace
+
+/*
+	http api handlers for the uspace service
+	"job webhook" related endpoints, subscriptions to job state transitions and their delivery log
+*/
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleWebhook handles the webhook subscriptions of a user
+//
+// @Summary     Get, create or delete webhook subscriptions
+// @Description A subscription is notified of the state transitions (queued, running, completed, failed, canceled)
+// @Description of every job of its owner, a job can also carry webhooks of its own (see ut.Job.Webhooks).
+// @Description GET lists the subscriptions of the caller (all of them for root), secrets left out.
+// @Description POST creates one, a secret is generated if none is given and returned only then.
+// @Description Its url may not point to a loopback, link-local or private address, unless allowed (J_WEBHOOK_ALLOWED_HOSTS).
+// @Description DELETE removes one, only allowed to its owner (or root).
+// @Tags        jobs, webhooks
+// @Accept      json
+// @Produce     json
+//
+// @Param       wid           query     int         false  "Webhook ID (DELETE)"
+// @Param       Access-Target header    string      true   "vid:vname:target uid:gids"
+// @Param       webhook       body      ut.Webhook  true   "Webhook (POST)"
+//
+// @Success     200           {object}  map[string]interface{}
+// @Failure     400           {object}  map[string]string
+// @Failure     403           {object}  map[string]string
+// @Failure     404           {object}  map[string]string
+// @Failure     405           {object}  map[string]string
+// @Failure     500           {object}  map[string]string
+//
+// @Router      /job/webhook [get]
+// @Router      /job/webhook [post]
+// @Router      /job/webhook [delete]
+func (srv *UService) handleWebhook(c *gin.Context) {
+	ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+	if err != nil {
+		log.Printf("failed to bind access-target: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+		return
+	}
+	uid, err := strconv.Atoi(strings.TrimSpace(ac.UID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi uid"})
+
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		// root sees every subscription
+		owner := uid
+		if uid == 0 {
+			owner = -1
+		}
+		webhooks, err := srv.getWebhooks(owner)
+		if err != nil {
+			log.Printf("failed to retrieve webhooks: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the webhooks"})
+
+			return
+		}
+		for i := range webhooks {
+			webhooks[i].Secret = ""
+		}
+		c.JSON(http.StatusOK, gin.H{"content": webhooks})
+
+	case http.MethodPost:
+		var webhook ut.Webhook
+		err := c.BindJSON(&webhook)
+		if err != nil {
+			log.Printf("failed to bind webhook: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind webhook"})
+
+			return
+		}
+		err = webhook.Validate(srv.webhookHosts()...)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+		// root may subscribe on behalf of another user
+		if uid != 0 || webhook.UID == 0 {
+			webhook.UID = uid
+		}
+		if webhook.Secret == "" {
+			webhook.Secret, err = ut.GenerateRandomString(32)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate a secret"})
+
+				return
+			}
+		}
+
+		wid, err := srv.insertWebhook(webhook)
+		if err != nil {
+			log.Printf("failed to create the webhook: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create the webhook"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "webhook created", "wid": wid, "secret": webhook.Secret})
+
+	case http.MethodDelete:
+		wid, err := strconv.ParseInt(strings.TrimSpace(c.Query("wid")), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "must provide a valid wid"})
+
+			return
+		}
+		webhook, err := srv.getWebhook(wid)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the webhook"})
+
+			return
+		}
+		if uid != 0 && uid != webhook.UID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the owner of the webhook can delete it"})
+
+			return
+		}
+		err = srv.deleteWebhook(wid)
+		if err != nil {
+			log.Printf("failed to delete webhook %d: %v", wid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete the webhook"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "webhook deleted", "wid": wid})
+
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{
+			"error": "method not allowed",
+		})
+	}
+}
+
+// handleWebhookDeliveries serves the webhook delivery log
+//
+// @Summary     Webhook delivery log
+// @Description Returns the logged webhook deliveries, most recent first: payload, tries, status (pending, delivered
+// @Description or failed), the last response code and error. wid 0 stands for the webhooks of the jobs themselves.
+// @Tags        admin, webhooks
+// @Produce     json
+//
+// @Param       jid     query     int     false  "Only the deliveries of this job"
+// @Param       wid     query     int     false  "Only the deliveries of this subscription"
+// @Param       status  query     string  false  "pending, delivered or failed"
+// @Param       limit   query     int     false  "At most that many deliveries (default 100)"
+//
+// @Success     200     {object}  map[string]interface{} "content: []ut.WebhookDelivery"
+// @Failure     400     {object}  map[string]string
+// @Failure     500     {object}  map[string]string
+//
+// @Router      /admin/job/webhook/deliveries [get]
+func (srv *UService) handleWebhookDeliveries(c *gin.Context) {
+	var jid, wid int64
+	limit := int64(100)
+	for name, target := range map[string]*int64{"jid": &jid, "wid": &wid, "limit": &limit} {
+		value := strings.TrimSpace(c.Query(name))
+		if value == "" {
+			continue
+		}
+		var err error
+		*target, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || *target < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+
+			return
+		}
+	}
+	status := strings.TrimSpace(c.Query("status"))
+	switch status {
+	case "", "pending", "delivered", "failed":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of pending, delivered or failed"})
+
+		return
+	}
+
+	deliveries, err := srv.getWebhookDeliveries(jid, wid, status, int(limit))
+	if err != nil {
+		log.Printf("failed to retrieve webhook deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the webhook deliveries"})
+
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"content": deliveries})
+}
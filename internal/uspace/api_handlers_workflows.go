@@ -0,0 +1,140 @@
+package uspace
+
+/*
+	http api handlers for the uspace service
+	"workflow" related endpoints, dependency graphs of jobs
+*/
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleWorkflow handles workflow submission (POST) and querying (GET)
+//
+// @Summary     Get or submit workflows
+// @Description GET retrieves a workflow with the status of each node by wid, the workflows of a uid, or all of them.
+// @Description POST submits a workflow: a DAG of jobs where a node starts once all its upstream nodes completed.
+// @Description A node's input may reference the output of another node as "${<node name>.output}".
+// @Description Every node is checked as a job submitted by the caller would be (see /job), and once more when it starts.
+// @Tags        workflows
+// @Accept      json
+// @Produce     json
+//
+// @Param       wid      query     int          false  "Workflow ID"
+// @Param       uid      query     int          false  "User ID whose workflows to list"
+// @Param       Access-Target header string false "Access target of the caller (POST), e.g. '0::/ 1000:1000'"
+// @Param       workflow body      ut.Workflow  true   "Workflow (POST)"
+//
+// @Success     200      {object}  map[string]interface{}
+// @Failure     400      {object}  map[string]string
+// @Failure     403      {object}  map[string]string
+// @Failure     405      {object}  map[string]string
+// @Failure     500      {object}  map[string]string
+//
+// @Router      /workflow [get]
+// @Router      /workflow [post]
+func (srv *UService) handleWorkflow(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet:
+		wid, _ := c.GetQuery("wid")
+		if wid != "" {
+			widInt, err := strconv.ParseInt(strings.TrimSpace(wid), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi wid"})
+
+				return
+			}
+			workflow, err := srv.getWorkflow(widInt)
+			if err != nil {
+				log.Printf("failed to retrieve workflow %d: %v", widInt, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the workflow"})
+
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"content": workflow})
+
+			return
+		}
+
+		uidInt := -1
+		uid, _ := c.GetQuery("uid")
+		if uid != "" {
+			var err error
+			uidInt, err = strconv.Atoi(strings.TrimSpace(uid))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to atoi uid"})
+
+				return
+			}
+		}
+		workflows, err := srv.getWorkflows(uidInt)
+		if err != nil {
+			log.Printf("failed to retrieve workflows: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the workflows"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"content": workflows})
+
+	case http.MethodPost:
+		ac, err := BindAccessTarget(c.GetHeader("Access-Target"))
+		if err != nil {
+			log.Printf("failed to bind access-target: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing Access-Target header"})
+
+			return
+		}
+		var workflow ut.Workflow
+		err = c.BindJSON(&workflow)
+		if err != nil {
+			log.Printf("failed to bind workflow: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to bind workflow"})
+
+			return
+		}
+
+		err = workflow.Validate()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+		err = bindJobOwner(ac, &workflow.UID, &workflow.GID)
+		if err == nil {
+			err = srv.admitWorkflow(&workflow, ac)
+		}
+		if err != nil {
+			log.Printf("rejected workflow of user %d: %v", workflow.UID, err)
+			if errors.Is(err, errJobAccess) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		wid, err := srv.submitWorkflow(workflow)
+		if err != nil {
+			log.Printf("failed to submit the workflow: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit the workflow"})
+
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "workflow submitted", "wid": wid})
+
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{
+			"error": "method not allowed",
+		})
+	}
+}
@@ -1,4 +1,6 @@
 import os
+import json
+import shlex
 import boto3
 import subprocess
 import sys
@@ -9,27 +11,45 @@ input_object = os.getenv("INPUT_OBJECT", "input")
 output_bucket = os.getenv("OUTPUT_BUCKET", "uspace-default")
 output_object = os.getenv("OUTPUT_OBJECT", "output")
 output_format = os.getenv("OUTPUT_FORMAT", "txt")
-logic = os.getenv("LOGIC", "cat {input} > {output}")
+# set for a directory output, every file written in {outdir} is uploaded under it
+output_prefix = os.getenv("OUTPUT_PREFIX", "")
+logic = os.getenv("LOGIC", "cat {inputs} > {output}")
+# every input of the job [{"bucket", "object", "name"}], the single one of INPUT_BUCKET/INPUT_OBJECT otherwise
+inputs = json.loads(os.getenv("INPUTS") or "[]") or [
+    {"bucket": input_bucket, "object": input_object, "name": os.path.basename(input_object)}
+]
 
 minio_endpoint = os.getenv("ENDPOINT", "http://minio:9000")
 minio_access_key = os.getenv("ACCESS_KEY", "minioadmin")
 minio_secret_key = os.getenv("SECRET_KEY", "minioadmin")
 
-input_path = "/tmp/input"
-output_path = f"/tmp/output.{output_format}"
+input_dir = "/tmp/input"
+output_dir = "/tmp/output"
+output_path = os.path.join(output_dir, f"output.{output_format}") if output_prefix else f"/tmp/output.{output_format}"
+os.makedirs(output_dir, exist_ok=True)
 
-# --- Download Input File from MinIO ---
-print(f"[INFO] Downloading s3://{input_bucket}/{input_object} to {input_path}")
+# --- Download Input Files from MinIO, each under its own name ---
 s3 = boto3.client(
     's3',
     endpoint_url="http://" + minio_endpoint.replace("http://", ""),
     aws_access_key_id=minio_access_key,
     aws_secret_access_key=minio_secret_key,
 )
-s3.download_file(input_bucket, input_object, input_path)
+input_paths = []
+for inp in inputs:
+    path = os.path.join(input_dir, inp["name"])
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    print(f"[INFO] Downloading s3://{inp['bucket']}/{inp['object']} to {path}")
+    s3.download_file(inp["bucket"], inp["object"], path)
+    input_paths.append(path)
 
-# --- Replace placeholders in logic ---
-shell_command = logic.replace("{input}", input_path).replace("{output}", output_path)
+# --- Replace placeholders in logic, {input} is the first input, {inputs} all of them ---
+shell_command = (
+    logic.replace("{inputs}", " ".join(shlex.quote(p) for p in input_paths))
+    .replace("{input}", shlex.quote(input_paths[0]))
+    .replace("{output}", output_path)
+    .replace("{outdir}", output_dir)
+)
 
 # --- Execute Command ---
 print(f"[EXEC] {shell_command}")
@@ -39,7 +59,17 @@ if result.returncode != 0:
     print("[ERROR] bash pipeline failed:\n", result.stderr, file=sys.stderr)
     sys.exit(1)
 
-# --- Upload Output ---
+# --- Upload Output, the whole output dir for a directory output ---
+if output_prefix:
+    for root, _, files in os.walk(output_dir):
+        for name in files:
+            path = os.path.join(root, name)
+            key = output_prefix + os.path.relpath(path, output_dir)
+            print(f"[INFO] Uploading {path} to s3://{output_bucket}/{key}")
+            s3.upload_file(path, output_bucket, key)
+    print(f"[INFO] Done. Files uploaded under s3://{output_bucket}/{output_prefix}")
+    sys.exit(0)
+
 print(f"[INFO] Uploading result to s3://{output_bucket}/{output_object}")
 s3.upload_file(output_path, output_bucket, output_object)
 print(f"[INFO] Done. File uploaded to s3://{output_bucket}/{output_object}")
@@ -1,4 +1,5 @@
 import os
+import json
 import boto3
 import ast
 import subprocess
@@ -11,6 +12,13 @@ output_bucket = os.getenv("OUTPUT_BUCKET", "uspace-default")
 output_object = os.getenv("OUTPUT_OBJECT", "output")
 output_format = os.getenv("OUTPUT_FORMAT", "txt")
 logic = os.getenv("LOGIC", "cat {input} > {output}")
+# every input of the job [{"bucket", "object", "name"}], the single one of INPUT_BUCKET/INPUT_OBJECT otherwise
+inputs = json.loads(os.getenv("INPUTS") or "[]")
+if inputs:
+    # a single image is processed
+    if len(inputs) > 1:
+        print(f"[WARN] {len(inputs)} inputs given, only the first one is processed")
+    input_bucket, input_object = inputs[0]["bucket"], inputs[0]["object"]
 
 minio_endpoint = os.getenv("ENDPOINT", "http://minio:9000")
 minio_access_key = os.getenv("ACCESS_KEY", "minioadmin")
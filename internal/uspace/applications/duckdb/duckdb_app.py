@@ -1,4 +1,5 @@
 import duckdb
+import json
 import os
 import sqlparse
 
@@ -11,6 +12,8 @@ query = query.replace('"', "'")
 input_bucket = os.getenv("INPUT_BUCKET", "uspace-default")
 input_object = os.getenv("INPUT_OBJECT", "input.csv")
 input_format = os.getenv("INPUT_FORMAT", "csv")
+# every input of the job [{"bucket", "object", "name"}], the single one of INPUT_BUCKET/INPUT_OBJECT otherwise
+inputs = json.loads(os.getenv("INPUTS") or "[]") or [{"bucket": input_bucket, "object": input_object}]
 
 output_bucket = os.getenv("OUTPUT_BUCKET", "uspace-default")
 output_object = os.getenv("OUTPUT_OBJECT", "output.csv")
@@ -22,25 +25,26 @@ minio_secret_key = os.getenv("SECRET_KEY", "minioadmin")
 
 # logs
 print(f"[INFO] Starting DuckDB application with query: {query}")
-print(f"[INFO] Input bucket: {input_bucket}")
-print(f"[INFO] Input object: {input_object}")
+for inp in inputs:
+    print(f"[INFO] Input: s3://{inp['bucket']}/{inp['object']}")
 print(f"[INFO] Input format: {output_format}")
 print(f"[INFO] Output bucket: {output_bucket}")
 print(f"[INFO] Output object: {output_object}")
 print(f"[INFO] Output format: {output_format}")
 print(f"[INFO] MinIO endpoint: {minio_endpoint}")
 
-# should format input query to read from s3://{input_bucket}/{input_object}
+# should format input query to read from every input, the readers take a list of files
+sources = "[" + ", ".join(f"'s3://{inp['bucket']}/{inp['object']}'" for inp in inputs) + "]"
 if input_format == "csv":
-    query = query.replace(placeholder, f"read_csv_auto('s3://{input_bucket}/{input_object}')")
+    query = query.replace(placeholder, f"read_csv_auto({sources})")
 elif input_format == "json":
-    query = query.replace(placeholder, f"read_json_auto('s3://{input_bucket}/{input_object}')")
+    query = query.replace(placeholder, f"read_json_auto({sources})")
 elif input_format == "parquet":
-    query = query.replace(placeholder, f"read_parquet('s3://{input_bucket}/{input_object}')")
+    query = query.replace(placeholder, f"read_parquet({sources})")
 elif input_format == "txt" or input_format == "text" or input_format == "str":
-    query = query.replace(placeholder, f"read_csv_auto('s3://{input_bucket}/{input_object}', delim='\\n', header=False)")
+    query = query.replace(placeholder, f"read_csv_auto({sources}, delim='\\n', header=False)")
 else:
-    query = query.replace(placeholder, f"read_csv_auto('s3://{input_bucket}/{input_object}')")
+    query = query.replace(placeholder, f"read_csv_auto({sources})")
 
 
 print(f"[INFO] Updated query: {query}")
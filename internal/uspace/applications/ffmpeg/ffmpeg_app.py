@@ -1,4 +1,6 @@
 import os
+import json
+import shlex
 import boto3
 import subprocess
 import sys
@@ -10,26 +12,39 @@ output_bucket = os.getenv("OUTPUT_BUCKET", "uspace-default")
 output_object = os.getenv("OUTPUT_OBJECT", "output.mp4")
 output_format = os.getenv("OUTPUT_FORMAT", "mp4")
 logic = os.getenv("LOGIC", "ffmpeg -i {input} -c:v libx264 -preset slow -crf 22 {output}")
+# every input of the job [{"bucket", "object", "name"}], the single one of INPUT_BUCKET/INPUT_OBJECT otherwise
+inputs = json.loads(os.getenv("INPUTS") or "[]") or [
+    {"bucket": input_bucket, "object": input_object, "name": os.path.basename(input_object)}
+]
 
 minio_endpoint = os.getenv("ENDPOINT", "http://minio:9000")
 minio_access_key = os.getenv("ACCESS_KEY", "minioadmin")
 minio_secret_key = os.getenv("SECRET_KEY", "minioadmin")
 
-input_path = "/tmp/input"
+input_dir = "/tmp/input"
 output_path = f"/tmp/output.{output_format}"
 
-# --- Download Input File from MinIO ---
-print(f"[INFO] Downloading s3://{input_bucket}/{input_object} to {input_path}")
+# --- Download Input Files from MinIO, each under its own name ---
 s3 = boto3.client(
     's3',
     endpoint_url="http://" + minio_endpoint.replace("http://", ""),
     aws_access_key_id=minio_access_key,
     aws_secret_access_key=minio_secret_key,
 )
-s3.download_file(input_bucket, input_object, input_path)
-
-# --- Replace placeholders in logic ---
-shell_command = logic.replace("{input}", input_path).replace("{output}", output_path)
+input_paths = []
+for inp in inputs:
+    path = os.path.join(input_dir, inp["name"])
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    print(f"[INFO] Downloading s3://{inp['bucket']}/{inp['object']} to {path}")
+    s3.download_file(inp["bucket"], inp["object"], path)
+    input_paths.append(path)
+
+# --- Replace placeholders in logic, {input} is the first input, {inputs} all of them as -i options ---
+shell_command = (
+    logic.replace("{inputs}", " ".join("-i " + shlex.quote(p) for p in input_paths))
+    .replace("{input}", shlex.quote(input_paths[0]))
+    .replace("{output}", output_path)
+)
 
 # --- Execute Command ---
 print(f"[EXEC] {shell_command}")
@@ -1,4 +1,5 @@
 import os
+import json
 import boto3
 import subprocess
 import sys
@@ -6,6 +7,10 @@ import sys
 input_bucket = os.getenv("INPUT_BUCKET", "uspace-default")
 input_object = os.getenv("INPUT_OBJECT", "input.csv")
 input_format = os.getenv("INPUT_FORMAT", "csv")
+# every input of the job [{"bucket", "object", "name"}], the single one of INPUT_BUCKET/INPUT_OBJECT otherwise
+inputs = json.loads(os.getenv("INPUTS") or "[]") or [
+    {"bucket": input_bucket, "object": input_object, "name": os.path.basename(input_object)}
+]
 
 output_bucket = os.getenv("OUTPUT_BUCKET", "uspace-default")
 output_object = os.getenv("OUTPUT_OBJECT", "output.csv")
@@ -27,11 +32,18 @@ s3 = boto3.client(
     aws_secret_access_key=minio_secret_key,
 )
 
-s3.download_file(input_bucket, input_object, "/tmp/input.csv")
+input_paths = []
+for inp in inputs:
+    path = os.path.join("/tmp/input", inp["name"])
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    s3.download_file(inp["bucket"], inp["object"], path)
+    input_paths.append(path)
 
-# Write Octave script to disk
+# Write Octave script to disk, input stacks the rows of every input, inputs holds each one apart
+reads = ", ".join(f"csvread('{p}')" for p in input_paths)
 octave_script = f"""
-input = csvread('/tmp/input.csv');
+inputs = {{{reads}}};
+input = vertcat(inputs{{:}});
 {logic_code}
 csvwrite('/tmp/output.csv', output);
 """
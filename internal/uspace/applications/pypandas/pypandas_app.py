@@ -1,4 +1,5 @@
 import os
+import json
 import boto3
 import pandas as pd
 import numpy as np
@@ -10,6 +11,10 @@ logic_code = os.getenv("LOGIC", "df['result'] = df['value'] * 2")
 input_bucket = os.getenv("INPUT_BUCKET", "uspace-default")
 input_object = os.getenv("INPUT_OBJECT", "input.csv")
 input_format = os.getenv("INPUT_FORMAT", "csv")
+# every input of the job [{"bucket", "object", "name"}], the single one of INPUT_BUCKET/INPUT_OBJECT otherwise
+inputs = json.loads(os.getenv("INPUTS") or "[]") or [
+    {"bucket": input_bucket, "object": input_object, "name": os.path.basename(input_object)}
+]
 
 output_bucket = os.getenv("OUTPUT_BUCKET", "uspace-default")
 output_object = os.getenv("OUTPUT_OBJECT", "output.csv")
@@ -19,7 +24,8 @@ minio_endpoint = os.getenv("ENDPOINT", "http://minio:9000")
 minio_access_key = os.getenv("ACCESS_KEY", "minioadmin")
 minio_secret_key = os.getenv("SECRET_KEY", "minioadmin")
 
-print(f"[INFO] Input: s3://{input_bucket}/{input_object}")
+for inp in inputs:
+    print(f"[INFO] Input: s3://{inp['bucket']}/{inp['object']}")
 print(f"[INFO] Output: s3://{output_bucket}/{output_object}")
 print(f"[INFO] Executing logic:\n{logic_code}")
 
@@ -32,23 +38,24 @@ s3 = boto3.client(
     region_name="eu-central-1"
 )
 
-# Download file from MinIO
-input_tmp_path = "/tmp/input"
-s3.download_file(input_bucket, input_object, input_tmp_path)
-
-# Load into DataFrame
-if input_format == "csv":
-    df = pd.read_csv(input_tmp_path)
-elif input_format == "json":
-    df = pd.read_json(input_tmp_path)
-elif input_format == "parquet":
-    df = pd.read_parquet(input_tmp_path)
-else:
+# Download files from MinIO, each under its own name, and load them
+readers = {"csv": pd.read_csv, "json": pd.read_json, "parquet": pd.read_parquet}
+if input_format not in readers:
     raise ValueError(f"Unsupported input format: {input_format}")
 
+dfs = []
+for inp in inputs:
+    input_tmp_path = os.path.join("/tmp/input", inp["name"])
+    os.makedirs(os.path.dirname(input_tmp_path), exist_ok=True)
+    s3.download_file(inp["bucket"], inp["object"], input_tmp_path)
+    dfs.append(readers[input_format](input_tmp_path))
+
+# df holds every input, one after the other, dfs each one apart
+df = pd.concat(dfs, ignore_index=True)
+
 # Execute the logic in a safe namespace
 try:
-    exec(logic_code, {"pd": pd, "np": np}, {"df": df})
+    exec(logic_code, {"pd": pd, "np": np}, {"df": df, "dfs": dfs})
 except Exception as e:
     print("[ERROR] Error during logic execution:")
     traceback.print_exc()
@@ -0,0 +1,361 @@
+package uspace
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// insertJobArray saves an array along with its children (as "pending" jobs) in a single transaction,
+// the children get their jids and the id of the array (ARRAY_ID in their env)
+func (srv *UService) insertJobArray(a ut.JobArray, children []ut.Job) (int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return -1, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	a.Children = nil
+	spec, err := json.Marshal(a)
+	if err != nil {
+		return -1, fmt.Errorf("failed to marshal the job array: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+
+		return -1, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	rollback := func() {
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("failed to rollback: %v", rerr)
+		}
+	}
+
+	var aid int64
+	err = tx.QueryRow(`
+		INSERT INTO
+			job_arrays (aid, uid, gid, name, spec, size, status, createdAt)
+		VALUES
+			(nextval('seq_arrayid'), ?, ?, ?, ?, ?, ?, ?)
+		RETURNING (aid);`,
+		a.UID, a.GID, a.Name, string(spec), len(children), "running", ut.CurrentTime()).Scan(&aid)
+	if err != nil {
+		log.Printf("failed to insert job array: %v", err)
+		rollback()
+
+		return -1, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	for i := range children {
+		if children[i].Env == nil {
+			children[i].Env = make(map[string]string)
+		}
+		children[i].Env["ARRAY_ID"] = strconv.FormatInt(aid, 10)
+	}
+	err = insertJobsTx(tx, children)
+	if err != nil {
+		rollback()
+
+		return -1, err
+	}
+
+	for i, child := range children {
+		_, err = tx.Exec(`
+			INSERT INTO
+				job_array_children (aid, idx, jid)
+			VALUES
+				(?, ?, ?);`, aid, a.Index(i), child.JID)
+		if err != nil {
+			log.Printf("failed to insert job array child: %v", err)
+			rollback()
+
+			return -1, fmt.Errorf("failed to execute query: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+
+		return -1, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return aid, nil
+}
+
+// jobArrayColumns are the columns an array is read from, in the order scanJobArray expects them
+const jobArrayColumns = `aid, uid, gid, name, spec, size, status, createdAt, completedAt`
+
+// scanJobArray reads an array (without its children), the template and source of the array come from its spec
+func scanJobArray(row rowScanner) (ut.JobArray, error) {
+	var (
+		a                      ut.JobArray
+		gid                    sql.NullInt64
+		spec                   string
+		completedAt, createdAt sql.NullString
+	)
+	err := row.Scan(&a.AID, &a.UID, &gid, &a.Name, &spec, &a.Size, &a.Status, &createdAt, &completedAt)
+	if err != nil {
+		return a, err
+	}
+	var def ut.JobArray
+	err = json.Unmarshal([]byte(spec), &def)
+	if err != nil {
+		return a, fmt.Errorf("corrupt spec of job array %d: %w", a.AID, err)
+	}
+	a.Job, a.Inputs, a.Params, a.Range = def.Job, def.Inputs, def.Params, def.Range
+	a.GID = int(gid.Int64)
+	a.CreatedAt = createdAt.String
+	a.CompletedAt = completedAt.String
+
+	return a, nil
+}
+
+// getJobArray returns an array with its children, in order
+func (srv *UService) getJobArray(aid int64) (ut.JobArray, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return ut.JobArray{}, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	a, err := scanJobArray(db.QueryRow(`
+		SELECT
+			`+jobArrayColumns+`
+		FROM
+			job_arrays
+		WHERE
+			aid = ?`, aid))
+	if err != nil {
+		log.Printf("failed to query row: %v", err)
+
+		return a, fmt.Errorf("failed to query row: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			c.idx, c.jid, COALESCE(j.status, 'unknown'), COALESCE(j.output, '')
+		FROM
+			job_array_children c
+		LEFT JOIN
+			jobs j ON j.jid = c.jid
+		WHERE
+			c.aid = ?
+		ORDER BY
+			c.idx ASC`, aid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return a, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var child ut.JobArrayChild
+		err = rows.Scan(&child.Index, &child.JID, &child.Status, &child.Output)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return a, fmt.Errorf("failed to scan row: %w", err)
+		}
+		a.Children = append(a.Children, child)
+	}
+
+	return a, rows.Err()
+}
+
+// getJobArrays returns the arrays (without their children) of a user, or all of them if uid < 0
+func (srv *UService) getJobArrays(uid int) ([]ut.JobArray, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			`+jobArrayColumns+`
+		FROM
+			job_arrays
+		WHERE
+			uid = ? OR ? < 0
+		ORDER BY
+			aid DESC`, uid, uid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var arrays []ut.JobArray
+	for rows.Next() {
+		a, err := scanJobArray(rows)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		arrays = append(arrays, a)
+	}
+
+	return arrays, rows.Err()
+}
+
+// getJobArraySummary returns the aggregate state of an array, the amount of its children per status
+func (srv *UService) getJobArraySummary(aid int64) (ut.JobArraySummary, error) {
+	summary := ut.JobArraySummary{AID: aid, Statuses: make(map[string]int)}
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return summary, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name, size, status FROM job_arrays WHERE aid = ?`, aid).
+		Scan(&summary.Name, &summary.Size, &summary.Status)
+	if err != nil {
+		log.Printf("failed to query row: %v", err)
+
+		return summary, fmt.Errorf("failed to query row: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			COALESCE(j.status, 'unknown'), COUNT(*)
+		FROM
+			job_array_children c
+		LEFT JOIN
+			jobs j ON j.jid = c.jid
+		WHERE
+			c.aid = ?
+		GROUP BY
+			1`, aid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return summary, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			status string
+			count  int
+		)
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return summary, fmt.Errorf("failed to scan row: %w", err)
+		}
+		summary.Statuses[status] = count
+		if !jobActive(status) {
+			summary.Finished += count
+		}
+	}
+
+	return summary, rows.Err()
+}
+
+// getJobArrayIDByJID returns the array a job belongs to, 0 if none
+func (srv *UService) getJobArrayIDByJID(jid int64) (int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return 0, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	var aid int64
+	err = db.QueryRow(`SELECT aid FROM job_array_children WHERE jid = ?`, jid).Scan(&aid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("failed to query row: %w", err)
+	}
+
+	return aid, nil
+}
+
+// getRunningJobArrayIDs returns the arrays that still have children to wait for
+func (srv *UService) getRunningJobArrayIDs() ([]int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`SELECT aid FROM job_arrays WHERE status = 'running' ORDER BY aid ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var aids []int64
+	for rows.Next() {
+		var aid int64
+		err = rows.Scan(&aid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		aids = append(aids, aid)
+	}
+
+	return aids, nil
+}
+
+func (srv *UService) updateJobArrayStatus(aid int64, status string) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE job_arrays
+		SET
+			status = ?, completedAt = ?
+		WHERE
+			aid = ?`, status, ut.CurrentTime(), aid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package uspace
+
+/*
+	job arrays: a job template expanded into many jobs
+
+	the children of an array are submitted all at once, as a batch: either all
+	of them fit within the per user/group limits or the array is rejected.
+
+	an array is advanced every time one of its children finishes, once none is active anymore
+	the array is "completed" if every child completed (or was cached), "failed" otherwise.
+	Canceling an array cancels all of its unfinished children.
+*/
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// serializes the advancement of arrays
+var arraysMu sync.Mutex
+
+// submitJobArray saves a (validated) array along with its (expanded) children and publishes them,
+// returns the id of the array, the children get their jids
+func (srv *UService) submitJobArray(a ut.JobArray, children []ut.Job) (int64, error) {
+	aid, err := srv.insertJobArray(a, children)
+	if err != nil {
+		return -1, err
+	}
+	log.Printf("[Arrays] job array %d (%s) submitted with %d job(s)", aid, a.Name, len(children))
+
+	err = srv.jdp.PublishJobs(children)
+	if err != nil {
+		srv.rejectPendingJobs(children)
+		uerr := srv.updateJobArrayStatus(aid, "rejected")
+		if uerr != nil {
+			log.Printf("[Arrays] failed to mark job array %d as rejected: %v", aid, uerr)
+		}
+
+		return aid, err
+	}
+
+	return aid, nil
+}
+
+// arrayJobFinished advances the array the job belongs to, if any
+func (srv *UService) arrayJobFinished(jid int64) {
+	aid, err := srv.getJobArrayIDByJID(jid)
+	if err != nil {
+		log.Printf("[Arrays] failed to look up the array of job %d: %v", jid, err)
+
+		return
+	}
+	if aid == 0 {
+		return
+	}
+	srv.advanceJobArray(aid)
+}
+
+// resumeJobArrays advances every unfinished array, in case children finished while the service was down
+func (srv *UService) resumeJobArrays() {
+	aids, err := srv.getRunningJobArrayIDs()
+	if err != nil {
+		log.Printf("[Arrays] failed to retrieve unfinished job arrays: %v", err)
+
+		return
+	}
+	for _, aid := range aids {
+		srv.advanceJobArray(aid)
+	}
+}
+
+func (srv *UService) advanceJobArray(aid int64) {
+	arraysMu.Lock()
+	defer arraysMu.Unlock()
+
+	summary, err := srv.getJobArraySummary(aid)
+	if err != nil {
+		log.Printf("[Arrays] failed to retrieve job array %d: %v", aid, err)
+
+		return
+	}
+	if summary.Status != "running" || summary.Finished < summary.Size {
+		return
+	}
+
+	succeeded := summary.Statuses["completed"] + summary.Statuses["cached"]
+	final := "completed"
+	if succeeded != summary.Size {
+		final = "failed"
+	}
+	log.Printf("[Arrays] job array %d finished: %s (%d/%d completed)", aid, final, succeeded, summary.Size)
+	err = srv.updateJobArrayStatus(aid, final)
+	if err != nil {
+		log.Printf("[Arrays] failed to mark job array %d as %s: %v", aid, final, err)
+	}
+}
+
+// cancelJobArray cancels every unfinished child of an array, returns how many were canceled
+/*
+	the array is marked as canceled first, so that the children finishing meanwhile
+	do not get to decide its final status
+*/
+func (srv *UService) cancelJobArray(a ut.JobArray) (int, error) {
+	arraysMu.Lock()
+	err := srv.updateJobArrayStatus(a.AID, "canceled")
+	arraysMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	canceled := 0
+	var errs []error
+	for _, child := range a.Children {
+		if !jobActive(child.Status) {
+			continue
+		}
+		err = srv.jdp.RemoveJob(int(child.JID))
+		switch {
+		case err == nil:
+			canceled++
+		case errors.Is(err, errJobNotActive):
+			// finished meanwhile
+		default:
+			log.Printf("[Arrays] failed to cancel job %d of array %d: %v", child.JID, a.AID, err)
+			errs = append(errs, err)
+		}
+	}
+	log.Printf("[Arrays] job array %d canceled, %d job(s) stopped", a.AID, canceled)
+
+	return canceled, errors.Join(errs...)
+}
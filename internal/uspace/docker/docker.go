@@ -0,0 +1,416 @@
+// Package docker defines a minimal docker Engine API client, talking http over the engine's unix socket
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIVersion is the Engine API version requested, supported by docker 20.10 onwards
+const APIVersion = "v1.41"
+
+// DefaultHost is where the engine listens by default
+const DefaultHost = "unix:///var/run/docker.sock"
+
+var (
+	// ErrNotFound is returned when the container (or image) does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when the request conflicts with the state of the container
+	// (e.g. a name already in use, or killing a container which is not running)
+	ErrConflict = errors.New("conflict")
+)
+
+// Client struct, talks to a docker engine
+type Client struct {
+	http *http.Client
+	base string // url of the api, version included
+}
+
+// ContainerConfig struct, what a container is created out of
+type ContainerConfig struct {
+	Image       string            `json:"Image"`
+	Cmd         []string          `json:"Cmd,omitempty"`
+	Env         []string          `json:"Env,omitempty"`
+	WorkingDir  string            `json:"WorkingDir,omitempty"`
+	OpenStdin   bool              `json:"OpenStdin,omitempty"`   // keep stdin open, see AttachStdin
+	StdinOnce   bool              `json:"StdinOnce,omitempty"`   // close stdin once its attached stream is closed
+	AttachStdin bool              `json:"AttachStdin,omitempty"` // attach stdin when started
+	Labels      map[string]string `json:"Labels,omitempty"`
+	HostConfig  HostConfig        `json:"HostConfig"`
+}
+
+// HostConfig struct, the host related part of a container configuration
+type HostConfig struct {
+	Binds      []string `json:"Binds,omitempty"`
+	NanoCPUs   int64    `json:"NanoCpus,omitempty"`   // cpu quota in units of 1e-9 cpus
+	Memory     int64    `json:"Memory,omitempty"`     // memory limit in bytes
+	MemorySwap int64    `json:"MemorySwap,omitempty"` // memory + swap limit, equal to Memory for no swap
+}
+
+// ContainerState struct, the state of a container as inspected
+type ContainerState struct {
+	Status     string `json:"Status"` // created, running, paused, restarting, removing, exited or dead
+	Running    bool   `json:"Running"`
+	OOMKilled  bool   `json:"OOMKilled"`
+	ExitCode   int    `json:"ExitCode"`
+	Error      string `json:"Error"`
+	StartedAt  string `json:"StartedAt"`
+	FinishedAt string `json:"FinishedAt"`
+}
+
+// NewClient function creates a client of the engine listening on host,
+// either unix:///path/to/docker.sock, tcp://host:port or http://host:port
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = DefaultHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+
+		return &Client{http: &http.Client{Transport: transport}, base: "http://docker/" + APIVersion}, nil
+	case "tcp", "http":
+		return &Client{http: &http.Client{}, base: "http://" + u.Host + "/" + APIVersion}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q", u.Scheme)
+	}
+}
+
+// apiError is the error body of the engine
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// request performs a call to the engine, the response body is the caller's to close
+// unless an error is returned
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return c.do(req)
+}
+
+// do performs a request to the engine, turning error responses into errors
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	method, path := req.Method, strings.TrimPrefix(req.URL.Path, "/"+APIVersion)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker engine unreachable: %w", err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var apiErr apiError
+	data, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, apiErr.Message)
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%w: %s", ErrConflict, apiErr.Message)
+	default:
+		return nil, fmt.Errorf("docker engine: %s %s: %d: %s", method, path, resp.StatusCode, apiErr.Message)
+	}
+}
+
+// call performs a call to the engine, decoding the response into out (if not nil)
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// CreateContainer method creates a named container, returns its id
+func (c *Client) CreateContainer(ctx context.Context, name string, config ContainerConfig) (string, error) {
+	var created struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}
+	err := c.call(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, config, &created)
+	if err != nil {
+		return "", err
+	}
+
+	return created.ID, nil
+}
+
+// StartContainer method starts a created container
+func (c *Client) StartContainer(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+// WaitContainer method blocks until the container stops, returns its exit code
+func (c *Client) WaitContainer(ctx context.Context, id string) (int, error) {
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	err := c.call(ctx, http.MethodPost, "/containers/"+id+"/wait", url.Values{"condition": {"not-running"}}, nil, &result)
+	if err != nil {
+		return -1, err
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return result.StatusCode, fmt.Errorf("docker engine: wait: %s", result.Error.Message)
+	}
+
+	return result.StatusCode, nil
+}
+
+// InspectContainer method returns the state of a container
+func (c *Client) InspectContainer(ctx context.Context, id string) (ContainerState, error) {
+	var inspected struct {
+		State ContainerState `json:"State"`
+	}
+	err := c.call(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, &inspected)
+
+	return inspected.State, err
+}
+
+// KillContainer method kills a running container
+func (c *Client) KillContainer(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodPost, "/containers/"+id+"/kill", nil, nil, nil)
+}
+
+// RemoveContainer method removes a container (and its anonymous volumes) whatever its state,
+// a container that does not exist is not an error
+func (c *Client) RemoveContainer(ctx context.Context, id string) error {
+	err := c.call(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"true"}, "v": {"true"}}, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// ContainerLogs method streams the stdout and stderr of a container (see Demux), following it if asked,
+// only what was written after since if not zero
+func (c *Client) ContainerLogs(ctx context.Context, id string, follow bool, since time.Time) (io.ReadCloser, error) {
+	query := url.Values{
+		"stdout": {"true"},
+		"stderr": {"true"},
+		"follow": {strconv.FormatBool(follow)},
+	}
+	if !since.IsZero() {
+		query.Set("since", fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()))
+	}
+	resp, err := c.request(ctx, http.MethodGet, "/containers/"+id+"/logs", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// AttachStdin method attaches to the stdin of a container created with OpenStdin (to be done before starting it),
+// what is written to the returned stream is fed to the container, closing it closes the stdin of a StdinOnce container
+func (c *Client) AttachStdin(ctx context.Context, id string) (io.WriteCloser, error) {
+	query := url.Values{"stream": {"true"}, "stdin": {"true"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/containers/"+id+"/attach?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// the engine hijacks the connection once upgraded
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		_ = resp.Body.Close()
+
+		return nil, fmt.Errorf("docker engine: attach: connection not upgraded (%d)", resp.StatusCode)
+	}
+
+	return stream, nil
+}
+
+// ContainerStats struct, a sample of the resource usage of a container, counters are cumulative
+type ContainerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"` // in nanoseconds
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage    uint64 `json:"usage"`
+		MaxUsage uint64 `json:"max_usage"` // not reported on cgroup v2
+	} `json:"memory_stats"`
+	BlkioStats struct {
+		IoServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+// Memory method returns the highest memory usage the sample tells of
+func (s ContainerStats) Memory() uint64 {
+	return max(s.MemoryStats.Usage, s.MemoryStats.MaxUsage)
+}
+
+// IO method returns the bytes read from and written to block devices so far
+func (s ContainerStats) IO() (uint64, uint64) {
+	var read, written uint64
+	for _, entry := range s.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			read += entry.Value
+		case "write":
+			written += entry.Value
+		}
+	}
+
+	return read, written
+}
+
+// StreamStats method calls fn with a sample of the usage of a container about every second,
+// until the container stops or ctx is done
+func (c *Client) StreamStats(ctx context.Context, id string, fn func(ContainerStats)) error {
+	resp, err := c.request(ctx, http.MethodGet, "/containers/"+id+"/stats", url.Values{"stream": {"true"}}, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var stats ContainerStats
+		err = decoder.Decode(&stats)
+		if err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+		fn(stats)
+	}
+}
+
+// PullImage method pulls an image (name[:tag]), the latest tag unless stated otherwise
+func (c *Client) PullImage(ctx context.Context, image string) error {
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	resp, err := c.request(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {name}, "tag": {tag}}, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// progress messages, failures are reported in the stream
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &msg) == nil && msg.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", image, msg.Error)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Demux splits a multiplexed log stream (of a container without a tty) into stdout and stderr
+/*
+	every frame has an 8 byte header: [stream, 0, 0, 0, size (4 bytes, big endian)],
+	stream being 1 for stdout and 2 for stderr
+*/
+func Demux(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case 0, 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			return fmt.Errorf("unexpected stream type %d", header[0])
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		_, err = io.CopyN(w, r, size)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package uspace
+
+/*
+	job admission
+
+	the executors read and write the storage with the credentials of the service, so a job is only admitted
+	if its submitter could do the same by themselves: every input (as resolved, see resolveJobInputs) must be
+	readable and an already existing output writable under the access claim of the caller.
+	What a glob or a directory prefix matches may change until the job runs, so the inputs are checked
+	once more as resolved for the run, against the identity it runs as (see resolveRunInputs).
+	The job is owned by the caller (see bindJobOwner), the limits of its user and group apply.
+
+	a new output must go where the caller could write it: every object on record in the nearest
+	enclosing directory of the output holding any (for a directory output, the directory itself first)
+	must be writable by them, so that no one adds to a directory of someone else.
+	An output with nothing enclosing it is checked against its volume, which must exist.
+
+	jobs out of a template (workflow nodes, schedules) are admitted upon the submission of the template
+	and once more, as the identity it was admitted as (see templateClaim), whenever a job is made out of it.
+*/
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+var errJobAccess = errors.New("job not allowed")
+
+// admitJob records the identity a job runs as and verifies it may access the storage the job touches,
+// root may run anything on behalf of anyone. The webhooks of the job must target allowed destinations, whoever submits it.
+func (srv *UService) admitJob(job *ut.Job, ac ut.AccessClaim) error {
+	for i := range job.Webhooks {
+		err := job.Webhooks[i].Validate(srv.webhookHosts()...)
+		if err != nil {
+			return err
+		}
+	}
+
+	job.RunAs = ac.UID + ":" + ac.Gids
+	if ac.UID == "0" {
+		return nil
+	}
+	err := bindJobOwner(ac, &job.UID, &job.GID)
+	if err != nil {
+		return err
+	}
+
+	if len(job.InputList()) > 0 {
+		inputs, err := srv.resolveJobInputs(*job)
+		if err != nil {
+			return err
+		}
+		err = srv.checkJobInputs(inputs, ac)
+		if err != nil {
+			return err
+		}
+	}
+
+	if output := strings.TrimSpace(job.Output); output != "" {
+		return srv.admitJobOutput(*job, output, ac)
+	}
+
+	return nil
+}
+
+// checkJobInputs verifies the caller may read every resolved input
+func (srv *UService) checkJobInputs(inputs []jobInput, ac ut.AccessClaim) error {
+	for _, in := range inputs {
+		resource, found, err := srv.lookupResource(in.Bucket, in.Object)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: input %s/%s has no owner on record", errJobAccess, in.Bucket, in.Object)
+		}
+		if !resource.HasAccess(ac) {
+			return fmt.Errorf("%w: no read access on input %s/%s", errJobAccess, in.Bucket, in.Object)
+		}
+	}
+
+	return nil
+}
+
+// resolveRunInputs resolves the inputs of a job about to run, each one readable by the identity it runs as
+func (srv *UService) resolveRunInputs(job ut.Job) ([]jobInput, error) {
+	inputs, err := srv.resolveJobInputs(job)
+	if err != nil {
+		return nil, err
+	}
+	ac := runAsClaim(job)
+	if ac.UID == "0" {
+		return inputs, nil
+	}
+
+	return inputs, srv.checkJobInputs(inputs, ac)
+}
+
+// admitJobOutput verifies the caller may write the output of a job, an existing object or a new one
+func (srv *UService) admitJobOutput(job ut.Job, output string, ac ut.AccessClaim) error {
+	vname, name := srv.splitObjectPath(output)
+	name = strings.TrimPrefix(name, "/")
+	if !job.OutputIsDir() {
+		resource, found, err := srv.lookupResource(vname, name)
+		if err != nil {
+			return err
+		}
+		if found {
+			if !resource.HasWriteAccess(ac) {
+				return fmt.Errorf("%w: no write access on output %s", errJobAccess, output)
+			}
+
+			return nil
+		}
+		name = path.Dir(name) + "/"
+	}
+
+	// the nearest enclosing directory holding anything
+	for dir := name; dir != "./" && dir != "/"; dir = path.Dir(strings.TrimSuffix(dir, "/")) + "/" {
+		objects, err := srv.listObjectResources(vname, dir)
+		if err != nil {
+			return fmt.Errorf("failed to list %s/%s: %w", vname, dir, err)
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		for _, object := range objects {
+			resource, found, err := srv.lookupResource(vname, object.Name)
+			if err != nil {
+				return err
+			}
+			if !found || !resource.HasWriteAccess(ac) {
+				return fmt.Errorf("%w: no write access on %s/%s, in the directory of output %s",
+					errJobAccess, vname, object.Name, output)
+			}
+		}
+
+		return nil
+	}
+
+	return srv.checkOutputVolume(output)
+}
+
+// templateClaim returns the access claim a job out of a template (a workflow node, a schedule) is admitted as:
+// the identity the template was admitted as, its owner for a template submitted before it was recorded
+func templateClaim(job ut.Job, uid, gid int) ut.AccessClaim {
+	if job.RunAs == "" {
+		return ut.AccessClaim{UID: strconv.Itoa(uid), Gids: strconv.Itoa(gid)}
+	}
+
+	return runAsClaim(job)
+}
+
+// bindJobOwner sets the owner of a job (or of a template of jobs) to the caller: its uid and its primary group,
+// the first of its groups. What the body of a request says is ignored, root aside, which may act on behalf of anyone.
+func bindJobOwner(ac ut.AccessClaim, uid, gid *int) error {
+	if ac.UID == "0" {
+		return nil
+	}
+	u, err := strconv.Atoi(strings.TrimSpace(ac.UID))
+	if err != nil {
+		return fmt.Errorf("%w: invalid uid %q", errJobAccess, ac.UID)
+	}
+	primary, _, _ := strings.Cut(ac.Gids, ",")
+	g, err := strconv.Atoi(strings.TrimSpace(primary))
+	if err != nil {
+		return fmt.Errorf("%w: invalid gids %q", errJobAccess, ac.Gids)
+	}
+	*uid, *gid = u, g
+
+	return nil
+}
+
+// lookupResource returns the record of an object, if there is one,
+// uploads are recorded with a leading slash while job outputs are not
+func (srv *UService) lookupResource(vname, name string) (ut.Resource, bool, error) {
+	for _, n := range []string{name, "/" + name} {
+		res, err := srv.fsl.SelectObjects(map[string]any{"name": n, "volume": vname})
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return ut.Resource{}, false, fmt.Errorf("failed to look up %s/%s: %w", vname, name, err)
+		}
+		resource, ok := res.(ut.Resource)
+		if !ok {
+			return ut.Resource{}, false, errors.New("failed to cast the resource")
+		}
+
+		return resource, true, nil
+	}
+
+	return ut.Resource{}, false, nil
+}
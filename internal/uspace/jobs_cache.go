@@ -0,0 +1,169 @@
+package uspace
+
+/*
+	job result caching
+
+	with J_CACHE enabled every job is fingerprinted right before it runs: a sha256 over
+	what it computes (its logic, code, formats, params and env) and the checksums of
+	the objects its inputs resolve to, as reported by the storage.
+
+	a job whose fingerprint matches a completed (or cached) job whose output still exists
+	is not run, the output of the previous job is copied over to its own output
+	and the job is marked as "cached". Jobs writing a directory are never cached.
+	A job with noCache set always runs, its fingerprint is recorded nonetheless.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// fingerprintInput is an input of a job as taken into account by its fingerprint
+type fingerprintInput struct {
+	Bucket   string `json:"bucket"`
+	Object   string `json:"object"`
+	Name     string `json:"name"`
+	Checksum string `json:"checksum"`
+}
+
+// jobFingerprint computes the fingerprint of a job against the current content of its inputs
+func (srv *UService) jobFingerprint(job ut.Job) (string, error) {
+	inputs, err := srv.resolveJobInputs(job)
+	if err != nil {
+		return "", err
+	}
+	fpInputs := make([]fingerprintInput, 0, len(inputs))
+	for _, in := range inputs {
+		checksum, err := srv.storage.Checksum(ut.Resource{Vname: in.Bucket, Name: in.Object})
+		if err != nil {
+			return "", fmt.Errorf("failed to checksum %s/%s: %w", in.Bucket, in.Object, err)
+		}
+		fpInputs = append(fpInputs, fingerprintInput{Bucket: in.Bucket, Object: in.Object, Name: in.Name, Checksum: checksum})
+	}
+
+	// params are stored comma separated, an empty list reads back as [""]
+	var params []string
+	for _, p := range job.Params {
+		if p != "" {
+			params = append(params, p)
+		}
+	}
+	env := make(map[string]string, len(job.Env))
+	for k, v := range job.Env {
+		// identifies the submission, not what is computed
+		if k == "ARRAY_ID" {
+			continue
+		}
+		env[k] = v
+	}
+
+	data, err := json.Marshal(struct {
+		Logic        string             `json:"logic"`
+		LogicHeaders string             `json:"logicHeaders"`
+		LogicBody    string             `json:"logicBody"`
+		InputFormat  string             `json:"inputFormat"`
+		OutputFormat string             `json:"outputFormat"`
+		Params       string             `json:"params"`
+		Env          map[string]string  `json:"env"`
+		Inputs       []fingerprintInput `json:"inputs"`
+	}{
+		Logic:        strings.TrimSpace(job.Logic),
+		LogicHeaders: job.LogicHeaders,
+		LogicBody:    job.LogicBody,
+		InputFormat:  job.InputFormat,
+		OutputFormat: job.OutputFormat,
+		Params:       strings.Join(params, ","),
+		Env:          env, // keys are sorted
+		Inputs:       fpInputs,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// serveFromCache fingerprints a job about to run and, if an identical job already produced its output,
+// reuses it: returns true if the job was finished out of the cache and should not be run
+func (jm *JobManager) serveFromCache(job ut.Job) bool {
+	// what an interactive job computes depends on what it is fed, it is neither served nor fingerprinted,
+	// nor is a job writing a directory, whose content is not known up front
+	if !jm.srv.config.UspaceJobCache || job.Interactive || job.OutputIsDir() {
+		return false
+	}
+
+	fingerprint, err := jm.srv.jobFingerprint(job)
+	if err != nil {
+		log.Printf("[Cache] failed to fingerprint job ID=%d, running it: %v", job.JID, err)
+
+		return false
+	}
+
+	var from ut.Job
+	if !job.NoCache {
+		from = jm.srv.reuseJobOutput(job, fingerprint)
+	}
+	err = jm.srv.updateJobFingerprint(job.JID, fingerprint, from.JID)
+	if err != nil {
+		log.Printf("[Cache] failed to record the fingerprint of job ID=%d: %v", job.JID, err)
+	}
+	if from.JID == 0 {
+		return false
+	}
+
+	log.Printf("[Cache] job ID=%d served from the cache, output of job ID=%d reused", job.JID, from.JID)
+	artifacts, err := jm.srv.registerJobOutputs(job)
+	if err != nil {
+		log.Printf("[Cache] failed to register the output of job ID=%d: %v", job.JID, err)
+	}
+	jm.srv.recordJobArtifacts(job.JID, artifacts)
+	jm.finishJob(job.JID, "cached", 0)
+	go jm.srv.notifyJobSocket(job.JID,
+		fmt.Sprintf("[executor] Job %d is identical to job %d, its output %s was reused\n", job.JID, from.JID, from.Output))
+
+	return true
+}
+
+// reuseJobOutput copies the output of the latest successful job with the given fingerprint
+// to the output of the job, returns the job whose output was reused (zero if none)
+func (srv *UService) reuseJobOutput(job ut.Job, fingerprint string) ut.Job {
+	candidates, err := srv.getJobsByFingerprint(fingerprint, job.JID)
+	if err != nil {
+		log.Printf("[Cache] failed to look up jobs identical to job ID=%d: %v", job.JID, err)
+
+		return ut.Job{}
+	}
+
+	dstVolume, dstName := srv.splitObjectPath(job.Output)
+	dst := ut.Resource{Vname: dstVolume, Name: strings.TrimPrefix(dstName, "/")}
+	for _, prev := range candidates {
+		srcVolume, srcName := srv.splitObjectPath(prev.Output)
+		src := ut.Resource{Vname: srcVolume, Name: strings.TrimPrefix(srcName, "/")}
+		_, err = srv.storage.Stat(src)
+		if err != nil {
+			// removed since
+			log.Printf("[Cache] output %s of job ID=%d is gone: %v", prev.Output, prev.JID, err)
+
+			continue
+		}
+		if src.Vname != dst.Vname || src.Name != dst.Name {
+			err = srv.storage.Copy(src, dst)
+			if err != nil {
+				log.Printf("[Cache] failed to copy the output of job ID=%d to %s: %v", prev.JID, job.Output, err)
+
+				continue
+			}
+		}
+
+		return prev
+	}
+
+	return ut.Job{}
+}
@@ -13,6 +13,8 @@ package uspace
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	ut "kyri56xcaesar/kuspace/internal/utils"
 	"log"
@@ -25,6 +27,7 @@ const (
 	CREATE TABLE IF NOT EXISTS jobs (
 		jid INTEGER PRIMARY KEY,
 		uid INTEGER,
+		gid INTEGER,
 		description TEXT,
 		duration FLOAT,
 		input TEXT,
@@ -46,7 +49,41 @@ const (
 		memoryLimit TEXT,
 		cpuLimit TEXT,
 		ephimeralStorageRequest TEXT,
-		ephimeralStorageLimit TEXT
+		ephimeralStorageLimit TEXT,
+		retryPolicy TEXT,
+		attempts INTEGER,
+		env TEXT,
+		noCache BOOLEAN,
+		fingerprint TEXT,
+		cachedFrom INTEGER,
+		templateId INTEGER,
+		templateVersion INTEGER,
+		cpuSeconds FLOAT,
+		peakMemory BIGINT,
+		bytesRead BIGINT,
+		bytesWritten BIGINT,
+		webhooks TEXT,
+		runAs TEXT,
+		interactive BOOLEAN,
+		logObject TEXT
+	);
+	CREATE TABLE IF NOT EXISTS job_attempts (
+		jid INTEGER,
+		attempt INTEGER,
+		status TEXT,
+		failureClass TEXT,
+		reason TEXT,
+		duration FLOAT,
+		finishedAt DATETIME,
+		PRIMARY KEY (jid, attempt)
+	);
+	CREATE TABLE IF NOT EXISTS job_artifacts (
+		jid INTEGER,
+		volume TEXT,
+		name TEXT,
+		size BIGINT,
+		createdAt DATETIME,
+		PRIMARY KEY (jid, volume, name)
 	);
 	CREATE TABLE IF NOT EXISTS apps (
 		id INTEGER PRIMARY KEY,
@@ -60,8 +97,140 @@ const (
 		insertedAt DATETIME,
 		createdAt DATETIME
 	);
+	CREATE TABLE IF NOT EXISTS workflows (
+		wid INTEGER PRIMARY KEY,
+		uid INTEGER,
+		gid INTEGER,
+		name TEXT,
+		description TEXT,
+		status TEXT,
+		createdAt DATETIME,
+		completedAt DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS workflow_nodes (
+		wid INTEGER,
+		idx INTEGER,
+		name TEXT,
+		dependsOn TEXT,
+		spec TEXT,
+		jid INTEGER,
+		status TEXT,
+		PRIMARY KEY (wid, name)
+	);
+	CREATE TABLE IF NOT EXISTS job_arrays (
+		aid INTEGER PRIMARY KEY,
+		uid INTEGER,
+		gid INTEGER,
+		name TEXT,
+		spec TEXT,
+		size INTEGER,
+		status TEXT,
+		createdAt DATETIME,
+		completedAt DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS job_array_children (
+		aid INTEGER,
+		idx INTEGER,
+		jid INTEGER,
+		PRIMARY KEY (aid, idx)
+	);
+	CREATE TABLE IF NOT EXISTS schedules (
+		sid INTEGER PRIMARY KEY,
+		uid INTEGER,
+		gid INTEGER,
+		name TEXT,
+		cron TEXT,
+		spec TEXT,
+		enabled BOOLEAN,
+		lastRun DATETIME,
+		nextRun DATETIME,
+		createdAt DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS schedule_runs (
+		sid INTEGER,
+		jid INTEGER,
+		scheduledAt DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS job_templates (
+		tid INTEGER,
+		version INTEGER,
+		uid INTEGER,
+		gid INTEGER,
+		shared BOOLEAN,
+		name TEXT,
+		description TEXT,
+		spec TEXT,
+		createdAt DATETIME,
+		PRIMARY KEY (tid, version)
+	);
+	CREATE TABLE IF NOT EXISTS webhooks (
+		wid INTEGER PRIMARY KEY,
+		uid INTEGER,
+		url TEXT,
+		events TEXT,
+		secret TEXT,
+		createdAt DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		did INTEGER PRIMARY KEY,
+		wid INTEGER,
+		jid INTEGER,
+		attempt INTEGER,
+		event TEXT,
+		url TEXT,
+		payload TEXT,
+		status TEXT,
+		tries INTEGER,
+		responseCode INTEGER,
+		error TEXT,
+		createdAt DATETIME,
+		updatedAt DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS job_events (
+		eid INTEGER PRIMARY KEY,
+		jid INTEGER,
+		fromStatus TEXT,
+		toStatus TEXT,
+		actor TEXT,
+		reason TEXT,
+		at DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS runtimes (
+		name TEXT PRIMARY KEY,
+		aliases TEXT,
+		image TEXT,
+		extension TEXT,
+		skeleton TEXT,
+		command TEXT,
+		createdAt DATETIME
+	);
+	-- columns added later on, existing databases are migrated in place
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS gid INTEGER;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS retryPolicy TEXT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS attempts INTEGER;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS env TEXT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS noCache BOOLEAN;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS fingerprint TEXT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cachedFrom INTEGER;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS templateId INTEGER;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS templateVersion INTEGER;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cpuSeconds FLOAT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS peakMemory BIGINT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS bytesRead BIGINT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS bytesWritten BIGINT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS webhooks TEXT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS runAs TEXT;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS interactive BOOLEAN;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS logObject TEXT;
 	CREATE SEQUENCE IF NOT EXISTS seq_jobid START 1;
 	CREATE SEQUENCE IF NOT EXISTS seq_appid START 1;
+	CREATE SEQUENCE IF NOT EXISTS seq_workflowid START 1;
+	CREATE SEQUENCE IF NOT EXISTS seq_scheduleid START 1;
+	CREATE SEQUENCE IF NOT EXISTS seq_arrayid START 1;
+	CREATE SEQUENCE IF NOT EXISTS seq_templateid START 1;
+	CREATE SEQUENCE IF NOT EXISTS seq_webhookid START 1;
+	CREATE SEQUENCE IF NOT EXISTS seq_deliveryid START 1;
+	CREATE SEQUENCE IF NOT EXISTS seq_eventid START 1;
 `
 )
 
@@ -76,25 +245,33 @@ func (srv *UService) insertJob(jb ut.Job) (int64, error) {
 
 	query := `
 		INSERT INTO 
-			jobs (jid, uid, description, duration, input, inputFormat, output, outputFormat, logic, logicBody,
+			jobs (jid, uid, gid, description, duration, input, inputFormat, output, outputFormat, logic, logicBody,
 			 logicHeaders, parameters, status, completed, createdAt, parallelism, priority, memoryRequest, cpuRequest,
-			  memoryLimit, cpuLimit, ephimeralStorageRequest, ephimeralStorageLimit)
+			  memoryLimit, cpuLimit, ephimeralStorageRequest, ephimeralStorageLimit, retryPolicy, attempts, env, noCache,
+			   templateId, templateVersion, webhooks, runAs, interactive)
 		VALUES
-			(nextval('seq_jobid'), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			(nextval('seq_jobid'), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?)
 		RETURNING (jid);`
 
 	var jid int64
-	err = db.QueryRow(query, jb.UID, jb.Description, jb.Duration, jb.Input,
+	err = db.QueryRow(query, jb.UID, jb.GID, jb.Description, jb.Duration, strings.Join(jb.InputList(), ","),
 		jb.InputFormat, jb.Output, jb.OutputFormat, jb.Logic, jb.LogicBody,
 		jb.LogicHeaders, strings.Join(jb.Params, ","), "pending", jb.Completed,
 		ut.CurrentTime(), jb.Parallelism, jb.Priority, jb.MemoryRequest, jb.CPURequest,
-		jb.MemoryLimit, jb.CPULimit, jb.EphimeralStorageRequest, jb.EphimeralStorageLimit).Scan(&jid)
+		jb.MemoryLimit, jb.CPULimit, jb.EphimeralStorageRequest, jb.EphimeralStorageLimit,
+		encodeRetryPolicy(jb.Retry), encodeJobEnv(jb.Env), jb.NoCache,
+		jb.TemplateID, jb.TemplateVersion, encodeJobWebhooks(jb.Webhooks), jb.RunAs, jb.Interactive).Scan(&jid)
 	if err != nil {
 		log.Printf("failed to execute query: %v", err)
 
 		return -1, fmt.Errorf("failed to execute query: %w", err)
 	}
 
+	err = insertJobEvent(db, ut.JobEvent{JID: jid, To: "pending", Actor: ut.ActorUser, Reason: "submitted"})
+	if err != nil {
+		return jid, err
+	}
+
 	if verbose {
 		log.Printf("[Database] Inserted job id: %v", jid)
 	}
@@ -110,14 +287,6 @@ func (srv *UService) insertJobs(jobs []ut.Job) error {
 
 		return fmt.Errorf("failed to retrieve db conn: %w", err)
 	}
-	query := `
-		INSERT INTO 
-			jobs (jid, uid, description, duration, input, inputFormat, output, outputFormat, logic,
-			 logicBody, logicHeaders, parameters, status, completed, createdAt, parallelism, priority,
-			  memoryRequest, cpuRequest, memoryLimit, cpuLimit, ephimeralStorageRequest, ephimeralStorageLimit)
-		VALUES
-			(nextval('seq_jobid'), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		RETURNING (jid);`
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -126,6 +295,37 @@ func (srv *UService) insertJobs(jobs []ut.Job) error {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
+	err = insertJobsTx(tx, jobs)
+	if err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("failed to rollback: %v", rerr)
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// insertJobsTx inserts the given jobs as "pending" within a transaction, setting their jids
+func insertJobsTx(tx *sql.Tx, jobs []ut.Job) error {
+	query := `
+		INSERT INTO 
+			jobs (jid, uid, gid, description, duration, input, inputFormat, output, outputFormat, logic,
+			 logicBody, logicHeaders, parameters, status, completed, createdAt, parallelism, priority,
+			  memoryRequest, cpuRequest, memoryLimit, cpuLimit, ephimeralStorageRequest, ephimeralStorageLimit,
+			   retryPolicy, attempts, env, noCache, templateId, templateVersion, webhooks, runAs, interactive)
+		VALUES
+			(nextval('seq_jobid'), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING (jid);`
+
 	stmt, err := tx.Prepare(query)
 	if err != nil {
 		log.Printf("failed to prepare statement: %v", err)
@@ -144,26 +344,23 @@ func (srv *UService) insertJobs(jobs []ut.Job) error {
 		jb := &(jobs)[i]
 
 		var jid int64
-		err = db.QueryRow(query, jb.UID, jb.Description, jb.Duration, jb.Input, jb.InputFormat, jb.Output,
+		err = stmt.QueryRow(jb.UID, jb.GID, jb.Description, jb.Duration, strings.Join(jb.InputList(), ","),
+			jb.InputFormat, jb.Output,
 			jb.OutputFormat, jb.Logic, jb.LogicBody, jb.LogicHeaders, strings.Join(jb.Params, ","), "pending",
-			jb.Completed, currentTime).Scan(&jid)
+			jb.Completed, currentTime, jb.Parallelism, jb.Priority, jb.MemoryRequest, jb.CPURequest,
+			jb.MemoryLimit, jb.CPULimit, jb.EphimeralStorageRequest, jb.EphimeralStorageLimit,
+			encodeRetryPolicy(jb.Retry), encodeJobEnv(jb.Env), jb.NoCache,
+			jb.TemplateID, jb.TemplateVersion, encodeJobWebhooks(jb.Webhooks), jb.RunAs, jb.Interactive).Scan(&jid)
 		if err != nil {
-			err = tx.Rollback()
-			if err != nil {
-				return err
-			}
 			log.Printf("failed to execute statement: %v", err)
 
 			return fmt.Errorf("failed to execute insertion query: %w", err)
 		}
 		jb.JID = jid
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		log.Printf("failed to commit transaction: %v", err)
-
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		err = insertJobEvent(tx, ut.JobEvent{JID: jid, To: "pending", Actor: ut.ActorUser, Reason: "submitted"})
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -243,6 +440,153 @@ func (srv *UService) removeJobs(jids []int) error {
 	return nil
 }
 
+// jobColumns are the columns a job is read from, in the order scanJob expects them
+const jobColumns = `jid, uid, gid, description, duration, input, inputFormat, output, outputFormat, logic,
+			logicBody, logicHeaders, parameters, status, completed, completedAt, createdAt, parallelism,
+			priority, memoryRequest, cpuRequest, memoryLimit, cpuLimit, ephimeralStorageRequest,
+			ephimeralStorageLimit, retryPolicy, attempts, env, noCache, fingerprint, cachedFrom, templateId,
+			templateVersion, cpuSeconds, peakMemory, bytesRead, bytesWritten, webhooks, runAs, interactive,
+			logObject`
+
+// rowScanner is either an *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row rowScanner) (ut.Job, error) {
+	var (
+		job                    ut.Job
+		gid                    sql.NullInt64
+		params                 string
+		completedAt, createdAt sql.NullString
+		retryPolicy, env       sql.NullString
+		webhooks, runAs        sql.NullString
+		logObject              sql.NullString
+		fingerprint            sql.NullString
+		attempts, cachedFrom   sql.NullInt64
+		tid, tversion          sql.NullInt64
+		noCache, interactive   sql.NullBool
+		cpuSeconds             sql.NullFloat64
+		peakMemory             sql.NullInt64
+		read, written          sql.NullInt64
+	)
+	err := row.Scan(&job.JID, &job.UID, &gid, &job.Description, &job.Duration, &job.Input,
+		&job.InputFormat, &job.Output, &job.OutputFormat, &job.Logic, &job.LogicBody, &job.LogicHeaders,
+		&params, &job.Status, &job.Completed, &completedAt, &createdAt, &job.Parallelism, &job.Priority,
+		&job.MemoryRequest, &job.CPURequest, &job.MemoryLimit, &job.CPULimit, &job.EphimeralStorageRequest,
+		&job.EphimeralStorageLimit, &retryPolicy, &attempts, &env, &noCache, &fingerprint, &cachedFrom,
+		&tid, &tversion, &cpuSeconds, &peakMemory, &read, &written, &webhooks, &runAs, &interactive,
+		&logObject)
+	if err != nil {
+		return job, err
+	}
+
+	job.GID = int(gid.Int64)
+	if completedAt.Valid {
+		job.CompletedAt = completedAt.String
+	}
+	if createdAt.Valid {
+		job.CreatedAt = createdAt.String
+	}
+	job.Params = strings.Split(strings.TrimSpace(params), ",")
+	job.Inputs = job.InputList()
+	job.Attempts = int(attempts.Int64)
+	job.NoCache = noCache.Bool
+	job.Fingerprint = fingerprint.String
+	job.CachedFrom = cachedFrom.Int64
+	job.TemplateID = tid.Int64
+	job.TemplateVersion = int(tversion.Int64)
+	job.RunAs = runAs.String
+	job.Interactive = interactive.Bool
+	job.Log = logObject.String
+	if cpuSeconds.Valid || peakMemory.Valid {
+		job.Usage = &ut.JobUsage{
+			CPUSeconds:   cpuSeconds.Float64,
+			PeakMemory:   peakMemory.Int64,
+			BytesRead:    read.Int64,
+			BytesWritten: written.Int64,
+		}
+	}
+	if retryPolicy.String != "" {
+		job.Retry = &ut.RetryPolicy{}
+		err = json.Unmarshal([]byte(retryPolicy.String), job.Retry)
+		if err != nil {
+			log.Printf("ignoring corrupt retry policy of job %d: %v", job.JID, err)
+			job.Retry = nil
+		}
+	}
+	if env.String != "" {
+		err = json.Unmarshal([]byte(env.String), &job.Env)
+		if err != nil {
+			log.Printf("ignoring corrupt env of job %d: %v", job.JID, err)
+			job.Env = nil
+		}
+	}
+	if webhooks.String != "" {
+		err = json.Unmarshal([]byte(webhooks.String), &job.Webhooks)
+		if err != nil {
+			log.Printf("ignoring corrupt webhooks of job %d: %v", job.JID, err)
+			job.Webhooks = nil
+		}
+	}
+
+	return job, nil
+}
+
+// encodeRetryPolicy returns the policy as stored in the jobs table, empty for none
+func encodeRetryPolicy(policy *ut.RetryPolicy) string {
+	if policy == nil {
+		return ""
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// encodeJobEnv returns the env of a job as stored in the jobs table, empty for none
+func encodeJobEnv(env map[string]string) string {
+	if len(env) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// encodeJobWebhooks returns the webhooks of a job as stored in the jobs table, empty for none
+func encodeJobWebhooks(webhooks []ut.Webhook) string {
+	if len(webhooks) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(webhooks)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+func scanJobs(rows *sql.Rows) ([]ut.Job, error) {
+	var jobs []ut.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
 func (srv *UService) getJobByID(jid int) (ut.Job, error) {
 	var job ut.Job
 	db, err := srv.jdbh.GetConn()
@@ -253,43 +597,23 @@ func (srv *UService) getJobByID(jid int) (ut.Job, error) {
 	}
 	query := `
 		SELECT
-			*
+			` + jobColumns + `
 		FROM
 			jobs
 		WHERE
 			jid = ?`
-	var params string
-	var completedAt, createdAt sql.NullString
 
-	err = db.QueryRow(query, jid).Scan(&job.JID, &job.UID, &job.Description, &job.Duration, &job.Input,
-		&job.InputFormat, &job.Output, &job.OutputFormat, &job.Logic, &job.LogicBody, &job.LogicHeaders,
-		&params, &job.Status, &job.Completed, &completedAt, &createdAt, &job.Parallelism, &job.Priority,
-		&job.MemoryRequest, &job.CPURequest, &job.MemoryLimit, &job.CPULimit, &job.EphimeralStorageRequest,
-		&job.EphimeralStorageLimit)
+	job, err = scanJob(db.QueryRow(query, jid))
 	if err != nil {
 		log.Printf("failed to query row: %v", err)
 
 		return job, fmt.Errorf("failed to query row: %w", err)
 	}
 
-	if completedAt.Valid {
-		job.CompletedAt = completedAt.String
-	} else {
-		job.CompletedAt = ""
-	}
-	if createdAt.Valid {
-		job.CreatedAt = createdAt.String
-	} else {
-		job.CreatedAt = ""
-	}
-
-	job.Params = strings.Split(strings.TrimSpace(params), ",")
-
 	return job, nil
 }
 
 func (srv *UService) getJobsByUID(uid int) ([]ut.Job, error) {
-	var jobs []ut.Job
 	db, err := srv.jdbh.GetConn()
 	if err != nil {
 		log.Printf("failed to get database connection: %v", err)
@@ -298,7 +622,7 @@ func (srv *UService) getJobsByUID(uid int) ([]ut.Job, error) {
 	}
 	query := `
 		SELECT
-			*
+			` + jobColumns + `
 		FROM
 			jobs
 		WHERE
@@ -309,40 +633,14 @@ func (srv *UService) getJobsByUID(uid int) ([]ut.Job, error) {
 
 		return nil, fmt.Errorf("failed to query row: %w", err)
 	}
-
-	var (
-		params                 string
-		completedAt, createdAt sql.NullString
-	)
-	for rows.Next() {
-		var job ut.Job
-
-		err = rows.Scan(&job.JID, &job.UID, &job.Description, &job.Duration, &job.Input,
-			&job.InputFormat, &job.Output, &job.OutputFormat, &job.Logic, &job.LogicBody,
-			&job.LogicHeaders, &params, &job.Status, &job.Completed, &completedAt, &createdAt,
-			&job.Parallelism, &job.Priority, &job.MemoryRequest, &job.CPURequest, &job.MemoryLimit,
-			&job.CPULimit, &job.EphimeralStorageRequest, &job.EphimeralStorageLimit)
+	defer func() {
+		err := rows.Close()
 		if err != nil {
-			log.Printf("failed to scan row: %v", err)
-
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
-		if completedAt.Valid {
-			job.CompletedAt = completedAt.String
-		} else {
-			job.CompletedAt = ""
-		}
-		if createdAt.Valid {
-			job.CreatedAt = createdAt.String
-		} else {
-			job.CreatedAt = ""
+			log.Printf("failed to close rows: %v", err)
 		}
+	}()
 
-		job.Params = strings.Split(strings.TrimSpace(params), ",")
-		jobs = append(jobs, job)
-	}
-
-	return jobs, nil
+	return scanJobs(rows)
 }
 
 func (srv *UService) getJobsByUIDs(uids []int) ([]ut.Job, error) {
@@ -370,12 +668,12 @@ func (srv *UService) getJobsByUIDs(uids []int) ([]ut.Job, error) {
 
 	query := fmt.Sprintf(`
 		SELECT
-			*
+			%s
 		FROM
 			jobs
 		WHERE
 			uid IN (%s)`,
-		placeholderStr)
+		jobColumns, placeholderStr)
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -390,43 +688,106 @@ func (srv *UService) getJobsByUIDs(uids []int) ([]ut.Job, error) {
 		}
 	}()
 
-	var (
-		params                 string
-		completedAt, createdAt sql.NullString
-	)
-	for rows.Next() {
-		var job ut.Job
+	return scanJobs(rows)
+}
+
+// getJobsByStatus returns the jobs that are in any of the given states, oldest first
+func (srv *UService) getJobsByStatus(statuses []string) ([]ut.Job, error) {
+	var jobs []ut.Job
+
+	if len(statuses) == 0 {
+		return jobs, nil
+	}
+
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	placeholders := make([]string, len(statuses))
+	args := make([]any, len(statuses))
+	for i, status := range statuses {
+		placeholders[i] = "?"
+		args[i] = status
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			jobs
+		WHERE
+			status IN (%s)
+		ORDER BY
+			jid ASC`,
+		jobColumns, strings.Join(placeholders, ","))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	return scanJobs(rows)
+}
+
+// countQueuedJobs returns the amount of queued jobs on record per limited identity (see keysOf)
+func (srv *UService) countQueuedJobs() (map[limitKey]int, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			uid, gid, COUNT(*)
+		FROM
+			jobs
+		WHERE
+			status = 'queued'
+		GROUP BY
+			uid, gid`)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
 
-		err = rows.Scan(&job.JID, &job.UID, &job.Description, &job.Duration, &job.Input,
-			&job.InputFormat, &job.Output, &job.OutputFormat, &job.Logic, &job.LogicBody,
-			&job.LogicHeaders, &params, &job.Status, &job.Completed, &completedAt, &createdAt,
-			&job.Parallelism, &job.Priority, &job.MemoryRequest, &job.CPURequest, &job.MemoryLimit,
-			&job.CPULimit, &job.EphimeralStorageRequest, &job.EphimeralStorageLimit)
+	queued := make(map[limitKey]int)
+	for rows.Next() {
+		var uid, gid, count int
+		err := rows.Scan(&uid, &gid, &count)
 		if err != nil {
 			log.Printf("failed to scan row: %v", err)
 
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
-		if completedAt.Valid {
-			job.CompletedAt = completedAt.String
-		} else {
-			job.CompletedAt = ""
+		for _, key := range keysOf(ut.Job{UID: uid, GID: gid}) {
+			queued[key] += count
 		}
-		if createdAt.Valid {
-			job.CreatedAt = createdAt.String
-		} else {
-			job.CreatedAt = ""
-		}
-		job.Params = strings.Split(strings.TrimSpace(params), ",")
-
-		jobs = append(jobs, job)
 	}
 
-	return jobs, nil
+	return queued, rows.Err()
 }
 
 func (srv *UService) getAllJobs(limit, offset string) ([]ut.Job, error) {
-	var jobs []ut.Job
 	db, err := srv.jdbh.GetConn()
 	if err != nil {
 		log.Printf("failed to get database connection: %v", err)
@@ -438,20 +799,20 @@ func (srv *UService) getAllJobs(limit, offset string) ([]ut.Job, error) {
 	if limit == "" {
 		query = `
 		SELECT
-			*
+			` + jobColumns + `
 		FROM
 			jobs`
 	} else if offset == "" {
 		query = `
 		SELECT
-			*
+			` + jobColumns + `
 		FROM
 			jobs
 		LIMIT ?;`
 	} else {
 		query = `
 		SELECT
-			*
+			` + jobColumns + `
 		FROM
 			jobs
 		LIMIT ? OFFSET ?;`
@@ -463,41 +824,82 @@ func (srv *UService) getAllJobs(limit, offset string) ([]ut.Job, error) {
 
 		return nil, fmt.Errorf("failed to query row: %w", err)
 	}
-
-	var (
-		params                 string
-		completedAt, createdAt sql.NullString
-	)
-	for rows.Next() {
-		var job ut.Job
-		err = rows.Scan(&job.JID, &job.UID, &job.Description, &job.Duration, &job.Input, &job.InputFormat,
-			&job.Output, &job.OutputFormat, &job.Logic, &job.LogicBody, &job.LogicHeaders, &params, &job.Status,
-			&job.Completed, &completedAt, &createdAt, &job.Parallelism, &job.Priority, &job.MemoryRequest, &job.CPURequest,
-			&job.MemoryLimit, &job.CPULimit, &job.EphimeralStorageRequest, &job.EphimeralStorageLimit)
+	defer func() {
+		err := rows.Close()
 		if err != nil {
-			log.Printf("failed to scan row: %v", err)
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
 
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+	return scanJobs(rows)
+}
+
+// updateJob updates the description and owner of a job along with its status, if set, in a single transaction:
+// nothing is written unless the status change is a legal transition. The duration (in seconds) is kept unless set.
+func (srv *UService) updateJob(jb ut.Job) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	rollback := func() {
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("failed to rollback: %v", rerr)
 		}
-		if completedAt.Valid {
-			job.CompletedAt = completedAt.String
-		} else {
-			job.CompletedAt = ""
+	}
+
+	changed := false
+	if jb.Status != "" {
+		var duration *time.Duration
+		if jb.Duration != 0 {
+			d := time.Duration(jb.Duration * float64(time.Second))
+			duration = &d
 		}
-		if createdAt.Valid {
-			job.CreatedAt = createdAt.String
-		} else {
-			job.CreatedAt = ""
+		changed, err = markJobStatusTx(tx, jb.JID, jb.Status, duration, ut.ActorUser, "set by an admin")
+		if err != nil {
+			rollback()
+
+			return err
 		}
-		job.Params = strings.Split(strings.TrimSpace(params), ",")
+	}
 
-		jobs = append(jobs, job)
+	query := `
+		UPDATE jobs
+		SET
+			description = ?, uid = ?
+		WHERE
+			jid = ?
+	`
+	_, err = tx.Exec(query, jb.Description, jb.UID, jb.JID)
+	if err != nil {
+		rollback()
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
 	}
 
-	return jobs, nil
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if changed {
+		go srv.notifyJobWebhooks(jb.JID, jb.Status)
+	}
+
+	return nil
 }
 
-func (srv *UService) updateJob(jb ut.Job) error {
+func (srv *UService) updateJobPriority(jid int64, priority int) error {
 	db, err := srv.jdbh.GetConn()
 	if err != nil {
 		log.Printf("failed to get database connection: %v", err)
@@ -508,11 +910,11 @@ func (srv *UService) updateJob(jb ut.Job) error {
 	query := `
 		UPDATE jobs
 		SET
-			description = ?, uid = ?, status = ?, completed = ?
+			priority = ?
 		WHERE
 			jid = ?
 	`
-	_, err = db.Exec(query, jb.Description, jb.UID, jb.Status, jb.Completed, jb.JID)
+	_, err = db.Exec(query, priority, jid)
 	if err != nil {
 		log.Printf("failed to execute query: %v", err)
 
@@ -522,48 +924,365 @@ func (srv *UService) updateJob(jb ut.Job) error {
 	return nil
 }
 
-func (srv *UService) markJobStatus(jid int64, status string, duration time.Duration) error {
+// markJobStatus persists the status of a job, once checked against the job state machine (see ut.JobTransitionAllowed),
+// a change of status is recorded in job_events and notified to the webhooks interested in it (see webhooks.go)
+func (srv *UService) markJobStatus(jid int64, status string, duration time.Duration, actor, reason string) error {
 	db, err := srv.jdbh.GetConn()
 	if err != nil {
 		log.Printf("failed to get database connection: %v", err)
 
 		return fmt.Errorf("failed to retrieve db conn: %w", err)
 	}
-	var (
-		completed bool
-		query     string
-	)
 
-	if status == "completed" {
-		completed = true
-		query = `
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	changed, err := markJobStatusTx(tx, jid, status, &duration, actor, reason)
+	if err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("failed to rollback: %v", rerr)
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if changed {
+		go srv.notifyJobWebhooks(jid, status)
+	}
+
+	return nil
+}
+
+// markJobStatusTx checks and persists the status of a job within a transaction, tells if the status changed,
+// a nil duration keeps the one on record
+func markJobStatusTx(tx *sql.Tx, jid int64, status string, duration *time.Duration, actor, reason string) (bool, error) {
+	var current sql.NullString
+	err := tx.QueryRow(`SELECT status FROM jobs WHERE jid = ?`, jid).Scan(&current)
+	if err != nil {
+		log.Printf("failed to query row: %v", err)
+
+		return false, fmt.Errorf("failed to query row: %w", err)
+	}
+	if !ut.JobTransitionAllowed(current.String, status) {
+		return false, fmt.Errorf("%w: %s -> %s", errIllegalTransition, current.String, status)
+	}
+
+	var d any
+	if duration != nil {
+		d = *duration
+	}
+	completed := jobSucceeded(status)
+	if completed {
+		_, err = tx.Exec(`
 		UPDATE jobs
 		SET
-			status = ?, completed = ?, completedAt = ?, duration = ?
+			status = ?, completed = ?, completedAt = ?, duration = COALESCE(?, duration)
 		WHERE
 			jid = ?
-	`
-		_, err = db.Exec(query, status, completed, ut.CurrentTime(), duration, jid)
+	`, status, completed, ut.CurrentTime(), d, jid)
+	} else {
+		_, err = tx.Exec(`
+		UPDATE jobs
+		SET
+			status = ?, completed = ?, duration = COALESCE(?, duration)
+		WHERE
+			jid = ?
+	`, status, completed, d, jid)
+	}
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return false, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	changed := current.String != status
+	if changed {
+		err = insertJobEvent(tx, ut.JobEvent{JID: jid, From: current.String, To: status, Actor: actor, Reason: reason})
 		if err != nil {
-			log.Printf("failed to execute query: %v", err)
+			return false, err
+		}
+	}
+
+	return changed, nil
+}
 
-			return fmt.Errorf("failed to execute query: %w", err)
+// rejectPendingJobs marks the given jobs that never made it to the queue as "rejected",
+// so that they are not picked up again on the next startup
+func (srv *UService) rejectPendingJobs(jobs []ut.Job) {
+	for _, jb := range jobs {
+		err := srv.markJobStatus(jb.JID, "rejected", 0, ut.ActorScheduler, "could not be queued")
+		// jobs that made it to the queue meanwhile are left alone
+		if err != nil && !errors.Is(err, errIllegalTransition) {
+			log.Printf("failed to reject job %d: %v", jb.JID, err)
 		}
-	} else {
-		query = `
+	}
+}
+
+// insertJobAttempt records the outcome of an execution of a job, along with the attempt count of the job
+func (srv *UService) insertJobAttempt(jid int64, attempt ut.JobAttempt, duration time.Duration) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT OR REPLACE INTO
+			job_attempts (jid, attempt, status, failureClass, reason, duration, finishedAt)
+		VALUES
+			(?, ?, ?, ?, ?, ?, ?)`,
+		jid, attempt.Attempt, attempt.Status, attempt.FailureClass, attempt.Reason, duration, ut.CurrentTime())
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	_, err = db.Exec(`UPDATE jobs SET attempts = ? WHERE jid = ?`, attempt.Attempt, jid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// getJobAttempts returns the outcome of every execution of a job, in order
+func (srv *UService) getJobAttempts(jid int64) ([]ut.JobAttempt, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			attempt, status, failureClass, reason, duration, finishedAt
+		FROM
+			job_attempts
+		WHERE
+			jid = ?
+		ORDER BY
+			attempt ASC`, jid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var attempts []ut.JobAttempt
+	for rows.Next() {
+		var (
+			attempt    ut.JobAttempt
+			finishedAt sql.NullString
+		)
+		err = rows.Scan(&attempt.Attempt, &attempt.Status, &attempt.FailureClass, &attempt.Reason,
+			&attempt.Duration, &finishedAt)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		attempt.FinishedAt = finishedAt.String
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, nil
+}
+
+// setJobArtifacts records the objects the output of a job consists of, replacing those of a previous execution
+func (srv *UService) setJobArtifacts(jid int64, artifacts []ut.JobArtifact) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	rollback := func(err error) error {
+		log.Printf("failed to execute query: %v", err)
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("failed to rollback: %v", rerr)
+		}
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM job_artifacts WHERE jid = ?`, jid)
+	if err != nil {
+		return rollback(err)
+	}
+	currentTime := ut.CurrentTime()
+	for _, artifact := range artifacts {
+		_, err = tx.Exec(`
+			INSERT OR REPLACE INTO
+				job_artifacts (jid, volume, name, size, createdAt)
+			VALUES
+				(?, ?, ?, ?, ?)`,
+			jid, artifact.Volume, artifact.Name, artifact.Size, currentTime)
+		if err != nil {
+			return rollback(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// setJobLog records the object the log of a job was stored as
+func (srv *UService) setJobLog(jid int64, object string) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
 		UPDATE jobs
 		SET
-			status = ?, completed = ?, duration = ?
+			logObject = ?
+		WHERE
+			jid = ?`, object, jid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// getJobArtifacts returns the objects the output of a job consists of, by name
+func (srv *UService) getJobArtifacts(jid int64) ([]ut.JobArtifact, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			volume, name, size
+		FROM
+			job_artifacts
 		WHERE
 			jid = ?
-	`
-		_, err = db.Exec(query, status, completed, duration, jid)
+		ORDER BY
+			volume ASC, name ASC`, jid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
 		if err != nil {
-			log.Printf("failed to execute query: %v", err)
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
 
-			return fmt.Errorf("failed to execute query: %w", err)
+	var artifacts []ut.JobArtifact
+	for rows.Next() {
+		var artifact ut.JobArtifact
+		err = rows.Scan(&artifact.Volume, &artifact.Name, &artifact.Size)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	return artifacts, nil
+}
+
+// updateJobFingerprint records the fingerprint of a job, along with the job whose output it reused (0 if none)
+func (srv *UService) updateJobFingerprint(jid int64, fingerprint string, cachedFrom int64) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE jobs
+		SET
+			fingerprint = ?, cachedFrom = ?
+		WHERE
+			jid = ?`, fingerprint, cachedFrom, jid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
 	}
 
 	return nil
 }
+
+// getJobsByFingerprint returns the successful jobs (other than jid) with the given fingerprint, most recent first
+func (srv *UService) getJobsByFingerprint(fingerprint string, jid int64) ([]ut.Job, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			`+jobColumns+`
+		FROM
+			jobs
+		WHERE
+			fingerprint = ? AND jid != ? AND status IN ('completed', 'cached')
+		ORDER BY
+			completedAt DESC, jid DESC
+		LIMIT 10`, fingerprint, jid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	return scanJobs(rows)
+}
@@ -24,8 +24,10 @@ import (
   - PublishJobs([]Job) error
   - RemoveJob(int) error
   - RemoveJobs([]int) error
+  - SetJobPriority(int64, int) error
 
-the default one is JDispatcher which works as a scheduler
+the default one is JDispatcher which works as a scheduler,
+JobDispatcherNats and JobDispatcherKafka distribute jobs among several instances
 */
 type JobDispatcher interface {
 	Start()
@@ -33,6 +35,7 @@ type JobDispatcher interface {
 	PublishJobs(jobs []ut.Job) error
 	RemoveJob(jid int) error
 	RemoveJobs(jids []int) error
+	SetJobPriority(jid int64, priority int) error
 	Subscribe(job ut.Job) error
 }
 
@@ -45,13 +48,13 @@ func DispatcherShipment(dispatcherType string, srv *UService) (JobDispatcher, er
 		return JobDispatcherImpl{Manager: NewJobManager(srv)}, nil
 	case "kafka":
 
-		return nil, ut.NewWarning("kafka dispatcher not implemented")
+		return NewJobDispatcherKafka(srv)
 	case "rabbitmq":
 
 		return nil, ut.NewWarning("rabbitmq dispatcher not implemented")
-	case "natss":
+	case "nats", "natss":
 
-		return nil, ut.NewWarning("natss dispatcher not implemented")
+		return NewJobDispatcherNats(srv)
 	default:
 
 		return nil, ut.NewWarning("unknown dispatcher type: %s", dispatcherType)
@@ -0,0 +1,88 @@
+package uspace
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+var errIllegalTransition = errors.New("illegal job status transition")
+
+// execer is either a connection or a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertJobEvent records a transition of a job, timestamped now
+func insertJobEvent(db execer, event ut.JobEvent) error {
+	_, err := db.Exec(`
+		INSERT INTO
+			job_events (eid, jid, fromStatus, toStatus, actor, reason, at)
+		VALUES
+			(nextval('seq_eventid'), ?, ?, ?, ?, ?, ?)`,
+		event.JID, event.From, event.To, event.Actor, event.Reason, time.Now().UTC())
+	if err != nil {
+		log.Printf("failed to record job event: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// getJobEvents returns the transitions of a job, in order
+func (srv *UService) getJobEvents(jid int64) ([]ut.JobEvent, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			eid, jid, fromStatus, toStatus, actor, reason, at
+		FROM
+			job_events
+		WHERE
+			jid = ?
+		ORDER BY
+			eid ASC`, jid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var events []ut.JobEvent
+	for rows.Next() {
+		var (
+			event                   ut.JobEvent
+			from, to, actor, reason sql.NullString
+			at                      sql.NullTime
+		)
+		err = rows.Scan(&event.EID, &event.JID, &from, &to, &actor, &reason, &at)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		event.From, event.To, event.Actor, event.Reason = from.String, to.String, actor.String, reason.String
+		if at.Valid {
+			event.At = at.Time.UTC().Format(ut.TimeFormat)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -5,17 +5,25 @@
 // examples: (already defined)
 // - docker engine
 // - kubernetes engine
+// - sandboxed local processes (linux)
 package uspace
 
 import ut "kyri56xcaesar/kuspace/internal/utils"
 
 // JobExecutor interface defining what a JobExecutor must implement
+//
+// GetJobStatus reports what the engine knows about a job that was previously launched:
+// "running", "completed", "failed" or "unknown" if the engine has no trace of it.
+// AttachJob picks up a job that is still alive in the engine (e.g after a restart),
+// follows it until it finishes and records its outcome, just like ExecuteJob would.
+// GetJobOutput and GetJobError return what a job wrote to its stdout and stderr, as persisted by streamJobOutput.
 type JobExecutor interface {
 	ExecuteJob(job ut.Job) error
 	CancelJob(job ut.Job) error
-	// GetJobStatus(jobID int) (string, error)
-	// GetJobOutput(jobID int) (string, error)
-	// GetJobError(jobID int) (string, error)
+	GetJobStatus(job ut.Job) (string, error)
+	AttachJob(job ut.Job) error
+	GetJobOutput(job ut.Job) (string, error)
+	GetJobError(job ut.Job) (string, error)
 }
 
 // JobExecutorShipment "ships"/returns the JobExecutor asked
@@ -23,10 +31,13 @@ func JobExecutorShipment(jobType string, jm *JobManager) (JobExecutor, error) {
 	switch jobType {
 	case "local", "docker", "default":
 
-		return NewJDockerExecutor(jm), nil
+		return NewJDockerExecutor(jm)
 	case "kubernetes":
 
 		return NewJKubernetesExecutor(jm), nil
+	case "sandbox", "process":
+
+		return NewJSandboxExecutor(jm)
 	default:
 
 		return nil, ut.NewError("Invalid job type")
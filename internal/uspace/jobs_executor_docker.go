@@ -2,111 +2,42 @@ package uspace
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"kyri56xcaesar/kuspace/internal/uspace/docker"
 	ut "kyri56xcaesar/kuspace/internal/utils"
 )
 
-var (
-	defaultVPath         = "data/volumes"
-	tmpPath              = "tmp/"
-	pythonIoSkeletonCode = `%s
-
-with open('%s', 'r') as input:
-	with open('%s', 'w') as output:
-		output.write(run(input.read()))
-
-`
-	nodeIoSkeletonCode = `%s
-
-const fs = require('fs');
-
-fs.readFile('%s', 'utf8', (err, data) => {
-	if (err) throw err;
-	fs.writeFile('%s',  run(data), 'utf8', (err) => {
-		if (err) throw err;
-	});
-});
-
-`
-	javaIoSkeletonCode = `import java.nio.file.Files;
-import java.nio.file.Paths;
-%s
-public class Main {
-%s
-	public static void main(String[] args) throws Exception {
-		String input = new String(Files.readAllBytes(Paths.get("%s")));
-		String output = run(input);
-		Files.write(Paths.get("%s"), output.getBytes());
-	}
-}
-
-`
-	cIoSkeletonCode = `#include <stdio.h>
-#define BUFFER_SIZE 1024
-%s
-%s
-int main() {
-	FILE *in_fp, *out_fp;
-	in_fp = fopen("%s", "r");
-	if (in_fp == NULL) {
-		perror("Error opening input file");
-		
-return 1;
-	}
-	out_fp = fopen("%s", "w");
-	if (out_fp == NULL) {
-		perror("Error opening output file");
-		
-return 1;
-	}
-	char buffer[BUFFER_SIZE];
- 	while (fgets(buffer, BUFFER_SIZE, in_fp) != NULL) {
-    	run(buffer);         // Convert to uppercase
-    	fputs(buffer, out_fp);  // Write to output file
-	}
-	fclose(in_fp);
-	fclose(out_fp);
-	
-return 0;
-}`
-	goIoSkeletonCode = `package main
-	import "os"
-%s
-
-func main() {
-	input, err := os.ReadFile("%s")
-	if err != nil {
-		panic(err)
-	}
-	err = os.WriteFile("%s", []byte(run(string(input))), 0644)
-	if err != nil {
-		panic(err)
-	}
-}
-
-`
-)
+var tmpPath = "tmp/"
 
 // JDockerExecutor struct impelementing the JobExecutor interface
-// responsible for executing jobs in the docker engine
+// responsible for executing jobs in the docker engine, talking to its http API (J_DOCKER_HOST)
 type JDockerExecutor struct {
-	jm *JobManager
+	jm     *JobManager
+	engine *docker.Client
 }
 
 // NewJDockerExecutor function as a constructor
-func NewJDockerExecutor(jm *JobManager) JDockerExecutor {
-	defaultVPath = jm.srv.storage.DefaultVolume(true)
+func NewJDockerExecutor(jm *JobManager) (JDockerExecutor, error) {
+	engine, err := docker.NewClient(jm.srv.config.UspaceDockerHost)
+	if err != nil {
+		return JDockerExecutor{}, err
+	}
 
 	return JDockerExecutor{
-		jm: jm,
-	}
+		jm:     jm,
+		engine: engine,
+	}, nil
 }
 
 // ExecuteJob method, the core logic of execution
@@ -114,319 +45,517 @@ func (je JDockerExecutor) ExecuteJob(job ut.Job) error {
 	// log.Printf("executing job: %+v", job)
 	defer func() { <-je.jm.workerPool }() // Release worker slot
 
-	je.jm.mu.Lock()
-	job.Status = "running"
-	je.jm.mu.Unlock()
-
-	// we should examine input "resources"
-	// or if exists in the storage
-	var asResource ut.Resource
-	// inp can be in format <volume>/<path>
-	parts := strings.Split(job.Input, "/")
-	if len(parts) > 1 {
-		asResource.Vname = parts[0]
-		asResource.Name = strings.Join(parts[1:], "/")
-	} else {
-		asResource.Vname = je.jm.srv.storage.DefaultVolume(false)
-		asResource.Name = job.Input
+	// the inputs are resolved against the storage and staged under tmp/input-<jid>/, mounted as /input,
+	// what the job writes in tmp/output-<jid>/ (mounted as /output) is uploaded once it completes
+	inputs, err := je.jm.srv.resolveRunInputs(job)
+	if err == nil {
+		err = je.jm.srv.stageJobInputs(inputs, tmpPath+fmt.Sprintf("input-%d", job.JID))
 	}
-
-	_, err := je.jm.srv.storage.Stat(asResource)
+	if err == nil {
+		err = os.MkdirAll(outputDir(job.JID), 0o777)
+	}
+	// lets cleanup the debree, whatever happens
+	defer cleanup(job.JID, true)
 	if err != nil {
-		log.Printf("failed to find input resource: %v", err)
+		log.Printf("failed to stage the inputs of job %d: %v", job.JID, err)
+		je.jm.failJob(job.JID, ut.FailureInput, err.Error(), 0)
 
 		return err
 	}
 
+	if je.jm.isCanceled(job.JID) {
+		log.Printf("job %d canceled before execution", job.JID)
+		updateJobStatus(&je, job.JID, "canceled", 0)
+		go je.jm.srv.notifyJobSocket(job.JID, fmt.Sprintf("[executor] Job %d canceled before execution\n", job.JID))
+
+		return nil
+	}
+
 	// language and version
-	cmd, duration, err := prepareExecution(job, true)
+	var config docker.ContainerConfig
+	runtime, version, err := je.jm.srv.lookupRuntime(job.Logic)
+	if err == nil {
+		config, err = containerConfig(job, inputs, runtime, version)
+	}
 	if err != nil {
-		log.Printf("failed to prepare or perform job: %v", err)
+		log.Printf("failed to prepare job: %v", err)
+		je.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
 
 		return err
 	}
-	job.Duration = duration.Abs().Seconds()
 
-	// output should be streamed back ...
-	wsChan := make(chan []byte, 100)
-	// ws_chan_err := make(chan []byte, 100)
-
-	stdout, err := cmd.StdoutPipe()
+	ctx := context.Background()
+	id, class, err := je.createContainer(ctx, job.JID, config)
 	if err != nil {
-		log.Printf("error creating stdout pipe: %v", err)
+		log.Printf("failed to create the container of job %d: %v", job.JID, err)
+		je.jm.failJob(job.JID, class, err.Error(), 0)
 
 		return err
 	}
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		wsChan <- []byte(line)
+	defer je.removeContainer(id)
+
+	// stdin must be attached before the container starts
+	var stdin io.WriteCloser
+	if job.Interactive {
+		stdin, err = je.engine.AttachStdin(ctx, id)
+		if err != nil {
+			log.Printf("failed to attach to the stdin of job %d: %v", job.JID, err)
+			je.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
+
+			return err
+		}
 	}
 
-	// Start the command
 	log.Printf("starting job execution")
-	if err := cmd.Start(); err != nil {
-		log.Printf("error starting command: %v", err)
-		updateJobStatus(&je, job.JID, "failed", 0)
+	start := time.Now()
+	err = je.engine.StartContainer(ctx, id)
+	if err != nil {
+		log.Printf("error starting container: %v", err)
+		je.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
 
 		return err
 	}
-	log.Printf("streaming to socket")
-	go streamToSocketWS(job.JID, wsChan)
+	updateJobStatus(&je, job.JID, "running", 0)
+	if je.jm.isCanceled(job.JID) {
+		// canceled while the container was starting
+		err := je.CancelJob(job)
+		if err != nil {
+			log.Printf("failed to stop job %d: %v", job.JID, err)
+		}
+	}
 
-	log.Printf("waiting...")
-	err = cmd.Wait()
-	if err != nil {
-		log.Printf("Job %d failed: %s\n", job.JID, err)
-		// je.updateJobStatus(job.JID, "failed", 0)
+	// same as the activeDeadlineSeconds of the k8s executor, the container is killed once the timeout expires
+	var timedOut atomic.Bool
+	timeout := time.Duration(job.Timeout) * time.Minute
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			log.Printf("Job %d timed out after %v, killing its container", job.JID, timeout)
+			timedOut.Store(true)
+			err := je.CancelJob(job)
+			if err != nil {
+				log.Printf("failed to stop job %d: %v", job.JID, err)
+			}
+		})
+		defer timer.Stop()
 	}
 
-	// success
-	log.Printf("Job %d completed successfully\n", job.JID)
-	// je.updateJobStatus(job.JID, "completed", duration)
+	// output should be streamed back ...
+	log.Printf("streaming to socket")
+	wsChan := make(chan []byte, 100)
+	go je.jm.srv.streamJobOutput(job.JID, logStdout, wsChan)
+	session := je.jm.startInteractive(job, stdin, func() {
+		err := je.CancelJob(job)
+		if err != nil {
+			log.Printf("failed to stop job %d: %v", job.JID, err)
+		}
+	})
+	logsDone := je.followLogs(id, time.Time{}, session.relay(wsChan))
+	usage := je.sampleUsage(id)
 
-	// // insert the output resource
-	// go je.syncOutputResource(job)
+	log.Printf("waiting...")
+	exitCode, err := je.engine.WaitContainer(ctx, id)
+	<-logsDone
+	session.stop()
+	je.jm.recordUsage(job.JID, usage())
+
+	var status string
+	idleReason, idle := session.idled()
+	switch {
+	case timedOut.Load():
+		wsChan <- []byte(fmt.Sprintf("[executor] Job %d timed out after %v\n", job.JID, timeout))
+		status = je.jm.failJob(job.JID, ut.FailureTimeout, fmt.Sprintf("exceeded its timeout of %v", timeout), time.Since(start))
+	case idle:
+		wsChan <- []byte(fmt.Sprintf("[executor] Job %d killed, %s\n", job.JID, idleReason))
+		status = je.jm.failJob(job.JID, ut.FailureTimeout, idleReason, time.Since(start))
+	case err != nil:
+		log.Printf("failed to wait for the container of job %d: %v", job.JID, err)
+		status = je.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), time.Since(start))
+	case exitCode != 0:
+		log.Printf("Job %d failed with exit code %d\n", job.JID, exitCode)
+		class, reason := je.dockerFailure(id, exitCode)
+		status = je.jm.failJob(job.JID, class, reason, time.Since(start))
+		err = fmt.Errorf("job %d: %s", job.JID, reason)
+	default:
+		err = je.storeOutputs(job, wsChan)
+		if err != nil {
+			status = je.jm.failJob(job.JID, ut.FailureExecutor, "failed to store the output: "+err.Error(), time.Since(start))
 
-	// should cleanup the tmps, etc..
-	// lets cleanup the debree
-	cleanup(job.JID, true, job.Logic)
+			break
+		}
+		log.Printf("Job %d completed successfully\n", job.JID)
+		status = updateJobStatus(&je, job.JID, "completed", time.Since(start))
+	}
+	wsChan <- []byte(fmt.Sprintf("[executor] Job %d finished with status: %s\n", job.JID, status))
+	close(wsChan)
 
 	return err
 }
 
 // CancelJob method, the logic that halts job execution
-func (je JDockerExecutor) CancelJob(_ ut.Job) error {
+// kills the container of the job, the worker running it will then clean up
+func (je JDockerExecutor) CancelJob(job ut.Job) error {
+	err := je.engine.KillContainer(context.Background(), containerName(job.JID))
+	// not started yet, or already gone
+	if errors.Is(err, docker.ErrNotFound) || errors.Is(err, docker.ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to kill container: %w", err)
+	}
+
 	return nil
 }
 
-func prepareExecution(job ut.Job, verbose bool) (*exec.Cmd, time.Duration, error) {
-	// ctx := context.Background()
-	command, err := formatJobCommandD(job, true)
+// GetJobStatus method, inspects the container of the job
+func (je JDockerExecutor) GetJobStatus(job ut.Job) (string, error) {
+	state, err := je.engine.InspectContainer(context.Background(), containerName(job.JID))
+	if errors.Is(err, docker.ErrNotFound) {
+		return "unknown", nil
+	}
 	if err != nil {
-		log.Printf("failed to format command: %v", err)
-
-		return nil, 0, err
+		return "unknown", err
 	}
-	if verbose {
-		log.Printf("command: %s", command)
+
+	switch state.Status {
+	case "created", "running", "restarting", "paused":
+
+		return "running", nil
+	case "exited":
+		if state.ExitCode == 0 {
+			return "completed", nil
+		}
+
+		return "failed", nil
+	case "dead":
+
+		return "failed", nil
+	default:
+
+		return "unknown", nil
 	}
+}
+
+// AttachJob method, follows an already launched container until it exits
+func (je JDockerExecutor) AttachJob(job ut.Job) error {
+	defer func() { <-je.jm.workerPool }() // Release worker slot
+
+	id := containerName(job.JID)
 	start := time.Now()
-	cmd := exec.Command("docker", command...)
+	defer cleanup(job.JID, true)
+	defer je.removeContainer(id)
+
+	// whatever was persisted before the restart is not fetched again
+	since := lastJobLogTime(job.JID)
+	if !since.IsZero() {
+		since = since.Add(time.Nanosecond)
+	}
+	wsChan := make(chan []byte, 100)
+	go je.jm.srv.streamJobOutput(job.JID, logStdout, wsChan)
+	logsDone := je.followLogs(id, since, wsChan)
+	// whatever it consumed before the restart is lost, the counters of the container are cumulative though
+	usage := je.sampleUsage(id)
+
+	exitCode, err := je.engine.WaitContainer(context.Background(), id)
+	<-logsDone
+	defer close(wsChan)
+	je.jm.recordUsage(job.JID, usage())
+	if err != nil {
+		log.Printf("failed to wait for container %s: %v", id, err)
+		je.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), time.Since(start))
+
+		return err
+	}
 
-	return cmd, time.Since(start), nil
+	var status string
+	if exitCode != 0 {
+		class, reason := je.dockerFailure(id, exitCode)
+		status = je.jm.failJob(job.JID, class, reason, time.Since(start))
+	} else if err = je.storeOutputs(job, wsChan); err != nil {
+		status = je.jm.failJob(job.JID, ut.FailureExecutor, "failed to store the output: "+err.Error(), time.Since(start))
+	} else {
+		status = updateJobStatus(&je, job.JID, "completed", time.Since(start))
+	}
+	log.Printf("Job %d re-attached and finished with status: %s", job.JID, status)
+
+	return nil
 }
 
-func formatJobCommandD(job ut.Job, fileSave bool) ([]string, error) {
-	/*
-		// ctx,
-		"docker",
-		"run",
-		"-v", "'"+cwd+"/tests/input/job.in:/input/job.in"+"'", //input
-		"-v", "'"+cwd+"/tests/output:/output"+"'", //output
-		"-v", "'"+cwd+fmt.Sprintf("/tests/job-%d.py:/script.py", job.JID),
-		language+":"+version,
-		command,
-	*/
-	cwd, err := os.Getwd()
+// createContainer creates the container of a job, pulling its image if missing.
+// A container left over by a previous run of the job is replaced.
+// On failure the failure class is returned along with the error
+func (je JDockerExecutor) createContainer(ctx context.Context, jid int64, config docker.ContainerConfig) (string, string, error) {
+	name := containerName(jid)
+	id, err := je.engine.CreateContainer(ctx, name, config)
+	if errors.Is(err, docker.ErrConflict) {
+		log.Printf("replacing the leftover container %s", name)
+		err = je.engine.RemoveContainer(ctx, name)
+		if err != nil {
+			return "", ut.FailureExecutor, err
+		}
+		id, err = je.engine.CreateContainer(ctx, name, config)
+	}
+	if errors.Is(err, docker.ErrNotFound) {
+		log.Printf("pulling image %s", config.Image)
+		err = je.engine.PullImage(ctx, config.Image)
+		if err != nil {
+			return "", ut.FailureImage, err
+		}
+		id, err = je.engine.CreateContainer(ctx, name, config)
+	}
 	if err != nil {
-		return nil, errors.New("failed to retrieve working directory")
+		return "", ut.FailureExecutor, err
 	}
 
-	// how should we handle multiple input files? 1] lets combine (append) them to a single file for now...
+	return id, "", nil
+}
 
-	inp := fmt.Sprintf("input-%d", job.JID)
-	out := strings.Split(job.Output, "/")
-	parts := strings.Split(job.Logic, ":")
-	language := parts[0]
-	var version string
-	version = "latest"
-	if len(parts) == 2 {
-		version = parts[1]
-	}
-
-	command := []string{"run"}
-	switch language {
-	case "python":
-		if fileSave {
-			err := os.WriteFile(fmt.Sprintf("tmp/job-%d.py", job.JID), []byte(fmt.Sprintf(pythonIoSkeletonCode, job.LogicBody, "/input/"+inp, "/output/"+out[len(out)-1])), 0o644)
-			if err != nil {
-				log.Printf("failed to write file: %v", err)
+// followLogs forwards the stdout/stderr of a container to ch until it stops,
+// the returned channel is closed once everything was forwarded
+func (je JDockerExecutor) followLogs(id string, since time.Time, ch chan<- []byte) <-chan struct{} {
+	done := make(chan struct{})
+	logs, err := je.engine.ContainerLogs(context.Background(), id, true, since)
+	if err != nil {
+		log.Printf("failed to attach to the logs of container %s: %v", id, err)
+		close(done)
 
-				return nil, fmt.Errorf("failed to write tmp file script: %w", err)
-			}
-			command = append(command, []string{
-				"-v", cwd + "/" + tmpPath + inp + ":/input/" + inp, // input
-				"-v", cwd + "/" + defaultVPath + "output:/output", // output,
-				"-v", cwd + fmt.Sprintf("/tmp/job-%d.py", job.JID) + ":/script.py", // script to run
-				language + ":" + version, // image
-				"python", "./script.py",
-				"ls", "-lrth",
-			}...)
-
-			return command, nil
-		}
-		// return "python -c " +
-		// fmt.Sprintf(pythonIOSkeletonCode, job.LogicBody, "/input/"+inp[len(inp)-1], "/output/"+out[len(out)-1])
+		return done
+	}
 
-	case "node", "javascript":
-		language = "node"
-		if fileSave {
-			err := os.WriteFile(fmt.Sprintf("tmp/job-%d.js", job.JID), []byte(fmt.Sprintf(nodeIoSkeletonCode, job.LogicBody, "/input/"+inp, "/output/"+out[len(out)-1])), 0o644)
+	go func() {
+		defer close(done)
+		defer func() {
+			err := logs.Close()
 			if err != nil {
-				log.Printf("failed to write file: %v", err)
-
-				return nil, fmt.Errorf("failed to write tmp file script: %w", err)
+				log.Printf("failed to close the logs of container %s: %v", id, err)
 			}
-			command = append(command, []string{
-				"-v", cwd + "/" + tmpPath + inp + ":/input/" + inp, // input
-				"-v", cwd + "/" + defaultVPath + "/output:/output", // output,
-				"-v", cwd + fmt.Sprintf("/tmp/job-%d.js", job.JID) + ":/script.js", // script to run
-				language + ":" + version, // image
-				"node", "./script.js",
-			}...)
-
-			return command, nil
+		}()
+
+		stdoutR, stdoutW := io.Pipe()
+		stderrR, stderrW := io.Pipe()
+		var pipes sync.WaitGroup
+		pipes.Add(2)
+		go forwardLines(&pipes, stdoutR, "", ch)
+		go forwardLines(&pipes, stderrR, stderrPrefix, ch)
+		err := docker.Demux(logs, stdoutW, stderrW)
+		if err != nil {
+			log.Printf("container logs stream ended: %v", err)
 		}
-	case "go", "golang":
-		language = "golang"
-		if fileSave {
-			err := os.WriteFile(fmt.Sprintf("tmp/job-%d.go", job.JID), []byte(fmt.Sprintf(goIoSkeletonCode, job.LogicBody, "/input/"+inp, "/output/"+out[len(out)-1])), 0o644)
-			if err != nil {
-				log.Printf("failed to write file: %v", err)
+		_ = stdoutW.Close()
+		_ = stderrW.Close()
+		pipes.Wait()
+	}()
 
-				return nil, fmt.Errorf("failed to write tmp file script: %w", err)
-			}
-			command = append(command, []string{
-				"-v", cwd + "/" + tmpPath + inp + ":/input/" + inp, // input
-				"-v", cwd + "/" + defaultVPath + "/output:/output", // output,
-				"-v", cwd + fmt.Sprintf("/tmp/job-%d.go", job.JID) + ":/script.go", // script to run
-				language + ":" + version, // image
-				"go", "run", "/script.go",
-			}...)
-
-			return command, nil
-		}
-	case "openjdk", "java": // java
-		language = "openjdk"
-		if fileSave {
-			err := os.WriteFile(fmt.Sprintf("tmp/job-%d.java", job.JID), []byte(fmt.Sprintf(javaIoSkeletonCode, job.LogicHeaders, job.LogicBody, "/input/"+inp, "/output/"+out[len(out)-1])), 0o644)
-			if err != nil {
-				log.Printf("failed to write file: %v", err)
+	return done
+}
 
-				return nil, fmt.Errorf("failed to write tmp file script: %w", err)
-			}
-			command = append(command, []string{
-				"-v", cwd + "/" + tmpPath + inp + ":/input/" + inp, // input
-				"-v", cwd + "/" + defaultVPath + "/output:/output", // output,
-				"-v", cwd + fmt.Sprintf("/tmp/job-%d.java", job.JID) + ":/Main.java", // script to run
-				language + ":" + version,      // image
-				"sh", "-c", "java /Main.java", // compile and run
-			}...)
-			time.Sleep(1 * time.Second)
-
-			return command, nil
+// sampleUsage samples the stats of a running container, the returned function stops sampling
+// and returns what the container consumed so far
+/*
+	cpu and block io counters are cumulative and the engine reports zeroes once the
+	container is gone, so the highest value seen is kept for each of them
+*/
+func (je JDockerExecutor) sampleUsage(id string) func() ut.JobUsage {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var (
+		mu                  sync.Mutex
+		cpu, memory         uint64
+		bytesRead, bytesOut uint64
+	)
+	go func() {
+		defer close(done)
+		err := je.engine.StreamStats(ctx, id, func(stats docker.ContainerStats) {
+			read, written := stats.IO()
+			mu.Lock()
+			cpu = max(cpu, stats.CPUStats.CPUUsage.TotalUsage)
+			memory = max(memory, stats.Memory())
+			bytesRead, bytesOut = max(bytesRead, read), max(bytesOut, written)
+			mu.Unlock()
+		})
+		if err != nil {
+			log.Printf("failed to sample the stats of container %s: %v", id, err)
 		}
-	case "c", "gcc":
-		if fileSave {
-			err := os.WriteFile(fmt.Sprintf("tmp/job-%d.c", job.JID), []byte(fmt.Sprintf(cIoSkeletonCode, job.LogicHeaders, job.LogicBody, "/input/"+inp, "/output/"+out[len(out)-1])), 0o644)
-			if err != nil {
-				log.Printf("failed to write file: %v", err)
-
-				return nil, fmt.Errorf("failed to write tmp file script: %w", err)
-			}
-			command = append(command, []string{
-				"-v", cwd + "/" + tmpPath + inp + ":/input/" + inp, // input
-				"-v", cwd + "/" + defaultVPath + "/output:/output", // output,
-				"-v", cwd + fmt.Sprintf("/tmp/job-%d.c", job.JID) + ":/program.c", // script to run
-				language + ":" + version,                             // image
-				"sh", "-c", "gcc /program.c -o program && ./program", // compile and run
-			}...)
-			time.Sleep(1 * time.Second)
-
-			return command, nil
+	}()
+
+	return func() ut.JobUsage {
+		cancel()
+		<-done
+		mu.Lock()
+		defer mu.Unlock()
+
+		return ut.JobUsage{
+			CPUSeconds:   float64(cpu) / 1e9,
+			PeakMemory:   int64(memory),
+			BytesRead:    int64(bytesRead),
+			BytesWritten: int64(bytesOut),
 		}
-	default:
-		log.Printf("language: %s", language)
+	}
+}
+
+// removeContainer removes the container of a job whatever its state
+func (je JDockerExecutor) removeContainer(id string) {
+	err := je.engine.RemoveContainer(context.Background(), id)
+	if err != nil {
+		log.Printf("failed to remove container %s: %v", id, err)
+	}
+}
 
-		return nil, errors.New("unrecognised/unsupported language")
+// dockerFailure classifies a failed container by its state and exit code
+func (je JDockerExecutor) dockerFailure(id string, exitCode int) (string, string) {
+	state, err := je.engine.InspectContainer(context.Background(), id)
+	if err == nil && state.OOMKilled {
+		return ut.FailureOOM, "container ran out of memory"
+	}
+	if exitCode == 137 {
+		return ut.FailureError, "container was killed"
 	}
 
-	return nil, errors.New("bad state")
+	return ut.FailureError, fmt.Sprintf("container exited with code %d", exitCode)
 }
 
-func updateJobStatus(je *JDockerExecutor, jid int64, status string, duration time.Duration) {
-	log.Printf("updating %v job status: %v", jid, status)
-	err := je.jm.srv.markJobStatus(jid, status, duration)
+// containerConfig writes the script of the job under tmp/ and returns the configuration of its container,
+// the staged inputs are mounted under /input, the output dir of the job under /output
+func containerConfig(job ut.Job, inputs []jobInput, runtime ut.Runtime, version string) (docker.ContainerConfig, error) {
+	cwd, err := os.Getwd()
 	if err != nil {
-		log.Printf("failed to update job %d status (%s): %v", jid, status, err)
+		return docker.ContainerConfig{}, errors.New("failed to retrieve working directory")
 	}
-}
 
-func syncOutputResource(je *JDockerExecutor, job ut.Job) {
-	fInfo, err := os.Stat(defaultVPath + "/output/" + job.Output)
+	inp := fmt.Sprintf("input-%d", job.JID)
+	paths, outPath := stagedPaths(inputs, "/input"), "/output/"+jobOutputFile(job)
+	target := "/" + runtime.ScriptName()
+	code, command, err := runtime.Render(ut.RuntimeScript{
+		Headers: job.LogicHeaders,
+		Logic:   job.LogicBody,
+		Inputs:  ut.QuotedPaths(paths),
+		Output:  outPath,
+		Script:  target,
+	})
+	if err != nil {
+		return docker.ContainerConfig{}, err
+	}
+
+	script := fmt.Sprintf("tmp/job-%d.%s", job.JID, runtime.Extension)
+	err = os.WriteFile(script, []byte(code), 0o644)
+	if err != nil {
+		log.Printf("failed to write file: %v", err)
+
+		return docker.ContainerConfig{}, fmt.Errorf("failed to write tmp file script: %w", err)
+	}
+
+	cpus, err := ut.ParseCPUQuantity(job.CPULimit)
+	if err != nil {
+		return docker.ContainerConfig{}, err
+	}
+	memory, err := ut.ParseMemoryQuantity(job.MemoryLimit)
 	if err != nil {
-		log.Printf("failed to find/stat the output file: %v", err)
+		return docker.ContainerConfig{}, err
+	}
 
-		return
+	// the code may also open the inputs one by one
+	env := []string{"INPUT_PATHS=" + strings.Join(paths, ","), "OUTPUT_DIR=/output"}
+	for k, v := range job.Env {
+		env = append(env, k+"="+v)
 	}
 
-	currentTime := ut.CurrentTime()
-	resource := ut.Resource{
-		Name:       "/output/" + job.Output,
-		Type:       "file",
-		CreatedAt:  currentTime,
-		UpdatedAt:  currentTime,
-		AccessedAt: currentTime,
-		Perms:      "rw-r--r--",
-		RID:        0,
-		UID:        job.UID,
-		VID:        0,
-		GID:        job.UID,
-		Size:       fInfo.Size(),
-		Links:      0,
+	return docker.ContainerConfig{
+		Image: runtime.Image + ":" + version,
+		Cmd:   []string{"sh", "-c", command},
+		Env:   env,
+		// the stdin of an interactive job is closed along with its attached stream
+		OpenStdin:   job.Interactive,
+		StdinOnce:   job.Interactive,
+		AttachStdin: job.Interactive,
+		HostConfig: docker.HostConfig{
+			Binds: []string{
+				cwd + "/" + tmpPath + inp + ":/input:ro",    // inputs
+				cwd + "/" + outputDir(job.JID) + ":/output", // output
+				cwd + "/" + script + ":" + target,           // script to run
+			},
+			NanoCPUs: int64(cpus * 1e9),
+			Memory:   memory,
+			// no swap on top of the memory limit, same as the k8s executor
+			MemorySwap: memory,
+		},
+	}, nil
+}
+
+// updateJobStatus records the job status and returns it, final statuses go through the JobManager
+// so that a canceled job is not recorded as failed
+func updateJobStatus(je *JDockerExecutor, jid int64, status string, duration time.Duration) string {
+	log.Printf("updating %v job status: %v", jid, status)
+	if status != "running" {
+		return je.jm.finishJob(jid, status, duration)
+	}
+	if je.jm.isCanceled(jid) {
+		return "canceled"
+	}
+	err := je.jm.srv.markJobStatus(jid, status, duration, ut.ActorExecutor, "container started")
+	if err != nil {
+		log.Printf("failed to update job %d status (%s): %v", jid, status, err)
 	}
 
-	cancelFn, err := je.jm.srv.storage.Insert([]any{resource})
-	defer cancelFn()
+	return status
+}
+
+// storeOutputs uploads what a completed job wrote in its output dir and records its artifacts
+func (je JDockerExecutor) storeOutputs(job ut.Job, wsChan chan<- []byte) error {
+	artifacts, err := je.jm.srv.storeJobOutputs(job, outputDir(job.JID))
 	if err != nil {
-		log.Printf("failed to insert the resource")
+		log.Printf("failed to store the output of job %d: %v", job.JID, err)
+		wsChan <- []byte(fmt.Sprintf("[executor] failed to store the output: %v\n", err))
+
+		return err
 	}
+	je.jm.srv.recordJobArtifacts(job.JID, artifacts)
+	wsChan <- []byte(fmt.Sprintf("[executor] stored %d output object(s) under %s\n", len(artifacts), job.Output))
+
+	return nil
 }
 
-func cleanup(jid int64, verbose bool, language string) {
-	// remove the tmp files
-	err := os.Remove(fmt.Sprintf("tmp/input-%d", jid))
-	if err != nil && verbose {
-		log.Printf("failed to remove tmp file: %v", err)
+// the output dir of a job, mounted as /output
+func outputDir(jid int64) string {
+	return fmt.Sprintf("%soutput-%d", tmpPath, jid)
+}
+
+// containers are named after the job so that they can be found again
+func containerName(jid int64) string {
+	return fmt.Sprintf("uspace-job-%d", jid)
+}
+
+// forwardLines sends every line read from r to ch, prefixed
+func forwardLines(wg *sync.WaitGroup, r io.Reader, prefix string, ch chan<- []byte) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ch <- []byte(prefix + scanner.Text())
 	}
+}
 
-	if strings.Contains(language, "python") {
-		err = os.Remove(fmt.Sprintf("tmp/job-%d.py", jid))
-		if err != nil && verbose {
-			log.Printf("failed to remove tmp file: %v", err)
-		}
-	} else if strings.Contains(language, "node") || strings.Contains(language, "javascript") {
-		err = os.Remove(fmt.Sprintf("tmp/job-%d.js", jid))
-		if err != nil && verbose {
-			log.Printf("failed to remove tmp file: %v", err)
-		}
-	} else if strings.Contains(language, "go") || strings.Contains(language, "golang") {
-		err = os.Remove(fmt.Sprintf("tmp/job-%d.go", jid))
-		if err != nil && verbose {
-			log.Printf("failed to remove tmp file: %v", err)
-		}
-	} else if strings.Contains(language, "openjdk") || strings.Contains(language, "java") {
-		err = os.Remove(fmt.Sprintf("tmp/job-%d.java", jid))
+// GetJobOutput method returns the persisted stdout of a job
+func (je JDockerExecutor) GetJobOutput(job ut.Job) (string, error) {
+	return je.jm.srv.jobLogText(job, logStdout)
+}
+
+// GetJobError method returns the persisted stderr of a job
+func (je JDockerExecutor) GetJobError(job ut.Job) (string, error) {
+	return je.jm.srv.jobLogText(job, logStderr)
+}
+
+func cleanup(jid int64, verbose bool) {
+	// remove the tmp files
+	for _, dir := range []string{fmt.Sprintf("tmp/input-%d", jid), outputDir(jid)} {
+		err := os.RemoveAll(dir)
 		if err != nil && verbose {
 			log.Printf("failed to remove tmp file: %v", err)
 		}
-	} else if strings.Contains(language, "c") || strings.Contains(language, "gcc") {
-		err = os.Remove(fmt.Sprintf("tmp/job-%d.c", jid))
+	}
+
+	scripts, _ := filepath.Glob(fmt.Sprintf("tmp/job-%d.*", jid))
+	for _, script := range scripts {
+		err := os.Remove(script)
 		if err != nil && verbose {
 			log.Printf("failed to remove tmp file: %v", err)
 		}
-	} else if verbose {
-		log.Printf("no such language support")
 	}
 }
@@ -10,16 +10,18 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	k "kyri56xcaesar/kuspace/internal/uspace/kubernetes"
 	ut "kyri56xcaesar/kuspace/internal/utils"
 
-	"github.com/minio/minio-go/v7"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -61,14 +63,131 @@ func (jke JKubernetesExecutor) CancelJob(job ut.Job) error {
 
 		return err
 	}
-	err = cancelJob(client, fmt.Sprintf("job-%d", job.JID), jke.jm.srv.config.Namespace)
+	err = cancelJob(client, "job-"+k8sJobName(job), jke.jm.srv.config.Namespace)
 	if err != nil {
+		// not launched yet, or already gone
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
 		log.Printf("[executor] failed to cancel the Job: %v", err)
 	}
 
 	return err
 }
 
+// GetJobStatus method looks up the k8s Job of the given job
+func (jke JKubernetesExecutor) GetJobStatus(job ut.Job) (string, error) {
+	client, err := k.GetKubeClient()
+	if err != nil {
+		log.Printf("[executor] could not retrieve k8s client: %v", err)
+
+		return "unknown", err
+	}
+	j, err := client.BatchV1().Jobs(jke.jm.srv.config.Namespace).Get(context.TODO(),
+		"job-"+k8sJobName(job), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "unknown", nil
+		}
+
+		return "unknown", err
+	}
+
+	return k8sJobStatus(j), nil
+}
+
+// AttachJob method follows an already launched k8s Job until it finishes
+func (jke JKubernetesExecutor) AttachJob(job ut.Job) error {
+	defer func() { <-jke.jm.workerPool }() // release worker slot
+
+	jobName := k8sJobName(job)
+	namespace := jke.jm.srv.config.Namespace
+
+	clientset, err := k.GetKubeClient()
+	if err != nil {
+		log.Printf("[executor] could not retrieve kube client: %v", err)
+
+		return err
+	}
+
+	startTime := time.Now()
+	j, err := clientset.BatchV1().Jobs(namespace).Get(context.TODO(), "job-"+jobName, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("[executor] could not retrieve k8s job: %v", err)
+
+		return err
+	}
+	if j.Status.StartTime != nil {
+		startTime = j.Status.StartTime.Time
+	}
+
+	// whatever was persisted before the restart is not fetched again
+	since := lastJobLogTime(job.JID)
+
+	wsChan := make(chan []byte, 100)
+	go jke.jm.srv.streamJobOutput(job.JID, logExecutor, wsChan)
+	// closed once the pod logs are no longer streamed to it
+	var streaming sync.WaitGroup
+	defer close(wsChan)
+	defer streaming.Wait()
+	wsChan <- []byte(fmt.Sprintf("[executor] re-attached to job-%s\n", jobName))
+
+	status := k8sJobStatus(j)
+	if status == "running" {
+		streaming.Add(1)
+		go func() {
+			defer streaming.Done()
+			err := streamJobLogs(clientset, jobName, namespace, since, func(data []byte) {
+				wsChan <- data
+			})
+			if err != nil {
+				log.Printf("failed to stream job logs.. :%v", err)
+			}
+		}()
+
+		// whatever the pods consumed before the restart is lost
+		usage := sampleK8sUsage(jobName, namespace)
+		status, err = monitorJob(clientset, j.Name, namespace)
+		if err != nil {
+			log.Printf("error monitoring job: %v", err)
+		}
+		jke.jm.recordUsage(job.JID, usage())
+	}
+	finalizeK8sJob(&jke, job, status, time.Since(startTime), wsChan)
+
+	return nil
+}
+
+// GetJobOutput method returns the persisted output of a job, pods' stdout and stderr are combined
+func (jke JKubernetesExecutor) GetJobOutput(job ut.Job) (string, error) {
+	return jke.jm.srv.jobLogText(job, logStdout)
+}
+
+// GetJobError method returns the persisted stderr of a job, which k8s combines with stdout
+func (jke JKubernetesExecutor) GetJobError(job ut.Job) (string, error) {
+	return jke.jm.srv.jobLogText(job, logStderr)
+}
+
+// k8sJobName names the k8s Job of the current attempt of a job, retries get a fresh k8s Job
+func k8sJobName(job ut.Job) string {
+	if job.Attempts > 0 {
+		return fmt.Sprintf("j-%d-%d", job.JID, job.Attempts+1)
+	}
+
+	return fmt.Sprintf("j-%d", job.JID)
+}
+
+func k8sJobStatus(j *batchv1.Job) string {
+	if j.Status.Succeeded > 0 {
+		return "completed"
+	}
+	if j.Status.Failed > 0 {
+		return "failed"
+	}
+
+	return "running"
+}
+
 func buildK8sJob(
 	jobID string,
 	image string,
@@ -150,8 +269,17 @@ func monitorJob(clientset *kubernetes.Clientset, jobName, namespace string) (str
 		return "", err
 	}
 
+	defer watcher.Stop()
+
 	for event := range watcher.ResultChan() {
-		j := event.Object.(*batchv1.Job)
+		// the Job is only deleted underneath us when canceled
+		if event.Type == watch.Deleted {
+			return "canceled", nil
+		}
+		j, ok := event.Object.(*batchv1.Job)
+		if !ok {
+			continue
+		}
 		if j.Status.Succeeded > 0 {
 			return "completed", nil
 		}
@@ -163,7 +291,49 @@ func monitorJob(clientset *kubernetes.Clientset, jobName, namespace string) (str
 	return "unknown", errors.New("watch ended unexpectedly")
 }
 
-func streamJobLogs(clientset *kubernetes.Clientset, jobName, namespace string, send func([]byte)) error {
+// k8sFailure classifies a failed k8s Job by its conditions and the state of its pods
+func k8sFailure(clientset *kubernetes.Clientset, jobName, namespace string) (string, string) {
+	j, err := clientset.BatchV1().Jobs(namespace).Get(context.TODO(), "job-"+jobName, metav1.GetOptions{})
+	if err == nil {
+		for _, cond := range j.Status.Conditions {
+			if cond.Type == batchv1.JobFailed && cond.Reason == "DeadlineExceeded" {
+				return ut.FailureTimeout, cond.Message
+			}
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "job-name=job-" + jobName,
+	})
+	if err != nil {
+		return ut.FailureError, "job failed"
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Reason == "Evicted" {
+			return ut.FailureEvicted, pod.Status.Message
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil {
+				switch cs.State.Waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+					return ut.FailureImage, cs.State.Waiting.Message
+				}
+			}
+			if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+				if t.Reason == "OOMKilled" {
+					return ut.FailureOOM, "container ran out of memory"
+				}
+
+				return ut.FailureError, fmt.Sprintf("container exited with code %d: %s", t.ExitCode, t.Reason)
+			}
+		}
+	}
+
+	return ut.FailureError, "job failed"
+}
+
+// streamJobLogs follows the logs of the pod of a k8s Job, from the given time on if not zero
+func streamJobLogs(clientset *kubernetes.Clientset, jobName, namespace string, since time.Time, send func([]byte)) error {
 	labelSelector := "job-name=job-" + jobName
 
 	var podName string
@@ -220,9 +390,13 @@ WAIT_FOR_READY:
 	}
 
 	// Step 3: Start streaming logs
-	req := clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
+	opts := &corev1.PodLogOptions{
 		Follow: true,
-	})
+	}
+	if !since.IsZero() {
+		opts.SinceTime = &metav1.Time{Time: since}
+	}
+	req := clientset.CoreV1().Pods(namespace).GetLogs(podName, opts)
 
 	stream, err := req.Stream(context.TODO())
 	if err != nil {
@@ -252,26 +426,26 @@ WAIT_FOR_READY:
 }
 
 func executeK8sJob(je *JKubernetesExecutor, job ut.Job) {
-	jobName := fmt.Sprintf("j-%d", job.JID)
+	jobName := k8sJobName(job)
 	namespace := je.jm.srv.config.Namespace
 	// llets create a stream channel (for the websocket)
 	wsChan := make(chan []byte, 100)
-	// begin streaming channel, also set a deadline,  perhaps a context with a deadline could work
-	go streamToSocketWS(job.JID, wsChan)
-	go func() {
-		time.Sleep(time.Second * 500)
-		close(wsChan) // this propably lasts longer than the prev context
-	}()
+	// begin streaming channel, closed once the pod logs are no longer streamed to it
+	go je.jm.srv.streamJobOutput(job.JID, logExecutor, wsChan)
+	var streaming sync.WaitGroup
+	defer close(wsChan)
+	defer streaming.Wait()
 
 	wsChan <- []byte("...")
 	wsChan <- []byte("=-----------------------------------------------------------------------=")
 	wsChan <- []byte("...")
 	wsChan <- []byte(fmt.Sprintf("[executor] formatting Job as job-name: job-%s\n", jobName))
 
-	command, err := formatJobData(je, &job)
+	command, err := formatJobData(je.jm.srv, &job)
 	if err != nil {
 		log.Printf("error formatting job data: %v", err)
 		wsChan <- []byte(fmt.Sprintf("[executor]: error formatting job data %v\n", err))
+		je.jm.failJob(job.JID, ut.FailureInput, err.Error(), 0)
 
 		return
 	}
@@ -309,6 +483,13 @@ func executeK8sJob(je *JKubernetesExecutor, job ut.Job) {
 	if err != nil {
 		log.Printf("[executor] could not retrieve kube client: %v", err)
 		wsChan <- []byte("could not retrieve k8s client, fatal...\nexiting...")
+		je.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
+
+		return
+	}
+	if je.jm.isCanceled(job.JID) {
+		je.jm.finishJob(job.JID, "canceled", 0)
+		wsChan <- []byte(fmt.Sprintf("[executor] Job %v canceled before launch\n", jobName))
 
 		return
 	}
@@ -316,14 +497,23 @@ func executeK8sJob(je *JKubernetesExecutor, job ut.Job) {
 	if err != nil {
 		log.Printf("error starting job: %v", err)
 		wsChan <- []byte(fmt.Sprintf("[executor]: error launching job execution%v\n", err))
+		je.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
 
 		return
 	}
 	startTime := time.Now()
+	if !je.jm.isCanceled(job.JID) {
+		err = je.jm.srv.markJobStatus(job.JID, "running", 0, ut.ActorExecutor, "kubernetes job started")
+		if err != nil {
+			log.Printf("failed to mark job as running: %v", err)
+		}
+	}
 
 	// monitor and stream the logs of that job
+	streaming.Add(1)
 	go func() {
-		err = streamJobLogs(clientset, jobName, namespace, func(data []byte) {
+		defer streaming.Done()
+		err := streamJobLogs(clientset, jobName, namespace, time.Time{}, func(data []byte) {
 			wsChan <- data
 		})
 		if err != nil {
@@ -332,70 +522,84 @@ func executeK8sJob(je *JKubernetesExecutor, job ut.Job) {
 		}
 	}()
 
+	usage := sampleK8sUsage(jobName, namespace)
 	status, err := monitorJob(clientset, jobSpec.Name, namespace)
 	if err != nil {
 		log.Printf("error monitoring job: %v", err)
 	}
-	duration := time.Since(startTime)
-	// log.Printf("[executor] Job %v finished with status: %s, duration: %v", jobName, status, duration)
-	wsChan <- []byte(fmt.Sprintf("[executor] Job %v finished with status: %s, duration: %v\n", jobName, status, duration))
+	je.jm.recordUsage(job.JID, usage())
+	finalizeK8sJob(je, job, status, time.Since(startTime), wsChan)
+}
 
-	// Optional: cleanup or postprocess
-	err = je.jm.srv.markJobStatus(job.JID, status, duration)
-	if err != nil {
-		log.Printf("failed to annotate result to database")
-		wsChan <- []byte(fmt.Sprintf("[executor]: error marking job completion%v\n", err))
+// k8sUsageInterval is how often the metrics of the pods of a running k8s Job are sampled
+const k8sUsageInterval = 10 * time.Second
+
+// sampleK8sUsage samples the metrics of the pods of a k8s Job, the returned function stops sampling
+// and returns their cpu and memory usage so far
+func sampleK8sUsage(jobName, namespace string) func() ut.JobUsage {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan k.PodsUsage, 1)
+	go func() {
+		usage, err := k.SamplePodsUsage(ctx, namespace, "job-name=job-"+jobName, k8sUsageInterval)
+		if err != nil {
+			log.Printf("failed to sample the usage of job-%s: %v", jobName, err)
+		}
+		done <- usage
+	}()
+
+	return func() ut.JobUsage {
+		cancel()
+		usage := <-done
+
+		return ut.JobUsage{CPUSeconds: usage.CPUSeconds, PeakMemory: usage.PeakMemory}
 	}
+}
+
+// finalizeK8sJob records the outcome of a finished k8s Job and registers its output
+func finalizeK8sJob(je *JKubernetesExecutor, job ut.Job, status string, duration time.Duration, wsChan chan<- []byte) {
+	jobName := k8sJobName(job)
 
-	// save output to db
-	p := strings.SplitN(job.Output, "/", 2)
-	if len(p) != 2 {
-		log.Printf("job output was invalid format, should have escaped by now...")
+	// Optional: cleanup or postprocess
+	if status == "failed" {
+		class, reason := ut.FailureError, "job failed"
+		clientset, err := k.GetKubeClient()
+		if err == nil {
+			class, reason = k8sFailure(clientset, jobName, je.jm.srv.config.Namespace)
+		}
+		status = je.jm.failJob(job.JID, class, reason, duration)
+	} else {
+		status = je.jm.finishJob(job.JID, status, duration)
 	}
+	// log.Printf("[executor] Job %v finished with status: %s, duration: %v", jobName, status, duration)
+	wsChan <- []byte(fmt.Sprintf("[executor] Job %v finished with status: %s, duration: %v\n", jobName, status, duration))
 
+	// the applications upload the output on their own, it is registered once there
 	if status == "completed" {
-		outputResource := ut.Resource{
-			Name:  p[1],
-			Path:  "/",
-			Type:  "file",
-			Perms: "rw-r--r--",
-			UID:   job.UID,
-			Vname: p[0],
-			GID:   job.UID,
-		}
-		info, err := je.jm.srv.storage.Stat(outputResource)
+		artifacts, err := je.jm.srv.registerJobOutputs(job)
 		if err != nil {
-			log.Printf("failed to stat output file from storage: %v", err)
-			wsChan <- []byte(fmt.Sprintf("[executor]: error retrieving output file %v\n", err))
+			log.Printf("failed to register the output of job %d: %v", job.JID, err)
+			wsChan <- []byte(fmt.Sprintf("[executor]: error retrieving the output %v\n", err))
 
 			return
 		}
-		// this should be changed to be independent of minio... // will do "resourceInfo struct "
-		infoCasted, ok := info.(minio.ObjectInfo)
-		if !ok {
-			log.Printf("failed to cast to object info")
-			wsChan <- []byte(fmt.Sprintf("[executor]: error retrieving output file format %v\n", err))
-
-			return
-		}
-		outputResource.Size = infoCasted.Size
-
-		// log.Printf("[executor]...saving output in database...")
-		wsChan <- []byte(fmt.Sprintf("[executor] saving output %s/%s ...\n", outputResource.Vname, outputResource.Name))
-		_, err = je.jm.srv.fsl.Insert(outputResource)
-		if err != nil {
-			log.Printf("failed to insert output object in database: %v", err)
-			wsChan <- []byte(fmt.Sprintf("[executor]: error saving output data in db...%v\n", err))
+		var written int64
+		for _, artifact := range artifacts {
+			written += artifact.Size
 		}
+		// the metrics api tells nothing of io, the output written is accounted for at least
+		je.jm.recordUsage(job.JID, ut.JobUsage{BytesWritten: written})
+		je.jm.srv.recordJobArtifacts(job.JID, artifacts)
 
+		wsChan <- []byte(fmt.Sprintf("[executor] saved %d output object(s) under %s\n", len(artifacts), job.Output))
 		wsChan <- []byte("[executor] OK.\n")
 	}
 }
 
-func formatJobData(je *JKubernetesExecutor, job *ut.Job) ([]string, error) {
+// formatJobData resolves a job into the command and environment of its container, filling in the defaults,
+// also run by a dry-run of a job (see validateJob)
+func formatJobData(srv *UService, job *ut.Job) ([]string, error) {
 	// handle some generic checks as guard statement
-	if !ut.AssertStructNotEmptyUpon(job, map[any]bool{
-		"Input":     true,
+	if len(job.InputList()) == 0 || !ut.AssertStructNotEmptyUpon(job, map[any]bool{
 		"Output":    true,
 		"Logic":     true,
 		"LogicBody": true,
@@ -408,43 +612,37 @@ func formatJobData(je *JKubernetesExecutor, job *ut.Job) ([]string, error) {
 		InpAsResource ut.Resource
 		OutAsResource ut.Resource
 	)
-	command, err := formatJobCommand(job)
+	command, err := formatJobCommand(srv, job)
 	if err != nil {
 		log.Printf("error formatting job command: %v", err)
 
 		return nil, err
 	}
 
-	// create an env map
-	envMap := make(map[string]string)
+	// create an env map, on top of the env of the job (e.g. the parameters of an array job)
+	envMap := make(map[string]string, len(job.Env))
+	for k, v := range job.Env {
+		envMap[k] = v
+	}
 
 	// inp/out can be in format <volume>/<path>
 
-	// we should handle the job input by contacting the storage_system api
-	// can do it with Share or simply by Stat the objects, idk
-	parts := strings.Split(job.Input, "/")
-	if len(parts) > 1 {
-		InpAsResource.Vname = parts[0]
-		InpAsResource.Name = strings.Join(parts[1:], "/")
-	} else {
-		InpAsResource.Vname = je.jm.srv.storage.DefaultVolume(false)
-		InpAsResource.Name = job.Input
+	// the inputs are resolved against the storage, globs and directory prefixes expanded,
+	// the applications stage them on their own
+	inputs, err := srv.resolveRunInputs(*job)
+	if err != nil {
+		return nil, err
 	}
+	InpAsResource.Vname = inputs[0].Bucket
+	InpAsResource.Name = inputs[0].Object
 
-	parts = strings.Split(job.Output, "/")
-	if len(parts) > 1 {
-		OutAsResource.Vname = parts[0]
-		OutAsResource.Name = strings.Join(parts[1:], "/")
-	} else {
-		OutAsResource.Vname = je.jm.srv.storage.DefaultVolume(false)
-		OutAsResource.Name = job.Output
-	}
+	OutAsResource.Vname, OutAsResource.Name = srv.splitJobOutput(*job)
 
 	// format job vars
 	job.Output = strings.TrimSpace(job.Output)
 	job.OutputFormat = strings.TrimSpace(job.OutputFormat)
 	if job.OutputFormat == "" {
-		p := strings.Split(job.Input, ".")
+		p := strings.Split(InpAsResource.Name, ".")
 		if len(p) == 0 || p[len(p)-1] == "" {
 			job.OutputFormat = "txt" // default format
 		} else {
@@ -453,7 +651,7 @@ func formatJobData(je *JKubernetesExecutor, job *ut.Job) ([]string, error) {
 	}
 	if job.InputFormat == "" {
 		// deduce input format
-		p := strings.Split(job.Input, ".")
+		p := strings.Split(InpAsResource.Name, ".")
 		if len(p) == 0 || p[len(p)-1] == "" {
 			job.InputFormat = "txt" // default format
 		} else {
@@ -480,15 +678,22 @@ func formatJobData(je *JKubernetesExecutor, job *ut.Job) ([]string, error) {
 		job.CPURequest = "500m"
 	}
 
-	envMap["ENDPOINT"] = je.jm.srv.config.MinioEndpoint
-	envMap["ACCESS_KEY"] = je.jm.srv.config.MinioAccessKey
-	envMap["SECRET_KEY"] = je.jm.srv.config.MinioSecretKey
+	envMap["ENDPOINT"] = srv.config.MinioEndpoint
+	envMap["ACCESS_KEY"] = srv.config.MinioAccessKey
+	envMap["SECRET_KEY"] = srv.config.MinioSecretKey
 	envMap["LOGIC"] = job.LogicBody
-	envMap["INPUT_BUCKET"] = InpAsResource.Vname
+	envMap["INPUT_BUCKET"] = InpAsResource.Vname // the first input
 	envMap["INPUT_OBJECT"] = InpAsResource.Name
+	envMap["INPUTS"] = encodeJobInputs(inputs) // all of them: [{"bucket", "object", "name"}]
 	envMap["INPUT_FORMAT"] = job.InputFormat
 	envMap["OUTPUT_BUCKET"] = OutAsResource.Vname
 	envMap["OUTPUT_OBJECT"] = OutAsResource.Name
+	envMap["OUTPUT_PREFIX"] = ""
+	if job.OutputIsDir() {
+		// the main result lands in the directory, along with whatever else the application uploads under it
+		envMap["OUTPUT_PREFIX"] = OutAsResource.Name
+		envMap["OUTPUT_OBJECT"] = OutAsResource.Name + "output." + job.OutputFormat
+	}
 	envMap["OUTPUT_FORMAT"] = job.OutputFormat
 	envMap["TIMEOUT"] = strconv.Itoa(job.Timeout)
 	job.Env = envMap
@@ -496,7 +701,13 @@ func formatJobData(je *JKubernetesExecutor, job *ut.Job) ([]string, error) {
 	return command, nil
 }
 
-func formatJobCommand(job *ut.Job) ([]string, error) {
+// formatJobCommand returns the command of the container of a job, setting its image as the logic:
+// the image of an application, or of the runtime of its code
+func formatJobCommand(srv *UService, job *ut.Job) ([]string, error) {
+	if job.Interactive {
+		// pods are not attached to, see jobs_interactive.go
+		return nil, errors.New("interactive jobs are not supported by the kubernetes executor")
+	}
 	var name, version string
 	// deduct name and version and format it
 	p := strings.Split(strings.TrimSpace(job.Logic), ":")
@@ -511,7 +722,6 @@ func formatJobCommand(job *ut.Job) ([]string, error) {
 		return nil, errors.New("invalid job data")
 	}
 	job.Logic = fmt.Sprintf("%s:%s", name, version)
-	body := job.LogicBody
 
 	lang := job.Logic[:strings.Index(job.Logic+":", ":")]
 	switch lang {
@@ -539,138 +749,20 @@ func formatJobCommand(job *ut.Job) ([]string, error) {
 		job.Logic = bashImage
 
 		return []string{"python3", "bash_app.py"}, nil
-	case "python", "py":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("python3 -c '%s'", body)}, nil
-	case "go", "golang":
-
-		return []string{"/bin/sh", "-c",
-			fmt.Sprintf("echo '%s' > /tmp/tmp.go && go run /tmp/tmp.go && rm /tmp/tmp.go", body)}, nil
-	case "java", "javac", "openjdk":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf(`cat <<EOF > /tmp/Tmp.java
-		%s
-		EOF
-		javac /tmp/Tmp.java && java -cp /tmp Tmp && rm /tmp/Tmp.java /tmp/Tmp.class`, body)}, nil
-	case "node", "javascript", "js":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("node -e '%s'", body)}, nil
-
-	case "ruby":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("ruby -e '%s'", body)}, nil
-	case "php":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("php -r '%s'", body)}, nil
-	case "perl":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("perl -e '%s'", body)}, nil
-	case "rust":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("rustc -e '%s'", body)}, nil
-	case "swift":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("swift -e '%s'", body)}, nil
-	case "typescript":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("ts-node -e '%s'", body)}, nil
-	case "scala":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("scala -e '%s'", body)}, nil
-	case "haskell":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("runhaskell -e '%s'", body)}, nil
-	case "kotlin":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("kotlin -e '%s'", body)}, nil
-	case "elixir":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("elixir -e '%s'", body)}, nil
-	case "lua":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("lua -e '%s'", body)}, nil
-	case "r":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("Rscript -e '%s'", body)}, nil
-	case "dart":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("dart -e '%s'", body)}, nil
-	case "powershell":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("pwsh -c '%s'", body)}, nil
-	case "sql":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("sqlcmd -Q '%s'", body)}, nil
-	case "groovy":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("groovy -e '%s'", body)}, nil
-	case "clojure":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("clojure -e '%s'", body)}, nil
-	case "objective-c":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("clang -x objective-c -e '%s'", body)}, nil
-	case "visual-basic":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("vbc -e '%s'", body)}, nil
-	case "assembly":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("nasm -e '%s'", body)}, nil
-	case "fortran":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("gfortran -e '%s'", body)}, nil
-	case "pascal":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("fpc -e '%s'", body)}, nil
-	case "prolog":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("swipl -e '%s'", body)}, nil
-	case "scheme":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("guile -c '%s'", body)}, nil
-	case "tcl":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("tclsh -e '%s'", body)}, nil
-	case "smalltalk":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("gst -e '%s'", body)}, nil
-	case "nim":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("nim c -d:nodebug -e '%s'", body)}, nil
-	case "ocaml":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("ocaml -e '%s'", body)}, nil
-	case "f#":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("fsharpi -e '%s'", body)}, nil
-	case "crystal":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("crystal eval '%s'", body)}, nil
-	case "reason":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("reason-cli -e '%s'", body)}, nil
-	case "d":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("dmd -run '%s'", body)}, nil
-	case "solidity":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("solc --bin '%s'", body)}, nil
-	case "v":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("v run '%s'", body)}, nil
-	case "zig":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("zig run '%s'", body)}, nil
-	case "vala":
-
-		return []string{"/bin/sh", "-c", fmt.Sprintf("valac --pkg gtk+-3.0 '%s'", body)}, nil
-	case "c", "gcc":
-
-		return []string{"/bin/sh", "-c",
-			fmt.Sprintf("cat <<EOF > /tmp/tmp.c \n%s\nEOF && gcc /tmp/tmp.c -o /tmp/tmp.out && /tmp/tmp.out && rm /tmp/tmp.*",
-				body)}, nil
 	default:
+		// code, in any of the registered runtimes
+		runtime, version, err := srv.lookupRuntime(job.Logic)
+		if err != nil {
+			return nil, err
+		}
+		job.Logic = runtime.Image + ":" + version
+		// the code is handed over in the LOGIC env var (see formatJobData) and run as is, the inputs are not staged
+		script := "/tmp/" + runtime.ScriptName()
+		_, command, err := runtime.Render(ut.RuntimeScript{Script: script})
+		if err != nil {
+			return nil, err
+		}
 
-		return nil, fmt.Errorf("unsupported language: %s", lang)
+		return []string{"/bin/sh", "-c", fmt.Sprintf(`printf '%%s' "$LOGIC" > %s && cd /tmp && %s`, script, command)}, nil
 	}
 }
@@ -0,0 +1,226 @@
+//go:build linux
+
+package uspace
+
+/*
+	sandbox init
+
+	a sandboxed job is started as a re-execution of the service binary (sandboxInitArg), cloned into
+	its namespaces, which builds the root of the job before it becomes the command of the job:
+
+		/                   the working dir of the job (input/, output/, tmp/, its script)
+		/usr, /bin, ...     read-only binds of the system dirs and of the dirs on PATH
+		/etc/...            read-only binds of the few files a runtime needs (the dynamic linker cache, certificates)
+		/dev/null, ...      binds of the harmless devices
+		/proc               a fresh proc, of the pid namespace of the job
+
+	the host root is then detached (pivot_root), nothing else of the host is reachable:
+	not the configuration of the service, its databases, nor the working dirs of other jobs.
+	The job runs as root of its user namespace (the service uid on the host) without any capability.
+
+	any step failing aborts the job before it runs, the reason is written to the setup pipe (fd 3),
+	which is closed once the command of the job is executed.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// argv[0] of the re-executed service binary, followed by the root of the job and its command
+const sandboxInitArg = "kuspace-sandbox-init"
+
+// fd of the setup pipe in the sandbox init
+const sandboxSetupFD = 3
+
+const prSetNoNewPrivs = 38
+
+var (
+	// bound read-only if present on the host, symlinks (merged /usr) are recreated as such
+	sandboxSystemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32"}
+	sandboxEtcFiles   = []string{
+		"/etc/ld.so.cache", "/etc/ld.so.conf", "/etc/ld.so.conf.d", "/etc/alternatives",
+		"/etc/ssl", "/etc/ca-certificates", "/etc/localtime", "/etc/nsswitch.conf",
+	}
+	sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom", "/dev/tty"}
+)
+
+func init() {
+	if len(os.Args) < 3 || os.Args[0] != sandboxInitArg {
+		return
+	}
+
+	setup := os.NewFile(sandboxSetupFD, "setup")
+	err := sandboxInit(os.Args[1], os.Args[2:])
+	// only returns on failure
+	_, _ = fmt.Fprintf(setup, "sandbox setup failed: %v", err)
+	os.Exit(126)
+}
+
+// sandboxInit builds the root of the job out of its working dir, pivots into it and executes the command
+func sandboxInit(root string, command []string) error {
+	// capabilities are per thread, the one dropping them has to be the one executing the command
+	runtime.LockOSThread()
+
+	// nothing done here propagates to the host
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("failed to make the mounts private: %w", err)
+	}
+	err = syscall.Mount(root, root, "", syscall.MS_BIND, "")
+	if err != nil {
+		return fmt.Errorf("failed to bind the working dir: %w", err)
+	}
+
+	dirs := append([]string{}, sandboxSystemDirs...)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		// a dir enclosing the root would bring the host back in
+		if dir = filepath.Clean(dir); filepath.IsAbs(dir) && !strings.HasPrefix(root+"/", dir+"/") {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, p := range append(append(dirs, sandboxEtcFiles...), sandboxDevices...) {
+		err = sandboxBind(root, p, !strings.HasPrefix(p, "/dev/"))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Join(root, "proc"), 0o755)
+	if err == nil {
+		err = syscall.Mount("proc", filepath.Join(root, "proc"), "proc",
+			syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mount proc: %w", err)
+	}
+
+	err = sandboxPivot(root)
+	if err != nil {
+		return err
+	}
+
+	err = sandboxDropCapabilities()
+	if err != nil {
+		return err
+	}
+	syscall.CloseOnExec(sandboxSetupFD)
+
+	return syscall.Exec(command[0], command, os.Environ())
+}
+
+// sandboxBind binds a path of the host at the same path under the root, if it exists,
+// a path already reachable under the root (a PATH dir within /usr) is left alone
+func sandboxBind(root, p string, readOnly bool) error {
+	target := filepath.Join(root, p)
+	info, err := os.Lstat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", p, err)
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(p)
+		if err != nil {
+			return fmt.Errorf("failed to read link %s: %w", p, err)
+		}
+
+		return os.Symlink(link, target)
+	}
+	if info.IsDir() {
+		err = os.Mkdir(target, 0o755)
+	} else {
+		var f *os.File
+		f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0o644)
+		if err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create the mount point of %s: %w", p, err)
+	}
+
+	err = syscall.Mount(p, target, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("failed to bind %s: %w", p, err)
+	}
+	if readOnly {
+		err = syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, "")
+		if err != nil {
+			return fmt.Errorf("failed to make %s read-only: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// sandboxPivot makes the root the root of the mount namespace and detaches the host root
+func sandboxPivot(root string) error {
+	err := syscall.Chdir(root)
+	if err != nil {
+		return fmt.Errorf("failed to enter the root: %w", err)
+	}
+	err = os.Mkdir(".oldroot", 0o700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to create the old root: %w", err)
+	}
+	err = syscall.PivotRoot(".", ".oldroot")
+	if err != nil {
+		return fmt.Errorf("failed to pivot into the root: %w", err)
+	}
+	err = syscall.Chdir("/")
+	if err == nil {
+		err = syscall.Unmount("/.oldroot", syscall.MNT_DETACH)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to detach the host root: %w", err)
+	}
+
+	return os.Remove("/.oldroot")
+}
+
+// sandboxDropCapabilities empties the bounding set, the command is executed without any capability
+func sandboxDropCapabilities() error {
+	for c := uintptr(0); ; c++ {
+		_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, c, 0)
+		if errno == syscall.EINVAL {
+			break
+		} else if errno != 0 {
+			return fmt.Errorf("failed to drop capability %d: %w", c, errno)
+		}
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+
+	return nil
+}
+
+// sandboxSetupError waits for the sandbox init to either execute the command of the job or fail,
+// returns why it failed
+func sandboxSetupError(setup io.Reader) error {
+	msg, err := io.ReadAll(setup)
+	if err != nil {
+		return fmt.Errorf("failed to read the sandbox setup: %w", err)
+	}
+	if len(msg) > 0 {
+		return errors.New(string(msg))
+	}
+
+	return nil
+}
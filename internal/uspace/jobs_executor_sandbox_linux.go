@@ -0,0 +1,609 @@
+//go:build linux
+
+package uspace
+
+/*
+	sandboxed local process executor, no container engine required
+
+	every job runs as a plain child process (the command of its runtime, see runtimes.go,
+	out of the PATH) inside a private working dir, the version of the runtime is not enforced:
+
+		<J_SANDBOX_PATH>/job-<jid>/
+			input/<name>        the inputs, fetched from the storage (see jobs_inputs.go)
+			output/             whatever the job writes, uploaded to the storage once it succeeds (see jobs_outputs.go)
+			<script>
+
+	isolation:
+	  - its own user, pid, mount, network, ipc and uts namespaces, a job sees no other process and has no network
+	  - its working dir as its root, along with read-only system dirs and a proc of its own (see jobs_executor_sandbox_init_linux.go),
+	    a job sees nothing else of the host. A job whose sandbox cannot be set up fails, it never runs unisolated.
+	  - a cgroup v2 of its own under J_SANDBOX_CGROUP, cpu.max and memory.max are derived from
+	    Job.CPULimit and Job.MemoryLimit. The dir must be delegated to the service (writable),
+	    a job asking for limits fails if they cannot be enforced.
+	  - a clean environment: only PATH, HOME/TMPDIR (the working dir) and the job's Env
+	  - no stdin, unless the job is interactive (see jobs_interactive.go)
+
+	processes do not survive the service, a running job is never re-attached.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// cgroup v2 cpu.max period, in microseconds
+const sandboxCPUPeriod = 100000
+
+// JSandboxExecutor struct implementing the JobExecutor interface
+// responsible for executing jobs as isolated local processes
+type JSandboxExecutor struct {
+	jm *JobManager
+
+	root       string // working dirs
+	cgroupRoot string
+
+	mu    *sync.Mutex
+	procs map[int64]*sandboxProc // running jobs (guarded by mu)
+}
+
+// sandboxProc is a running job, along with its cgroup (if any)
+type sandboxProc struct {
+	cmd    *exec.Cmd
+	cgroup string
+}
+
+// NewJSandboxExecutor function as a constructor
+func NewJSandboxExecutor(jm *JobManager) (JSandboxExecutor, error) {
+	cfg := jm.srv.config
+	root, err := filepath.Abs(cfg.UspaceSandboxPath)
+	if err != nil {
+		return JSandboxExecutor{}, fmt.Errorf("invalid sandbox path: %w", err)
+	}
+	err = os.MkdirAll(root, 0o700)
+	if err != nil {
+		return JSandboxExecutor{}, fmt.Errorf("failed to create the sandbox dir: %w", err)
+	}
+
+	return JSandboxExecutor{
+		jm:         jm,
+		root:       root,
+		cgroupRoot: cfg.UspaceSandboxCgroup,
+		mu:         &sync.Mutex{},
+		procs:      make(map[int64]*sandboxProc),
+	}, nil
+}
+
+// ExecuteJob method, the core logic of execution
+func (se JSandboxExecutor) ExecuteJob(job ut.Job) error {
+	defer func() { <-se.jm.workerPool }() // Release worker slot
+
+	dir := filepath.Join(se.root, fmt.Sprintf("job-%d", job.JID))
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			log.Printf("failed to remove the sandbox of job %d: %v", job.JID, err)
+		}
+	}()
+
+	inputs, err := se.fetchInputs(job, dir)
+	if err != nil {
+		log.Printf("failed to fetch the input of job %d: %v", job.JID, err)
+		se.jm.failJob(job.JID, ut.FailureInput, err.Error(), 0)
+
+		return err
+	}
+
+	if se.jm.isCanceled(job.JID) {
+		log.Printf("job %d canceled before execution", job.JID)
+		se.jm.finishJob(job.JID, "canceled", 0)
+		go se.jm.srv.notifyJobSocket(job.JID, fmt.Sprintf("[executor] Job %d canceled before execution\n", job.JID))
+
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	runtime, _, err := se.jm.srv.lookupRuntime(job.Logic)
+	if err == nil {
+		cmd, err = sandboxCommand(job, dir, inputs, runtime)
+	}
+	if err != nil {
+		log.Printf("failed to prepare job %d: %v", job.JID, err)
+		se.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
+
+		return err
+	}
+
+	wsChan := make(chan []byte, 100)
+	go se.jm.srv.streamJobOutput(job.JID, logStdout, wsChan)
+	defer close(wsChan)
+
+	cgroup, cgroupFD, err := se.createCgroup(job)
+	if err != nil {
+		cpu, _ := ut.ParseCPUQuantity(job.CPULimit)
+		memory, _ := ut.ParseMemoryQuantity(job.MemoryLimit)
+		if cpu > 0 || memory > 0 {
+			log.Printf("cannot enforce the limits of job %d: %v", job.JID, err)
+			wsChan <- []byte(fmt.Sprintf("[executor] cannot enforce the cpu/memory limits: %v\n", err))
+			se.jm.failJob(job.JID, ut.FailureExecutor, "cannot enforce the cpu/memory limits: "+err.Error(), 0)
+
+			return err
+		}
+		log.Printf("job %d runs without a cgroup: %v", job.JID, err)
+	}
+	if cgroup != "" {
+		defer removeCgroup(cgroup)
+	}
+
+	cmd.SysProcAttr = sandboxSysProcAttr(cgroupFD)
+	setup, setupW, err := os.Pipe()
+	if err != nil {
+		log.Printf("error creating the setup pipe of job %d: %v", job.JID, err)
+		se.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
+
+		return err
+	}
+	defer func() {
+		_ = setup.Close()
+	}()
+	cmd.ExtraFiles = []*os.File{setupW}
+	stdout, err := cmd.StdoutPipe()
+	var stderr io.ReadCloser
+	if err == nil {
+		stderr, err = cmd.StderrPipe()
+	}
+	var stdin io.WriteCloser
+	if err == nil && job.Interactive {
+		stdin, err = cmd.StdinPipe()
+	}
+	if err != nil {
+		log.Printf("error creating the pipes of job %d: %v", job.JID, err)
+		se.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
+
+		return err
+	}
+
+	log.Printf("starting job %d: %s", job.JID, strings.Join(cmd.Args, " "))
+	start := time.Now()
+	err = cmd.Start()
+	if cgroupFD >= 0 {
+		_ = syscall.Close(cgroupFD)
+	}
+	_ = setupW.Close()
+	if err == nil {
+		err = sandboxSetupError(setup)
+		if err != nil {
+			// the output of the init, if any, is of no use
+			_ = cmd.Wait()
+		}
+	}
+	if err != nil {
+		log.Printf("error starting job %d: %v", job.JID, err)
+		wsChan <- []byte(fmt.Sprintf("[executor] failed to start the job: %v\n", err))
+		se.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
+
+		return err
+	}
+	se.mu.Lock()
+	se.procs[job.JID] = &sandboxProc{cmd: cmd, cgroup: cgroup}
+	se.mu.Unlock()
+	defer func() {
+		se.mu.Lock()
+		delete(se.procs, job.JID)
+		se.mu.Unlock()
+	}()
+
+	se.jm.markStatus(job.JID, "running", ut.ActorExecutor, "sandbox started")
+	if se.jm.isCanceled(job.JID) {
+		// canceled while the process was starting
+		err := se.CancelJob(job)
+		if err != nil {
+			log.Printf("failed to stop job %d: %v", job.JID, err)
+		}
+	}
+
+	var timedOut atomic.Bool
+	timeout := time.Duration(job.Timeout) * time.Minute
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			log.Printf("Job %d timed out after %v, killing it", job.JID, timeout)
+			timedOut.Store(true)
+			err := se.CancelJob(job)
+			if err != nil {
+				log.Printf("failed to stop job %d: %v", job.JID, err)
+			}
+		})
+		defer timer.Stop()
+	}
+
+	session := se.jm.startInteractive(job, stdin, func() {
+		err := se.CancelJob(job)
+		if err != nil {
+			log.Printf("failed to stop job %d: %v", job.JID, err)
+		}
+	})
+	output := session.relay(wsChan)
+
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	go forwardLines(&pipes, stdout, "", output)
+	go forwardLines(&pipes, stderr, stderrPrefix, output)
+	pipes.Wait()
+	session.stop()
+
+	var status string
+	err = cmd.Wait()
+	duration := time.Since(start)
+	se.jm.recordUsage(job.JID, sandboxUsage(cgroup, cmd.ProcessState))
+	idleReason, idle := session.idled()
+	switch {
+	case timedOut.Load():
+		wsChan <- []byte(fmt.Sprintf("[executor] Job %d timed out after %v\n", job.JID, timeout))
+		status = se.jm.failJob(job.JID, ut.FailureTimeout, fmt.Sprintf("exceeded its timeout of %v", timeout), duration)
+	case idle:
+		wsChan <- []byte(fmt.Sprintf("[executor] Job %d killed, %s\n", job.JID, idleReason))
+		status = se.jm.failJob(job.JID, ut.FailureTimeout, idleReason, duration)
+	case err != nil:
+		log.Printf("Job %d failed: %s\n", job.JID, err)
+		class, reason := ut.FailureExecutor, err.Error()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			class, reason = sandboxFailure(cgroup, exitErr)
+		}
+		status = se.jm.failJob(job.JID, class, reason, duration)
+	default:
+		var artifacts []ut.JobArtifact
+		artifacts, err = se.jm.srv.storeJobOutputs(job, filepath.Join(dir, "output"))
+		if err != nil {
+			log.Printf("failed to store the output of job %d: %v", job.JID, err)
+			wsChan <- []byte(fmt.Sprintf("[executor] failed to store the output: %v\n", err))
+			status = se.jm.failJob(job.JID, ut.FailureExecutor, "failed to store the output: "+err.Error(), duration)
+
+			break
+		}
+		se.jm.srv.recordJobArtifacts(job.JID, artifacts)
+		wsChan <- []byte(fmt.Sprintf("[executor] stored %d output object(s) under %s\n", len(artifacts), job.Output))
+		log.Printf("Job %d completed successfully\n", job.JID)
+		status = se.jm.finishJob(job.JID, "completed", duration)
+	}
+	wsChan <- []byte(fmt.Sprintf("[executor] Job %d finished with status: %s\n", job.JID, status))
+
+	return err
+}
+
+// CancelJob method kills every process of the job, the worker running it will then clean up
+func (se JSandboxExecutor) CancelJob(job ut.Job) error {
+	se.mu.Lock()
+	proc, exists := se.procs[job.JID]
+	se.mu.Unlock()
+	if !exists {
+		// not started yet, or already gone
+		return nil
+	}
+
+	if proc.cgroup != "" {
+		err := os.WriteFile(filepath.Join(proc.cgroup, "cgroup.kill"), []byte("1"), 0o644)
+		if err == nil {
+			return nil
+		}
+	}
+	// the whole process group (or pid namespace, whose init it is)
+	err := syscall.Kill(-proc.cmd.Process.Pid, syscall.SIGKILL)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to kill job %d: %w", job.JID, err)
+	}
+
+	return nil
+}
+
+// GetJobStatus method, processes of a previous run of the service are gone
+func (se JSandboxExecutor) GetJobStatus(job ut.Job) (string, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	if _, exists := se.procs[job.JID]; exists {
+		return "running", nil
+	}
+
+	return "unknown", nil
+}
+
+// AttachJob method, there is nothing to re-attach to
+func (se JSandboxExecutor) AttachJob(job ut.Job) error {
+	defer func() { <-se.jm.workerPool }() // Release worker slot
+
+	err := errors.New("sandboxed processes cannot be re-attached")
+	se.jm.failJob(job.JID, ut.FailureExecutor, err.Error(), 0)
+
+	return err
+}
+
+// GetJobOutput method returns the persisted stdout of a job
+func (se JSandboxExecutor) GetJobOutput(job ut.Job) (string, error) {
+	return se.jm.srv.jobLogText(job, logStdout)
+}
+
+// GetJobError method returns the persisted stderr of a job
+func (se JSandboxExecutor) GetJobError(job ut.Job) (string, error) {
+	return se.jm.srv.jobLogText(job, logStderr)
+}
+
+// fetchInputs creates the working dir of the job and stages its inputs in it
+func (se JSandboxExecutor) fetchInputs(job ut.Job, dir string) ([]jobInput, error) {
+	for _, d := range []string{"input", "output", "tmp"} {
+		err := os.MkdirAll(filepath.Join(dir, d), 0o700)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the working dir: %w", err)
+		}
+	}
+
+	inputs, err := se.jm.srv.resolveRunInputs(job)
+	if err != nil {
+		return nil, err
+	}
+
+	return inputs, se.jm.srv.stageJobInputs(inputs, filepath.Join(dir, "input"))
+}
+
+// sandboxCommand writes the script of the job in its working dir and returns the command running it
+func sandboxCommand(job ut.Job, dir string, inputs []jobInput, runtime ut.Runtime) (*exec.Cmd, error) {
+	paths := stagedPaths(inputs, "input")
+	script := runtime.ScriptName()
+	code, command, err := runtime.Render(ut.RuntimeScript{
+		Headers: job.LogicHeaders,
+		Logic:   job.LogicBody,
+		Inputs:  ut.QuotedPaths(paths),
+		Output:  "output/" + jobOutputFile(job),
+		Script:  script,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(filepath.Join(dir, script), []byte(code), 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the script: %w", err)
+	}
+	// what the command starts with has to be installed
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("runtime %s has an empty command", runtime.Name)
+	}
+	_, err = exec.LookPath(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s is not available on this host: %w", fields[0], err)
+	}
+	bin, err := exec.LookPath("sh")
+	if err != nil {
+		return nil, fmt.Errorf("sh is not available on this host: %w", err)
+	}
+	bin, err = filepath.Abs(bin)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sandbox init (see jobs_executor_sandbox_init_linux.go) pivots into the working dir, the paths are within it
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Args = []string{sandboxInitArg, dir, bin, "-c", command}
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=/",
+		"TMPDIR=/tmp",
+		"GOCACHE=/tmp/go-build",
+		"GOTOOLCHAIN=local",
+		"INPUT_PATHS=" + strings.Join(paths, ","),
+		"OUTPUT_DIR=/output",
+	}
+	for k, v := range job.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	return cmd, nil
+}
+
+func sandboxSysProcAttr(cgroupFD int) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		// root of the namespace, to set up the mounts, is the service on the host
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+	if cgroupFD >= 0 {
+		attr.UseCgroupFD = true
+		attr.CgroupFD = cgroupFD
+	}
+
+	return attr
+}
+
+// createCgroup creates the cgroup of a job with its limits, returns it along with an open fd of it (-1 on failure)
+func (se JSandboxExecutor) createCgroup(job ut.Job) (string, int, error) {
+	cpuMax, err := sandboxCPUMax(job.CPULimit)
+	if err != nil {
+		return "", -1, err
+	}
+	memoryMax, err := sandboxMemoryMax(job.MemoryLimit)
+	if err != nil {
+		return "", -1, err
+	}
+	if se.cgroupRoot == "" {
+		return "", -1, errors.New("no cgroup dir configured")
+	}
+
+	// the controllers must be enabled for the children of the root
+	err = os.WriteFile(filepath.Join(se.cgroupRoot, "cgroup.subtree_control"), []byte("+cpu +memory"), 0o644)
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to enable the cpu/memory controllers in %s: %w", se.cgroupRoot, err)
+	}
+	cgroup := filepath.Join(se.cgroupRoot, fmt.Sprintf("job-%d", job.JID))
+	err = os.Mkdir(cgroup, 0o755)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return "", -1, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	limits := map[string]string{"cpu.max": cpuMax, "memory.max": memoryMax}
+	if memoryMax != "max" {
+		limits["memory.swap.max"] = "0"
+	}
+	for file, value := range limits {
+		err = os.WriteFile(filepath.Join(cgroup, file), []byte(value), 0o644)
+		if err != nil && !(file == "memory.swap.max" && errors.Is(err, os.ErrNotExist)) {
+			removeCgroup(cgroup)
+
+			return "", -1, fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+
+	fd, err := syscall.Open(cgroup, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		removeCgroup(cgroup)
+
+		return "", -1, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+
+	return cgroup, fd, nil
+}
+
+func removeCgroup(cgroup string) {
+	// the cgroup can only be removed once its processes are gone
+	for range 10 {
+		err := os.Remove(cgroup)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("failed to remove cgroup %s", cgroup)
+}
+
+// sandboxFailure classifies a failed process, an oom kill is told by the memory events of its cgroup
+func sandboxFailure(cgroup string, exitErr *exec.ExitError) (string, string) {
+	if cgroup != "" {
+		events, err := os.ReadFile(filepath.Join(cgroup, "memory.events"))
+		if err == nil {
+			for _, line := range strings.Split(string(events), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) == 2 && fields[0] == "oom_kill" && fields[1] != "0" {
+					return ut.FailureOOM, "process ran out of memory"
+				}
+			}
+		}
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return ut.FailureError, fmt.Sprintf("process was killed by %v", status.Signal())
+	}
+
+	return ut.FailureError, fmt.Sprintf("process exited with code %d", exitErr.ExitCode())
+}
+
+// sandboxUsage returns what a finished process consumed, out of its cgroup counters if it had one,
+// of its rusage (which only covers the processes it waited for) otherwise
+func sandboxUsage(cgroup string, state *os.ProcessState) ut.JobUsage {
+	var usage ut.JobUsage
+	if state != nil {
+		if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+			usage.CPUSeconds = time.Duration(ru.Utime.Nano() + ru.Stime.Nano()).Seconds()
+			usage.PeakMemory = ru.Maxrss * 1024 // in kilobytes
+			usage.BytesRead, usage.BytesWritten = ru.Inblock*512, ru.Oublock*512
+		}
+	}
+	if cgroup == "" {
+		return usage
+	}
+
+	if usec, ok := cgroupStat(filepath.Join(cgroup, "cpu.stat"), "usage_usec"); ok {
+		usage.CPUSeconds = float64(usec) / 1e6
+	}
+	// memory.peak is only there on linux 5.19 onwards
+	if peak, err := os.ReadFile(filepath.Join(cgroup, "memory.peak")); err == nil {
+		if bytes, err := strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64); err == nil {
+			usage.PeakMemory = bytes
+		}
+	}
+	// io.stat is only there if the io controller is enabled, a line per device
+	if rbytes, ok := cgroupStat(filepath.Join(cgroup, "io.stat"), "rbytes"); ok {
+		usage.BytesRead = rbytes
+	}
+	if wbytes, ok := cgroupStat(filepath.Join(cgroup, "io.stat"), "wbytes"); ok {
+		usage.BytesWritten = wbytes
+	}
+
+	return usage
+}
+
+// cgroupStat sums the values of a key over a cgroup stat file, either "key value" or "device key=value ..." lines
+func cgroupStat(file, key string) (int64, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+
+	var (
+		sum   int64
+		found bool
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			var value string
+			switch {
+			case field == key && i+1 < len(fields):
+				value = fields[i+1]
+			case strings.HasPrefix(field, key+"="):
+				value = strings.TrimPrefix(field, key+"=")
+			default:
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				sum += n
+				found = true
+			}
+		}
+	}
+
+	return sum, found
+}
+
+// sandboxCPUMax converts a cpu limit to a cpu.max value
+func sandboxCPUMax(limit string) (string, error) {
+	cores, err := ut.ParseCPUQuantity(limit)
+	if err != nil {
+		return "", err
+	}
+	if cores == 0 {
+		return "max", nil
+	}
+	quota := max(int64(cores*sandboxCPUPeriod), 1000)
+
+	return fmt.Sprintf("%d %d", quota, sandboxCPUPeriod), nil
+}
+
+// sandboxMemoryMax converts a memory limit to a memory.max value
+func sandboxMemoryMax(limit string) (string, error) {
+	bytes, err := ut.ParseMemoryQuantity(limit)
+	if err != nil {
+		return "", err
+	}
+	if bytes == 0 {
+		return "max", nil
+	}
+
+	return strconv.FormatInt(bytes, 10), nil
+}
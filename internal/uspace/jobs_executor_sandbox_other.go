@@ -0,0 +1,10 @@
+//go:build !linux
+
+package uspace
+
+import "errors"
+
+// NewJSandboxExecutor function, the sandbox executor relies on linux namespaces and cgroups
+func NewJSandboxExecutor(_ *JobManager) (JobExecutor, error) {
+	return nil, errors.New("the sandbox executor is only supported on linux")
+}
@@ -0,0 +1,232 @@
+package uspace
+
+/*
+	job inputs
+
+	a job lists its inputs (see ut.Job.InputList), each one an object, a glob over
+	object names or a directory prefix. They are resolved against the storage once
+	the job is about to run and every object matched is staged under its own name:
+
+		volume/data/a.csv     ->  a.csv
+		volume/data/*.csv     ->  a.csv, b.csv
+		volume/data/          ->  data/a.csv, data/b.csv, data/sub/c.csv
+
+	an object or a glob match by its base name, the objects under a directory prefix
+	by their path relative to the parent of the directory. Two inputs staged under
+	the same name are rejected.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// jobInput struct, an object resolved out of the inputs of a job
+type jobInput struct {
+	Bucket string `json:"bucket"`
+	Object string `json:"object"`
+	Name   string `json:"name"` // the relative path it is staged under
+}
+
+// splitObjectPath splits <volume>/<path> into its parts, a path alone refers to the default volume
+func (srv *UService) splitObjectPath(p string) (string, string) {
+	parts := strings.SplitN(p, "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+
+	return srv.storage.DefaultVolume(false), p
+}
+
+// listObjects returns the names of the objects of a volume starting with the given prefix
+func (srv *UService) listObjects(vname, prefix string) ([]string, error) {
+	objects, err := srv.listObjectResources(vname, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(objects))
+	for _, r := range objects {
+		names = append(names, r.Name)
+	}
+
+	return names, nil
+}
+
+// listObjectResources returns the objects of a volume starting with the given prefix, by name (without a leading slash)
+func (srv *UService) listObjectResources(vname, prefix string) ([]ut.Resource, error) {
+	res, err := srv.storage.SelectObjects(map[string]any{"vname": vname, "prefix": prefix})
+	if err != nil {
+		return nil, err
+	}
+	resources, ok := res.([]ut.Resource)
+	if !ok {
+		return nil, errors.New("failed to cast the listed objects")
+	}
+
+	var objects []ut.Resource
+	for _, r := range resources {
+		r.Name = strings.TrimPrefix(r.Name, "/")
+		if r.Vname != "" && r.Vname != vname {
+			continue
+		}
+		if strings.HasPrefix(r.Name, prefix) && !strings.HasSuffix(r.Name, "/") {
+			objects = append(objects, r)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+
+	return objects, nil
+}
+
+// resolveJobInputs expands the inputs of a job into the objects they match, in order
+func (srv *UService) resolveJobInputs(job ut.Job) ([]jobInput, error) {
+	var resolved []jobInput
+	staged := make(map[string]string) // staged name -> object
+	for _, input := range job.InputList() {
+		vname, name := srv.splitObjectPath(input)
+		name = strings.TrimPrefix(name, "/")
+
+		var matched []jobInput
+		switch {
+		case name == "" || strings.HasSuffix(name, "/"):
+			objects, err := srv.listObjects(vname, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", input, err)
+			}
+			parent := path.Dir(strings.TrimSuffix(name, "/"))
+			for _, object := range objects {
+				stagedName := object
+				if parent != "." {
+					stagedName = strings.TrimPrefix(object, parent+"/")
+				}
+				matched = append(matched, jobInput{Bucket: vname, Object: object, Name: stagedName})
+			}
+		case strings.ContainsAny(name, "*?["):
+			_, err := path.Match(name, "")
+			if err != nil {
+				return nil, fmt.Errorf("invalid input pattern %s: %w", input, err)
+			}
+			objects, err := srv.listObjects(vname, name[:strings.IndexAny(name, "*?[")])
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", input, err)
+			}
+			for _, object := range objects {
+				if ok, _ := path.Match(name, object); ok {
+					matched = append(matched, jobInput{Bucket: vname, Object: object, Name: path.Base(object)})
+				}
+			}
+		default:
+			_, err := srv.storage.Stat(ut.Resource{Vname: vname, Name: name})
+			if err != nil {
+				return nil, fmt.Errorf("input %s not found: %w", input, err)
+			}
+			matched = append(matched, jobInput{Bucket: vname, Object: name, Name: path.Base(name)})
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("input %s matches no object", input)
+		}
+
+		for _, in := range matched {
+			object := in.Bucket + "/" + in.Object
+			if prev, ok := staged[in.Name]; ok {
+				if prev == object {
+					continue
+				}
+
+				return nil, fmt.Errorf("inputs %s and %s would both be staged as %s", prev, object, in.Name)
+			}
+			if !filepath.IsLocal(in.Name) {
+				return nil, fmt.Errorf("input %s cannot be staged as %s", object, in.Name)
+			}
+			staged[in.Name] = object
+			resolved = append(resolved, in)
+		}
+	}
+
+	return resolved, nil
+}
+
+// stageJobInputs downloads every resolved input of a job under dir, by its staged name
+func (srv *UService) stageJobInputs(inputs []jobInput, dir string) error {
+	for _, in := range inputs {
+		err := srv.downloadObject(in.Bucket, in.Object, filepath.Join(dir, filepath.FromSlash(in.Name)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downloadObject writes an object of the storage to the given file, creating its parent dirs
+func (srv *UService) downloadObject(vname, name, dst string) error {
+	resource := &ut.Resource{Vname: vname, Name: name}
+	var r any = resource
+	cancelFn, err := srv.storage.Download(&r)
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download %s/%s: %w", vname, name, err)
+	}
+	if resource.Reader == nil {
+		return fmt.Errorf("input %s/%s not found", vname, name)
+	}
+	if closer, ok := resource.Reader.(io.Closer); ok {
+		defer func() {
+			err := closer.Close()
+			if err != nil {
+				log.Printf("failed to close %s/%s: %v", vname, name, err)
+			}
+		}()
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create the input dir: %w", err)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create the input file: %w", err)
+	}
+	_, err = io.Copy(f, resource.Reader)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the input file: %w", err)
+	}
+
+	return nil
+}
+
+// stagedPaths returns where the given inputs are staged, under dir
+func stagedPaths(inputs []jobInput, dir string) []string {
+	paths := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		paths = append(paths, path.Join(dir, in.Name))
+	}
+
+	return paths
+}
+
+// encodeJobInputs returns the resolved inputs as handed to the applications (the INPUTS env var)
+func encodeJobInputs(inputs []jobInput) string {
+	data, err := json.Marshal(inputs)
+	if err != nil {
+		return "[]"
+	}
+
+	return string(data)
+}
@@ -0,0 +1,189 @@
+package uspace
+
+/*
+	interactive jobs
+
+	the stdin of an interactive job (Job.Interactive) is fed by its owner, over the socket of the job:
+	the owner asks for a token (see handleJobAttach) and connects to the socket as a producer with it,
+	wss then forwards what they send to the executor, connected as "stdin", which writes it to the job,
+	a line per message. A message of a single EOT (ctrl-d) closes the stdin of the job.
+	The output is streamed back to the consumers of the socket as with any job, the owner's connection included.
+
+	a job going without input nor output for J_INTERACTIVE_IDLE_TIMEOUT is killed and ends as "timeout".
+	Only the docker and sandbox executors attach stdin, a re-attached container gets none.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// how long an attach token may be used to connect, the connection itself is not bound by it
+const jobAttachTokenTTL = 5 * time.Minute
+
+// statuses of a job which is not over yet
+var liveJobStatuses = []string{"pending", "queued", "running", "retrying"}
+
+// interactiveSession feeds the stdin of a running job with what its owner sends to its socket,
+// a nil session (the job is not interactive) does nothing
+type interactiveSession struct {
+	jid     int64
+	stdin   io.WriteCloser
+	timeout time.Duration
+
+	conn     *websocket.Conn
+	last     atomic.Int64 // unix nanoseconds of the last input or output
+	idle     atomic.Bool
+	done     chan struct{}
+	relayed  chan []byte
+	relaying sync.WaitGroup
+	once     sync.Once
+}
+
+// startInteractive connects the stdin of an interactive job to its socket and watches it,
+// kill is called once the job is idle for longer than the idle timeout
+func (jm *JobManager) startInteractive(job ut.Job, stdin io.WriteCloser, kill func()) *interactiveSession {
+	if !job.Interactive || stdin == nil {
+		return nil
+	}
+
+	s := &interactiveSession{
+		jid:     job.JID,
+		stdin:   stdin,
+		timeout: time.Duration(max(jm.srv.config.UspaceJobIdleTimeout, 1)) * time.Second,
+		done:    make(chan struct{}),
+	}
+	s.touch()
+
+	header := http.Header{}
+	header.Set("X-Service-Secret", string(jm.srv.config.ServiceSecretKey))
+	wsURL := fmt.Sprintf("ws://%s/get-session?jid=%s&role=stdin", jobsSocketAddress, strconv.FormatInt(job.JID, 10))
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		// the job is left without input, the watchdog ends it
+		log.Printf("failed to connect the stdin of job %d to its socket: %v", job.JID, err)
+	} else {
+		s.conn = conn
+		go s.feed()
+	}
+	go s.watch(kill)
+
+	return s
+}
+
+// feed writes every message of the socket to the stdin of the job
+func (s *interactiveSession) feed() {
+	for {
+		_, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.touch()
+		if string(msg) == "\x04" {
+			err = s.stdin.Close()
+			if err != nil {
+				log.Printf("failed to close the stdin of job %d: %v", s.jid, err)
+			}
+
+			return
+		}
+		if !bytes.HasSuffix(msg, []byte("\n")) {
+			msg = append(msg, '\n')
+		}
+		_, err = s.stdin.Write(msg)
+		if err != nil {
+			log.Printf("failed to write to the stdin of job %d: %v", s.jid, err)
+
+			return
+		}
+	}
+}
+
+// watch kills the job once idle for longer than the timeout
+func (s *interactiveSession) watch(kill func()) {
+	ticker := time.NewTicker(max(s.timeout/10, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, s.last.Load())) > s.timeout {
+				log.Printf("Job %d idle for longer than %v, killing it", s.jid, s.timeout)
+				s.idle.Store(true)
+				kill()
+
+				return
+			}
+		}
+	}
+}
+
+func (s *interactiveSession) touch() {
+	s.last.Store(time.Now().UnixNano())
+}
+
+// relay returns the channel the output of the job is to be sent to, forwarded to ch,
+// so that output counts as activity. It is closed by stop.
+func (s *interactiveSession) relay(ch chan<- []byte) chan<- []byte {
+	if s == nil {
+		return ch
+	}
+
+	s.relayed = make(chan []byte, 100)
+	s.relaying.Add(1)
+	go func() {
+		defer s.relaying.Done()
+		for msg := range s.relayed {
+			s.touch()
+			ch <- msg
+		}
+	}()
+
+	return s.relayed
+}
+
+// stop ends the session once the job wrote all of its output, the relayed output is flushed
+func (s *interactiveSession) stop() {
+	if s == nil {
+		return
+	}
+
+	s.once.Do(func() {
+		close(s.done)
+		if s.relayed != nil {
+			close(s.relayed)
+			s.relaying.Wait()
+		}
+		if s.conn != nil {
+			err := s.conn.Close()
+			if err != nil {
+				log.Printf("failed to close the stdin connection of job %d: %v", s.jid, err)
+			}
+		}
+		// already closed if the process is gone, or on request
+		_ = s.stdin.Close()
+	})
+}
+
+// idled tells if the job was killed for being idle, and why
+func (s *interactiveSession) idled() (string, bool) {
+	if s == nil || !s.idle.Load() {
+		return "", false
+	}
+
+	return fmt.Sprintf("no input nor output for %v", s.timeout), true
+}
@@ -1,2 +1,487 @@
-// Package uspace todo...
 package uspace
+
+/*
+	Kafka dispatcher
+
+	jobs are produced to a topic (J_KAFKA_TOPIC) keyed by uid, so the jobs of a user
+	land on the same partition and are consumed in the order they were submitted.
+
+	the instances executing jobs form a consumer group (J_KAFKA_GROUP), each record
+	is handed to the local JobManager and its offset is committed only once a worker
+	took the job (along with the records before it, see commit): an instance that dies
+	before has its records consumed again by another member of the group (at-least-once),
+	so does one that loses a partition, the jobs of which are taken out of its queue.
+	A job that does not fit (queue full, per user/group limits) is held, its partition
+	paused, and offered again every kafkaRejectDelay while polling goes on.
+
+	records cannot be taken back, the jobs database (shared by the instances) is consulted
+	when a job is consumed and once more when a worker takes it instead: a job canceled
+	meanwhile (by any instance, see RemoveJob) is dropped, one already taken is not consumed
+	again and the current priority is the one in effect.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// a job rejected by the local queue is offered again after this
+const kafkaRejectDelay = 5 * time.Second
+
+// kafkaPartition is a partition of the topic
+type kafkaPartition struct {
+	topic     string
+	partition int32
+}
+
+// kafkaRecord is a consumed job record, not committed yet
+type kafkaRecord struct {
+	record *kgo.Record
+	job    ut.Job
+	held   bool // not accepted by the local JobManager yet
+	taken  bool // taken by a worker (or dropped), may be committed
+}
+
+// JobDispatcherKafka struct, a JobDispatcher over a Kafka topic
+type JobDispatcherKafka struct {
+	srv     *UService
+	manager JobManager // executes the jobs consumed by this instance
+
+	topic    string
+	producer *kgo.Client
+	consumer *kgo.Client // member of the consumer group, once subscribed
+
+	mu         *sync.Mutex
+	subscribed bool
+	pending    map[kafkaPartition][]*kafkaRecord // consumed records not committed yet, in order (guarded by mu)
+	byJID      map[int64]*kafkaRecord            // the same, by the job they carry (guarded by mu)
+	committed  map[kafkaPartition]*kgo.Record    // the last record of each partition to commit (guarded by mu)
+	commitMu   *sync.Mutex                       // serializes the commits, so that no offset goes back
+}
+
+// NewJobDispatcherKafka function creates a dispatcher producing to the configured brokers
+func NewJobDispatcherKafka(srv *UService) (*JobDispatcherKafka, error) {
+	cfg := srv.config
+	producer, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.UspaceKafkaBrokers...),
+		kgo.AllowAutoTopicCreation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the kafka producer: %w", err)
+	}
+
+	d := &JobDispatcherKafka{
+		srv:       srv,
+		topic:     cfg.UspaceKafkaTopic,
+		producer:  producer,
+		mu:        &sync.Mutex{},
+		pending:   make(map[kafkaPartition][]*kafkaRecord),
+		byJID:     make(map[int64]*kafkaRecord),
+		committed: make(map[kafkaPartition]*kgo.Record),
+		commitMu:  &sync.Mutex{},
+	}
+	d.manager = newJobManager(srv, d.jobTaken, nil)
+
+	return d, nil
+}
+
+// Start method launches the consumption of jobs (unless J_KAFKA_CONSUME is off), along with the recurring jobs scheduler
+func (d *JobDispatcherKafka) Start() {
+	if d.srv.config.UspaceKafkaConsume {
+		err := d.Subscribe(ut.Job{})
+		if err != nil {
+			log.Printf("[Kafka] failed to subscribe, this instance will not execute jobs: %v", err)
+		}
+	}
+	d.manager.StartScheduler()
+}
+
+// PublishJob method produces a Job to the topic, keyed by its uid
+func (d *JobDispatcherKafka) PublishJob(job ut.Job) error {
+	return d.PublishJobs([]ut.Job{job})
+}
+
+// PublishJobs method, same as PublishJob but with plurality
+func (d *JobDispatcherKafka) PublishJobs(jobs []ut.Job) error {
+	err := d.srv.admitQueuedJobs(d.manager.jobQueue.limits, jobs)
+	if err != nil {
+		return err
+	}
+
+	records := make([]*kgo.Record, 0, len(jobs))
+	jids := make(map[*kgo.Record]int64, len(jobs))
+	for _, job := range jobs {
+		job.Status = "queued"
+		job.CreatedAt = ut.CurrentTime()
+		data, err := json.Marshal(job)
+		if err != nil {
+			return fmt.Errorf("failed to marshal job %d: %w", job.JID, err)
+		}
+		record := &kgo.Record{
+			Topic: d.topic,
+			Key:   []byte(strconv.Itoa(job.UID)),
+			Value: data,
+		}
+		records = append(records, record)
+		jids[record] = job.JID
+	}
+
+	// marked before they are produced, an instance may consume them right away
+	for _, job := range jobs {
+		err := d.srv.markJobStatus(job.JID, "queued", 0, ut.ActorScheduler, "produced to kafka")
+		if err != nil {
+			log.Printf("[Kafka] failed to mark job ID=%d as queued: %v", job.JID, err)
+		}
+	}
+
+	// results come in no particular order
+	var produceErr error
+	results := d.producer.ProduceSync(context.Background(), records...)
+	for _, res := range results {
+		jid := jids[res.Record]
+		if res.Err != nil {
+			d.failUnproduced(jid, res.Err)
+			if produceErr == nil {
+				produceErr = fmt.Errorf("failed to produce job %d: %w", jid, res.Err)
+			}
+
+			continue
+		}
+		log.Printf("[Kafka] Job ID=%d produced (partition %d, offset %d)", jid, res.Record.Partition, res.Record.Offset)
+	}
+
+	return produceErr
+}
+
+// failUnproduced marks a job that could not be produced as failed, nothing is left to execute it
+func (d *JobDispatcherKafka) failUnproduced(jid int64, cause error) {
+	err := d.srv.markJobStatus(jid, "failed", 0, ut.ActorScheduler, "failed to produce to kafka: "+cause.Error())
+	if err != nil {
+		log.Printf("[Kafka] failed to mark job ID=%d as failed: %v", jid, err)
+	}
+}
+
+// RemoveJob method cancels a Job held by this instance, or one not consumed yet
+// (or queued on another instance, which drops it once a worker takes it)
+func (d *JobDispatcherKafka) RemoveJob(jid int) error {
+	err := d.manager.CancelJob(jid)
+	if err == nil {
+		d.dropRecord(int64(jid))
+	}
+	if !errors.Is(err, errJobNotActive) {
+		return err
+	}
+
+	job, err := d.srv.getJobByID(jid)
+	if err != nil {
+		return err
+	}
+	if job.Status != "queued" {
+		return errJobNotActive
+	}
+	// it will be skipped once consumed, or dropped once taken
+	err = d.srv.markJobStatus(job.JID, "canceled", 0, ut.ActorUser, "canceled before execution")
+	if err != nil {
+		return err
+	}
+	go d.srv.notifyJobSocket(job.JID, fmt.Sprintf("[executor] Job %d canceled before execution\n", job.JID))
+	go d.srv.workflowJobFinished(job.JID)
+
+	return nil
+}
+
+// RemoveJobs method same but with plurality
+func (d *JobDispatcherKafka) RemoveJobs(jids []int) error {
+	for _, jid := range jids {
+		err := d.RemoveJob(jid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetJobPriority method changes the priority of a Job queued on this instance, or one not consumed yet
+func (d *JobDispatcherKafka) SetJobPriority(jid int64, priority int) error {
+	err := d.manager.SetJobPriority(jid, priority)
+	if !errors.Is(err, errJobNotInQueue) {
+		return err
+	}
+
+	job, err := d.srv.getJobByID(int(jid))
+	if err != nil {
+		return err
+	}
+	if job.Status != "queued" {
+		return errJobNotInQueue
+	}
+
+	// in effect once consumed
+	return d.srv.updateJobPriority(jid, priority)
+}
+
+// Subscribe method links the local JobManager to the topic, this instance joins the consumer group
+// and starts executing jobs. Subscribing more than once has no effect,
+// the given job is not used: every member consumes every kind of job.
+func (d *JobDispatcherKafka) Subscribe(_ ut.Job) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subscribed {
+		return nil
+	}
+
+	cfg := d.srv.config
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.UspaceKafkaBrokers...),
+		kgo.ConsumerGroup(cfg.UspaceKafkaGroup),
+		kgo.ConsumeTopics(d.topic),
+		kgo.DisableAutoCommit(),
+		kgo.OnPartitionsRevoked(func(_ context.Context, _ *kgo.Client, revoked map[string][]int32) {
+			// what was taken is committed while the partitions are still ours
+			d.commit()
+			d.release(revoked)
+		}),
+		kgo.OnPartitionsLost(func(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+			d.release(lost)
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create the kafka consumer: %w", err)
+	}
+	d.consumer = consumer
+
+	d.manager.startWorker()
+	go d.consume()
+	d.subscribed = true
+	log.Printf("[Kafka] consuming jobs of topic %s as a member of group %s", d.topic, cfg.UspaceKafkaGroup)
+
+	return nil
+}
+
+// consume hands every polled record to the local JobManager,
+// while jobs are held the poll is cut short to offer them again
+func (d *JobDispatcherKafka) consume() {
+	for {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if d.offerHeld() {
+			ctx, cancel = context.WithTimeout(context.Background(), kafkaRejectDelay)
+		}
+		fetches := d.consumer.PollRecords(ctx, cap(d.manager.workerPool))
+		cancel()
+		if fetches.IsClientClosed() {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return
+			}
+			log.Printf("[Kafka] failed to fetch from %s/%d: %v", topic, partition, err)
+		})
+		fetches.EachRecord(d.deliver)
+		d.commit()
+	}
+}
+
+// deliver hands a consumed job to the local JobManager, behind the jobs of its partition held already
+func (d *JobDispatcherKafka) deliver(r *kgo.Record) {
+	entry := &kafkaRecord{record: r}
+	err := json.Unmarshal(r.Value, &entry.job)
+	if err != nil {
+		log.Printf("[Kafka] dropping malformed job record %s/%d@%d: %v", r.Topic, r.Partition, r.Offset, err)
+		entry.taken = true
+	}
+	p := kafkaPartition{topic: r.Topic, partition: r.Partition}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	held := false
+	for _, e := range d.pending[p] {
+		held = held || e.held
+	}
+	d.pending[p] = append(d.pending[p], entry)
+	if !entry.taken {
+		d.byJID[entry.job.JID] = entry
+		if held {
+			entry.held = true
+		} else {
+			d.offer(p, entry)
+		}
+	}
+	d.advance(p)
+}
+
+// offer hands a job to the local JobManager, false if it does not fit for now:
+// the job is then held and its partition paused (guarded by mu)
+func (d *JobDispatcherKafka) offer(p kafkaPartition, entry *kafkaRecord) bool {
+	job := entry.job
+	record, err := d.srv.getJobByID(int(job.JID))
+	if err != nil {
+		log.Printf("[Kafka] failed to look up job ID=%d, scheduling it as produced: %v", job.JID, err)
+	} else {
+		if record.Status != "pending" && record.Status != "queued" {
+			log.Printf("[Kafka] skipping job ID=%d (%s)", job.JID, record.Status)
+			entry.held, entry.taken = false, true
+			delete(d.byJID, job.JID)
+
+			return true
+		}
+		job.Priority = record.Priority
+	}
+
+	err = d.manager.ScheduleJob(job)
+	if err != nil {
+		if !entry.held {
+			log.Printf("[Kafka] Job ID=%d not accepted (%v), pausing %s/%d, offering it again in %v",
+				job.JID, err, p.topic, p.partition, kafkaRejectDelay)
+			d.consumer.PauseFetchPartitions(map[string][]int32{p.topic: {p.partition}})
+		}
+		entry.held = true
+
+		return false
+	}
+	entry.held = false
+
+	return true
+}
+
+// offerHeld offers the held jobs again in order, resuming the partitions which no longer hold any,
+// it tells whether some are still held
+func (d *JobDispatcherKafka) offerHeld() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stillHeld := false
+	for p, entries := range d.pending {
+		held, accepted := false, true
+		for _, entry := range entries {
+			if !entry.held {
+				continue
+			}
+			held = true
+			accepted = d.offer(p, entry)
+			if !accepted {
+				break
+			}
+		}
+		if !accepted {
+			stillHeld = true
+
+			continue
+		}
+		if held {
+			d.consumer.ResumeFetchPartitions(map[string][]int32{p.topic: {p.partition}})
+			d.advance(p)
+		}
+	}
+
+	return stillHeld
+}
+
+// jobTaken commits the record of a job taken by a worker,
+// a job canceled meanwhile, perhaps by another instance, is dropped
+func (d *JobDispatcherKafka) jobTaken(job ut.Job) bool {
+	d.dropRecord(job.JID)
+
+	record, err := d.srv.getJobByID(int(job.JID))
+	if err == nil && !jobActive(record.Status) {
+		log.Printf("[Kafka] dropping job ID=%d (%s)", job.JID, record.Status)
+
+		return false
+	}
+
+	return true
+}
+
+// dropRecord marks the record of a job as done with and commits what it can
+func (d *JobDispatcherKafka) dropRecord(jid int64) {
+	d.mu.Lock()
+	entry, exists := d.byJID[jid]
+	if exists {
+		delete(d.byJID, jid)
+		entry.taken = true
+		d.advance(kafkaPartition{topic: entry.record.Topic, partition: entry.record.Partition})
+	}
+	d.mu.Unlock()
+	if exists {
+		d.commit()
+	}
+}
+
+// advance forgets the leading records of a partition that are done with, the last of them is to be committed (guarded by mu)
+func (d *JobDispatcherKafka) advance(p kafkaPartition) {
+	entries := d.pending[p]
+	n := 0
+	for n < len(entries) && entries[n].taken {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	d.committed[p] = entries[n-1].record
+	d.pending[p] = entries[n:]
+	if len(d.pending[p]) == 0 {
+		delete(d.pending, p)
+	}
+}
+
+// commit commits the offsets advanced since the last commit
+func (d *JobDispatcherKafka) commit() {
+	d.commitMu.Lock()
+	defer d.commitMu.Unlock()
+
+	d.mu.Lock()
+	records := make([]*kgo.Record, 0, len(d.committed))
+	for p, r := range d.committed {
+		records = append(records, r)
+		delete(d.committed, p)
+	}
+	d.mu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+
+	err := d.consumer.CommitRecords(context.Background(), records...)
+	if err != nil {
+		log.Printf("[Kafka] failed to commit %d offset(s), their jobs may be consumed again: %v", len(records), err)
+	}
+}
+
+// release forgets the records of the partitions no longer assigned to this instance,
+// taking their jobs out of the local queue: they are consumed again by their new owner
+func (d *JobDispatcherKafka) release(partitions map[string][]int32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for topic, ids := range partitions {
+		for _, id := range ids {
+			p := kafkaPartition{topic: topic, partition: id}
+			for _, entry := range d.pending[p] {
+				if entry.taken {
+					continue
+				}
+				delete(d.byJID, entry.job.JID)
+				if !entry.held {
+					_, err := d.manager.jobQueue.remove(entry.job.JID)
+					if err != nil {
+						log.Printf("[Kafka] job ID=%d of released %s/%d is already taken: %v", entry.job.JID, topic, id, err)
+					}
+				}
+			}
+			delete(d.pending, p)
+			delete(d.committed, p)
+		}
+		d.consumer.ResumeFetchPartitions(map[string][]int32{topic: ids})
+	}
+}
@@ -0,0 +1,168 @@
+package uspace
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/zeebo/assert"
+)
+
+const (
+	testKafkaTopic = "kuspace-jobs"
+	testKafkaGroup = "kuspace-executors"
+)
+
+// blockingExecutor records the jobs it runs, each one until released
+type blockingExecutor struct {
+	JobExecutor
+	jm *JobManager
+
+	mu       sync.Mutex
+	executed []int64
+	started  chan int64
+	release  chan struct{}
+}
+
+func (e *blockingExecutor) ExecuteJob(job ut.Job) error {
+	defer func() { <-e.jm.workerPool }()
+	e.jm.markStatus(job.JID, "running", ut.ActorExecutor, "")
+	e.mu.Lock()
+	e.executed = append(e.executed, job.JID)
+	e.mu.Unlock()
+	e.started <- job.JID
+	<-e.release
+	e.jm.finishJob(job.JID, "completed", 0)
+
+	return nil
+}
+
+func (e *blockingExecutor) CancelJob(_ ut.Job) error {
+	return nil
+}
+
+func (e *blockingExecutor) jobs() []int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]int64(nil), e.executed...)
+}
+
+func newTestKafkaService(t *testing.T, brokers []string) *UService {
+	t.Helper()
+	dir := t.TempDir()
+	srv := &UService{config: ut.EnvConfig{
+		UspaceKafkaBrokers:  brokers,
+		UspaceKafkaTopic:    testKafkaTopic,
+		UspaceKafkaGroup:    testKafkaGroup,
+		UspaceJobQueueSize:  "10",
+		UspaceJobMaxWorkers: "1",
+		UspaceJobExecutor:   "kubernetes",
+		UspaceJobLogsPath:   "/dev/null/logs", // no log kept, none stored
+		WssAddress:          "127.0.0.1:1",
+	}}
+	srv.jdbh = ut.NewDBHandler("jobs.db", dir+"/", "duckdb")
+	srv.jdbh.Init(initSQLJobs, "1", "1", "60")
+	t.Cleanup(srv.jdbh.Close)
+
+	return srv
+}
+
+func publishTestJob(t *testing.T, srv *UService, d *JobDispatcherKafka) int64 {
+	t.Helper()
+	job := ut.Job{UID: 1000, GID: 1000, Output: "vol/out"}
+	jid, err := srv.insertJob(job)
+	assert.NoError(t, err)
+	job.JID = jid
+	assert.NoError(t, d.PublishJob(job))
+
+	return jid
+}
+
+func committedOffset(t *testing.T, brokers []string) int64 {
+	t.Helper()
+	cl, err := kgo.NewClient(kgo.SeedBrokers(brokers...))
+	assert.NoError(t, err)
+	defer cl.Close()
+
+	offsets, err := kadm.NewClient(cl).FetchOffsets(context.Background(), testKafkaGroup)
+	assert.NoError(t, err)
+	offset, ok := offsets.Lookup(testKafkaTopic, 0)
+	if !ok {
+		return -1
+	}
+
+	return offset.At
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func jobStatus(t *testing.T, srv *UService, jid int64) string {
+	t.Helper()
+	job, err := srv.getJobByID(int(jid))
+	assert.NoError(t, err)
+
+	return job.Status
+}
+
+func TestKafkaDispatcher(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, testKafkaTopic))
+	assert.NoError(t, err)
+	defer cluster.Close()
+	brokers := cluster.ListenAddrs()
+
+	srv := newTestKafkaService(t, brokers)
+	d, err := NewJobDispatcherKafka(srv)
+	assert.NoError(t, err)
+	executor := &blockingExecutor{jm: &d.manager, started: make(chan int64, 4), release: make(chan struct{})}
+	d.manager.executor = executor
+
+	// another instance, not consuming
+	other, err := NewJobDispatcherKafka(srv)
+	assert.NoError(t, err)
+
+	running := publishTestJob(t, srv, d)
+	canceledHere := publishTestJob(t, srv, d)
+	canceledThere := publishTestJob(t, srv, d)
+	assert.Equal(t, jobStatus(t, srv, running), "queued")
+
+	assert.NoError(t, d.Subscribe(ut.Job{}))
+	defer d.consumer.Close()
+
+	// publish -> consume -> execute: the first job holds the only worker, nothing else is committed
+	select {
+	case jid := <-executor.started:
+		assert.Equal(t, jid, running)
+	case <-time.After(20 * time.Second):
+		t.Fatal("timed out waiting for the first job to run")
+	}
+	waitFor(t, "the first job to be committed", func() bool { return committedOffset(t, brokers) == 1 })
+
+	// canceled by this instance, which consumed it, and by another one, only through the database
+	assert.NoError(t, d.RemoveJob(int(canceledHere)))
+	assert.NoError(t, other.RemoveJob(int(canceledThere)))
+	assert.Equal(t, jobStatus(t, srv, canceledThere), "canceled")
+
+	close(executor.release)
+	waitFor(t, "every record to be committed", func() bool { return committedOffset(t, brokers) == 3 })
+	waitFor(t, "the first job to complete", func() bool { return jobStatus(t, srv, running) == "completed" })
+
+	assert.DeepEqual(t, executor.jobs(), []int64{running})
+	assert.Equal(t, jobStatus(t, srv, canceledHere), "canceled")
+	assert.Equal(t, jobStatus(t, srv, canceledThere), "canceled")
+}
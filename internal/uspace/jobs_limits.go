@@ -0,0 +1,169 @@
+package uspace
+
+/*
+	fair share limits of the JobManager
+
+	every uid and gid may have at most a number of running and queued jobs,
+	defaults come from J_USER_MAX_* / J_GROUP_MAX_* and can be overridden
+	per id through J_LIMITS:
+
+		J_LIMITS=uid:1000:2:10,gid:1000:4:20
+		         <uid|gid>:<id>:<max running>:<max queued>
+
+	a limit of 0 means unlimited, root (id 0) is never limited
+
+	the queue of the nats and kafka dispatchers is shared by several instances, none of which
+	holds every queued job: the queued limits are checked against the jobs queued on record
+	upon publishing instead (see admitQueuedJobs).
+*/
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+var errJobLimit = errors.New("job limit reached")
+
+type limitKey struct {
+	group bool
+	id    int
+}
+
+func (k limitKey) String() string {
+	if k.group {
+		return fmt.Sprintf("group %d", k.id)
+	}
+
+	return fmt.Sprintf("user %d", k.id)
+}
+
+type jobLimit struct {
+	running int
+	queued  int
+}
+
+type jobLimits struct {
+	user      jobLimit
+	group     jobLimit
+	overrides map[limitKey]jobLimit
+}
+
+func newJobLimits(cfg ut.EnvConfig) jobLimits {
+	limits := jobLimits{
+		user:      jobLimit{running: int(cfg.UspaceJobUserMaxRunning), queued: int(cfg.UspaceJobUserMaxQueued)},
+		group:     jobLimit{running: int(cfg.UspaceJobGroupMaxRunning), queued: int(cfg.UspaceJobGroupMaxQueued)},
+		overrides: make(map[limitKey]jobLimit),
+	}
+
+	for _, entry := range strings.Split(cfg.UspaceJobLimits, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, limit, err := parseJobLimit(entry)
+		if err != nil {
+			log.Printf("[Scheduler] ignoring job limit %q: %v", entry, err)
+
+			continue
+		}
+		limits.overrides[key] = limit
+	}
+
+	return limits
+}
+
+func parseJobLimit(entry string) (limitKey, jobLimit, error) {
+	parts := strings.Split(entry, ":")
+	if len(parts) != 4 {
+		return limitKey{}, jobLimit{}, errors.New("expected <uid|gid>:<id>:<max running>:<max queued>")
+	}
+
+	var key limitKey
+	switch parts[0] {
+	case "uid":
+	case "gid":
+		key.group = true
+	default:
+
+		return limitKey{}, jobLimit{}, errors.New("expected uid or gid")
+	}
+
+	values := make([]int, 3)
+	for i, p := range parts[1:] {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || v < 0 {
+			return limitKey{}, jobLimit{}, fmt.Errorf("invalid number: %q", p)
+		}
+		values[i] = v
+	}
+	key.id = values[0]
+
+	return key, jobLimit{running: values[1], queued: values[2]}, nil
+}
+
+// keysOf returns the limited identities a job is accounted to
+func keysOf(job ut.Job) []limitKey {
+	keys := make([]limitKey, 0, 2)
+	if job.UID != 0 {
+		keys = append(keys, limitKey{id: job.UID})
+	}
+	if job.GID != 0 {
+		keys = append(keys, limitKey{group: true, id: job.GID})
+	}
+
+	return keys
+}
+
+func (l jobLimits) of(key limitKey) jobLimit {
+	if limit, exists := l.overrides[key]; exists {
+		return limit
+	}
+	if key.group {
+		return l.group
+	}
+
+	return l.user
+}
+
+// canRun tells if a job may start, given the currently running jobs per identity
+func (l jobLimits) canRun(job ut.Job, running map[limitKey]int) bool {
+	for _, key := range keysOf(job) {
+		limit := l.of(key).running
+		if limit > 0 && running[key] >= limit {
+			return false
+		}
+	}
+
+	return true
+}
+
+// admit checks that the given jobs fit next to the already queued ones
+func (l jobLimits) admit(jobs []ut.Job, queued map[limitKey]int) error {
+	extra := make(map[limitKey]int)
+	for _, job := range jobs {
+		for _, key := range keysOf(job) {
+			extra[key]++
+			limit := l.of(key).queued
+			if limit > 0 && queued[key]+extra[key] > limit {
+				return fmt.Errorf("%w: %s may have at most %d queued jobs", errJobLimit, key, limit)
+			}
+		}
+	}
+
+	return nil
+}
+
+// admitQueuedJobs checks that the given jobs fit next to the jobs queued on record
+func (srv *UService) admitQueuedJobs(limits jobLimits, jobs []ut.Job) error {
+	queued, err := srv.countQueuedJobs()
+	if err != nil {
+		return err
+	}
+
+	return limits.admit(jobs, queued)
+}
@@ -0,0 +1,197 @@
+package uspace
+
+import (
+	"errors"
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestParseJobLimit(t *testing.T) {
+	tests := []struct {
+		entry   string
+		key     limitKey
+		limit   jobLimit
+		invalid bool
+	}{
+		{entry: "uid:1000:2:10", key: limitKey{id: 1000}, limit: jobLimit{running: 2, queued: 10}},
+		{entry: "gid:100:4:0", key: limitKey{group: true, id: 100}, limit: jobLimit{running: 4}},
+		{entry: "uid:1000:2", invalid: true},
+		{entry: "pid:1000:2:10", invalid: true},
+		{entry: "uid:1000:-1:10", invalid: true},
+		{entry: "uid:me:1:10", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			key, limit, err := parseJobLimit(tt.entry)
+			if tt.invalid {
+				assert.Error(t, err)
+
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, key, tt.key)
+			assert.Equal(t, limit, tt.limit)
+		})
+	}
+}
+
+func TestNewJobLimits(t *testing.T) {
+	limits := newJobLimits(ut.EnvConfig{
+		UspaceJobUserMaxRunning:  1,
+		UspaceJobUserMaxQueued:   2,
+		UspaceJobGroupMaxRunning: 3,
+		UspaceJobGroupMaxQueued:  4,
+		UspaceJobLimits:          "uid:1000:5:6, gid:100:7:8,bogus",
+	})
+
+	assert.Equal(t, limits.of(limitKey{id: 1}), jobLimit{running: 1, queued: 2})
+	assert.Equal(t, limits.of(limitKey{group: true, id: 1}), jobLimit{running: 3, queued: 4})
+	assert.Equal(t, limits.of(limitKey{id: 1000}), jobLimit{running: 5, queued: 6})
+	assert.Equal(t, limits.of(limitKey{group: true, id: 100}), jobLimit{running: 7, queued: 8})
+}
+
+func TestJobLimitsAdmit(t *testing.T) {
+	limits := jobLimits{
+		user:  jobLimit{queued: 2},
+		group: jobLimit{queued: 3},
+		overrides: map[limitKey]jobLimit{
+			{id: 1000}:             {queued: 1},
+			{id: 2000}:             {},
+			{group: true, id: 200}: {queued: 1},
+			{group: true, id: 300}: {},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		queued  map[limitKey]int
+		jobs    []ut.Job
+		limited bool
+	}{
+		{name: "uid under its limit", jobs: []ut.Job{{UID: 1, GID: 300}, {UID: 1, GID: 300}}},
+		{name: "uid at its limit", queued: map[limitKey]int{{id: 1}: 2}, jobs: []ut.Job{{UID: 1, GID: 300}}, limited: true},
+		{name: "a batch over the uid limit", jobs: []ut.Job{{UID: 1, GID: 300}, {UID: 1, GID: 300}, {UID: 1, GID: 300}}, limited: true},
+		{name: "uid override", queued: map[limitKey]int{{id: 1000}: 1}, jobs: []ut.Job{{UID: 1000, GID: 300}}, limited: true},
+		{name: "uid unlimited", queued: map[limitKey]int{{id: 2000}: 100}, jobs: []ut.Job{{UID: 2000, GID: 300}}},
+		{name: "gid under its limit", queued: map[limitKey]int{{group: true, id: 1}: 2}, jobs: []ut.Job{{UID: 2000, GID: 1}}},
+		{name: "gid at its limit", queued: map[limitKey]int{{group: true, id: 1}: 3}, jobs: []ut.Job{{UID: 2000, GID: 1}}, limited: true},
+		{name: "gid shared among uids", jobs: []ut.Job{{UID: 2000, GID: 200}, {UID: 3, GID: 200}}, limited: true},
+		{name: "gid unlimited", queued: map[limitKey]int{{group: true, id: 300}: 100}, jobs: []ut.Job{{UID: 2000, GID: 300}}},
+		{name: "root is never limited", queued: map[limitKey]int{{id: 0}: 100}, jobs: []ut.Job{{UID: 0, GID: 0}, {UID: 0, GID: 0}, {UID: 0, GID: 0}}},
+		{name: "other uids do not count", queued: map[limitKey]int{{id: 2}: 2}, jobs: []ut.Job{{UID: 1, GID: 300}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := limits.admit(tt.jobs, tt.queued)
+			if tt.limited {
+				assert.True(t, errors.Is(err, errJobLimit))
+
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestJobLimitsCanRun(t *testing.T) {
+	limits := jobLimits{
+		user:      jobLimit{running: 1},
+		group:     jobLimit{running: 2},
+		overrides: map[limitKey]jobLimit{{id: 1000}: {running: 3}, {group: true, id: 300}: {}},
+	}
+
+	tests := []struct {
+		name    string
+		running map[limitKey]int
+		job     ut.Job
+		can     bool
+	}{
+		{name: "nothing running", job: ut.Job{UID: 1, GID: 1}, can: true},
+		{name: "uid at its limit", running: map[limitKey]int{{id: 1}: 1}, job: ut.Job{UID: 1, GID: 1}},
+		{name: "uid override", running: map[limitKey]int{{id: 1000}: 2}, job: ut.Job{UID: 1000, GID: 300}, can: true},
+		{name: "gid at its limit", running: map[limitKey]int{{group: true, id: 1}: 2}, job: ut.Job{UID: 2, GID: 1}},
+		{name: "gid unlimited", running: map[limitKey]int{{group: true, id: 300}: 50}, job: ut.Job{UID: 2, GID: 300}, can: true},
+		{name: "root", running: map[limitKey]int{{id: 0}: 50}, job: ut.Job{}, can: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, limits.canRun(tt.job, tt.running), tt.can)
+		})
+	}
+}
+
+func TestJobQueueLimits(t *testing.T) {
+	queued := jobLimits{user: jobLimit{queued: 2}, group: jobLimit{queued: 3}}
+	running := jobLimits{user: jobLimit{running: 1}, group: jobLimit{running: 2}}
+
+	t.Run("queued per uid, released once taken", func(t *testing.T) {
+		q := newJobQueue(10, 0, queued)
+		assert.NoError(t, q.push(ut.Job{JID: 1, UID: 1, GID: 1}, false))
+		assert.NoError(t, q.push(ut.Job{JID: 2, UID: 1, GID: 1}, false))
+		assert.True(t, errors.Is(q.push(ut.Job{JID: 3, UID: 1, GID: 1}, false), errJobLimit))
+		// recovered jobs were accepted once, they are not limited
+		assert.NoError(t, q.push(ut.Job{JID: 3, UID: 1, GID: 1}, true))
+
+		q.pop()
+		assert.True(t, errors.Is(q.admit([]ut.Job{{JID: 4, UID: 1, GID: 1}}), errJobLimit))
+		q.pop()
+		assert.NoError(t, q.push(ut.Job{JID: 4, UID: 1, GID: 1}, false))
+	})
+
+	t.Run("queued per gid", func(t *testing.T) {
+		q := newJobQueue(10, 0, queued)
+		assert.NoError(t, q.push(ut.Job{JID: 1, UID: 1, GID: 1}, false))
+		assert.NoError(t, q.push(ut.Job{JID: 2, UID: 2, GID: 1}, false))
+		assert.NoError(t, q.push(ut.Job{JID: 3, UID: 3, GID: 1}, false))
+		assert.True(t, errors.Is(q.push(ut.Job{JID: 4, UID: 4, GID: 1}, false), errJobLimit))
+		assert.NoError(t, q.push(ut.Job{JID: 4, UID: 4, GID: 2}, false))
+
+		_, err := q.remove(1)
+		assert.NoError(t, err)
+		assert.NoError(t, q.push(ut.Job{JID: 5, UID: 5, GID: 1}, false))
+	})
+
+	t.Run("running per uid, released on finish", func(t *testing.T) {
+		q := newJobQueue(10, 0, running)
+		assert.NoError(t, q.push(ut.Job{JID: 1, UID: 1, GID: 1}, false))
+		assert.NoError(t, q.push(ut.Job{JID: 2, UID: 1, GID: 1}, false))
+		assert.NoError(t, q.push(ut.Job{JID: 3, UID: 2, GID: 2}, false))
+
+		first := q.pop()
+		assert.Equal(t, first.JID, int64(1))
+		// uid 1 is at its limit, its turn is skipped
+		assert.Equal(t, q.pop().JID, int64(3))
+		assert.False(t, running.canRun(ut.Job{UID: 1, GID: 1}, q.running))
+
+		q.done(first)
+		assert.Equal(t, q.pop().JID, int64(2))
+	})
+
+	t.Run("running per gid, released on finish", func(t *testing.T) {
+		q := newJobQueue(10, 0, running)
+		assert.NoError(t, q.push(ut.Job{JID: 1, UID: 1, GID: 1}, false))
+		assert.NoError(t, q.push(ut.Job{JID: 2, UID: 2, GID: 1}, false))
+		assert.NoError(t, q.push(ut.Job{JID: 3, UID: 3, GID: 1}, false))
+
+		first := q.pop()
+		q.pop()
+		assert.False(t, running.canRun(ut.Job{UID: 3, GID: 1}, q.running))
+
+		q.done(first)
+		assert.Equal(t, q.pop().JID, int64(3))
+	})
+
+	t.Run("recovered running jobs count", func(t *testing.T) {
+		q := newJobQueue(10, 0, running)
+		q.markRunning(ut.Job{JID: 1, UID: 1, GID: 1})
+		assert.False(t, running.canRun(ut.Job{UID: 1, GID: 1}, q.running))
+		q.done(ut.Job{JID: 1, UID: 1, GID: 1})
+		assert.True(t, running.canRun(ut.Job{UID: 1, GID: 1}, q.running))
+	})
+}
@@ -0,0 +1,377 @@
+package uspace
+
+/*
+	persistent job logs
+
+	everything an executor streams for a job is appended to <J_LOGS_PATH>/job-<jid>.log
+	before being forwarded to the job's socket, one entry per line:
+
+		<RFC3339Nano timestamp> <stream> <text>
+
+	stream is one of stdout, stderr or executor (messages of the executor about the job).
+	The k8s API serves the combined output of a pod, which is recorded as stdout.
+
+	the local file is served while the job is active, on the instance running it. Once the
+	last stream of a job that is over closes, the file is stored in the volume of its output as
+	<volume>/logs/job-<jid>.log, owned by the owner of the job, recorded on the job (Job.Log)
+	and removed, the log of a finished job is read from there (from the local file as long as it
+	is not stored). A later stream (a cancellation notice) is appended to the stored log.
+*/
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// default value
+var jobLogsPath = "data/logs/jobs/output/"
+
+// the streams open on the log of every job, see streamJobOutput
+var (
+	jobLogStreamsMu sync.Mutex
+	jobLogStreams   = make(map[int64]int)
+)
+
+const (
+	logStdout   = "stdout"
+	logStderr   = "stderr"
+	logExecutor = "executor"
+
+	// prefixes marking the origin of a streamed message
+	executorPrefix = "[executor]"
+	stderrPrefix   = "[stderr] "
+	podPrefix      = "\t[POD]"
+)
+
+// JobLogEntry struct, a single persisted line of a job's output
+type JobLogEntry struct {
+	Time   string `json:"time"`
+	Stream string `json:"stream"`
+	Line   string `json:"line"`
+}
+
+func jobLogFile(jid int64) string {
+	return filepath.Join(jobLogsPath, fmt.Sprintf("job-%d.log", jid))
+}
+
+// jobLogObject returns the name the log of a job is stored as, in the volume of its output
+func jobLogObject(jid int64) string {
+	return fmt.Sprintf("logs/job-%d.log", jid)
+}
+
+// classifyLogLine tells the stream a message belongs to by its prefix, stripping the prefix of the job's own output
+func classifyLogLine(line, fallback string) (string, string) {
+	switch {
+	case strings.HasPrefix(line, executorPrefix):
+		return logExecutor, line
+	case strings.HasPrefix(line, stderrPrefix):
+		return logStderr, strings.TrimPrefix(line, stderrPrefix)
+	case strings.HasPrefix(line, podPrefix):
+		return logStdout, strings.TrimPrefix(line, podPrefix)
+	default:
+		return fallback, line
+	}
+}
+
+// streamJobOutput persists every message of a job's stream and forwards it to the job's socket,
+// unprefixed messages are recorded under the given stream.
+// the stream is drained until closed even if the socket goes away, the log is then stored if the job is over.
+func (srv *UService) streamJobOutput(jobID int64, stream string, ch <-chan []byte) {
+	jobLogStreamsMu.Lock()
+	jobLogStreams[jobID]++
+	jobLogStreamsMu.Unlock()
+	defer srv.closeJobLogStream(jobID)
+
+	var logFile *os.File
+	err := os.MkdirAll(jobLogsPath, 0o755)
+	if err == nil {
+		logFile, err = os.OpenFile(jobLogFile(jobID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	}
+	if err != nil {
+		log.Printf("failed to open the log file of job %d, its output will not be kept: %v", jobID, err)
+	} else {
+		defer func() {
+			err := logFile.Close()
+			if err != nil {
+				log.Printf("failed to close the log file of job %d: %v", jobID, err)
+			}
+		}()
+	}
+
+	socket := make(chan []byte, cap(ch))
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		streamToSocketWS(jobID, socket)
+	}()
+	defer close(socket)
+
+	for msg := range ch {
+		if logFile != nil {
+			now := time.Now().UTC().Format(time.RFC3339Nano)
+			var b strings.Builder
+			for _, line := range strings.Split(strings.TrimRight(string(msg), "\n"), "\n") {
+				s, text := classifyLogLine(line, stream)
+				fmt.Fprintf(&b, "%s %s %s\n", now, s, text)
+			}
+			_, err := logFile.WriteString(b.String())
+			if err != nil {
+				log.Printf("failed to persist the output of job %d: %v", jobID, err)
+			}
+		}
+
+		select {
+		case socket <- msg:
+		case <-gone:
+		}
+	}
+}
+
+// closeJobLogStream stores the log of a job once its last stream closes, if the job is over
+func (srv *UService) closeJobLogStream(jid int64) {
+	jobLogStreamsMu.Lock()
+	jobLogStreams[jid]--
+	last := jobLogStreams[jid] <= 0
+	if last {
+		delete(jobLogStreams, jid)
+	}
+	jobLogStreamsMu.Unlock()
+	if !last {
+		return
+	}
+
+	job, err := srv.getJobByID(int(jid))
+	if err != nil {
+		log.Printf("failed to retrieve job %d, its log is not stored: %v", jid, err)
+
+		return
+	}
+	if !jobActive(job.Status) {
+		srv.storeJobLog(job)
+	}
+}
+
+// storeJobLog uploads the log of a job to the volume of its output, after the log already stored if any,
+// records it on the job and removes the local file. The local file is kept (and served) if it fails.
+func (srv *UService) storeJobLog(job ut.Job) {
+	if _, err := os.Stat(jobLogFile(job.JID)); err != nil {
+		return
+	}
+	if job.Log != "" {
+		err := srv.prependStoredJobLog(job)
+		if err != nil {
+			log.Printf("failed to retrieve the stored log of job %d: %v", job.JID, err)
+
+			return
+		}
+	}
+	vname, _ := srv.splitJobOutput(job)
+	artifact, err := srv.storeJobOutput(job, jobLogFile(job.JID), vname, jobLogObject(job.JID))
+	if err != nil {
+		log.Printf("failed to store the log of job %d: %v", job.JID, err)
+
+		return
+	}
+	err = srv.setJobLog(job.JID, artifact.Volume+"/"+artifact.Name)
+	if err != nil {
+		log.Printf("failed to record the log of job %d: %v", job.JID, err)
+
+		return
+	}
+	removeJobLog(job.JID)
+}
+
+// prependStoredJobLog puts the stored log of a job before its local file, so that storing it again keeps both
+func (srv *UService) prependStoredJobLog(job ut.Job) error {
+	stored, closeFn, err := srv.openStoredJobLog(job)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	tmp, err := os.CreateTemp(jobLogsPath, fmt.Sprintf("job-%d-*.log", job.JID))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := os.Remove(tmp.Name())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("failed to remove %s: %v", tmp.Name(), err)
+		}
+	}()
+
+	local, err := os.Open(jobLogFile(job.JID))
+	if err == nil {
+		_, err = io.Copy(tmp, stored)
+		if err == nil {
+			_, err = io.Copy(tmp, local)
+		}
+		_ = local.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), jobLogFile(job.JID))
+}
+
+func parseJobLogEntry(line string) (JobLogEntry, bool) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return JobLogEntry{}, false
+	}
+	entry := JobLogEntry{Time: parts[0], Stream: parts[1]}
+	if len(parts) == 3 {
+		entry.Line = parts[2]
+	}
+
+	return entry, true
+}
+
+// jobLog returns the entries of the log of a job past the given offset (see readJobLog):
+// out of the local file while the job is active or its log is not stored (yet), out of the stored log otherwise.
+// Nothing is returned for a job without a log, the offset is left as is.
+func (srv *UService) jobLog(job ut.Job, offset, tail int) ([]JobLogEntry, int, error) {
+	if jobActive(job.Status) || job.Log == "" {
+		return readJobLog(job.JID, offset, tail)
+	}
+
+	stored, closeFn, err := srv.openStoredJobLog(job)
+	if err != nil {
+		return nil, offset, err
+	}
+	defer closeFn()
+
+	return scanJobLog(stored, offset, tail)
+}
+
+// openStoredJobLog opens the log stored for a job, to be closed by the returned function
+func (srv *UService) openStoredJobLog(job ut.Job) (io.Reader, func(), error) {
+	vname, name, _ := strings.Cut(job.Log, "/")
+	resource := &ut.Resource{Vname: vname, Name: name}
+	var r any = resource
+	cancelFn, err := srv.storage.Download(&r)
+	closeFn := func() {
+		if closer, ok := resource.Reader.(io.Closer); ok {
+			err := closer.Close()
+			if err != nil {
+				log.Printf("failed to close %s: %v", job.Log, err)
+			}
+		}
+		if cancelFn != nil {
+			cancelFn()
+		}
+	}
+	if err != nil {
+		closeFn()
+
+		return nil, nil, fmt.Errorf("failed to download %s: %w", job.Log, err)
+	}
+	if resource.Reader == nil {
+		closeFn()
+
+		return nil, nil, fmt.Errorf("log %s not found", job.Log)
+	}
+
+	return resource.Reader, closeFn, nil
+}
+
+// readJobLog returns the entries of the local log of a job past the given offset (in entries),
+// only the last tail ones if tail > 0, along with the offset of the next entry to come
+func readJobLog(jid int64, offset, tail int) ([]JobLogEntry, int, error) {
+	f, err := os.Open(jobLogFile(jid))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, offset, nil
+		}
+
+		return nil, offset, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			log.Printf("failed to close the log file of job %d: %v", jid, err)
+		}
+	}()
+
+	return scanJobLog(f, offset, tail)
+}
+
+// scanJobLog reads the entries of a log past the given offset, see readJobLog
+func scanJobLog(r io.Reader, offset, tail int) ([]JobLogEntry, int, error) {
+	var entries []JobLogEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	count := 0
+	for scanner.Scan() {
+		count++
+		if count <= offset {
+			continue
+		}
+		entry, ok := parseJobLogEntry(scanner.Text())
+		if !ok {
+			continue
+		}
+		entries = append(entries, entry)
+		if tail > 0 && len(entries) > tail {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, offset, err
+	}
+
+	return entries, count, nil
+}
+
+// jobLogText returns the text a job wrote to the given stream
+func (srv *UService) jobLogText(job ut.Job, stream string) (string, error) {
+	entries, _, err := srv.jobLog(job, 0, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		if entry.Stream == stream {
+			b.WriteString(entry.Line)
+			b.WriteByte('\n')
+		}
+	}
+
+	return b.String(), nil
+}
+
+// lastJobLogTime returns the time of the last persisted entry of a job, zero if there is none
+func lastJobLogTime(jid int64) time.Time {
+	entries, _, err := readJobLog(jid, 0, 1)
+	if err != nil || len(entries) == 0 {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, entries[0].Time)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+func removeJobLog(jid int64) {
+	err := os.Remove(jobLogFile(jid))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("failed to remove the log file of job %d: %v", jid, err)
+	}
+}
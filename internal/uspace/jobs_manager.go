@@ -3,7 +3,6 @@ package uspace
 import (
 	"bufio"
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	ut "kyri56xcaesar/kuspace/internal/utils"
 
@@ -37,9 +37,10 @@ type JobDispatcherImpl struct {
 	Manager JobManager
 }
 
-// Start method launching the Dispatcher work
+// Start method launching the Dispatcher work, along with the recurring jobs scheduler
 func (j JobDispatcherImpl) Start() {
 	j.Manager.StartDispatcher()
+	j.Manager.StartScheduler()
 }
 
 // PublishJob method which publishes an incoming Job towards into a Queue towards execution
@@ -51,7 +52,12 @@ func (j JobDispatcherImpl) PublishJob(jb ut.Job) error {
 }
 
 // PublishJobs method, same as PublishJob but with plurality
+// the whole batch is rejected if it does not fit within the limits
 func (j JobDispatcherImpl) PublishJobs(jbs []ut.Job) error {
+	err := j.Manager.jobQueue.admit(jbs)
+	if err != nil {
+		return err
+	}
 	for _, jb := range jbs {
 		err := j.Manager.ScheduleJob(jb)
 		if err != nil {
@@ -62,8 +68,12 @@ func (j JobDispatcherImpl) PublishJobs(jbs []ut.Job) error {
 	return nil
 }
 
+// SetJobPriority method changes the priority of a Job still waiting in the Queue
+func (j JobDispatcherImpl) SetJobPriority(jid int64, priority int) error {
+	return j.Manager.SetJobPriority(jid, priority)
+}
+
 // RemoveJob method removes a Job from the Execution Queue pre or while execution
-// Not fully functional
 func (j JobDispatcherImpl) RemoveJob(jid int) error {
 	return j.Manager.CancelJob(jid)
 }
@@ -103,15 +113,41 @@ type JobManager struct {
 	mu  *sync.Mutex
 
 	// jobs       map[int]*Job // cache of the jobs
-	jobQueue   chan ut.Job   // actual queue of the jobs
+	jobQueue   *jobQueue     // actual (priority) queue of the jobs
 	workerPool chan struct{} //
 
+	active   map[int64]ut.Job       // jobs taken out of the queue, not yet finished (guarded by mu)
+	canceled map[int64]bool         // active jobs asked to stop (guarded by mu)
+	failures map[int64]jobFailure   // why active jobs failed, as reported by the executor (guarded by mu)
+	retrying map[int64]pendingRetry // failed jobs waiting to be retried (guarded by mu)
+
 	executor JobExecutor // logic defined for exetuing a Job
+
+	// called once a worker takes a job, before it runs, lets a dispatcher acknowledge it or drop it (optional)
+	onTake func(job ut.Job) bool
+	// called once a job reaches its final status, lets a dispatcher acknowledge it (optional)
+	onFinish func(jid int64, status string)
+}
+
+// jobFailure is the failure class (see ut.Failure*) of an execution, along with a human readable reason
+type jobFailure struct {
+	class  string
+	reason string
+}
+
+// pendingRetry is a failed job to be put back in the queue after a delay
+type pendingRetry struct {
+	job   ut.Job
+	delay time.Duration
 }
 
 // NewJobManager function as in a constructor for JobManager struct
 /* constructor for the JobManager */
 func NewJobManager(srv *UService) JobManager {
+	return newJobManager(srv, nil, nil)
+}
+
+func newJobManager(srv *UService, onTake func(job ut.Job) bool, onFinish func(jid int64, status string)) JobManager {
 	qs, err := strconv.Atoi(srv.config.UspaceJobQueueSize)
 	if err != nil {
 		qs = 100 // default size
@@ -120,16 +156,31 @@ func NewJobManager(srv *UService) JobManager {
 	if err != nil {
 		mw = 10 // default size
 	}
+	aging := defaultAgingEvery
+	if srv.config.UspaceJobAgingInterval >= 0 {
+		aging = time.Duration(srv.config.UspaceJobAgingInterval) * time.Second
+	}
 
 	jobsSocketAddress = srv.config.WssAddress
+	if srv.config.UspaceJobLogsPath != "" {
+		jobLogsPath = srv.config.UspaceJobLogsPath
+	}
 
 	jm := JobManager{
 		mu:  &sync.Mutex{},
 		srv: srv,
 
 		// jobs:       make(map[int]*Job),
-		jobQueue:   make(chan ut.Job, qs),
+		jobQueue:   newJobQueue(qs, aging, newJobLimits(srv.config)),
 		workerPool: make(chan struct{}, mw),
+
+		active:   make(map[int64]ut.Job),
+		canceled: make(map[int64]bool),
+		failures: make(map[int64]jobFailure),
+		retrying: make(map[int64]pendingRetry),
+
+		onTake:   onTake,
+		onFinish: onFinish,
 	}
 
 	executor, err := JobExecutorShipment(srv.config.UspaceJobExecutor, &jm)
@@ -141,17 +192,49 @@ func NewJobManager(srv *UService) JobManager {
 	return jm
 }
 
-// StartDispatcher method launches a goroutine which handles the jobQueue channel queue
+// StartDispatcher method launches a goroutine which handles the jobQueue priority queue
+// jobs left behind by a previous run of the service are recovered from the database
 func (jm *JobManager) StartDispatcher() {
+	jm.startWorker()
+
+	go jm.recoverJobs()
+}
+
+// startWorker launches the goroutine handing queued jobs to the executor
+func (jm *JobManager) startWorker() {
 	log.Printf("[Scheduler] Starting worker")
 	go func() {
-		for job := range jm.jobQueue {
+		for {
+			job := jm.jobQueue.pop()
+			jm.track(job)
 			log.Printf("[Scheduler] Job received: ID=%d. Waiting for available worker slot...", job.JID)
 			jm.workerPool <- struct{}{} // Acquire worker slot
+			if jm.isCanceled(job.JID) {
+				// canceled while waiting for a slot
+				<-jm.workerPool
+				jm.finishJob(job.JID, "canceled", 0)
+				jm.untrack(job.JID)
+				go jm.srv.notifyJobSocket(job.JID, fmt.Sprintf("[executor] Job %d canceled before execution\n", job.JID))
+
+				continue
+			}
+			if jm.onTake != nil && !jm.onTake(job) {
+				// dropped by the dispatcher, it recorded why
+				<-jm.workerPool
+				jm.untrack(job.JID)
+
+				continue
+			}
 			log.Printf("[Scheduler] Assigned job ID=%ds to a worker. Active workers: %d/%d",
 				job.JID, len(jm.workerPool), cap(jm.workerPool))
 			// the worker itself will release it
 			go func() {
+				defer jm.untrack(job.JID)
+				if jm.serveFromCache(job) {
+					<-jm.workerPool // nothing to run
+
+					return
+				}
 				err := jm.executor.ExecuteJob(job) // spawn worker goroutine
 				if err != nil {
 					log.Printf("execution of job: %v failed.", job.JID)
@@ -168,35 +251,338 @@ func (jm *JobManager) ScheduleJob(jb ut.Job) error {
 	jb.Status = "queued"
 	jb.CreatedAt = ut.CurrentTime()
 
-	select {
-	case jm.jobQueue <- jb:
-		log.Printf("[Scheduler] Job ID=%d added to queue. Current queue length: %d/%d",
-			jb.JID, len(jm.jobQueue), cap(jm.jobQueue))
+	err := jm.jobQueue.admit([]ut.Job{jb})
+	if err != nil {
+		log.Printf("⚠️ [Scheduler] Job ID=%d rejected: %v", jb.JID, err)
 
-		return nil
-	default:
-		log.Printf("⚠️ [Scheduler] Job queue full! Job ID=%d rejected", jb.JID)
+		return err
+	}
+	// marked before it is pushed, a worker may take it right away
+	jm.markStatus(jb.JID, "queued", ut.ActorScheduler, "")
+	err = jm.jobQueue.push(jb, false)
+	if err != nil {
+		log.Printf("⚠️ [Scheduler] Job ID=%d rejected: %v", jb.JID, err)
+
+		return err
+	}
+	log.Printf("[Scheduler] Job ID=%d (priority %d) added to queue. Current queue length: %d/%d",
+		jb.JID, jb.Priority, jm.jobQueue.len(), jm.jobQueue.capacity)
 
-		return errors.New("job queue full")
+	return nil
+}
+
+// SetJobPriority method changes the priority of a queued job, both in the queue and the database
+func (jm *JobManager) SetJobPriority(jid int64, priority int) error {
+	err := jm.jobQueue.setPriority(jid, priority)
+	if err != nil {
+		return err
 	}
+	log.Printf("[Scheduler] Job ID=%d priority set to %d", jid, priority)
+
+	return jm.srv.updateJobPriority(jid, priority)
 }
 
-// CancelJob method removes a job from the execution channel queue
-// Not fully functional yet
+// CancelJob method stops a job
+/*
+	a queued job is simply taken out of the queue,
+	an active job is marked as canceled and its executor is asked to kill it,
+	the executor will record the "canceled" status once the job is gone
+*/
 func (jm *JobManager) CancelJob(jid int) error {
-	log.Printf("canceling job: %v", jid)
+	id := int64(jid)
+	log.Printf("[Scheduler] Canceling job ID=%d", id)
+
+	_, err := jm.jobQueue.remove(id)
+	if err == nil {
+		jm.finishJob(id, "canceled", 0)
+		go jm.srv.notifyJobSocket(id, fmt.Sprintf("[executor] Job %d canceled before execution\n", id))
+
+		return nil
+	}
+
 	jm.mu.Lock()
-	defer jm.mu.Unlock()
+	job, exists := jm.active[id]
+	if exists {
+		jm.canceled[id] = true
+	}
+	_, retrying := jm.retrying[id]
+	if retrying && !exists {
+		delete(jm.retrying, id)
+	}
+	jm.mu.Unlock()
+	if retrying && !exists {
+		// waiting to be retried, nothing is running
+		jm.finishJob(id, "canceled", 0)
+
+		return nil
+	}
+	if !exists {
+		return errJobNotActive
+	}
 
-	// if _, exists := js.jobs[jid]; !exists {
-	// return fmt.Errorf("job %d not found", jid)
-	// }
+	jm.markStatus(id, "canceled", ut.ActorUser, "canceled while active")
+	err = jm.executor.CancelJob(job)
+	if err != nil {
+		log.Printf("[Scheduler] failed to stop job ID=%d: %v", id, err)
+
+		return err
+	}
 
-	// delete(js.jobs, jid)
-	// log.Printf("Job %d cancelled\n", jid)
 	return nil
 }
 
+// recoverJobs rebuilds the queue out of the jobs database
+/*
+	pending/queued (or retrying) jobs are put back in the queue in their original order,
+	running jobs are checked against the executor:
+	  - still alive (or finished while we were away): re-attached so that their outcome is recorded
+	  - unknown to the executor: re-queued or marked as failed, according to J_RECOVERY_POLICY
+*/
+func (jm *JobManager) recoverJobs() {
+	jobs, err := jm.srv.getJobsByStatus([]string{"pending", "queued", "retrying", "running"})
+	if err != nil {
+		log.Printf("[Scheduler] failed to retrieve unfinished jobs, nothing recovered: %v", err)
+
+		return
+	}
+	// workflows and arrays move on once their jobs are recovered
+	defer jm.srv.resumeJobArrays()
+	defer jm.srv.resumeWorkflows()
+	if len(jobs) == 0 {
+		return
+	}
+	log.Printf("[Scheduler] recovering %d unfinished job(s)", len(jobs))
+
+	for _, job := range jobs {
+		if job.Status != "running" {
+			jm.requeue(job)
+
+			continue
+		}
+
+		status, err := jm.executor.GetJobStatus(job)
+		if err != nil {
+			log.Printf("[Scheduler] failed to retrieve job ID=%d status from the executor: %v", job.JID, err)
+		}
+		switch status {
+		case "running", "completed", "failed":
+			log.Printf("[Scheduler] re-attaching to job ID=%d (%s)", job.JID, status)
+			jm.track(job)
+			jm.jobQueue.markRunning(job)
+			jm.workerPool <- struct{}{} // the executor will release it
+			go func() {
+				defer jm.untrack(job.JID)
+				err := jm.executor.AttachJob(job)
+				if err != nil {
+					log.Printf("re-attaching to job: %v failed: %v", job.JID, err)
+				}
+			}()
+		default:
+			if strings.ToLower(jm.srv.config.UspaceJobRecoveryPolicy) == "fail" {
+				log.Printf("[Scheduler] job ID=%d was lost, marking as failed", job.JID)
+				jm.markStatus(job.JID, "failed", ut.ActorScheduler, "lost by the executor")
+
+				continue
+			}
+			log.Printf("[Scheduler] job ID=%d was lost, re-queueing", job.JID)
+			jm.requeue(job)
+		}
+	}
+}
+
+// requeue puts a recovered job back in the queue, waiting for space if needed
+func (jm *JobManager) requeue(job ut.Job) {
+	from := job.Status
+	job.Status = "queued"
+	err := jm.jobQueue.push(job, true)
+	if err != nil {
+		log.Printf("[Scheduler] failed to re-queue job ID=%d: %v", job.JID, err)
+
+		return
+	}
+	jm.markStatus(job.JID, "queued", ut.ActorScheduler, "re-queued, was "+from)
+}
+
+// finishJob records the final status of an active job and returns it,
+// a job that was canceled is recorded as such regardless of how it ended
+/*
+	every execution of an active job is recorded as an attempt,
+	a failed job whose retry policy covers the failure is recorded as "retrying"
+	and put back in the queue once its backoff expires (see untrack)
+*/
+func (jm *JobManager) finishJob(jid int64, status string, duration time.Duration) string {
+	jm.mu.Lock()
+	canceled := jm.canceled[jid]
+	job, active := jm.active[jid]
+	failure, reported := jm.failures[jid]
+	delete(jm.failures, jid)
+	jm.mu.Unlock()
+	if canceled {
+		status = "canceled"
+	}
+
+	if active {
+		attempt := ut.JobAttempt{Attempt: job.Attempts + 1, Status: status}
+		failed := status == "failed" || status == "timeout"
+		if failed {
+			if !reported {
+				failure = jobFailure{class: ut.FailureError}
+			}
+			attempt.FailureClass, attempt.Reason = failure.class, failure.reason
+		}
+		err := jm.srv.insertJobAttempt(jid, attempt, duration)
+		if err != nil {
+			log.Printf("[Scheduler] failed to record attempt %d of job ID=%d: %v", attempt.Attempt, jid, err)
+		}
+
+		if failed && job.Retry.Retries(failure.class, attempt.Attempt) {
+			job.Attempts = attempt.Attempt
+			delay := job.Retry.Delay(attempt.Attempt)
+			log.Printf("[Scheduler] Job ID=%d failed (%s), retrying in %v (attempt %d/%d)",
+				jid, failure.class, delay, attempt.Attempt+1, job.Retry.MaxAttempts)
+			jm.mu.Lock()
+			jm.retrying[jid] = pendingRetry{job: job, delay: delay}
+			jm.mu.Unlock()
+			jm.markStatus(jid, "retrying", ut.ActorScheduler,
+				fmt.Sprintf("attempt %d failed (%s), retrying in %v", attempt.Attempt, failure.class, delay))
+
+			return "retrying"
+		}
+	}
+
+	actor, reason := finishActor(status, canceled, failure)
+	err := jm.srv.markJobStatus(jid, status, duration, actor, reason)
+	if err != nil {
+		log.Printf("[Scheduler] failed to mark job ID=%d as %s: %v", jid, status, err)
+	}
+
+	if jm.onFinish != nil {
+		jm.onFinish(jid, status)
+	}
+	// let whatever waits on this job move on
+	go jm.srv.workflowJobFinished(jid)
+	go jm.srv.arrayJobFinished(jid)
+
+	return status
+}
+
+// failJob records why an active job failed before finishing it, returns the recorded status,
+// a job that exceeded its timeout is recorded as "timeout" rather than "failed"
+func (jm *JobManager) failJob(jid int64, class, reason string, duration time.Duration) string {
+	jm.mu.Lock()
+	jm.failures[jid] = jobFailure{class: class, reason: reason}
+	jm.mu.Unlock()
+
+	status := "failed"
+	if class == ut.FailureTimeout {
+		status = "timeout"
+	}
+
+	return jm.finishJob(jid, status, duration)
+}
+
+func (jm *JobManager) track(job ut.Job) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	jm.active[job.JID] = job
+}
+
+// untrack forgets a finished job, freeing its running slot in the per user/group limits,
+// a job to be retried is put back in the queue after its backoff
+func (jm *JobManager) untrack(jid int64) {
+	jm.mu.Lock()
+	job, exists := jm.active[jid]
+	canceled := jm.canceled[jid]
+	retry, retrying := jm.retrying[jid]
+	if retrying && canceled {
+		delete(jm.retrying, jid)
+	}
+	delete(jm.active, jid)
+	delete(jm.canceled, jid)
+	delete(jm.failures, jid)
+	jm.mu.Unlock()
+
+	if exists {
+		jm.jobQueue.done(job)
+	}
+	switch {
+	case retrying && canceled:
+		// canceled after it failed
+		jm.finishJob(jid, "canceled", 0)
+	case retrying:
+		time.AfterFunc(retry.delay, func() { jm.retryJob(jid) })
+	}
+}
+
+// retryJob puts a job waiting to be retried back in the queue, unless it got canceled meanwhile
+func (jm *JobManager) retryJob(jid int64) {
+	jm.mu.Lock()
+	retry, retrying := jm.retrying[jid]
+	delete(jm.retrying, jid)
+	jm.mu.Unlock()
+	if !retrying {
+		return
+	}
+
+	log.Printf("[Scheduler] retrying job ID=%d (attempt %d)", jid, retry.job.Attempts+1)
+	jm.requeue(retry.job)
+}
+
+// jobActive tells if a job with the given status has yet to reach a final status
+func jobActive(status string) bool {
+	switch status {
+	case "pending", "queued", "retrying", "running":
+		return true
+	default:
+		return false
+	}
+}
+
+// jobSucceeded tells if a job with the given status produced its output, by running or out of the cache
+func jobSucceeded(status string) bool {
+	return status == "completed" || status == "cached"
+}
+
+func (jm *JobManager) isCanceled(jid int64) bool {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	return jm.canceled[jid]
+}
+
+// finishActor returns who brought a job to its final status and why
+func finishActor(status string, canceled bool, failure jobFailure) (string, string) {
+	switch {
+	case canceled || status == "canceled":
+		return ut.ActorUser, "canceled"
+	case status == "cached":
+		return ut.ActorScheduler, "output reused from an identical job"
+	case failure.class != "" && failure.reason != "":
+		return ut.ActorExecutor, failure.class + ": " + failure.reason
+	case failure.class != "":
+		return ut.ActorExecutor, failure.class
+	default:
+		return ut.ActorExecutor, ""
+	}
+}
+
+// markStatus persists an intermediate job status, failures are only logged
+func (jm *JobManager) markStatus(jid int64, status, actor, reason string) {
+	err := jm.srv.markJobStatus(jid, status, 0, actor, reason)
+	if err != nil {
+		log.Printf("[Scheduler] failed to mark job ID=%d as %s: %v", jid, status, err)
+	}
+}
+
+// recordUsage accounts the resources an execution of a job consumed, reported by the executor before finishing it
+func (jm *JobManager) recordUsage(jid int64, usage ut.JobUsage) {
+	err := jm.srv.addJobUsage(jid, usage)
+	if err != nil {
+		log.Printf("[Scheduler] failed to record the usage of job ID=%d: %v", jid, err)
+	}
+}
+
 func streamToSocketWS(jobID int64, ch <-chan []byte) {
 	jobIDStr := strconv.FormatInt(jobID, 10)
 	wsURL := fmt.Sprintf("ws://"+jobsSocketAddress+"/get-session?jid=%s&role=Producer", jobIDStr)
@@ -227,6 +613,14 @@ func streamToSocketWS(jobID int64, ch <-chan []byte) {
 	}
 }
 
+// notifyJobSocket sends a single message to the socket of a job which has no executor stream
+func (srv *UService) notifyJobSocket(jobID int64, msg string) {
+	ch := make(chan []byte, 1)
+	ch <- []byte(msg)
+	close(ch)
+	srv.streamJobOutput(jobID, logExecutor, ch)
+}
+
 func streamToSocket(jobID int, pipe io.Reader) {
 	jobIDStr := strconv.Itoa(jobID)
 	scanner := bufio.NewScanner(pipe)
@@ -0,0 +1,519 @@
+package uspace
+
+/*
+	NATS JetStream dispatcher
+
+	jobs are published to a JetStream work queue stream, one subject per job (kuspace.jobs.queue.<jid>),
+	so that several uspace instances may publish jobs and several may consume them.
+
+	delivery is at-least-once:
+	  - a consuming instance pulls a job only when it has a free worker slot,
+	    and hands it to its local JobManager (and thus its executor)
+	  - the message is acknowledged once the job reaches its final status (a retried job stays unacknowledged),
+	    meanwhile it is kept alive by a heartbeat
+	  - a job whose instance died (no ack, no heartbeat within J_NATS_ACK_WAIT) is redelivered to another one,
+	    up to J_NATS_MAX_DELIVER times
+
+	cancel/priority requests are broadcast (kuspace.jobs.cancel/priority) to the consuming instances,
+	the one holding the job replies, a job still waiting in the stream is simply deleted from it.
+
+	all instances are expected to share the jobs database: the job id is what ties a message to its record.
+
+	an embedded server (J_NATS_EMBEDDED) can be started for single node setups, nothing external is required then.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	natsJobsStream      = "KUSPACE_JOBS"
+	natsJobsConsumer    = "kuspace-workers"
+	natsJobsSubject     = "kuspace.jobs.queue"
+	natsCancelSubject   = "kuspace.jobs.cancel"
+	natsPrioritySubject = "kuspace.jobs.priority"
+
+	natsRequestTimeout = 2 * time.Second  // how long a cancel/priority request waits for the instance holding the job
+	natsFetchWait      = 5 * time.Second  // how long a pull waits for a job to come
+	natsRejectDelay    = 10 * time.Second // a job rejected by the local queue (limits) is redelivered after this
+	natsSetupTimeout   = 10 * time.Second
+)
+
+// JobDispatcherNats struct, a JobDispatcher over a NATS JetStream work queue
+type JobDispatcherNats struct {
+	srv     *UService
+	manager JobManager // executes the jobs consumed by this instance
+
+	server   *natsserver.Server // embedded server, if any
+	conn     *nats.Conn
+	js       jetstream.JetStream
+	stream   jetstream.Stream
+	consumer jetstream.Consumer
+
+	ackWait    time.Duration
+	maxDeliver int
+
+	mu         *sync.Mutex
+	subscribed bool
+	inflight   map[int64]natsDelivery // consumed jobs not yet acknowledged (guarded by mu)
+	slots      chan struct{}          // one per consumed job not yet finished
+}
+
+// natsDelivery is a consumed job message along with the stop signal of its heartbeat
+type natsDelivery struct {
+	msg  jetstream.Msg
+	stop chan struct{}
+}
+
+type natsPriorityRequest struct {
+	JID      int64 `json:"jid"`
+	Priority int   `json:"priority"`
+}
+
+// NewJobDispatcherNats function connects to (or embeds) the nats server and sets up the jobs stream
+func NewJobDispatcherNats(srv *UService) (*JobDispatcherNats, error) {
+	cfg := srv.config
+	d := &JobDispatcherNats{
+		srv:        srv,
+		ackWait:    time.Duration(cfg.UspaceNatsAckWait) * time.Second,
+		maxDeliver: int(cfg.UspaceNatsMaxDeliver),
+		mu:         &sync.Mutex{},
+		inflight:   make(map[int64]natsDelivery),
+	}
+	if d.ackWait <= 0 {
+		d.ackWait = 30 * time.Second
+	}
+	d.manager = newJobManager(srv, nil, d.jobFinished)
+	d.slots = make(chan struct{}, cap(d.manager.workerPool))
+
+	natsURL := cfg.UspaceNatsURL
+	if cfg.UspaceNatsEmbedded {
+		ns, err := startEmbeddedNats(natsURL, cfg.UspaceNatsStorePath)
+		if err != nil {
+			return nil, err
+		}
+		d.server = ns
+		natsURL = ns.ClientURL()
+	}
+
+	conn, err := nats.Connect(natsURL, nats.Name("uspace"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats at %s: %w", natsURL, err)
+	}
+	d.conn = conn
+	d.js, err = jetstream.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jetstream context: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsSetupTimeout)
+	defer cancel()
+	d.stream, err = d.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      natsJobsStream,
+		Subjects:  []string{natsJobsSubject + ".>"},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the jobs stream: %w", err)
+	}
+	log.Printf("[NATS] connected to %s, jobs stream %s ready", natsURL, natsJobsStream)
+
+	return d, nil
+}
+
+// startEmbeddedNats runs a JetStream enabled server in process, listening on the host:port of the given url
+func startEmbeddedNats(natsURL, storePath string) (*natsserver.Server, error) {
+	hostPort := natsURL
+	if i := strings.Index(hostPort, "://"); i >= 0 {
+		hostPort = hostPort[i+3:]
+	}
+	host, p, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats url %q: %w", natsURL, err)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats port %q: %w", p, err)
+	}
+
+	ns, err := natsserver.NewServer(&natsserver.Options{
+		Host:      host,
+		Port:      port, // -1 picks a random one
+		JetStream: true,
+		StoreDir:  storePath,
+		NoSigs:    true,
+		NoLog:     true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the embedded nats server: %w", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(natsSetupTimeout) {
+		ns.Shutdown()
+
+		return nil, errors.New("embedded nats server not ready in time")
+	}
+	log.Printf("[NATS] embedded server listening on %s", ns.ClientURL())
+
+	return ns, nil
+}
+
+// Start method launches the consumption of jobs (unless J_NATS_CONSUME is off), along with the recurring jobs scheduler
+func (d *JobDispatcherNats) Start() {
+	if d.srv.config.UspaceNatsConsume {
+		err := d.Subscribe(ut.Job{})
+		if err != nil {
+			log.Printf("[NATS] failed to subscribe, this instance will not execute jobs: %v", err)
+		}
+	}
+	d.manager.StartScheduler()
+}
+
+// PublishJob method publishes a Job to the stream, any consuming instance may execute it
+func (d *JobDispatcherNats) PublishJob(job ut.Job) error {
+	return d.PublishJobs([]ut.Job{job})
+}
+
+// PublishJobs method, same as PublishJob but with plurality
+func (d *JobDispatcherNats) PublishJobs(jobs []ut.Job) error {
+	err := d.srv.admitQueuedJobs(d.manager.jobQueue.limits, jobs)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		err := d.publish(job)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publish puts a job in the stream, under its own subject
+func (d *JobDispatcherNats) publish(job ut.Job) error {
+	job.Status = "queued"
+	job.CreatedAt = ut.CurrentTime()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job %d: %w", job.JID, err)
+	}
+
+	// marked before it is published, an instance may consume it right away
+	err = d.srv.markJobStatus(job.JID, "queued", 0, ut.ActorScheduler, "published to nats")
+	if err != nil {
+		log.Printf("[NATS] failed to mark job ID=%d as queued: %v", job.JID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsRequestTimeout)
+	defer cancel()
+	ack, err := d.js.Publish(ctx, natsJobSubject(job.JID), data)
+	if err != nil {
+		d.failUnpublished(job.JID, err)
+
+		return fmt.Errorf("failed to publish job %d: %w", job.JID, err)
+	}
+	log.Printf("[NATS] Job ID=%d published (stream sequence %d)", job.JID, ack.Sequence)
+
+	return nil
+}
+
+// failUnpublished marks a job that could not be published as failed, nothing is left to execute it
+func (d *JobDispatcherNats) failUnpublished(jid int64, cause error) {
+	err := d.srv.markJobStatus(jid, "failed", 0, ut.ActorScheduler, "failed to publish to nats: "+cause.Error())
+	if err != nil {
+		log.Printf("[NATS] failed to mark job ID=%d as failed: %v", jid, err)
+	}
+}
+
+// RemoveJob method cancels a Job, whether it is held by some instance or still waiting in the stream
+func (d *JobDispatcherNats) RemoveJob(jid int) error {
+	id := int64(jid)
+	_, err := d.conn.Request(natsCancelSubject, []byte(strconv.FormatInt(id, 10)), natsRequestTimeout)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrNoResponders) && !errors.Is(err, nats.ErrTimeout) {
+		return fmt.Errorf("failed to request the cancelation of job %d: %w", id, err)
+	}
+
+	// no instance holds it, perhaps it is still waiting
+	ctx, cancel := context.WithTimeout(context.Background(), natsRequestTimeout)
+	defer cancel()
+	msg, err := d.stream.GetLastMsgForSubject(ctx, natsJobSubject(id))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return errJobNotActive
+		}
+
+		return fmt.Errorf("failed to look up job %d in the stream: %w", id, err)
+	}
+	err = d.stream.DeleteMsg(ctx, msg.Sequence)
+	if err != nil {
+		return fmt.Errorf("failed to delete job %d from the stream: %w", id, err)
+	}
+
+	err = d.srv.markJobStatus(id, "canceled", 0, ut.ActorUser, "canceled before execution")
+	if err != nil {
+		log.Printf("[NATS] failed to mark job ID=%d as canceled: %v", id, err)
+	}
+	go d.srv.notifyJobSocket(id, fmt.Sprintf("[executor] Job %d canceled before execution\n", id))
+	go d.srv.workflowJobFinished(id)
+
+	return nil
+}
+
+// RemoveJobs method same but with plurality
+func (d *JobDispatcherNats) RemoveJobs(jids []int) error {
+	for _, jid := range jids {
+		err := d.RemoveJob(jid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetJobPriority method changes the priority of a Job waiting in the queue of a consuming instance,
+// the stream itself is first come first served
+func (d *JobDispatcherNats) SetJobPriority(jid int64, priority int) error {
+	data, err := json.Marshal(natsPriorityRequest{JID: jid, Priority: priority})
+	if err != nil {
+		return err
+	}
+	_, err = d.conn.Request(natsPrioritySubject, data, natsRequestTimeout)
+	if err != nil {
+		if errors.Is(err, nats.ErrNoResponders) || errors.Is(err, nats.ErrTimeout) {
+			return errJobNotInQueue
+		}
+
+		return fmt.Errorf("failed to request the priority change of job %d: %w", jid, err)
+	}
+
+	return nil
+}
+
+// Subscribe method links the local JobManager to the stream, this instance starts executing jobs
+// and answering cancel/priority requests. Subscribing more than once has no effect,
+// the given job is not used: every instance consumes every kind of job.
+func (d *JobDispatcherNats) Subscribe(_ ut.Job) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subscribed {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsSetupTimeout)
+	defer cancel()
+	consumer, err := d.js.CreateOrUpdateConsumer(ctx, natsJobsStream, jetstream.ConsumerConfig{
+		Durable:       natsJobsConsumer,
+		FilterSubject: natsJobsSubject + ".>",
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       d.ackWait,
+		MaxDeliver:    d.maxDeliver,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up the jobs consumer: %w", err)
+	}
+	d.consumer = consumer
+
+	_, err = d.conn.Subscribe(natsCancelSubject, d.handleCancel)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", natsCancelSubject, err)
+	}
+	_, err = d.conn.Subscribe(natsPrioritySubject, d.handlePriority)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", natsPrioritySubject, err)
+	}
+
+	d.manager.startWorker()
+	go d.consume()
+	d.subscribed = true
+	log.Printf("[NATS] consuming jobs, up to %d at a time", cap(d.slots))
+
+	return nil
+}
+
+// consume pulls a job whenever a worker slot is free
+func (d *JobDispatcherNats) consume() {
+	for {
+		d.slots <- struct{}{}
+		batch, err := d.consumer.Fetch(1, jetstream.FetchMaxWait(natsFetchWait))
+		if err != nil {
+			log.Printf("[NATS] failed to fetch jobs: %v", err)
+			<-d.slots
+			time.Sleep(natsFetchWait)
+
+			continue
+		}
+
+		received := false
+		for msg := range batch.Messages() {
+			received = true
+			d.deliver(msg)
+		}
+		if !received {
+			<-d.slots
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			log.Printf("[NATS] failed to fetch jobs: %v", err)
+		}
+	}
+}
+
+// deliver hands a consumed job to the local JobManager, the slot it took is released once the job finishes
+func (d *JobDispatcherNats) deliver(msg jetstream.Msg) {
+	var job ut.Job
+	err := json.Unmarshal(msg.Data(), &job)
+	if err != nil {
+		log.Printf("[NATS] dropping malformed job message: %v", err)
+		<-d.slots
+		err = msg.Term()
+		if err != nil {
+			log.Printf("[NATS] failed to terminate message: %v", err)
+		}
+
+		return
+	}
+
+	d.mu.Lock()
+	if delivery, exists := d.inflight[job.JID]; exists {
+		// redelivered while still being executed here, keep the most recent delivery
+		delivery.msg = msg
+		d.inflight[job.JID] = delivery
+		d.mu.Unlock()
+		<-d.slots
+
+		return
+	}
+	delivery := natsDelivery{msg: msg, stop: make(chan struct{})}
+	d.inflight[job.JID] = delivery
+	d.mu.Unlock()
+
+	if meta, err := msg.Metadata(); err == nil && meta.NumDelivered > 1 {
+		log.Printf("[NATS] Job ID=%d redelivered (delivery %d)", job.JID, meta.NumDelivered)
+	}
+
+	err = d.manager.ScheduleJob(job)
+	if err != nil {
+		d.mu.Lock()
+		delete(d.inflight, job.JID)
+		d.mu.Unlock()
+		close(delivery.stop)
+		<-d.slots
+		err = msg.NakWithDelay(natsRejectDelay)
+		if err != nil {
+			log.Printf("[NATS] failed to nak job ID=%d: %v", job.JID, err)
+		}
+
+		return
+	}
+
+	go d.heartbeat(job.JID, delivery.stop)
+}
+
+// heartbeat keeps a consumed job from being redelivered while it is alive
+func (d *JobDispatcherNats) heartbeat(jid int64, stop <-chan struct{}) {
+	ticker := time.NewTicker(d.ackWait / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			delivery, exists := d.inflight[jid]
+			d.mu.Unlock()
+			if !exists {
+				return
+			}
+			err := delivery.msg.InProgress()
+			if err != nil {
+				log.Printf("[NATS] failed to extend job ID=%d: %v", jid, err)
+			}
+		}
+	}
+}
+
+// jobFinished acknowledges the message of a job that reached its final status
+func (d *JobDispatcherNats) jobFinished(jid int64, status string) {
+	d.mu.Lock()
+	delivery, exists := d.inflight[jid]
+	delete(d.inflight, jid)
+	d.mu.Unlock()
+	if !exists {
+		return
+	}
+	close(delivery.stop)
+	<-d.slots
+
+	err := delivery.msg.Ack()
+	if err != nil {
+		log.Printf("[NATS] failed to ack job ID=%d (%s), it may be redelivered: %v", jid, status, err)
+	}
+}
+
+func (d *JobDispatcherNats) handleCancel(m *nats.Msg) {
+	jid, err := strconv.Atoi(string(m.Data))
+	if err != nil {
+		log.Printf("[NATS] malformed cancel request: %v", err)
+
+		return
+	}
+	err = d.manager.CancelJob(jid)
+	if err != nil {
+		if !errors.Is(err, errJobNotActive) {
+			log.Printf("[NATS] failed to cancel job ID=%d: %v", jid, err)
+		}
+
+		return
+	}
+	err = m.Respond([]byte("canceled"))
+	if err != nil {
+		log.Printf("[NATS] failed to respond to cancel request: %v", err)
+	}
+}
+
+func (d *JobDispatcherNats) handlePriority(m *nats.Msg) {
+	var req natsPriorityRequest
+	err := json.Unmarshal(m.Data, &req)
+	if err != nil {
+		log.Printf("[NATS] malformed priority request: %v", err)
+
+		return
+	}
+	err = d.manager.SetJobPriority(req.JID, req.Priority)
+	if err != nil {
+		if !errors.Is(err, errJobNotInQueue) {
+			log.Printf("[NATS] failed to set the priority of job ID=%d: %v", req.JID, err)
+		}
+
+		return
+	}
+	err = m.Respond([]byte("ok"))
+	if err != nil {
+		log.Printf("[NATS] failed to respond to priority request: %v", err)
+	}
+}
+
+func natsJobSubject(jid int64) string {
+	return fmt.Sprintf("%s.%d", natsJobsSubject, jid)
+}
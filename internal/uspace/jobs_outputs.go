@@ -0,0 +1,212 @@
+package uspace
+
+/*
+	job outputs
+
+	the output of a job (see ut.Job.OutputIsDir) is either an object or a directory prefix:
+
+		volume/results/out.csv    the file the job writes as its output
+		volume/plots/             every file the job writes in its output dir, at the same relative path:
+		                          plots/output, plots/a.png, plots/2024/b.png
+
+	the docker and sandbox executors give the job an output dir (OUTPUT_DIR) and upload what it holds
+	once the job completes, the applications of the kubernetes executor upload on their own
+	(OUTPUT_OBJECT, OUTPUT_PREFIX for a directory) and their objects are listed afterwards.
+
+	every object is registered as a resource owned by the job's uid and recorded as an artifact
+	of the job. An object already on record is only overwritten if the job may write it
+	(the identity it runs as, see admitJob), it keeps its owner.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// jobOutputFile returns the name of the file, in the output dir, the code of a job writes its output to:
+// the base name of the output object, "output" for a directory output
+func jobOutputFile(job ut.Job) string {
+	if job.OutputIsDir() {
+		return "output"
+	}
+
+	return path.Base(strings.TrimSpace(job.Output))
+}
+
+// splitJobOutput returns the volume of the output of a job and its name, a directory prefix ending with a slash
+func (srv *UService) splitJobOutput(job ut.Job) (string, string) {
+	vname, name := srv.splitObjectPath(strings.TrimSpace(job.Output))
+
+	return vname, strings.TrimPrefix(name, "/")
+}
+
+// storeJobOutputs uploads the output of a completed job out of its output dir and registers it,
+// returns its artifacts
+func (srv *UService) storeJobOutputs(job ut.Job, dir string) ([]ut.JobArtifact, error) {
+	vname, name := srv.splitJobOutput(job)
+	if !job.OutputIsDir() {
+		artifact, err := srv.storeJobOutput(job, filepath.Join(dir, jobOutputFile(job)), vname, name)
+		if err != nil {
+			return nil, err
+		}
+
+		return []ut.JobArtifact{artifact}, nil
+	}
+
+	var artifacts []ut.JobArtifact
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		artifact, err := srv.storeJobOutput(job, p, vname, name+filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, artifact)
+
+		return nil
+	})
+	if err != nil {
+		return artifacts, err
+	}
+	if len(artifacts) == 0 {
+		return nil, errors.New("the job wrote nothing in its output dir")
+	}
+
+	return artifacts, nil
+}
+
+// storeJobOutput uploads a file the job wrote as the given object
+func (srv *UService) storeJobOutput(job ut.Job, file, vname, name string) (ut.JobArtifact, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return ut.JobArtifact{}, fmt.Errorf("the job wrote no output: %w", err)
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			log.Printf("failed to close the output of job %d: %v", job.JID, err)
+		}
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return ut.JobArtifact{}, err
+	}
+
+	existing, found, err := srv.lookupResource(vname, name)
+	if err != nil {
+		return ut.JobArtifact{}, err
+	}
+	if ac := runAsClaim(job); found && ac.UID != "0" && !existing.HasWriteAccess(ac) {
+		return ut.JobArtifact{}, fmt.Errorf("%w: no write access on output %s/%s", errJobAccess, vname, name)
+	}
+
+	currentTime := ut.CurrentTime()
+	resource := ut.Resource{
+		Vname:  vname,
+		Name:   name,
+		Path:   path.Dir(name) + "/",
+		Type:   "file",
+		Reader: f,
+
+		CreatedAt:  currentTime,
+		UpdatedAt:  currentTime,
+		AccessedAt: currentTime,
+		Perms:      "rw-r--r--",
+		UID:        job.UID,
+		GID:        job.GID,
+		Size:       info.Size(),
+	}
+	_, err = srv.storage.Insert(resource)
+	if err != nil {
+		return ut.JobArtifact{}, fmt.Errorf("failed to upload %s/%s: %w", vname, name, err)
+	}
+	if !found {
+		_, err = srv.fsl.Insert(resource)
+		if err != nil {
+			log.Printf("failed to record the output %s/%s of job %d: %v", vname, name, job.JID, err)
+		}
+	}
+
+	return ut.JobArtifact{Volume: vname, Name: name, Size: info.Size()}, nil
+}
+
+// registerJobOutputs registers the output a completed job uploaded on its own, the objects under its prefix
+// for a directory output, returns its artifacts
+func (srv *UService) registerJobOutputs(job ut.Job) ([]ut.JobArtifact, error) {
+	vname, name := srv.splitJobOutput(job)
+	objects, err := srv.listObjectResources(vname, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the output of the job: %w", err)
+	}
+
+	var artifacts []ut.JobArtifact
+	for _, object := range objects {
+		if !job.OutputIsDir() && object.Name != name {
+			continue
+		}
+		_, found, err := srv.lookupResource(vname, object.Name)
+		if err != nil {
+			return artifacts, err
+		}
+		if !found {
+			currentTime := ut.CurrentTime()
+			_, err = srv.fsl.Insert(ut.Resource{
+				Vname: vname,
+				Name:  object.Name,
+				Path:  path.Dir(object.Name) + "/",
+				Type:  "file",
+
+				CreatedAt:  currentTime,
+				UpdatedAt:  currentTime,
+				AccessedAt: currentTime,
+				Perms:      "rw-r--r--",
+				UID:        job.UID,
+				GID:        job.GID,
+				Size:       object.Size,
+			})
+			if err != nil {
+				log.Printf("failed to record the output %s/%s of job %d: %v", vname, object.Name, job.JID, err)
+			}
+		}
+		artifacts = append(artifacts, ut.JobArtifact{Volume: vname, Name: object.Name, Size: object.Size})
+	}
+	if len(artifacts) == 0 {
+		return nil, fmt.Errorf("the job wrote no output to %s", job.Output)
+	}
+
+	return artifacts, nil
+}
+
+// recordJobArtifacts records the artifacts of a job, failing to do so does not fail the job
+func (srv *UService) recordJobArtifacts(jid int64, artifacts []ut.JobArtifact) {
+	err := srv.setJobArtifacts(jid, artifacts)
+	if err != nil {
+		log.Printf("failed to record the artifacts of job %d: %v", jid, err)
+	}
+}
+
+// runAsClaim returns the access claim of the identity a job runs as, root for a job admitted before it was recorded
+func runAsClaim(job ut.Job) ut.AccessClaim {
+	uid, gids, ok := strings.Cut(job.RunAs, ":")
+	if !ok || uid == "" {
+		return ut.AccessClaim{UID: "0", Gids: "0"}
+	}
+
+	return ut.AccessClaim{UID: uid, Gids: gids}
+}
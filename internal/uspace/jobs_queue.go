@@ -0,0 +1,308 @@
+package uspace
+
+/*
+	the wait queue of the JobManager
+
+	every user has its own priority queue of jobs waiting for a worker slot,
+	users are served round-robin, while within a user's queue
+	jobs with a higher Job.Priority are dispatched first.
+	a user (or group) that reached its running limit is skipped until one of its jobs is done.
+
+	aging:
+	a waiting job gains one priority level for every "aging" interval it spends in the queue,
+	so that low priority jobs are not starved by a constant flow of high priority ones.
+
+	effective(now) = priority + (now - enqueuedAt) / aging
+
+	since every waiting job ages at the same rate, the ordering between two jobs never
+	changes while they wait, so the heap can be keyed on: priority - enqueuedAt / aging
+*/
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+var (
+	errQueueFull      = errors.New("job queue full")
+	errJobNotInQueue  = errors.New("job is not waiting in the queue")
+	errJobNotActive   = errors.New("job is neither queued nor running")
+	defaultAgingEvery = 60 * time.Second
+)
+
+type queuedJob struct {
+	job        ut.Job
+	enqueuedAt time.Time
+	seq        uint64 // arrival order, breaks ties
+	key        float64
+	index      int
+}
+
+type jobHeap []*queuedJob
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].key == h[j].key {
+		return h[i].seq < h[j].seq
+	}
+
+	return h[i].key > h[j].key
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x any) {
+	item, _ := x.(*queuedJob)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *jobHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+
+	return item
+}
+
+type jobQueue struct {
+	mu   *sync.Mutex
+	cond *sync.Cond
+
+	users map[int]*jobHeap // waiting jobs per uid
+	ring  []int            // uids with waiting jobs, in round-robin order
+	next  int              // position of the next uid to serve in the ring
+	byJID map[int64]*queuedJob
+	size  int
+	seq   uint64
+
+	capacity int
+	aging    time.Duration
+
+	limits  jobLimits
+	queued  map[limitKey]int
+	running map[limitKey]int
+}
+
+func newJobQueue(capacity int, aging time.Duration, limits jobLimits) *jobQueue {
+	mu := &sync.Mutex{}
+
+	return &jobQueue{
+		mu:       mu,
+		cond:     sync.NewCond(mu),
+		users:    make(map[int]*jobHeap),
+		byJID:    make(map[int64]*queuedJob),
+		capacity: capacity,
+		aging:    aging,
+		limits:   limits,
+		queued:   make(map[limitKey]int),
+		running:  make(map[limitKey]int),
+	}
+}
+
+func (q *jobQueue) keyOf(item *queuedJob) float64 {
+	if q.aging <= 0 {
+		return float64(item.job.Priority)
+	}
+
+	return float64(item.job.Priority) - float64(item.enqueuedAt.UnixNano())/float64(q.aging.Nanoseconds())
+}
+
+// admit checks whether the given jobs may be queued, without queueing them
+func (q *jobQueue) admit(jobs []ut.Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.size+len(jobs) > q.capacity {
+		return errQueueFull
+	}
+
+	return q.limits.admit(jobs, q.queued)
+}
+
+// push adds a job in the queue
+/*
+	if wait is set it blocks while the queue is full and ignores the queued limits,
+	used for jobs that were already accepted once (recovery)
+*/
+func (q *jobQueue) push(job ut.Job, wait bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !wait {
+		err := q.limits.admit([]ut.Job{job}, q.queued)
+		if err != nil {
+			return err
+		}
+	}
+	for q.size >= q.capacity {
+		if !wait {
+			return errQueueFull
+		}
+		q.cond.Wait()
+	}
+
+	q.seq++
+	item := &queuedJob{job: job, enqueuedAt: time.Now(), seq: q.seq}
+	item.key = q.keyOf(item)
+
+	h, exists := q.users[job.UID]
+	if !exists {
+		h = &jobHeap{}
+		q.users[job.UID] = h
+		q.ring = append(q.ring, job.UID)
+	}
+	heap.Push(h, item)
+	q.byJID[job.JID] = item
+	q.size++
+	for _, key := range keysOf(job) {
+		q.queued[key]++
+	}
+	q.cond.Broadcast()
+
+	return nil
+}
+
+// pop blocks until a job may run, and returns it
+/*
+	users are visited round-robin starting after the last one served,
+	the first one whose best job is within its running limits is served.
+	the job is accounted as running until done is called
+*/
+func (q *jobQueue) pop() ut.Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for i := range q.ring {
+			pos := (q.next + i) % len(q.ring)
+			uid := q.ring[pos]
+			h := q.users[uid]
+			if !q.limits.canRun((*h)[0].job, q.running) {
+				continue
+			}
+
+			item, _ := heap.Pop(h).(*queuedJob)
+			q.forget(item)
+			q.next = pos + 1
+			if h.Len() == 0 {
+				q.dropUser(pos)
+			}
+			q.started(item.job)
+			q.cond.Broadcast()
+
+			return item.job
+		}
+		q.cond.Wait()
+	}
+}
+
+// markRunning accounts a job that runs without going through pop (recovered jobs)
+func (q *jobQueue) markRunning(job ut.Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.started(job)
+}
+
+// done releases the running slot of a job, letting the same user/group run more
+func (q *jobQueue) done(job ut.Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, key := range keysOf(job) {
+		if q.running[key] > 0 {
+			q.running[key]--
+		}
+	}
+	q.cond.Broadcast()
+}
+
+// setPriority changes the priority of a waiting job, its waiting time is preserved
+func (q *jobQueue) setPriority(jid int64, priority int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, exists := q.byJID[jid]
+	if !exists {
+		return errJobNotInQueue
+	}
+	item.job.Priority = priority
+	item.key = q.keyOf(item)
+	heap.Fix(q.users[item.job.UID], item.index)
+
+	return nil
+}
+
+// remove takes a waiting job out of the queue
+func (q *jobQueue) remove(jid int64) (ut.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, exists := q.byJID[jid]
+	if !exists {
+		return ut.Job{}, errJobNotInQueue
+	}
+	h := q.users[item.job.UID]
+	heap.Remove(h, item.index)
+	q.forget(item)
+	if h.Len() == 0 {
+		for pos, uid := range q.ring {
+			if uid == item.job.UID {
+				q.dropUser(pos)
+
+				break
+			}
+		}
+	}
+	q.cond.Broadcast()
+
+	return item.job, nil
+}
+
+func (q *jobQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.size
+}
+
+// forget drops the bookkeeping of a job leaving the queue, mu must be held
+func (q *jobQueue) forget(item *queuedJob) {
+	delete(q.byJID, item.job.JID)
+	q.size--
+	for _, key := range keysOf(item.job) {
+		q.queued[key]--
+	}
+}
+
+// started accounts a job as running, mu must be held
+func (q *jobQueue) started(job ut.Job) {
+	for _, key := range keysOf(job) {
+		q.running[key]++
+	}
+}
+
+// dropUser removes a user with no more waiting jobs from the ring, mu must be held
+func (q *jobQueue) dropUser(pos int) {
+	delete(q.users, q.ring[pos])
+	q.ring = append(q.ring[:pos], q.ring[pos+1:]...)
+	if q.next > pos {
+		q.next--
+	}
+	if len(q.ring) == 0 || q.next >= len(q.ring) {
+		q.next = 0
+	}
+}
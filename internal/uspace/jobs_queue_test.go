@@ -0,0 +1,199 @@
+package uspace
+
+import (
+	"container/heap"
+	"errors"
+	"testing"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+type testQueued struct {
+	jid      int64
+	priority int
+	waited   time.Duration // how long ago it was enqueued
+}
+
+func TestJobHeapOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		aging time.Duration
+		jobs  []testQueued
+		want  []int64
+	}{
+		{
+			name: "higher priority first",
+			jobs: []testQueued{{jid: 1, priority: 1}, {jid: 2, priority: 5}, {jid: 3, priority: 3}},
+			want: []int64{2, 3, 1},
+		},
+		{
+			name: "same priority in arrival order",
+			jobs: []testQueued{{jid: 1}, {jid: 2}, {jid: 3}},
+			want: []int64{1, 2, 3},
+		},
+		{
+			name: "negative priorities last",
+			jobs: []testQueued{{jid: 1, priority: -2}, {jid: 2}, {jid: 3, priority: -1}},
+			want: []int64{2, 3, 1},
+		},
+		{
+			name:  "aging lifts a job waiting long enough",
+			aging: time.Minute,
+			jobs:  []testQueued{{jid: 1, waited: 10 * time.Minute}, {jid: 2, priority: 5}},
+			want:  []int64{1, 2},
+		},
+		{
+			name:  "aging not enough to overtake",
+			aging: time.Minute,
+			jobs:  []testQueued{{jid: 1, waited: 2 * time.Minute}, {jid: 2, priority: 5}},
+			want:  []int64{2, 1},
+		},
+		{
+			name:  "older first among equal priorities",
+			aging: time.Minute,
+			jobs:  []testQueued{{jid: 1, priority: 2}, {jid: 2, priority: 2, waited: 30 * time.Second}},
+			want:  []int64{2, 1},
+		},
+		{
+			name:  "no aging without an interval",
+			aging: 0,
+			jobs:  []testQueued{{jid: 1, waited: time.Hour}, {jid: 2, priority: 1}},
+			want:  []int64{2, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newJobQueue(len(tt.jobs), tt.aging, jobLimits{})
+			now := time.Now()
+			h := &jobHeap{}
+			for i, j := range tt.jobs {
+				item := &queuedJob{
+					job:        ut.Job{JID: j.jid, Priority: j.priority},
+					enqueuedAt: now.Add(-j.waited),
+					seq:        uint64(i + 1),
+				}
+				item.key = q.keyOf(item)
+				heap.Push(h, item)
+			}
+
+			var got []int64
+			for h.Len() > 0 {
+				item, _ := heap.Pop(h).(*queuedJob)
+				got = append(got, item.job.JID)
+			}
+			assert.DeepEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestJobQueueKey(t *testing.T) {
+	q := newJobQueue(1, time.Minute, jobLimits{})
+	enqueuedAt := time.Unix(0, 0).Add(90 * time.Minute)
+	item := &queuedJob{job: ut.Job{Priority: 3}, enqueuedAt: enqueuedAt}
+
+	// priority - enqueuedAt / aging
+	assert.Equal(t, q.keyOf(item), 3.0-90.0)
+}
+
+func TestJobQueuePopOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []ut.Job // pushed in order
+		want []int64
+	}{
+		{
+			name: "round-robin across uids",
+			jobs: []ut.Job{
+				{JID: 1, UID: 1}, {JID: 2, UID: 1}, {JID: 3, UID: 1},
+				{JID: 4, UID: 2}, {JID: 5, UID: 2},
+				{JID: 6, UID: 3},
+			},
+			want: []int64{1, 4, 6, 2, 5, 3},
+		},
+		{
+			name: "priority within a uid, round-robin across",
+			jobs: []ut.Job{
+				{JID: 1, UID: 1}, {JID: 2, UID: 1, Priority: 9},
+				{JID: 3, UID: 2}, {JID: 4, UID: 2, Priority: 1},
+			},
+			want: []int64{2, 4, 1, 3},
+		},
+		{
+			name: "a high priority does not skip the turn of other uids",
+			jobs: []ut.Job{
+				{JID: 1, UID: 1}, {JID: 2, UID: 2, Priority: 9}, {JID: 3, UID: 2, Priority: 8},
+			},
+			want: []int64{1, 2, 3},
+		},
+		{
+			name: "a single uid in priority order",
+			jobs: []ut.Job{{JID: 1, UID: 1}, {JID: 2, UID: 1, Priority: 2}, {JID: 3, UID: 1, Priority: 1}},
+			want: []int64{2, 3, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newJobQueue(len(tt.jobs), 0, jobLimits{})
+			for _, job := range tt.jobs {
+				assert.NoError(t, q.push(job, false))
+			}
+			assert.Equal(t, q.len(), len(tt.jobs))
+
+			var got []int64
+			for range tt.jobs {
+				got = append(got, q.pop().JID)
+			}
+			assert.DeepEqual(t, got, tt.want)
+			assert.Equal(t, q.len(), 0)
+		})
+	}
+}
+
+func TestJobQueueCapacity(t *testing.T) {
+	q := newJobQueue(2, 0, jobLimits{})
+	assert.NoError(t, q.push(ut.Job{JID: 1, UID: 1}, false))
+	assert.NoError(t, q.push(ut.Job{JID: 2, UID: 2}, false))
+
+	assert.True(t, errors.Is(q.push(ut.Job{JID: 3, UID: 3}, false), errQueueFull))
+	assert.True(t, errors.Is(q.admit([]ut.Job{{JID: 3, UID: 3}}), errQueueFull))
+
+	q.pop()
+	assert.NoError(t, q.admit([]ut.Job{{JID: 3, UID: 3}}))
+	assert.NoError(t, q.push(ut.Job{JID: 3, UID: 3}, false))
+}
+
+func TestJobQueueSetPriority(t *testing.T) {
+	q := newJobQueue(3, 0, jobLimits{})
+	for jid := int64(1); jid <= 3; jid++ {
+		assert.NoError(t, q.push(ut.Job{JID: jid, UID: 1}, false))
+	}
+
+	assert.NoError(t, q.setPriority(3, 5))
+	assert.True(t, errors.Is(q.setPriority(4, 5), errJobNotInQueue))
+	assert.Equal(t, q.pop().JID, int64(3))
+	assert.Equal(t, q.pop().JID, int64(1))
+}
+
+func TestJobQueueRemove(t *testing.T) {
+	q := newJobQueue(3, 0, jobLimits{})
+	assert.NoError(t, q.push(ut.Job{JID: 1, UID: 1}, false))
+	assert.NoError(t, q.push(ut.Job{JID: 2, UID: 2}, false))
+	assert.NoError(t, q.push(ut.Job{JID: 3, UID: 1}, false))
+
+	job, err := q.remove(1)
+	assert.NoError(t, err)
+	assert.Equal(t, job.JID, int64(1))
+	_, err = q.remove(1)
+	assert.True(t, errors.Is(err, errJobNotInQueue))
+
+	// the last job of a uid takes it out of the round-robin
+	_, err = q.remove(2)
+	assert.NoError(t, err)
+	assert.Equal(t, q.len(), 1)
+	assert.Equal(t, q.pop().JID, int64(3))
+}
@@ -0,0 +1,196 @@
+package uspace
+
+/*
+	job dry-run
+
+	a job is put through every check its executor would run, without scheduling anything:
+	the form limits, the logic (command formatting or io skeleton), the application behind it,
+	the inputs against the storage, the volume of the output and the resource quantities.
+	Every problem found is reported, rather than the first one.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// jobApps maps the logic names served by an application (see formatJobCommand) to the name it is registered under
+var jobApps = map[string]string{
+	"duckdb":   "duckdb",
+	"pandas":   "pypandas",
+	"pypandas": "pypandas",
+	"octave":   "octave",
+	"ffmpeg":   "ffmpeg",
+	"caengine": "caengine",
+	"bash":     "bash",
+	"sh":       "bash",
+	"shell":    "bash",
+}
+
+// validateJob dry-runs a job, returns the problems that would keep it from running, none if it would run
+func (srv *UService) validateJob(job ut.Job) []ut.JobProblem {
+	var problems []ut.JobProblem
+	report := func(field string, err error) {
+		problems = append(problems, ut.JobProblem{Field: field, Message: err.Error()})
+	}
+	kubernetes := srv.config.UspaceJobExecutor == "kubernetes"
+
+	// the form, as checked on submission (the checks mutate the job)
+	form := job
+	err := form.ValidateForm(srv.config.UspaceJobMaxCPU, srv.config.UspaceJobMaxMemory, srv.config.UspaceJobMaxStorage,
+		int64(srv.config.UspaceJobMaxParallelism), srv.config.UspaceJobMaxTimeout, srv.config.UspaceJobMaxLogicSize)
+	if err != nil {
+		report("job", err)
+	}
+
+	// the logic
+	name, version, _ := strings.Cut(strings.TrimSpace(job.Logic), ":")
+	if kubernetes {
+		probe := job
+		_, err = formatJobCommand(srv, &probe)
+		if err != nil {
+			report("logic", err)
+		}
+	} else if _, _, err = srv.lookupRuntime(job.Logic); err != nil {
+		report("logic", err)
+	}
+	if app, ok := jobApps[strings.TrimPrefix(name, "application/")]; ok && kubernetes {
+		err = srv.checkJobApp(app, version)
+		if err != nil {
+			report("logic", err)
+		}
+	}
+
+	// the inputs and output
+	if len(job.InputList()) > 0 {
+		_, err = srv.resolveJobInputs(job)
+		if err != nil {
+			report("input", err)
+		}
+	}
+	if strings.TrimSpace(job.Output) != "" {
+		err = srv.checkOutputVolume(job.Output)
+		if err != nil {
+			report("output", err)
+		}
+	}
+
+	// the resources
+	for _, q := range []struct{ field, quantity string }{
+		{"cpuRequest", job.CPURequest},
+		{"cpuLimit", job.CPULimit},
+		{"memoryRequest", job.MemoryRequest},
+		{"memoryLimit", job.MemoryLimit},
+		{"ephimeralStorageRequest", job.EphimeralStorageRequest},
+		{"ephimeralStorageLimit", job.EphimeralStorageLimit},
+	} {
+		err = checkQuantity(q.field, q.quantity, kubernetes)
+		if err != nil {
+			report(q.field, err)
+		}
+	}
+
+	// whatever else the kubernetes executor would trip on
+	if kubernetes && len(problems) == 0 {
+		probe := job
+		_, err = formatJobData(srv, &probe)
+		if err != nil {
+			report("job", err)
+		}
+	}
+
+	return problems
+}
+
+// checkJobApp verifies the application a job runs is registered and available,
+// the latest version is any available one
+func (srv *UService) checkJobApp(name, version string) error {
+	if version != "" && version != "latest" {
+		app, err := srv.getAppByNameAndVersion(name, version)
+		if err != nil {
+			return fmt.Errorf("application %s:%s is not registered", name, version)
+		}
+		if app.Status != "available" {
+			return fmt.Errorf("application %s:%s is %s, not available", name, version, app.Status)
+		}
+
+		return nil
+	}
+
+	apps, err := srv.getAllApps("", "")
+	if err != nil {
+		return fmt.Errorf("failed to look up application %s: %w", name, err)
+	}
+	registered := false
+	for _, app := range apps {
+		if app.Name != name {
+			continue
+		}
+		registered = true
+		if app.Status == "available" {
+			return nil
+		}
+	}
+	if !registered {
+		return fmt.Errorf("application %s is not registered", name)
+	}
+
+	return fmt.Errorf("no version of application %s is available", name)
+}
+
+// checkOutputVolume verifies the volume an output goes to exists and has room left
+func (srv *UService) checkOutputVolume(output string) error {
+	vname, _ := srv.splitObjectPath(strings.TrimSpace(output))
+	res, err := srv.storage.SelectVolumes(map[string]any{"name": vname})
+	if err != nil {
+		return fmt.Errorf("volume %s not found", vname)
+	}
+
+	switch v := res.(type) {
+	case ut.Volume:
+		if v.Capacity > 0 && v.Usage >= v.Capacity {
+			return fmt.Errorf("volume %s is full (%.2f of %.2f GB used)", vname, v.Usage, v.Capacity)
+		}
+	case []any:
+		for _, r := range v {
+			if volume, ok := r.(ut.Volume); ok && volume.Name == vname {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("volume %s not found", vname)
+	}
+
+	return nil
+}
+
+// checkQuantity verifies a resource quantity parses the way the executor parses it, empty ones get defaults
+func checkQuantity(field, quantity string, kubernetes bool) error {
+	quantity = strings.TrimSpace(quantity)
+	if quantity == "" {
+		return nil
+	}
+	if kubernetes {
+		_, err := resource.ParseQuantity(quantity)
+		if err != nil {
+			return fmt.Errorf("invalid quantity %q: %w", quantity, err)
+		}
+
+		return nil
+	}
+
+	var err error
+	switch {
+	case strings.HasPrefix(field, "cpu"):
+		_, err = ut.ParseCPUQuantity(quantity)
+	case strings.HasPrefix(field, "memory"):
+		_, err = ut.ParseMemoryQuantity(quantity)
+	}
+	// ephimeral storage is not enforced outside of kubernetes
+
+	return err
+}
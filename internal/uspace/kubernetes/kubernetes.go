@@ -15,14 +15,9 @@ import (
 // it falls back to using the local kubeconfig file located at $HOME/.kube/config.
 // Returns a Kubernetes clientset or an error if configuration or client creation fails.
 func GetKubeClient() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
+	config, err := kubeConfig()
 	if err != nil {
-		// Fallback to local config
-		kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			return nil, err
-		}
+		return nil, err
 	}
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -31,3 +26,16 @@ func GetKubeClient() (*kubernetes.Clientset, error) {
 
 	return clientset, nil
 }
+
+// kubeConfig returns the in-cluster configuration, or the one of the local kubeconfig file outside a cluster
+func kubeConfig() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		// Fallback to local config
+		kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
+
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+
+	return config, nil
+}
@@ -165,3 +165,54 @@ func GetSystemMetrics(ns string) (map[string]any, error) {
 
 	return result, nil
 }
+
+// PodsUsage struct, the resources consumed by a set of pods as sampled out of the metrics api
+type PodsUsage struct {
+	CPUSeconds float64
+	PeakMemory int64 // in bytes, the highest total of the pods seen
+}
+
+// SamplePodsUsage polls the metrics of the pods matching selector every interval until ctx is done,
+// then returns what they consumed. The metrics api reports cpu as a rate (averaged over the scrape window
+// of the metrics server), cpu seconds are integrated over the time between samples.
+// It tells nothing of disk or network io.
+func SamplePodsUsage(ctx context.Context, namespace, selector string, interval time.Duration) (PodsUsage, error) {
+	var usage PodsUsage
+	config, err := kubeConfig()
+	if err != nil {
+		return usage, err
+	}
+	metricsClient, err := metrics.NewForConfig(config)
+	if err != nil {
+		return usage, err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return usage, nil
+		case now := <-ticker.C:
+			list, err := metricsClient.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				if ctx.Err() != nil {
+					return usage, nil
+				}
+
+				return usage, fmt.Errorf("fetch metrics error: %w", err)
+			}
+			var cpuMilli, memory int64
+			for _, podMetrics := range list.Items {
+				for _, container := range podMetrics.Containers {
+					cpuMilli += container.Usage.Cpu().MilliValue()
+					memory += container.Usage.Memory().Value()
+				}
+			}
+			usage.CPUSeconds += float64(cpuMilli) / 1000 * now.Sub(last).Seconds()
+			usage.PeakMemory = max(usage.PeakMemory, memory)
+			last = now
+		}
+	}
+}
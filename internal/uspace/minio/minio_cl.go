@@ -226,12 +226,9 @@ func (mc *Client) SelectObjects(which map[string]any) (any, error) {
 	if !is {
 		return nil, errors.New("bad volume identifier")
 	}
-	// fix vName
-	prefix := which["prefix"].(string)
-	p := strings.Split(strings.TrimPrefix(prefix, "/"), "/")
-	if len(p) > 0 {
-		prefix = p[len(p)-1]
-	}
+	// object names carry no leading slash
+	prefix, _ := which["prefix"].(string)
+	prefix = strings.TrimPrefix(prefix, "/")
 
 	objectCh, cancel := mc.listObjects(vName, prefix)
 	defer cancel()
@@ -283,6 +280,26 @@ func (mc *Client) Stat(t any) (any, error) {
 	return mc.statObject(object.Vname, object.Name)
 }
 
+// Checksum returns the ETag of an object, it changes whenever the content of the object does.
+// ✅
+func (mc *Client) Checksum(t any) (string, error) {
+	object, ok := t.(ut.Resource)
+	if !ok {
+		return "", ut.NewError("failed to cast")
+	}
+
+	info, err := mc.statObject(object.Vname, object.Name)
+	if err != nil {
+		return "", err
+	}
+	etag := strings.Trim(info.ETag, `"`)
+	if etag == "" {
+		return "", fmt.Errorf("object %s/%s has no etag", object.Vname, object.Name)
+	}
+
+	return etag, nil
+}
+
 // Remove deletes an object from Minio.
 // ✅
 func (mc *Client) Remove(t any) error {
@@ -0,0 +1,175 @@
+package uspace
+
+/*
+	language runtime registry
+
+	the languages the code of a job may be written in are declared as data (see ut.Runtime):
+	the built-in ones below, and the ones admins add through /admin/runtime, stored in the runtimes table.
+	An added runtime named after a built-in one takes its place, removing it brings the built-in one back.
+
+	the docker and sandbox executors stage the inputs and wrap the code in the skeleton of its runtime,
+	the kubernetes executor runs the code as is (the inputs are not staged, see formatJobCommand).
+*/
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+var errUnknownRuntime = errors.New("unknown runtime")
+
+// every skeleton feeds run() with the contents of all the inputs, concatenated in order
+const (
+	pythonIoSkeletonCode = `{{.Logic}}
+
+data = ''
+for path in [{{.Inputs}}]:
+	with open(path, 'r') as input:
+		data += input.read()
+
+with open('{{.Output}}', 'w') as output:
+	output.write(run(data))
+
+`
+	nodeIoSkeletonCode = `{{.Logic}}
+
+const fs = require('fs');
+
+const data = [{{.Inputs}}].map((path) => fs.readFileSync(path, 'utf8')).join('');
+fs.writeFile('{{.Output}}',  run(data), 'utf8', (err) => {
+	if (err) throw err;
+});
+
+`
+	javaIoSkeletonCode = `import java.nio.file.Files;
+import java.nio.file.Paths;
+{{.Headers}}
+public class Main {
+{{.Logic}}
+	public static void main(String[] args) throws Exception {
+		StringBuilder input = new StringBuilder();
+		for (String path : new String[]{ {{.Inputs}} }) {
+			input.append(new String(Files.readAllBytes(Paths.get(path))));
+		}
+		String output = run(input.toString());
+		Files.write(Paths.get("{{.Output}}"), output.getBytes());
+	}
+}
+
+`
+	cIoSkeletonCode = `#include <stdio.h>
+#define BUFFER_SIZE 1024
+{{.Headers}}
+{{.Logic}}
+int main() {
+	const char *inputs[] = { {{.Inputs}} };
+	FILE *in_fp, *out_fp;
+	out_fp = fopen("{{.Output}}", "w");
+	if (out_fp == NULL) {
+		perror("Error opening output file");
+
+return 1;
+	}
+	char buffer[BUFFER_SIZE];
+	for (size_t i = 0; i < sizeof(inputs) / sizeof(inputs[0]); i++) {
+		in_fp = fopen(inputs[i], "r");
+		if (in_fp == NULL) {
+			perror("Error opening input file");
+
+return 1;
+		}
+ 		while (fgets(buffer, BUFFER_SIZE, in_fp) != NULL) {
+    		run(buffer);         // Convert to uppercase
+    		fputs(buffer, out_fp);  // Write to output file
+		}
+		fclose(in_fp);
+	}
+	fclose(out_fp);
+
+return 0;
+}`
+	goIoSkeletonCode = `package main
+	import "os"
+{{.Logic}}
+
+func main() {
+	var input []byte
+	for _, path := range []string{ {{.Inputs}} } {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+		input = append(input, data...)
+	}
+	err := os.WriteFile("{{.Output}}", []byte(run(string(input))), 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+`
+)
+
+// builtinRuntimes are the runtimes shipped with uspace
+var builtinRuntimes = []ut.Runtime{
+	{Name: "python", Aliases: []string{"py"}, Image: "python", Extension: "py",
+		Skeleton: pythonIoSkeletonCode, Command: "python3 {{.Script}}"},
+	{Name: "node", Aliases: []string{"javascript", "js"}, Image: "node", Extension: "js",
+		Skeleton: nodeIoSkeletonCode, Command: "node {{.Script}}"},
+	{Name: "go", Aliases: []string{"golang"}, Image: "golang", Extension: "go",
+		Skeleton: goIoSkeletonCode, Command: "go run {{.Script}}"},
+	{Name: "java", Aliases: []string{"openjdk", "javac"}, Image: "openjdk", Extension: "java",
+		Skeleton: javaIoSkeletonCode, Command: "java {{.Script}}"}, // compile and run
+	{Name: "c", Aliases: []string{"gcc"}, Image: "gcc", Extension: "c",
+		Skeleton: cIoSkeletonCode, Command: "gcc {{.Script}} -o program && ./program"}, // compile and run
+	{Name: "ruby", Image: "ruby", Extension: "rb", Command: "ruby {{.Script}}"},
+	{Name: "php", Image: "php", Extension: "php", Command: "php {{.Script}}"},
+	{Name: "perl", Image: "perl", Extension: "pl", Command: "perl {{.Script}}"},
+	{Name: "r", Aliases: []string{"rscript"}, Image: "r-base", Extension: "R", Command: "Rscript {{.Script}}"},
+	{Name: "julia", Image: "julia", Extension: "jl", Command: "julia {{.Script}}"},
+	{Name: "rust", Aliases: []string{"rs"}, Image: "rust", Extension: "rs",
+		Command: "rustc {{.Script}} -o program && ./program"},
+}
+
+// getRuntimes returns the registry, the runtimes added by the admins followed by the built-in ones they leave
+func (srv *UService) getRuntimes() ([]ut.Runtime, error) {
+	runtimes, err := srv.getAddedRuntimes()
+	if err != nil {
+		return nil, err
+	}
+	for _, builtin := range builtinRuntimes {
+		if !slices.ContainsFunc(runtimes, func(r ut.Runtime) bool { return r.Name == builtin.Name }) {
+			builtin.Builtin = true
+			runtimes = append(runtimes, builtin)
+		}
+	}
+
+	return runtimes, nil
+}
+
+// lookupRuntime returns the runtime the logic of a job names (name:version) and the version, latest by default
+func (srv *UService) lookupRuntime(logic string) (ut.Runtime, string, error) {
+	name, version, _ := strings.Cut(strings.TrimSpace(logic), ":")
+	name = strings.ToLower(name)
+	if version == "" {
+		version = "latest"
+	}
+
+	runtimes, err := srv.getRuntimes()
+	if err != nil {
+		return ut.Runtime{}, version, fmt.Errorf("failed to retrieve the runtimes: %w", err)
+	}
+	names := make([]string, 0, len(runtimes))
+	for _, r := range runtimes {
+		if slices.Contains(r.Names(), name) {
+			return r, version, nil
+		}
+		names = append(names, r.Name)
+	}
+
+	return ut.Runtime{}, version, fmt.Errorf("%w %q, must be one of %s", errUnknownRuntime, name, strings.Join(names, ", "))
+}
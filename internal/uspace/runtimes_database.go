@@ -0,0 +1,112 @@
+package uspace
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// getAddedRuntimes returns the runtimes added by the admins, by name
+func (srv *UService) getAddedRuntimes() ([]ut.Runtime, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			name, aliases, image, extension, skeleton, command, createdAt
+		FROM
+			runtimes
+		ORDER BY
+			name ASC`)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var runtimes []ut.Runtime
+	for rows.Next() {
+		var (
+			r                 ut.Runtime
+			aliases, skeleton sql.NullString
+			created           sql.NullTime
+		)
+		err = rows.Scan(&r.Name, &aliases, &r.Image, &r.Extension, &skeleton, &r.Command, &created)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if aliases.String != "" {
+			r.Aliases = strings.Split(aliases.String, ",")
+		}
+		r.Skeleton = skeleton.String
+		if created.Valid {
+			r.CreatedAt = created.Time.UTC().Format(ut.TimeFormat)
+		}
+		runtimes = append(runtimes, r)
+	}
+
+	return runtimes, nil
+}
+
+// upsertRuntime adds a runtime, replacing the one of the same name if any
+func (srv *UService) upsertRuntime(r ut.Runtime) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT OR REPLACE INTO
+			runtimes (name, aliases, image, extension, skeleton, command, createdAt)
+		VALUES
+			(?, ?, ?, ?, ?, ?, ?)`,
+		r.Name, strings.Join(r.Aliases, ","), r.Image, r.Extension, r.Skeleton, r.Command, time.Now().UTC())
+	if err != nil {
+		log.Printf("failed to save runtime %s: %v", r.Name, err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// deleteRuntime removes an added runtime, returns sql.ErrNoRows if there is none of that name
+func (srv *UService) deleteRuntime(name string) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	res, err := db.Exec(`DELETE FROM runtimes WHERE name = ?`, name)
+	if err != nil {
+		log.Printf("failed to delete runtime %s: %v", name, err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
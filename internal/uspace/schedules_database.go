@@ -0,0 +1,294 @@
+package uspace
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+const scheduleColumns = `sid, uid, gid, name, cron, spec, enabled, lastRun, nextRun, createdAt`
+
+func scanSchedule(row rowScanner) (ut.Schedule, error) {
+	var (
+		s                         ut.Schedule
+		gid                       sql.NullInt64
+		spec                      string
+		lastRun, nextRun, created sql.NullTime
+	)
+	err := row.Scan(&s.SID, &s.UID, &gid, &s.Name, &s.Cron, &spec, &s.Enabled, &lastRun, &nextRun, &created)
+	if err != nil {
+		return s, err
+	}
+	s.GID = int(gid.Int64)
+	if lastRun.Valid {
+		s.LastRun = lastRun.Time.UTC().Format(ut.TimeFormat)
+	}
+	if nextRun.Valid {
+		s.NextRun = nextRun.Time.UTC().Format(ut.TimeFormat)
+	}
+	if created.Valid {
+		s.CreatedAt = created.Time.UTC().Format(ut.TimeFormat)
+	}
+	err = json.Unmarshal([]byte(spec), &s.Job)
+	if err != nil {
+		return s, fmt.Errorf("corrupt job template of schedule %d: %w", s.SID, err)
+	}
+
+	return s, nil
+}
+
+func (srv *UService) insertSchedule(s ut.Schedule, nextRun time.Time) (int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return -1, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	spec, err := json.Marshal(s.Job)
+	if err != nil {
+		return -1, fmt.Errorf("failed to marshal the job template: %w", err)
+	}
+
+	var sid int64
+	err = db.QueryRow(`
+		INSERT INTO
+			schedules (sid, uid, gid, name, cron, spec, enabled, nextRun, createdAt)
+		VALUES
+			(nextval('seq_scheduleid'), ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING (sid);`,
+		s.UID, s.GID, s.Name, s.Cron, string(spec), s.Enabled, nextRun, time.Now().UTC()).Scan(&sid)
+	if err != nil {
+		log.Printf("failed to insert schedule: %v", err)
+
+		return -1, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return sid, nil
+}
+
+func (srv *UService) getSchedule(sid int64) (ut.Schedule, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return ut.Schedule{}, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	s, err := scanSchedule(db.QueryRow(`SELECT `+scheduleColumns+` FROM schedules WHERE sid = ?`, sid))
+	if err != nil {
+		log.Printf("failed to query row: %v", err)
+
+		return s, fmt.Errorf("failed to query row: %w", err)
+	}
+
+	return s, nil
+}
+
+// getSchedules returns the schedules of a user, or all of them if uid < 0
+func (srv *UService) getSchedules(uid int) ([]ut.Schedule, error) {
+	return srv.querySchedules(`
+		SELECT
+			`+scheduleColumns+`
+		FROM
+			schedules
+		WHERE
+			uid = ? OR ? < 0
+		ORDER BY
+			sid DESC`, uid, uid)
+}
+
+// getDueSchedules returns the enabled schedules whose next run is not later than now
+func (srv *UService) getDueSchedules(now time.Time) ([]ut.Schedule, error) {
+	return srv.querySchedules(`
+		SELECT
+			`+scheduleColumns+`
+		FROM
+			schedules
+		WHERE
+			enabled AND nextRun <= ?
+		ORDER BY
+			nextRun ASC`, now)
+}
+
+func (srv *UService) querySchedules(query string, args ...any) ([]ut.Schedule, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var schedules []ut.Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		schedules = append(schedules, s)
+	}
+
+	return schedules, nil
+}
+
+// updateScheduleRun records that a schedule ran and when it is due next
+func (srv *UService) updateScheduleRun(sid int64, lastRun, nextRun time.Time) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE schedules
+		SET
+			lastRun = ?, nextRun = ?
+		WHERE
+			sid = ?`, lastRun, nextRun, sid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// setScheduleEnabled pauses or resumes a schedule, a resumed schedule only runs from nextRun on
+func (srv *UService) setScheduleEnabled(sid int64, enabled bool, nextRun time.Time) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE schedules
+		SET
+			enabled = ?, nextRun = ?
+		WHERE
+			sid = ?`, enabled, nextRun, sid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// deleteSchedule removes a schedule and its run history, the jobs it produced are kept
+func (srv *UService) deleteSchedule(sid int64) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`DELETE FROM schedule_runs WHERE sid = ?`, sid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	_, err = db.Exec(`DELETE FROM schedules WHERE sid = ?`, sid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+func (srv *UService) insertScheduleRun(sid, jid int64, scheduledAt time.Time) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO schedule_runs (sid, jid, scheduledAt) VALUES (?, ?, ?)`, sid, jid, scheduledAt)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// getScheduleRuns returns the jobs a schedule produced, most recent first, along with their current status
+func (srv *UService) getScheduleRuns(sid int64, limit int) ([]ut.ScheduleRun, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			r.jid, r.scheduledAt, COALESCE(j.status, 'deleted')
+		FROM
+			schedule_runs r
+		LEFT JOIN
+			jobs j ON j.jid = r.jid
+		WHERE
+			r.sid = ?
+		ORDER BY
+			r.scheduledAt DESC
+		LIMIT ?`, sid, limit)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var runs []ut.ScheduleRun
+	for rows.Next() {
+		var (
+			run         ut.ScheduleRun
+			scheduledAt time.Time
+		)
+		err = rows.Scan(&run.JID, &scheduledAt, &run.Status)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		run.ScheduledAt = scheduledAt.UTC().Format(ut.TimeFormat)
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,131 @@
+package uspace
+
+/*
+	recurring jobs
+
+	a schedule is a job template along with a cron expression (in UTC),
+	the scheduler goroutine looks for due schedules every scheduleTick
+	and materializes each of them into a regular job.
+
+	runs missed while the service was down (or while the schedule was paused)
+	are not made up for, a due schedule runs once and moves on to its next match.
+
+	every run is admitted (see admitJob) as the identity the schedule was created by,
+	a run which is not allowed anymore is recorded as a rejected job.
+*/
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// how often due schedules are looked for, cron has a minute resolution
+const scheduleTick = 15 * time.Second
+
+// StartScheduler method launches a goroutine which turns due schedules into jobs
+func (jm *JobManager) StartScheduler() {
+	log.Printf("[Schedules] Starting scheduler")
+	go func() {
+		ticker := time.NewTicker(scheduleTick)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			jm.runDueSchedules(now.UTC())
+		}
+	}()
+}
+
+func (jm *JobManager) runDueSchedules(now time.Time) {
+	schedules, err := jm.srv.getDueSchedules(now)
+	if err != nil {
+		log.Printf("[Schedules] failed to retrieve due schedules: %v", err)
+
+		return
+	}
+
+	for _, s := range schedules {
+		cron, err := ut.ParseCron(s.Cron)
+		if err != nil {
+			log.Printf("[Schedules] schedule %d has an invalid cron expression, pausing it: %v", s.SID, err)
+			err = jm.srv.setScheduleEnabled(s.SID, false, now)
+			if err != nil {
+				log.Printf("[Schedules] failed to pause schedule %d: %v", s.SID, err)
+			}
+
+			continue
+		}
+
+		jm.runSchedule(s, now)
+
+		err = jm.srv.updateScheduleRun(s.SID, now, cron.Next(now))
+		if err != nil {
+			log.Printf("[Schedules] failed to update schedule %d: %v", s.SID, err)
+		}
+	}
+}
+
+// runSchedule submits a job out of the schedule's template and records it in the run history
+func (jm *JobManager) runSchedule(s ut.Schedule, now time.Time) {
+	job := s.Job
+	job.UID = s.UID
+	job.GID = s.GID
+	if job.Description == "" {
+		job.Description = fmt.Sprintf("schedule %s", s.Name)
+	}
+
+	admitErr := jm.srv.admitJob(&job, templateClaim(s.Job, s.UID, s.GID))
+
+	jid, err := jm.srv.insertJob(job)
+	if err != nil {
+		log.Printf("[Schedules] failed to insert the job of schedule %d: %v", s.SID, err)
+
+		return
+	}
+	job.JID = jid
+
+	err = jm.srv.insertScheduleRun(s.SID, jid, now)
+	if err != nil {
+		log.Printf("[Schedules] failed to record run of schedule %d: %v", s.SID, err)
+	}
+	if admitErr != nil {
+		log.Printf("[Schedules] job %d of schedule %d not allowed: %v", jid, s.SID, admitErr)
+		err = jm.srv.markJobStatus(jid, "rejected", 0, ut.ActorScheduler, admitErr.Error())
+		if err != nil {
+			log.Printf("[Schedules] failed to reject job %d: %v", jid, err)
+		}
+
+		return
+	}
+
+	// through the dispatcher, the job may be executed by another instance
+	err = jm.srv.jdp.PublishJob(job)
+	if err != nil {
+		log.Printf("[Schedules] failed to schedule the job of schedule %d: %v", s.SID, err)
+		jm.srv.rejectPendingJobs([]ut.Job{job})
+
+		return
+	}
+	log.Printf("[Schedules] schedule %d (%s) submitted job %d", s.SID, s.Name, jid)
+}
+
+// createSchedule saves a (validated) schedule, due at the next match of its cron expression
+func (srv *UService) createSchedule(s ut.Schedule) (int64, error) {
+	cron, err := ut.ParseCron(s.Cron)
+	if err != nil {
+		return -1, err
+	}
+
+	return srv.insertSchedule(s, cron.Next(time.Now().UTC()))
+}
+
+// pauseSchedule disables or re-enables a schedule
+func (srv *UService) pauseSchedule(s ut.Schedule, paused bool) error {
+	cron, err := ut.ParseCron(s.Cron)
+	if err != nil {
+		return err
+	}
+
+	return srv.setScheduleEnabled(s.SID, !paused, cron.Next(time.Now().UTC()))
+}
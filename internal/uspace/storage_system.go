@@ -22,6 +22,8 @@ type StorageSystem interface {
 	Download(t *any) (context.CancelFunc, error)
 
 	Stat(t any) (any, error)
+	// Checksum returns a digest of the content of an object, changing whenever its content does
+	Checksum(t any) (string, error)
 
 	Remove(t any) error
 	RemoveVolume(t any) error
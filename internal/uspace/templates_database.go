@@ -0,0 +1,239 @@
+package uspace
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+const templateColumns = `tid, version, uid, gid, shared, name, description, spec, createdAt`
+
+func scanJobTemplate(row rowScanner) (ut.JobTemplate, error) {
+	var (
+		t           ut.JobTemplate
+		gid         sql.NullInt64
+		shared      sql.NullBool
+		description sql.NullString
+		spec        string
+		created     sql.NullTime
+	)
+	err := row.Scan(&t.TID, &t.Version, &t.UID, &gid, &shared, &t.Name, &description, &spec, &created)
+	if err != nil {
+		return t, err
+	}
+	t.GID = int(gid.Int64)
+	t.Shared = shared.Bool
+	t.Description = description.String
+	if created.Valid {
+		t.CreatedAt = created.Time.UTC().Format(ut.TimeFormat)
+	}
+	err = json.Unmarshal([]byte(spec), &t.Job)
+	if err != nil {
+		return t, fmt.Errorf("corrupt job of template %d v%d: %w", t.TID, t.Version, err)
+	}
+
+	return t, nil
+}
+
+// insertJobTemplate saves a new template as its first version
+func (srv *UService) insertJobTemplate(t ut.JobTemplate) (int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return -1, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	spec, err := json.Marshal(t.Job)
+	if err != nil {
+		return -1, fmt.Errorf("failed to marshal the template job: %w", err)
+	}
+
+	var tid int64
+	err = db.QueryRow(`
+		INSERT INTO
+			job_templates (tid, version, uid, gid, shared, name, description, spec, createdAt)
+		VALUES
+			(nextval('seq_templateid'), 1, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING (tid);`,
+		t.UID, t.GID, t.Shared, t.Name, t.Description, string(spec), time.Now().UTC()).Scan(&tid)
+	if err != nil {
+		log.Printf("failed to insert template: %v", err)
+
+		return -1, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return tid, nil
+}
+
+// insertJobTemplateVersion saves the given template as the next version of t.TID, returns that version
+func (srv *UService) insertJobTemplateVersion(t ut.JobTemplate) (int, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return -1, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	spec, err := json.Marshal(t.Job)
+	if err != nil {
+		return -1, fmt.Errorf("failed to marshal the template job: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+
+		return -1, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	var version int
+	err = tx.QueryRow(`SELECT COALESCE(MAX(version), 0) + 1 FROM job_templates WHERE tid = ?`, t.TID).Scan(&version)
+	if err == nil {
+		_, err = tx.Exec(`
+			INSERT INTO
+				job_templates (tid, version, uid, gid, shared, name, description, spec, createdAt)
+			VALUES
+				(?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+			t.TID, version, t.UID, t.GID, t.Shared, t.Name, t.Description, string(spec), time.Now().UTC())
+	}
+	if err != nil {
+		log.Printf("failed to insert template version: %v", err)
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("failed to rollback: %v", rerr)
+		}
+
+		return -1, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+
+		return -1, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return version, nil
+}
+
+// getJobTemplate returns the given version of a template, the latest one if version <= 0
+func (srv *UService) getJobTemplate(tid int64, version int) (ut.JobTemplate, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return ut.JobTemplate{}, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	t, err := scanJobTemplate(db.QueryRow(`
+		SELECT
+			`+templateColumns+`
+		FROM
+			job_templates
+		WHERE
+			tid = ? AND (version = ? OR ? <= 0)
+		ORDER BY
+			version DESC
+		LIMIT 1`, tid, version, version))
+	if err != nil {
+		log.Printf("failed to query row: %v", err)
+
+		return t, fmt.Errorf("failed to query row: %w", err)
+	}
+
+	return t, nil
+}
+
+// getJobTemplateVersions returns every version of a template, most recent first
+func (srv *UService) getJobTemplateVersions(tid int64) ([]ut.JobTemplate, error) {
+	return srv.queryJobTemplates(`
+		SELECT
+			`+templateColumns+`
+		FROM
+			job_templates
+		WHERE
+			tid = ?
+		ORDER BY
+			version DESC`, tid)
+}
+
+// getJobTemplates returns the latest version of the templates owned by uid or shared with any of gids,
+// or of all of them if uid < 0
+func (srv *UService) getJobTemplates(uid int, gids []int) ([]ut.JobTemplate, error) {
+	query := `
+		SELECT
+			` + templateColumns + `
+		FROM
+			job_templates t
+		WHERE
+			version = (SELECT MAX(version) FROM job_templates l WHERE l.tid = t.tid)
+			AND (? < 0 OR uid = ?`
+	args := []any{uid, uid}
+	for _, gid := range gids {
+		query += ` OR (shared AND gid = ?)`
+		args = append(args, gid)
+	}
+	query += `)
+		ORDER BY
+			tid DESC`
+
+	return srv.queryJobTemplates(query, args...)
+}
+
+func (srv *UService) queryJobTemplates(query string, args ...any) ([]ut.JobTemplate, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var templates []ut.JobTemplate
+	for rows.Next() {
+		t, err := scanJobTemplate(rows)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, nil
+}
+
+// deleteJobTemplate removes every version of a template, the jobs submitted out of it are kept
+func (srv *UService) deleteJobTemplate(tid int64) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`DELETE FROM job_templates WHERE tid = ?`, tid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
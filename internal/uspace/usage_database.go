@@ -0,0 +1,103 @@
+package uspace
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// usageKeys are the columns usage can be aggregated by, per report kind
+var usageKeys = map[string]string{
+	"user":  "CAST(uid AS VARCHAR)",
+	"group": "CAST(COALESCE(gid, 0) AS VARCHAR)",
+	"app":   "logic",
+}
+
+// addJobUsage accumulates the usage of an execution of a job, retried jobs account for all of their attempts
+func (srv *UService) addJobUsage(jid int64, usage ut.JobUsage) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE jobs
+		SET
+			cpuSeconds = COALESCE(cpuSeconds, 0) + CAST(? AS FLOAT),
+			peakMemory = GREATEST(COALESCE(peakMemory, 0), CAST(? AS BIGINT)),
+			bytesRead = COALESCE(bytesRead, 0) + CAST(? AS BIGINT),
+			bytesWritten = COALESCE(bytesWritten, 0) + CAST(? AS BIGINT)
+		WHERE
+			jid = ?`, usage.CPUSeconds, usage.PeakMemory, usage.BytesRead, usage.BytesWritten, jid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// getUsageReport aggregates the usage of the jobs created within [from, to), per user, group or app
+// (see usageKeys), the heaviest consumers of cpu first
+func (srv *UService) getUsageReport(by string, from, to time.Time) ([]ut.UsageReport, error) {
+	key, ok := usageKeys[by]
+	if !ok {
+		return nil, fmt.Errorf("cannot aggregate usage by %q", by)
+	}
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	// the duration of a job is stored in nanoseconds
+	rows, err := db.Query(`
+		SELECT
+			`+key+` AS k,
+			COUNT(*),
+			COALESCE(SUM(duration), 0) / 1e9,
+			COALESCE(SUM(cpuSeconds), 0),
+			COALESCE(MAX(peakMemory), 0),
+			COALESCE(SUM(bytesRead), 0),
+			COALESCE(SUM(bytesWritten), 0)
+		FROM
+			jobs
+		WHERE
+			(cpuSeconds IS NOT NULL OR peakMemory IS NOT NULL)
+			AND createdAt >= ? AND createdAt < ?
+		GROUP BY
+			k
+		ORDER BY
+			4 DESC, k`, from.UTC(), to.UTC())
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var reports []ut.UsageReport
+	for rows.Next() {
+		var r ut.UsageReport
+		err = rows.Scan(&r.Key, &r.Jobs, &r.Duration, &r.CPUSeconds, &r.PeakMemory, &r.BytesRead, &r.BytesWritten)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		reports = append(reports, r)
+	}
+
+	return reports, nil
+}
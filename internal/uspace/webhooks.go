@@ -0,0 +1,284 @@
+package uspace
+
+/*
+	job webhooks
+
+	every status a job moves to (see markJobStatus) that maps to a webhook event
+	(see ut.WebhookEvent) is notified to the webhooks of the job and to the subscriptions
+	of its owner that want it:
+
+		POST <url>
+		Content-Type: application/json
+		X-Kuspace-Event: completed
+		X-Kuspace-Delivery: <did>
+		X-Kuspace-Signature: sha256=<hex hmac-sha256 of the body>
+
+	with a ut.WebhookPayload body. Every delivery is logged in webhook_deliveries, once per
+	webhook, job attempt and event, and retried with an exponential backoff on network errors,
+	5xx, 408 and 429 responses, up to J_WEBHOOK_MAX_ATTEMPTS tries. Deliveries still pending
+	when the service stops are resumed on startup.
+
+	a webhook may not reach into the network of the service: its url is checked when submitted
+	(see ut.Webhook.Validate) and every address it resolves to when delivered, loopback, link-local
+	and private ones are refused unless allowed by J_WEBHOOK_ALLOWED_HOSTS. Redirects are not followed.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+var errWebhookDestination = errors.New("webhook destination not allowed")
+
+const (
+	webhookBackoff    = 2 * time.Second // before the second try, doubled on every try
+	webhookMaxBackoff = 5 * time.Minute
+)
+
+// notifyJobWebhooks delivers the event a job status maps to, if any, to the webhooks interested in it
+func (srv *UService) notifyJobWebhooks(jid int64, status string) {
+	event, ok := ut.WebhookEvent(status)
+	if !ok {
+		return
+	}
+	job, err := srv.getJobByID(int(jid))
+	if err != nil {
+		log.Printf("[Webhooks] failed to retrieve job %d: %v", jid, err)
+
+		return
+	}
+	subscriptions, err := srv.getWebhooks(job.UID)
+	if err != nil {
+		log.Printf("[Webhooks] failed to retrieve the subscriptions of uid %d: %v", job.UID, err)
+	}
+
+	// the attempt a transition belongs to, a final one comes after the attempt was recorded
+	final := event != ut.WebhookQueued && event != ut.WebhookRunning
+	attempt := job.Attempts + 1
+	if final {
+		attempt = max(job.Attempts, 1)
+	}
+	payload, err := json.Marshal(ut.WebhookPayload{
+		Event:       event,
+		Status:      status,
+		JID:         job.JID,
+		UID:         job.UID,
+		GID:         job.GID,
+		Attempt:     attempt,
+		Description: job.Description,
+		Output:      job.Output,
+		Duration:    time.Duration(job.Duration).Seconds(),
+		Timestamp:   ut.CurrentTime(),
+	})
+	if err != nil {
+		log.Printf("[Webhooks] failed to marshal the payload of job %d: %v", jid, err)
+
+		return
+	}
+
+	for _, hook := range append(job.Webhooks, subscriptions...) {
+		if !hook.Wants(event) {
+			continue
+		}
+		delivery := ut.WebhookDelivery{
+			WID:     hook.WID,
+			JID:     job.JID,
+			Attempt: attempt,
+			Event:   event,
+			URL:     hook.URL,
+			Payload: string(payload),
+		}
+		logged, err := srv.insertWebhookDelivery(&delivery, final)
+		if err != nil || !logged {
+			continue
+		}
+		go srv.deliverWebhook(delivery, srv.webhookSecret(hook))
+	}
+}
+
+// webhookSecret returns the key the deliveries of a webhook are signed with, none if empty
+func (srv *UService) webhookSecret(hook ut.Webhook) []byte {
+	if hook.Secret != "" {
+		return []byte(hook.Secret)
+	}
+
+	return []byte(srv.config.UspaceWebhookSecret)
+}
+
+// deliverWebhook posts a logged delivery until it is accepted, rejected or out of tries,
+// the log is updated after every try
+func (srv *UService) deliverWebhook(d ut.WebhookDelivery, secret []byte) {
+	maxTries := max(int(srv.config.UspaceWebhookMaxAttempts), 1)
+	client := srv.webhookClient(d.URL)
+
+	for d.Tries < maxTries {
+		if d.Tries > 0 {
+			time.Sleep(webhookDelay(d.Tries))
+		}
+		d.Tries++
+		retry := srv.postWebhook(client, &d, secret)
+		switch {
+		case d.Error == "":
+			d.Status = "delivered"
+		case !retry || d.Tries >= maxTries:
+			d.Status = "failed"
+		}
+		err := srv.updateWebhookDelivery(d)
+		if err != nil {
+			log.Printf("[Webhooks] failed to log delivery %d: %v", d.DID, err)
+		}
+		if d.Status != "pending" {
+			break
+		}
+	}
+	if d.Status == "failed" {
+		log.Printf("[Webhooks] gave up on delivery %d (%s of job %d) to %s after %d tries: %s",
+			d.DID, d.Event, d.JID, d.URL, d.Tries, d.Error)
+	}
+}
+
+// webhookHosts returns the hosts, ips and cidrs webhooks may target although not public
+func (srv *UService) webhookHosts() []string {
+	return strings.Split(srv.config.UspaceWebhookHosts, ",")
+}
+
+// webhookClient returns the client a webhook is delivered with, it refuses to connect to an address
+// that is not public (as resolved) unless allowed, and does not follow redirects
+func (srv *UService) webhookClient(rawURL string) *http.Client {
+	allowed := srv.webhookHosts()
+	hostAllowed := false
+	if u, err := url.Parse(rawURL); err == nil {
+		hostAllowed = ut.WebhookHostAllowed(u.Hostname(), allowed)
+	}
+	timeout := time.Duration(max(srv.config.UspaceWebhookTimeout, 1)) * time.Second
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if hostAllowed || ut.PublicIP(ip) || ut.WebhookHostAllowed(host, allowed) {
+				return nil
+			}
+
+			return fmt.Errorf("%w: %s is not a public address", errWebhookDestination, host)
+		},
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout, DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// postWebhook tries a delivery once, recording its response code and error (empty on success),
+// returns if a failure is worth retrying
+func (srv *UService) postWebhook(client *http.Client, d *ut.WebhookDelivery, secret []byte) bool {
+	d.ResponseCode, d.Error = 0, ""
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		d.Error = err.Error()
+
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Kuspace-Event", d.Event)
+	req.Header.Set("X-Kuspace-Delivery", strconv.FormatInt(d.DID, 10))
+	if len(secret) > 0 {
+		req.Header.Set("X-Kuspace-Signature", ut.SignWebhook(secret, []byte(d.Payload)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		d.Error = err.Error()
+
+		return !errors.Is(err, errWebhookDestination)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	d.ResponseCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false
+	}
+	d.Error = fmt.Sprintf("responded with %s", resp.Status)
+
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+}
+
+// webhookDelay returns how long to wait before the try following the given one
+func webhookDelay(tries int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < tries && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, webhookMaxBackoff)
+}
+
+// resumeWebhookDeliveries picks up the deliveries left pending by a previous run of the service
+func (srv *UService) resumeWebhookDeliveries() {
+	deliveries, err := srv.getWebhookDeliveries(0, 0, "pending", 10000)
+	if err != nil {
+		log.Printf("[Webhooks] failed to retrieve the pending deliveries: %v", err)
+
+		return
+	}
+
+	for _, d := range deliveries {
+		var (
+			hook  ut.Webhook
+			found bool
+		)
+		if d.WID != 0 {
+			hook, err = srv.getWebhook(d.WID)
+			found = err == nil
+		} else {
+			job, err := srv.getJobByID(int(d.JID))
+			if err == nil {
+				for _, h := range job.Webhooks {
+					if h.URL == d.URL {
+						hook, found = h, true
+
+						break
+					}
+				}
+			}
+		}
+		if !found {
+			d.Status, d.Error = "failed", "webhook no longer exists"
+			err = srv.updateWebhookDelivery(d)
+			if err != nil {
+				log.Printf("[Webhooks] failed to log delivery %d: %v", d.DID, err)
+			}
+
+			continue
+		}
+		go srv.deliverWebhook(d, srv.webhookSecret(hook))
+	}
+	if len(deliveries) > 0 {
+		log.Printf("[Webhooks] resumed %d pending deliveries", len(deliveries))
+	}
+}
@@ -0,0 +1,299 @@
+package uspace
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+const webhookColumns = `wid, uid, url, events, secret, createdAt`
+
+func scanWebhook(row rowScanner) (ut.Webhook, error) {
+	var (
+		w       ut.Webhook
+		events  sql.NullString
+		secret  sql.NullString
+		created sql.NullTime
+	)
+	err := row.Scan(&w.WID, &w.UID, &w.URL, &events, &secret, &created)
+	if err != nil {
+		return w, err
+	}
+	if events.String != "" {
+		w.Events = strings.Split(events.String, ",")
+	}
+	w.Secret = secret.String
+	if created.Valid {
+		w.CreatedAt = created.Time.UTC().Format(ut.TimeFormat)
+	}
+
+	return w, nil
+}
+
+// insertWebhook saves a subscription of a user to the events of its jobs
+func (srv *UService) insertWebhook(w ut.Webhook) (int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return -1, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	var wid int64
+	err = db.QueryRow(`
+		INSERT INTO
+			webhooks (wid, uid, url, events, secret, createdAt)
+		VALUES
+			(nextval('seq_webhookid'), ?, ?, ?, ?, ?)
+		RETURNING (wid);`,
+		w.UID, w.URL, strings.Join(w.Events, ","), w.Secret, time.Now().UTC()).Scan(&wid)
+	if err != nil {
+		log.Printf("failed to insert webhook: %v", err)
+
+		return -1, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return wid, nil
+}
+
+// getWebhook returns a subscription by its id
+func (srv *UService) getWebhook(wid int64) (ut.Webhook, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return ut.Webhook{}, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	w, err := scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE wid = ?`, wid))
+	if err != nil {
+		log.Printf("failed to query row: %v", err)
+
+		return w, fmt.Errorf("failed to query row: %w", err)
+	}
+
+	return w, nil
+}
+
+// getWebhooks returns the subscriptions of a user, or of all of them if uid < 0
+func (srv *UService) getWebhooks(uid int) ([]ut.Webhook, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			`+webhookColumns+`
+		FROM
+			webhooks
+		WHERE
+			? < 0 OR uid = ?
+		ORDER BY
+			wid`, uid, uid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var webhooks []ut.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, nil
+}
+
+// deleteWebhook removes a subscription, its deliveries are kept in the log
+func (srv *UService) deleteWebhook(wid int64) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`DELETE FROM webhooks WHERE wid = ?`, wid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+const deliveryColumns = `did, wid, jid, attempt, event, url, payload, status, tries, responseCode, error, createdAt, updatedAt`
+
+func scanWebhookDelivery(row rowScanner) (ut.WebhookDelivery, error) {
+	var (
+		d                   ut.WebhookDelivery
+		code                sql.NullInt64
+		errMsg              sql.NullString
+		created, updated    sql.NullTime
+		attempt, wid, tries sql.NullInt64
+	)
+	err := row.Scan(&d.DID, &wid, &d.JID, &attempt, &d.Event, &d.URL, &d.Payload, &d.Status, &tries, &code,
+		&errMsg, &created, &updated)
+	if err != nil {
+		return d, err
+	}
+	d.WID = wid.Int64
+	d.Attempt = int(attempt.Int64)
+	d.Tries = int(tries.Int64)
+	d.ResponseCode = int(code.Int64)
+	d.Error = errMsg.String
+	if created.Valid {
+		d.CreatedAt = created.Time.UTC().Format(ut.TimeFormat)
+	}
+	if updated.Valid {
+		d.UpdatedAt = updated.Time.UTC().Format(ut.TimeFormat)
+	}
+
+	return d, nil
+}
+
+// insertWebhookDelivery logs a new pending delivery and sets its id, unless the same event of the same
+// job attempt was already logged for that webhook (final events are logged once per job), returns if it was
+func (srv *UService) insertWebhookDelivery(d *ut.WebhookDelivery, final bool) (bool, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return false, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	var logged int
+	err = tx.QueryRow(`
+		SELECT
+			COUNT(*)
+		FROM
+			webhook_deliveries
+		WHERE
+			wid = ? AND url = ? AND jid = ? AND event = ? AND (attempt = ? OR ?)`,
+		d.WID, d.URL, d.JID, d.Event, d.Attempt, final).Scan(&logged)
+	if err == nil && logged == 0 {
+		now := time.Now().UTC()
+		err = tx.QueryRow(`
+			INSERT INTO
+				webhook_deliveries (did, wid, jid, attempt, event, url, payload, status, tries, createdAt, updatedAt)
+			VALUES
+				(nextval('seq_deliveryid'), ?, ?, ?, ?, ?, ?, 'pending', 0, ?, ?)
+			RETURNING (did);`,
+			d.WID, d.JID, d.Attempt, d.Event, d.URL, d.Payload, now, now).Scan(&d.DID)
+		d.Status = "pending"
+	}
+	if err != nil {
+		log.Printf("failed to log webhook delivery: %v", err)
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("failed to rollback: %v", rerr)
+		}
+
+		return false, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return logged == 0, nil
+}
+
+// updateWebhookDelivery records the outcome of the latest try of a delivery
+func (srv *UService) updateWebhookDelivery(d ut.WebhookDelivery) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE webhook_deliveries
+		SET
+			status = ?, tries = ?, responseCode = ?, error = ?, updatedAt = ?
+		WHERE
+			did = ?`, d.Status, d.Tries, d.ResponseCode, d.Error, time.Now().UTC(), d.DID)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+// getWebhookDeliveries returns the logged deliveries, most recent first, filtered by the non zero/empty
+// arguments, at most limit of them
+func (srv *UService) getWebhookDeliveries(jid, wid int64, status string, limit int) ([]ut.WebhookDelivery, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			`+deliveryColumns+`
+		FROM
+			webhook_deliveries
+		WHERE
+			(? = 0 OR jid = ?) AND (? = 0 OR wid = ?) AND (? = '' OR status = ?)
+		ORDER BY
+			did DESC
+		LIMIT ?`, jid, jid, wid, wid, status, status, limit)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var deliveries []ut.WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
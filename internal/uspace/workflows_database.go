@@ -0,0 +1,316 @@
+package uspace
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// insertWorkflow saves a workflow along with its nodes, all nodes start as "waiting"
+func (srv *UService) insertWorkflow(w ut.Workflow) (int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return -1, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+
+		return -1, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	var wid int64
+	err = tx.QueryRow(`
+		INSERT INTO
+			workflows (wid, uid, gid, name, description, status, createdAt)
+		VALUES
+			(nextval('seq_workflowid'), ?, ?, ?, ?, ?, ?)
+		RETURNING (wid);`,
+		w.UID, w.GID, w.Name, w.Description, "running", ut.CurrentTime()).Scan(&wid)
+	if err != nil {
+		log.Printf("failed to insert workflow: %v", err)
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("failed to rollback: %v", rerr)
+		}
+
+		return -1, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	for i, node := range w.Nodes {
+		spec, err := json.Marshal(node.Job)
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				log.Printf("failed to rollback: %v", rerr)
+			}
+
+			return -1, fmt.Errorf("failed to marshal node %s: %w", node.Name, err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO
+				workflow_nodes (wid, idx, name, dependsOn, spec, jid, status)
+			VALUES
+				(?, ?, ?, ?, ?, 0, 'waiting');`,
+			wid, i, node.Name, strings.Join(node.DependsOn, ","), string(spec))
+		if err != nil {
+			log.Printf("failed to insert workflow node: %v", err)
+			if rerr := tx.Rollback(); rerr != nil {
+				log.Printf("failed to rollback: %v", rerr)
+			}
+
+			return -1, fmt.Errorf("failed to execute query: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+
+		return -1, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return wid, nil
+}
+
+// getWorkflow returns a workflow with its nodes,
+// the status of a submitted node is the status of its job
+func (srv *UService) getWorkflow(wid int64) (ut.Workflow, error) {
+	var w ut.Workflow
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return w, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	var completedAt, createdAt sql.NullString
+	var gid sql.NullInt64
+	err = db.QueryRow(`
+		SELECT
+			wid, uid, gid, name, description, status, createdAt, completedAt
+		FROM
+			workflows
+		WHERE
+			wid = ?`, wid).Scan(&w.WID, &w.UID, &gid, &w.Name, &w.Description, &w.Status, &createdAt, &completedAt)
+	if err != nil {
+		log.Printf("failed to query row: %v", err)
+
+		return w, fmt.Errorf("failed to query row: %w", err)
+	}
+	w.GID = int(gid.Int64)
+	w.CreatedAt = createdAt.String
+	w.CompletedAt = completedAt.String
+
+	rows, err := db.Query(`
+		SELECT
+			n.name, n.dependsOn, n.spec, n.jid, COALESCE(j.status, n.status), j.output
+		FROM
+			workflow_nodes n
+		LEFT JOIN
+			jobs j ON j.jid = n.jid
+		WHERE
+			n.wid = ?
+		ORDER BY
+			n.idx ASC`, wid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return w, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			node            ut.WorkflowNode
+			dependsOn, spec string
+			output          sql.NullString
+		)
+		err = rows.Scan(&node.Name, &dependsOn, &spec, &node.JID, &node.Status, &output)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return w, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if dependsOn != "" {
+			node.DependsOn = strings.Split(dependsOn, ",")
+		}
+		err = json.Unmarshal([]byte(spec), &node.Job)
+		if err != nil {
+			return w, fmt.Errorf("corrupt spec of node %s: %w", node.Name, err)
+		}
+		// the submitted job carries the resolved values
+		if output.Valid {
+			node.Job.Output = output.String
+		}
+		node.Job.JID = node.JID
+		node.Job.Status = node.Status
+		w.Nodes = append(w.Nodes, node)
+	}
+
+	return w, nil
+}
+
+// getWorkflows returns the workflows (without their nodes) of a user, or all of them if uid < 0
+func (srv *UService) getWorkflows(uid int) ([]ut.Workflow, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	query := `
+		SELECT
+			wid, uid, gid, name, description, status, createdAt, completedAt
+		FROM
+			workflows
+		WHERE
+			uid = ? OR ? < 0
+		ORDER BY
+			wid DESC`
+	rows, err := db.Query(query, uid, uid)
+	if err != nil {
+		log.Printf("failed to query rows: %v", err)
+
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var workflows []ut.Workflow
+	for rows.Next() {
+		var (
+			w                      ut.Workflow
+			gid                    sql.NullInt64
+			completedAt, createdAt sql.NullString
+		)
+		err = rows.Scan(&w.WID, &w.UID, &gid, &w.Name, &w.Description, &w.Status, &createdAt, &completedAt)
+		if err != nil {
+			log.Printf("failed to scan row: %v", err)
+
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		w.GID = int(gid.Int64)
+		w.CreatedAt = createdAt.String
+		w.CompletedAt = completedAt.String
+		workflows = append(workflows, w)
+	}
+
+	return workflows, nil
+}
+
+// getWorkflowIDByJID returns the workflow a job belongs to, 0 if none
+func (srv *UService) getWorkflowIDByJID(jid int64) (int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return 0, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	var wid int64
+	err = db.QueryRow(`SELECT wid FROM workflow_nodes WHERE jid = ?`, jid).Scan(&wid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("failed to query row: %w", err)
+	}
+
+	return wid, nil
+}
+
+// getRunningWorkflowIDs returns the workflows that still have work to do
+func (srv *UService) getRunningWorkflowIDs() ([]int64, error) {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return nil, fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	rows, err := db.Query(`SELECT wid FROM workflows WHERE status = 'running' ORDER BY wid ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var wids []int64
+	for rows.Next() {
+		var wid int64
+		err = rows.Scan(&wid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		wids = append(wids, wid)
+	}
+
+	return wids, nil
+}
+
+func (srv *UService) updateWorkflowNode(wid int64, name string, jid int64, status string) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE workflow_nodes
+		SET
+			jid = ?, status = ?
+		WHERE
+			wid = ? AND name = ?`, jid, status, wid, name)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
+
+func (srv *UService) updateWorkflowStatus(wid int64, status string) error {
+	db, err := srv.jdbh.GetConn()
+	if err != nil {
+		log.Printf("failed to get database connection: %v", err)
+
+		return fmt.Errorf("failed to retrieve db conn: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE workflows
+		SET
+			status = ?, completedAt = ?
+		WHERE
+			wid = ?`, status, ut.CurrentTime(), wid)
+	if err != nil {
+		log.Printf("failed to execute query: %v", err)
+
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return nil
+}
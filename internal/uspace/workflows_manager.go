@@ -0,0 +1,221 @@
+package uspace
+
+/*
+	workflows: dependency graphs of jobs
+
+	a workflow is advanced every time one of its jobs finishes:
+	  - waiting nodes whose upstream nodes have all completed (or were cached) are submitted as regular jobs,
+	    with the output references in their input resolved
+	  - waiting nodes with an upstream node that did not complete are skipped
+
+	once no node is waiting or active anymore, the workflow is
+	"completed" if every node completed, "failed" otherwise.
+
+	every node is admitted (see admitJob) as the submitter when the workflow is submitted, the inputs
+	referencing upstream outputs aside, and once more, with its inputs resolved, when it is submitted.
+	A node which is not allowed anymore is "rejected".
+*/
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+// serializes the advancement of workflows
+var workflowsMu sync.Mutex
+
+func workflowNodeActive(status string) bool {
+	return status == "submitted" || jobActive(status)
+}
+
+// submitWorkflow saves a (validated) workflow and submits its root nodes
+func (srv *UService) submitWorkflow(w ut.Workflow) (int64, error) {
+	wid, err := srv.insertWorkflow(w)
+	if err != nil {
+		return -1, err
+	}
+	log.Printf("[Workflows] workflow %d (%s) submitted with %d node(s)", wid, w.Name, len(w.Nodes))
+	srv.advanceWorkflow(wid)
+
+	return wid, nil
+}
+
+// admitWorkflow admits every node of a workflow on behalf of the caller, recording the identity each runs as
+func (srv *UService) admitWorkflow(w *ut.Workflow, ac ut.AccessClaim) error {
+	for i := range w.Nodes {
+		node := &w.Nodes[i]
+		job := node.Job
+		job.UID = w.UID
+		job.GID = w.GID
+		// upstream outputs do not exist yet, they are checked upon submission of the node
+		job.Inputs = node.StaticInputs()
+		job.Input = ""
+
+		err := srv.admitJob(&job, ac)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node.Name, err)
+		}
+		node.Job.RunAs = job.RunAs
+	}
+
+	return nil
+}
+
+// workflowJobFinished advances the workflow the job belongs to, if any
+func (srv *UService) workflowJobFinished(jid int64) {
+	wid, err := srv.getWorkflowIDByJID(jid)
+	if err != nil {
+		log.Printf("[Workflows] failed to look up the workflow of job %d: %v", jid, err)
+
+		return
+	}
+	if wid == 0 {
+		return
+	}
+	srv.advanceWorkflow(wid)
+}
+
+// resumeWorkflows advances every unfinished workflow, in case jobs finished while the service was down
+func (srv *UService) resumeWorkflows() {
+	wids, err := srv.getRunningWorkflowIDs()
+	if err != nil {
+		log.Printf("[Workflows] failed to retrieve unfinished workflows: %v", err)
+
+		return
+	}
+	for _, wid := range wids {
+		srv.advanceWorkflow(wid)
+	}
+}
+
+func (srv *UService) advanceWorkflow(wid int64) {
+	workflowsMu.Lock()
+	defer workflowsMu.Unlock()
+
+	w, err := srv.getWorkflow(wid)
+	if err != nil {
+		log.Printf("[Workflows] failed to retrieve workflow %d: %v", wid, err)
+
+		return
+	}
+	if w.Status != "running" {
+		return
+	}
+
+	status := make(map[string]string, len(w.Nodes))
+	outputs := make(map[string]string, len(w.Nodes))
+	for _, node := range w.Nodes {
+		status[node.Name] = node.Status
+		outputs[node.Name] = node.Job.Output
+	}
+
+	// a skipped (or rejected) node affects its own downstream nodes, repeat until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for i := range w.Nodes {
+			node := &w.Nodes[i]
+			if status[node.Name] != "waiting" {
+				continue
+			}
+
+			ready, blocked := true, false
+			for _, up := range node.Upstream() {
+				switch s := status[up]; {
+				case jobSucceeded(s):
+				case s == "waiting" || workflowNodeActive(s):
+					ready = false
+				default:
+					blocked = true
+				}
+			}
+
+			switch {
+			case blocked:
+				log.Printf("[Workflows] workflow %d: skipping node %s", wid, node.Name)
+				status[node.Name] = "skipped"
+				err = srv.updateWorkflowNode(wid, node.Name, 0, "skipped")
+				if err != nil {
+					log.Printf("[Workflows] failed to mark node %s as skipped: %v", node.Name, err)
+				}
+				changed = true
+			case ready:
+				status[node.Name] = srv.submitWorkflowNode(w, node, outputs)
+				changed = true
+			}
+		}
+	}
+
+	final := "completed"
+	for _, s := range status {
+		if s == "waiting" || workflowNodeActive(s) {
+			return
+		}
+		if !jobSucceeded(s) {
+			final = "failed"
+		}
+	}
+	log.Printf("[Workflows] workflow %d finished: %s", wid, final)
+	err = srv.updateWorkflowStatus(wid, final)
+	if err != nil {
+		log.Printf("[Workflows] failed to mark workflow %d as %s: %v", wid, final, err)
+	}
+}
+
+// submitWorkflowNode turns a node into a job and publishes it, returns the resulting node status
+func (srv *UService) submitWorkflowNode(w ut.Workflow, node *ut.WorkflowNode, outputs map[string]string) string {
+	job := node.Job
+	job.UID = w.UID
+	job.GID = w.GID
+	job.Inputs = node.ResolveInputs(outputs)
+	job.Input = ""
+	if job.Description == "" {
+		job.Description = fmt.Sprintf("workflow %s: %s", w.Name, node.Name)
+	}
+
+	err := srv.admitJob(&job, templateClaim(node.Job, w.UID, w.GID))
+	if err != nil {
+		log.Printf("[Workflows] workflow %d: node %s not allowed: %v", w.WID, node.Name, err)
+		status := "failed"
+		if errors.Is(err, errJobAccess) {
+			status = "rejected"
+		}
+		err = srv.updateWorkflowNode(w.WID, node.Name, 0, status)
+		if err != nil {
+			log.Printf("[Workflows] failed to mark node %s as %s: %v", node.Name, status, err)
+		}
+
+		return status
+	}
+
+	jid, err := srv.insertJob(job)
+	if err != nil {
+		log.Printf("[Workflows] failed to insert the job of node %s: %v", node.Name, err)
+		err = srv.updateWorkflowNode(w.WID, node.Name, 0, "failed")
+		if err != nil {
+			log.Printf("[Workflows] failed to mark node %s as failed: %v", node.Name, err)
+		}
+
+		return "failed"
+	}
+	job.JID = jid
+
+	err = srv.updateWorkflowNode(w.WID, node.Name, jid, "submitted")
+	if err != nil {
+		log.Printf("[Workflows] failed to record the job of node %s: %v", node.Name, err)
+	}
+
+	err = srv.jdp.PublishJob(job)
+	if err != nil {
+		log.Printf("[Workflows] failed to publish the job of node %s: %v", node.Name, err)
+		srv.rejectPendingJobs([]ut.Job{job})
+
+		return "rejected"
+	}
+	log.Printf("[Workflows] workflow %d: node %s submitted as job %d", w.WID, node.Name, jid)
+
+	return "queued"
+}
@@ -108,18 +108,49 @@ type EnvConfig struct {
 
 	// Main api (uspace) is using a manager/dispatcher/scheduler mechanism
 	// configuration here.
-	WssAddress              string
-	WssLogsPath             string
-	UspaceDispatcher        string
-	UspaceJobQueueSize      string
-	UspaceJobMaxWorkers     string
-	UspaceJobExecutor       string
-	UspaceJobMaxCPU         int64
-	UspaceJobMaxMemory      int64
-	UspaceJobMaxStorage     int64
-	UspaceJobMaxParallelism int
-	UspaceJobMaxTimeout     int64
-	UspaceJobMaxLogicSize   int64
+	WssAddress               string
+	WssLogsPath              string
+	UspaceDispatcher         string
+	UspaceJobQueueSize       string
+	UspaceJobMaxWorkers      string
+	UspaceJobExecutor        string
+	UspaceJobMaxCPU          int64
+	UspaceJobMaxMemory       int64
+	UspaceJobMaxStorage      int64
+	UspaceJobMaxParallelism  int
+	UspaceJobMaxTimeout      int64
+	UspaceJobMaxLogicSize    int64
+	UspaceJobRecoveryPolicy  string // what to do with lost running jobs on startup: requeue or fail
+	UspaceJobAgingInterval   int64  // seconds a queued job waits to gain a priority level (0 disables aging)
+	UspaceJobUserMaxRunning  int64  // per uid running jobs (0 means unlimited)
+	UspaceJobUserMaxQueued   int64  // per uid queued jobs (0 means unlimited)
+	UspaceJobGroupMaxRunning int64  // per gid running jobs (0 means unlimited)
+	UspaceJobGroupMaxQueued  int64  // per gid queued jobs (0 means unlimited)
+	UspaceJobLimits          string // per uid/gid overrides: uid:<id>:<running>:<queued>,gid:<id>:<running>:<queued>
+	UspaceJobLogsPath        string // dir where the output of every job is persisted
+	UspaceJobArrayMaxSize    int64  // most child jobs a single job array may expand into
+	UspaceJobCache           bool   // reuse the output of a previous identical job instead of running it again
+	UspaceWebhookSecret      string // hmac key of the deliveries of job webhooks without a secret of their own
+	UspaceWebhookMaxAttempts int64  // deliveries of a webhook event tried before giving up on it
+	UspaceWebhookTimeout     int64  // seconds a webhook is given to respond
+	UspaceWebhookHosts       string // hosts, ips or cidrs webhooks may target although loopback, link-local or private
+	UspaceJobIdleTimeout     int64  // seconds an interactive job may go without input nor output before it is killed
+	// sandbox executor
+	UspaceDockerHost    string // docker engine api, unix:///path/to/docker.sock or tcp://host:port
+	UspaceSandboxPath   string // dir holding the private working dir of every job
+	UspaceSandboxCgroup string // cgroup v2 dir (delegated to us) under which every job gets its own cgroup
+	// nats dispatcher
+	UspaceNatsURL        string // server the jobs are published to (and consumed from)
+	UspaceNatsEmbedded   bool   // run an in-process server listening on UspaceNatsURL
+	UspaceNatsStorePath  string // JetStream storage dir of the embedded server
+	UspaceNatsConsume    bool   // whether this instance executes jobs, or only publishes them
+	UspaceNatsAckWait    int64  // seconds a delivered job may go without a heartbeat before redelivery
+	UspaceNatsMaxDeliver int64  // times a job is delivered at most (-1 means unlimited)
+	// kafka dispatcher
+	UspaceKafkaBrokers []string // seed brokers, comma separated
+	UspaceKafkaTopic   string   // topic the jobs are published to, keyed by uid
+	UspaceKafkaGroup   string   // consumer group of the instances executing jobs
+	UspaceKafkaConsume bool     // whether this instance executes jobs, or only publishes them
 	// database storage of the jobs
 	UspaceJobsDB             string
 	UspaceJobsDBDriver       string
@@ -218,6 +249,34 @@ func LoadConfig(path string) EnvConfig {
 		UspaceJobMaxParallelism:  int(getInt64Env("J_MAX_PARALLELISM", 16)),
 		UspaceJobMaxTimeout:      getInt64Env("J_MAX_TIMEOUT", 6000),
 		UspaceJobMaxLogicSize:    getInt64Env("J_MAX_LOGIC_CHARS", 1000000),
+		UspaceJobRecoveryPolicy:  getEnv("J_RECOVERY_POLICY", "requeue"),
+		UspaceJobAgingInterval:   getInt64Env("J_AGING_INTERVAL", 60),
+		UspaceJobUserMaxRunning:  getInt64Env("J_USER_MAX_RUNNING", 0),
+		UspaceJobUserMaxQueued:   getInt64Env("J_USER_MAX_QUEUED", 0),
+		UspaceJobGroupMaxRunning: getInt64Env("J_GROUP_MAX_RUNNING", 0),
+		UspaceJobGroupMaxQueued:  getInt64Env("J_GROUP_MAX_QUEUED", 0),
+		UspaceJobLimits:          getEnv("J_LIMITS", ""),
+		UspaceJobLogsPath:        getEnv("J_LOGS_PATH", "data/logs/jobs/output/"),
+		UspaceJobArrayMaxSize:    getInt64Env("J_ARRAY_MAX_SIZE", 1000),
+		UspaceJobCache:           getBoolEnv("J_CACHE", "false"),
+		UspaceWebhookSecret:      getEnv("J_WEBHOOK_SECRET", ""),
+		UspaceWebhookMaxAttempts: getInt64Env("J_WEBHOOK_MAX_ATTEMPTS", 5),
+		UspaceWebhookTimeout:     getInt64Env("J_WEBHOOK_TIMEOUT", 10),
+		UspaceWebhookHosts:       getEnv("J_WEBHOOK_ALLOWED_HOSTS", ""),
+		UspaceJobIdleTimeout:     getInt64Env("J_INTERACTIVE_IDLE_TIMEOUT", 600),
+		UspaceDockerHost:         getEnv("J_DOCKER_HOST", "unix:///var/run/docker.sock"),
+		UspaceSandboxPath:        getEnv("J_SANDBOX_PATH", "tmp/sandbox"),
+		UspaceSandboxCgroup:      getEnv("J_SANDBOX_CGROUP", "/sys/fs/cgroup/kuspace"),
+		UspaceNatsURL:            getEnv("J_NATS_URL", "nats://127.0.0.1:4222"),
+		UspaceNatsEmbedded:       getBoolEnv("J_NATS_EMBEDDED", "false"),
+		UspaceNatsStorePath:      getEnv("J_NATS_STORE_PATH", "data/nats"),
+		UspaceNatsConsume:        getBoolEnv("J_NATS_CONSUME", "true"),
+		UspaceNatsAckWait:        getInt64Env("J_NATS_ACK_WAIT", 30),
+		UspaceNatsMaxDeliver:     getInt64Env("J_NATS_MAX_DELIVER", 5),
+		UspaceKafkaBrokers:       strings.Split(getEnv("J_KAFKA_BROKERS", "localhost:9092"), ","),
+		UspaceKafkaTopic:         getEnv("J_KAFKA_TOPIC", "kuspace-jobs"),
+		UspaceKafkaGroup:         getEnv("J_KAFKA_GROUP", "kuspace-workers"),
+		UspaceKafkaConsume:       getBoolEnv("J_KAFKA_CONSUME", "true"),
 		UspaceJobsDB:             getEnv("DB_JOBS", "jobs.db"),
 		UspaceJobsDBDriver:       getEnv("DB_JOBS_DRIVER", "duckdb"),
 		UspaceJobsDBPath:         getEnv("DB_JOBS_PATH", "data/db/uspace"),
@@ -365,6 +424,33 @@ func (cfg *EnvConfig) DeepCopy() EnvConfig {
 		UspaceJobQueueSize:          cfg.UspaceJobQueueSize,
 		UspaceJobMaxWorkers:         cfg.UspaceJobMaxWorkers,
 		UspaceJobExecutor:           cfg.UspaceJobExecutor,
+		UspaceJobRecoveryPolicy:     cfg.UspaceJobRecoveryPolicy,
+		UspaceJobAgingInterval:      cfg.UspaceJobAgingInterval,
+		UspaceJobUserMaxRunning:     cfg.UspaceJobUserMaxRunning,
+		UspaceJobUserMaxQueued:      cfg.UspaceJobUserMaxQueued,
+		UspaceJobGroupMaxRunning:    cfg.UspaceJobGroupMaxRunning,
+		UspaceJobGroupMaxQueued:     cfg.UspaceJobGroupMaxQueued,
+		UspaceJobLimits:             cfg.UspaceJobLimits,
+		UspaceJobLogsPath:           cfg.UspaceJobLogsPath,
+		UspaceJobArrayMaxSize:       cfg.UspaceJobArrayMaxSize,
+		UspaceJobCache:              cfg.UspaceJobCache,
+		UspaceWebhookSecret:         cfg.UspaceWebhookSecret,
+		UspaceWebhookMaxAttempts:    cfg.UspaceWebhookMaxAttempts,
+		UspaceWebhookTimeout:        cfg.UspaceWebhookTimeout,
+		UspaceWebhookHosts:          cfg.UspaceWebhookHosts,
+		UspaceJobIdleTimeout:        cfg.UspaceJobIdleTimeout,
+		UspaceDockerHost:            cfg.UspaceDockerHost,
+		UspaceSandboxPath:           cfg.UspaceSandboxPath,
+		UspaceSandboxCgroup:         cfg.UspaceSandboxCgroup,
+		UspaceNatsURL:               cfg.UspaceNatsURL,
+		UspaceNatsEmbedded:          cfg.UspaceNatsEmbedded,
+		UspaceNatsStorePath:         cfg.UspaceNatsStorePath,
+		UspaceNatsConsume:           cfg.UspaceNatsConsume,
+		UspaceNatsAckWait:           cfg.UspaceNatsAckWait,
+		UspaceNatsMaxDeliver:        cfg.UspaceNatsMaxDeliver,
+		UspaceKafkaTopic:            cfg.UspaceKafkaTopic,
+		UspaceKafkaGroup:            cfg.UspaceKafkaGroup,
+		UspaceKafkaConsume:          cfg.UspaceKafkaConsume,
 		WssAddress:                  cfg.WssAddress,
 		WssAddressInternal:          cfg.WssAddressInternal,
 		WssLogsPath:                 cfg.WssLogsPath,
@@ -406,6 +492,10 @@ func (cfg *EnvConfig) DeepCopy() EnvConfig {
 		copied.AllowedMethods = make([]string, len(cfg.AllowedMethods))
 		copy(copied.AllowedMethods, cfg.AllowedMethods)
 	}
+	if cfg.UspaceKafkaBrokers != nil {
+		copied.UspaceKafkaBrokers = make([]string, len(cfg.UspaceKafkaBrokers))
+		copy(copied.UspaceKafkaBrokers, cfg.UspaceKafkaBrokers)
+	}
 
 	return copied
 }
@@ -0,0 +1,191 @@
+package utils
+
+/*
+	a minimal cron expression parser, used by recurring jobs
+
+	the standard 5 fields are supported:
+
+		┌───────────── minute (0 - 59)
+		│ ┌───────────── hour (0 - 23)
+		│ │ ┌───────────── day of the month (1 - 31)
+		│ │ │ ┌───────────── month (1 - 12)
+		│ │ │ │ ┌───────────── day of the week (0 - 6, sunday is 0 or 7)
+		│ │ │ │ │
+		* * * * *
+
+	each field may be "*", a value, a range "a-b", a step "*\/n" or "a-b/n",
+	or a comma separated list of those. The @yearly, @monthly, @weekly,
+	@daily and @hourly shorthands are accepted as well.
+
+	as in the classic cron, when both day fields are restricted
+	a day matches if either of them does.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// a set of allowed values of a field, bit i stands for value i
+type cronField uint64
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// CronExpr struct, a parsed cron expression
+type CronExpr struct {
+	expr string
+
+	minute, hour, dom, month, dow cronField
+	domAny, dowAny                bool
+}
+
+// ParseCron function parses a 5 field cron expression (or a shorthand)
+func ParseCron(expr string) (CronExpr, error) {
+	c := CronExpr{expr: strings.TrimSpace(expr)}
+	spec := c.expr
+	if shorthand, exists := cronShorthands[strings.ToLower(spec)]; exists {
+		spec = shorthand
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return c, fmt.Errorf("invalid cron expression %q: expected 5 fields", expr)
+	}
+
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return c, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return c, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return c, fmt.Errorf("invalid day of month field: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return c, fmt.Errorf("invalid month field: %w", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return c, fmt.Errorf("invalid day of week field: %w", err)
+	}
+	// 7 is sunday as well
+	if c.dow.has(7) {
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+
+	if c.Next(time.Now().UTC()).IsZero() {
+		return c, fmt.Errorf("invalid cron expression %q: never matches", expr)
+	}
+
+	return c, nil
+}
+
+func parseCronField(field string, minValue, maxValue int) (cronField, error) {
+	var f cronField
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], s
+		}
+
+		lo, hi := minValue, maxValue
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			lo = v
+			// "a/n" means from a up to the maximum
+			if step == 1 {
+				hi = v
+			}
+		}
+		if lo < minValue || hi > maxValue || lo > hi {
+			return 0, fmt.Errorf("%q out of range [%d-%d]", rng, minValue, maxValue)
+		}
+
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+	if f == 0 {
+		return 0, errors.New("empty field")
+	}
+
+	return f, nil
+}
+
+// String method returns the expression as it was given
+func (c CronExpr) String() string {
+	return c.expr
+}
+
+func (c CronExpr) dayMatches(t time.Time) bool {
+	dom, dow := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next method returns the first time matching the expression strictly after the given one,
+// or the zero time if there is none within the next 5 years (e.g. "0 0 30 2 *")
+func (c CronExpr) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !c.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
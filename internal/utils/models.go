@@ -6,6 +6,15 @@
 //   - Volume: Models a physical or logical storage volume, including capacity and usage.
 //   - User, Group: Represent system users and groups, including membership and credentials.
 //   - Job: Encapsulates computational jobs with resource requirements and execution metadata.
+//   - Workflow: A dependency graph of jobs, chaining the output of a job to the input of the next.
+//   - JobArray: A job template expanded into many jobs, over a list of inputs, parameter values or a range.
+//   - Schedule: A job template that is run periodically, according to a cron expression.
+//   - JobTemplate: A named, versioned job definition that jobs are submitted out of, with overrides.
+//   - JobEvent: A transition of a job between two statuses, who caused it and why.
+//   - JobArtifact: An object the output of a completed job consists of.
+//   - Runtime: A language the code of a job may be written in, its image, skeleton and command.
+//   - JobUsage, UsageReport: The resources consumed by a job and their aggregation per user, group or app.
+//   - Webhook, WebhookDelivery: Callbacks notified of job state transitions, and the log of their deliveries.
 //   - AccessClaim: Carries user and group context for access control decisions.
 //   - Permissions, PermTriplet: Parse and represent UNIX-like permission schemes.
 //
@@ -30,12 +39,24 @@ package utils
 *
 * */
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 	"unicode"
 )
 
@@ -670,6 +691,9 @@ func GidsToString(groups []Group) string {
 type Job struct {
 	JID int64 `json:"jid,omitempty" form:"jid"`
 	UID int   `json:"uid" form:"uid"`
+	GID int   `json:"gid,omitempty" form:"gid"` // primary group of the submitter
+
+	RunAs string `json:"runAs,omitempty"` // uid:gids the job was admitted as, its inputs are read and its output written on their behalf
 
 	Parallelism int `json:"parallelism,omitempty" form:"parallelism"`
 	Priority    int `json:"priority,omitempty" form:"priority"`
@@ -685,9 +709,12 @@ type Job struct {
 	Description string  `json:"description,omitempty" form:"description"`
 	Duration    float64 `json:"duration,omitempty" form:"duration"`
 
-	Input   string `json:"input" form:"input"`
-	Output  string `json:"output" form:"output"`
-	Timeout int    `json:"timeout,omitempty" form:"timeout" ` // in minutes
+	Input   string   `json:"input" form:"input"`                // comma separated inputs
+	Inputs  []string `json:"inputs,omitempty" form:"inputs"`    // objects, globs or directory prefixes, see InputList
+	Output  string   `json:"output" form:"output"`              // an object, or a directory prefix ending with a slash, see OutputIsDir
+	Timeout int      `json:"timeout,omitempty" form:"timeout" ` // in minutes
+
+	Interactive bool `json:"interactive,omitempty" form:"interactive"` // stdin fed by its owner over the socket of the job, see J_INTERACTIVE_IDLE_TIMEOUT
 
 	Env map[string]string `json:"env,omitempty"`
 
@@ -704,6 +731,484 @@ type Job struct {
 	Completed   bool   `json:"completed,omitempty" form:"completed"`
 	CompletedAt string `json:"completedAt,omitempty" form:"completedAt"`
 	CreatedAt   string `json:"createdAt,omitempty" form:"createdAt"`
+
+	Retry          *RetryPolicy `json:"retry,omitempty"`
+	Attempts       int          `json:"attempts,omitempty"`       // finished executions so far
+	AttemptHistory []JobAttempt `json:"attemptHistory,omitempty"` // outcome of each execution
+
+	Artifacts []JobArtifact `json:"artifacts,omitempty"` // the objects its output consists of, once completed
+	Log       string        `json:"log,omitempty"`       // the object its log is stored as (volume/name), once over
+
+	NoCache     bool   `json:"noCache,omitempty" form:"noCache"` // always run, even if an identical job completed before
+	Fingerprint string `json:"fingerprint,omitempty"`            // digest of what the job computes, see J_CACHE
+	CachedFrom  int64  `json:"cachedFrom,omitempty"`             // the job whose output was reused, if "cached"
+
+	TemplateID      int64 `json:"templateId,omitempty"`      // the template the job was submitted out of, if any
+	TemplateVersion int   `json:"templateVersion,omitempty"` // the version of that template
+
+	Usage *JobUsage `json:"usage,omitempty"` // resources consumed, over all of its attempts
+
+	Webhooks []Webhook `json:"webhooks,omitempty"` // callbacks notified of the state transitions of the job
+}
+
+// InputList method returns the inputs of the job, as listed in Inputs or else comma separated in Input.
+/*
+each input is <volume>/<path> (the default volume if none given), either:
+	- an object: volume/data/a.csv
+	- a glob over object names: volume/data/*.csv (path.Match syntax)
+	- a directory prefix, ending with a slash: volume/data/
+*/
+func (j *Job) InputList() []string {
+	list := j.Inputs
+	if len(list) == 0 {
+		list = strings.Split(j.Input, ",")
+	}
+
+	var inputs []string
+	for _, input := range list {
+		input = strings.TrimSpace(input)
+		if input != "" {
+			inputs = append(inputs, input)
+		}
+	}
+
+	return inputs
+}
+
+// OutputIsDir method tells if the output of the job is a directory prefix (volume/plots/) rather than an object:
+// every file the job writes in its output dir is then uploaded under it, at the same relative path
+func (j *Job) OutputIsDir() bool {
+	return strings.HasSuffix(strings.TrimSpace(j.Output), "/")
+}
+
+// JobArtifact struct, an object the output of a completed job consists of, owned by the owner of the job
+type JobArtifact struct {
+	Volume string `json:"volume"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+}
+
+// failure classes of a job execution, as reported by the executors
+const (
+	FailureExecutor = "executor" // the executor could not launch the job
+	FailureImage    = "image"    // the image could not be pulled
+	FailureEvicted  = "evicted"  // the job was evicted from its node
+	FailureOOM      = "oom"      // the job ran out of memory
+	FailureTimeout  = "timeout"  // the job exceeded its timeout
+	FailureInput    = "input"    // the input of the job is missing
+	FailureError    = "error"    // the job itself exited with an error
+)
+
+// failure classes retried when a policy does not specify any, the transient ones
+var defaultRetryOn = []string{FailureExecutor, FailureImage, FailureEvicted}
+
+// RetryPolicy struct, describes if and when a failed job is resubmitted
+type RetryPolicy struct {
+	MaxAttempts int      `json:"maxAttempts"`          // total executions, including the first one
+	Backoff     int      `json:"backoff,omitempty"`    // seconds before the first retry, doubled on every retry
+	MaxBackoff  int      `json:"maxBackoff,omitempty"` // upper bound of the backoff in seconds, an hour by default
+	On          []string `json:"on,omitempty"`         // failure classes to retry, transient ones by default
+}
+
+// Retries method tells if a failure of the given class should be retried after the given attempt
+func (p *RetryPolicy) Retries(class string, attempt int) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	on := p.On
+	if len(on) == 0 {
+		on = defaultRetryOn
+	}
+	for _, c := range on {
+		if c == class || c == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delay method returns how long to wait before retrying the given (failed) attempt
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	delay := time.Duration(p.Backoff) * time.Second
+	maxDelay := time.Hour
+	if p.MaxBackoff > 0 {
+		maxDelay = time.Duration(p.MaxBackoff) * time.Second
+	}
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
+// JobAttempt struct, the outcome of a single execution of a job
+type JobAttempt struct {
+	Attempt      int     `json:"attempt"`
+	Status       string  `json:"status"`
+	FailureClass string  `json:"failureClass,omitempty"`
+	Reason       string  `json:"reason,omitempty"`
+	Duration     float64 `json:"duration,omitempty"`
+	FinishedAt   string  `json:"finishedAt,omitempty"`
+}
+
+// actors behind a job state transition
+const (
+	ActorUser      = "user"      // the owner of the job, or an admin
+	ActorScheduler = "scheduler" // the job manager or a dispatcher
+	ActorExecutor  = "executor"  // whatever runs the job
+)
+
+// JobEvent struct, a transition of a job from a status to another
+type JobEvent struct {
+	EID    int64  `json:"eid"`
+	JID    int64  `json:"jid"`
+	From   string `json:"from"` // empty for the submission of the job
+	To     string `json:"to"`
+	Actor  string `json:"actor"`
+	Reason string `json:"reason,omitempty"`
+	At     string `json:"at"`
+}
+
+// jobTransitions lists the statuses a job may move to out of each status,
+// final statuses (completed, cached, failed, timeout, canceled, rejected) lead nowhere
+var jobTransitions = map[string][]string{
+	"":         {"pending"},
+	"pending":  {"queued", "cached", "canceled", "rejected", "failed"},
+	"queued":   {"running", "cached", "retrying", "failed", "timeout", "canceled"},
+	"running":  {"completed", "failed", "timeout", "canceled", "retrying", "queued"},
+	"retrying": {"queued", "canceled"},
+}
+
+// JobTransitionAllowed tells if a job may move from a status to another, staying put is always allowed
+func JobTransitionAllowed(from, to string) bool {
+	return from == to || slices.Contains(jobTransitions[from], to)
+}
+
+// JobProblem struct, something a dry-run found that would keep a job from running
+type JobProblem struct {
+	Field   string `json:"field"` // the job field at fault, "job" if none in particular
+	Message string `json:"message"`
+}
+
+// Runtime struct, a language the code of a job may be written in, as declared to the executors
+/*
+a job names a runtime (or one of its aliases) in its logic as name:version, the version being the tag of the image.
+The code (Job.LogicBody) is written to script.<extension>, wrapped in the skeleton if there is one, and run by the command.
+
+the skeleton is a text/template over a RuntimeScript:
+	{{.Headers}}	the headers of the code (Job.LogicHeaders), e.g. imports
+	{{.Logic}}		the code itself, expected to define run(data) returning the output
+	{{.Inputs}}		the paths of the inputs, quoted and comma separated, to be put in a list literal
+	{{.Output}}		the path the output is to be written to, a file named output for a directory output
+without a skeleton the code is run as is, it finds its inputs in the INPUT_PATHS env var.
+Either way, whatever else it writes in its output dir (the OUTPUT_DIR env var) is kept for a directory output.
+
+the command is a shell command (sh -c), a text/template as well, where {{.Script}} is the path of the script,
+e.g. "gcc {{.Script}} -o program && ./program"
+*/
+type Runtime struct {
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases,omitempty"`
+	Image     string   `json:"image"` // without a tag
+	Extension string   `json:"extension"`
+	Skeleton  string   `json:"skeleton,omitempty"`
+	Command   string   `json:"command"`
+	Builtin   bool     `json:"builtin,omitempty"` // shipped with uspace rather than added by an admin
+	CreatedAt string   `json:"createdAt,omitempty"`
+}
+
+// RuntimeScript struct, what the skeleton and the command of a runtime are rendered with
+type RuntimeScript struct {
+	Headers string
+	Logic   string
+	Inputs  string // see QuotedPaths
+	Output  string
+	Script  string
+}
+
+var runtimeNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]*$`)
+
+// Validate method normalizes the runtime and checks that it can be rendered
+func (r *Runtime) Validate() error {
+	r.Name = strings.ToLower(strings.TrimSpace(r.Name))
+	for i, alias := range r.Aliases {
+		r.Aliases[i] = strings.ToLower(strings.TrimSpace(alias))
+	}
+	for _, name := range r.Names() {
+		if !runtimeNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid runtime name %q", name)
+		}
+	}
+	r.Image = strings.TrimSpace(r.Image)
+	if r.Image == "" || strings.Contains(path.Base(r.Image), ":") {
+		return errors.New("a runtime must name an image without a tag, the version a job asks for is the tag")
+	}
+	r.Extension = strings.TrimPrefix(strings.TrimSpace(r.Extension), ".")
+	if r.Extension == "" || strings.ContainsAny(r.Extension, "/ ") {
+		return fmt.Errorf("invalid runtime extension %q", r.Extension)
+	}
+	if strings.TrimSpace(r.Command) == "" {
+		return errors.New("a runtime must have a command")
+	}
+	_, _, err := r.Render(RuntimeScript{})
+
+	return err
+}
+
+// Names method returns the name of the runtime followed by its aliases
+func (r *Runtime) Names() []string {
+	return append([]string{r.Name}, r.Aliases...)
+}
+
+// ScriptName method returns the name of the file the code of a job is written to
+func (r *Runtime) ScriptName() string {
+	return "script." + r.Extension
+}
+
+// Render method returns the script (the code wrapped in the skeleton, if any) and the command running it
+func (r *Runtime) Render(script RuntimeScript) (string, string, error) {
+	code := script.Logic
+	if r.Skeleton != "" {
+		var err error
+		code, err = renderTemplate("skeleton", r.Skeleton, script)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	command, err := renderTemplate("command", r.Command, script)
+	if err != nil {
+		return "", "", err
+	}
+
+	return code, command, nil
+}
+
+func renderTemplate(name, text string, data any) (string, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", name, err)
+	}
+	var b strings.Builder
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render the %s: %w", name, err)
+	}
+
+	return b.String(), nil
+}
+
+// QuotedPaths function returns the paths double quoted and comma separated, see RuntimeScript.Inputs
+func QuotedPaths(paths []string) string {
+	quoted := make([]string, 0, len(paths))
+	for _, p := range paths {
+		quoted = append(quoted, strconv.Quote(p))
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// JobUsage struct, the resources a job consumed as measured by its executor
+type JobUsage struct {
+	CPUSeconds   float64 `json:"cpuSeconds"`
+	PeakMemory   int64   `json:"peakMemory"` // in bytes
+	BytesRead    int64   `json:"bytesRead"`
+	BytesWritten int64   `json:"bytesWritten"`
+}
+
+// UsageReport struct, the usage of the jobs accounted to a user, a group or an app over a time window
+type UsageReport struct {
+	Key          string  `json:"key"` // the uid, gid or app
+	Jobs         int     `json:"jobs"`
+	Duration     float64 `json:"duration"` // in seconds
+	CPUSeconds   float64 `json:"cpuSeconds"`
+	PeakMemory   int64   `json:"peakMemory"` // highest peak of any of the jobs
+	BytesRead    int64   `json:"bytesRead"`
+	BytesWritten int64   `json:"bytesWritten"`
+}
+
+// job state transitions webhooks are notified of
+const (
+	WebhookQueued    = "queued"
+	WebhookRunning   = "running"
+	WebhookCompleted = "completed"
+	WebhookFailed    = "failed"
+	WebhookCanceled  = "canceled"
+)
+
+// WebhookEvents are the events a webhook may subscribe to
+var WebhookEvents = []string{WebhookQueued, WebhookRunning, WebhookCompleted, WebhookFailed, WebhookCanceled}
+
+// WebhookEvent function returns the event a job status is notified as, if any,
+// a cached job counts as completed and a timed out one as failed
+func WebhookEvent(status string) (string, bool) {
+	switch status {
+	case "queued", "running", "completed", "failed", "canceled":
+		return status, true
+	case "cached":
+		return WebhookCompleted, true
+	case "timeout":
+		return WebhookFailed, true
+	default:
+		return "", false
+	}
+}
+
+// Webhook struct, a callback url notified of job state transitions with a signed JSON payload (see WebhookPayload)
+/*
+a webhook either belongs to a single job (Job.Webhooks) or is a subscription of a user,
+notified of the transitions of every job of that user.
+
+every delivery is signed with HMAC-SHA256 over its body, using the secret of the webhook
+(or the service wide J_WEBHOOK_SECRET for job webhooks without one), see SignWebhook.
+*/
+type Webhook struct {
+	WID       int64    `json:"wid,omitempty"`
+	UID       int      `json:"uid,omitempty"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events,omitempty"` // all of WebhookEvents if empty
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"createdAt,omitempty"`
+}
+
+// Validate method checks that the url is an absolute http(s) one and that the events are known,
+// the url may not name a loopback, link-local or private destination unless its host is one of allowedHosts
+// (see WebhookHostAllowed). A name resolving to such an address is only caught when delivered.
+func (w *Webhook) Validate(allowedHosts ...string) error {
+	w.URL = strings.TrimSpace(w.URL)
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url %q must be an absolute http(s) url", w.URL)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if !WebhookHostAllowed(host, allowedHosts) {
+		ip := net.ParseIP(host)
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && !PublicIP(ip)) {
+			return fmt.Errorf("webhook url %q must not point to a loopback, link-local or private address", w.URL)
+		}
+	}
+	for i, event := range w.Events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if !slices.Contains(WebhookEvents, event) {
+			return fmt.Errorf("unknown webhook event %q, must be one of %s", event, strings.Join(WebhookEvents, ", "))
+		}
+		w.Events[i] = event
+	}
+
+	return nil
+}
+
+// WebhookHostAllowed function tells if a host is one of the allowed hosts, ips or cidrs
+func WebhookHostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+		} else if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+		} else if entry == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PublicIP function tells if an ip is a public unicast address, that is neither loopback, link-local,
+// private (including the shared 100.64.0.0/10), unspecified, broadcast nor multicast
+func PublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4[0] != 0 && (ip4[0] != 100 || ip4[1]&0xc0 != 64) && !ip4.Equal(net.IPv4bcast)
+	}
+
+	return true
+}
+
+// Wants method tells if the webhook subscribes to the given event
+func (w *Webhook) Wants(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// WebhookPayload struct, the JSON body of a webhook delivery
+type WebhookPayload struct {
+	Event       string  `json:"event"`
+	Status      string  `json:"status"` // the actual status of the job, e.g. "timeout" for a failed event
+	JID         int64   `json:"jid"`
+	UID         int     `json:"uid"`
+	GID         int     `json:"gid,omitempty"`
+	Attempt     int     `json:"attempt"` // the execution the transition belongs to, starting at 1
+	Description string  `json:"description,omitempty"`
+	Output      string  `json:"output,omitempty"`
+	Duration    float64 `json:"duration,omitempty"` // in seconds
+	Timestamp   string  `json:"timestamp"`
+}
+
+// WebhookDelivery struct, an entry of the delivery log, a payload sent (or being sent) to a webhook
+type WebhookDelivery struct {
+	DID          int64  `json:"did"`
+	WID          int64  `json:"wid,omitempty"` // 0 for a webhook of the job itself
+	JID          int64  `json:"jid"`
+	Attempt      int    `json:"attempt"`
+	Event        string `json:"event"`
+	URL          string `json:"url"`
+	Payload      string `json:"payload"`
+	Status       string `json:"status"` // pending, delivered or failed
+	Tries        int    `json:"tries"`
+	ResponseCode int    `json:"responseCode,omitempty"`
+	Error        string `json:"error,omitempty"`
+	CreatedAt    string `json:"createdAt,omitempty"`
+	UpdatedAt    string `json:"updatedAt,omitempty"`
+}
+
+// SignWebhook function returns the signature header value of a webhook body: sha256=<hex hmac of the body>
+func SignWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignJobAttach function returns the token letting a user attach to the stdin of an interactive job
+// until it expires: <uid>.<unix expiry>.<hex hmac of the job, the uid and the expiry>
+func SignJobAttach(secret []byte, jid int64, uid int, expires time.Time) string {
+	claim := fmt.Sprintf("%d.%d", uid, expires.Unix())
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(fmt.Sprintf("%d.%s", jid, claim)))
+
+	return claim + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyJobAttach function tells if a token was signed (see SignJobAttach) for the job and has not expired,
+// returns the uid it was issued to
+func VerifyJobAttach(secret []byte, jid int64, token string, now time.Time) (int, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return 0, false
+	}
+	expected := SignJobAttach(secret, jid, uid, time.Unix(expiry, 0))
+
+	return uid, hmac.Equal([]byte(token), []byte(expected))
 }
 
 // ValidateForm method sanitizes and checks if the given Job object is within limits
@@ -737,7 +1242,8 @@ func (j *Job) ValidateForm(maxCPU, maxMem, maxStorage, maxParal, maxTimeout, max
 		return errors.New("headers must contain valid characters")
 	}
 
-	if j.Input == "" {
+	inputs := j.InputList()
+	if len(inputs) == 0 {
 		return errors.New("must provide input")
 	}
 
@@ -745,7 +1251,12 @@ func (j *Job) ValidateForm(maxCPU, maxMem, maxStorage, maxParal, maxTimeout, max
 		return errors.New("must provide output")
 	}
 	// Validate paths
-	if !IsValidPath(j.Input) || !IsValidPath(j.Output) {
+	for _, input := range inputs {
+		if !IsValidPattern(input) {
+			return errors.New("input/output paths contain invalid characters")
+		}
+	}
+	if !IsValidPath(j.Output) {
 		return errors.New("input/output paths contain invalid characters")
 	}
 
@@ -753,6 +1264,13 @@ func (j *Job) ValidateForm(maxCPU, maxMem, maxStorage, maxParal, maxTimeout, max
 		return errors.New("description must be less or equal than 150 characters")
 	}
 
+	for i := range j.Webhooks {
+		err := j.Webhooks[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+
 	if j.Parallelism > int(maxParal) || j.Parallelism < 0 {
 		return fmt.Errorf("parallelism must be between 0 and %d", maxParal)
 	}
@@ -795,10 +1313,12 @@ func (j *Job) ValidateForm(maxCPU, maxMem, maxStorage, maxParal, maxTimeout, max
 	}
 
 	// sanitize
-	j.Input = strings.TrimSpace(j.Input)
+	j.Inputs = inputs
+	j.Input = strings.Join(inputs, ",")
 	j.Output = strings.TrimSpace(j.Output)
 
-	if p := strings.Split(j.Output, "/"); len(p) != 2 || p[1] == "" {
+	dir := j.OutputIsDir() && strings.Count(j.Output, "/") > 1 // a directory, volume/dir/, is kept as is
+	if p := strings.Split(j.Output, "/"); !dir && (len(p) != 2 || p[1] == "") {
 		r, err := GenerateRandomString(16)
 		if err != nil {
 			return errors.New("failed to generate output name, must provide an output object name")
@@ -822,44 +1342,611 @@ func (j *Job) ValidateForm(maxCPU, maxMem, maxStorage, maxParal, maxTimeout, max
 // in a specific order. This is useful for scanning database rows directly
 // into the struct fields or for generic update operations.
 func (j *Job) PtrFields() []any {
-	return []any{&j.JID, &j.UID, &j.Description, &j.Duration, &j.Input,
+	return []any{&j.JID, &j.UID, &j.GID, &j.Description, &j.Duration, &j.Input,
 		&j.InputFormat, &j.Output, &j.OutputFormat, &j.Logic, &j.LogicBody,
 		&j.LogicHeaders, &j.Params, &j.Status, &j.Completed, &j.CompletedAt,
 		&j.CreatedAt, &j.Parallelism, &j.Priority, &j.MemoryRequest, &j.CPURequest,
-		&j.MemoryLimit, &j.CPULimit, &j.EphimeralStorageRequest, &j.EphimeralStorageLimit}
+		&j.MemoryLimit, &j.CPULimit, &j.EphimeralStorageRequest, &j.EphimeralStorageLimit,
+		&j.Retry, &j.Attempts}
 }
 
 // Fields returns a slice containing the values of the Job struct fields,
 // in a specific order suitable for database operations or serialization.
 // The returned slice includes all fields, including IDs and metadata.
 func (j *Job) Fields() []any {
-	return []any{j.JID, j.UID, j.Description, j.Duration, j.Input,
+	return []any{j.JID, j.UID, j.GID, j.Description, j.Duration, j.Input,
 		j.InputFormat, j.Output, j.OutputFormat, j.Logic, j.LogicBody,
 		j.LogicHeaders, j.Params, j.Status, j.Completed, j.CompletedAt,
 		j.CreatedAt, j.Parallelism, j.Priority, j.MemoryRequest, j.CPURequest,
-		j.MemoryLimit, j.CPULimit, j.EphimeralStorageRequest, j.EphimeralStorageLimit}
+		j.MemoryLimit, j.CPULimit, j.EphimeralStorageRequest, j.EphimeralStorageLimit,
+		j.Retry, j.Attempts}
 }
 
 // PtrFieldsNoID returns a slice of pointers to the Job struct fields,
 // excluding the primary ID (Rid). This is typically used for insert or update
 // operations where the ID is auto-generated or not required.
 func (j *Job) PtrFieldsNoID() []any {
-	return []any{&j.UID, &j.Description, &j.Duration, &j.Input,
+	return []any{&j.UID, &j.GID, &j.Description, &j.Duration, &j.Input,
 		&j.InputFormat, &j.Output, &j.OutputFormat, &j.Logic, &j.LogicBody,
 		&j.LogicHeaders, &j.Params, &j.Status, &j.Completed, &j.CompletedAt,
 		&j.CreatedAt, &j.Parallelism, &j.Priority, &j.MemoryRequest, &j.CPURequest,
-		&j.MemoryLimit, &j.CPULimit, &j.EphimeralStorageRequest, &j.EphimeralStorageLimit}
+		&j.MemoryLimit, &j.CPULimit, &j.EphimeralStorageRequest, &j.EphimeralStorageLimit,
+		&j.Retry, &j.Attempts}
 }
 
 // FieldsNoID returns a slice containing the values of the Job struct fields,
 // excluding the primary ID (Rid). This is typically used for insert or update
 // operations where the ID is auto-generated or not required.
 func (j *Job) FieldsNoID() []any {
-	return []any{j.UID, j.Description, j.Duration, j.Input,
+	return []any{j.UID, j.GID, j.Description, j.Duration, j.Input,
 		j.InputFormat, j.Output, j.OutputFormat, j.Logic, j.LogicBody,
 		j.LogicHeaders, j.Params, j.Status, j.Completed, j.CompletedAt,
 		j.CreatedAt, j.Parallelism, j.Priority, j.MemoryRequest, j.CPURequest,
-		j.MemoryLimit, j.CPULimit, j.EphimeralStorageRequest, j.EphimeralStorageLimit}
+		j.MemoryLimit, j.CPULimit, j.EphimeralStorageRequest, j.EphimeralStorageLimit,
+		j.Retry, j.Attempts}
+}
+
+// Workflow struct defines a dependency graph (DAG) of jobs
+/*
+an edge A -> B means "start B after A succeeds",
+a node may use the output of an upstream node as its input by referencing it:
+
+	{"name": "aggregate", "job": {"input": "${clean.output}", ...}}
+
+a reference implies a dependency on the referenced node.
+*/
+type Workflow struct {
+	WID int64 `json:"wid,omitempty"`
+	UID int   `json:"uid"`
+	GID int   `json:"gid,omitempty"`
+
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	Nodes []WorkflowNode `json:"nodes"`
+
+	Status      string `json:"status,omitempty"` // running, completed, failed
+	CreatedAt   string `json:"createdAt,omitempty"`
+	CompletedAt string `json:"completedAt,omitempty"`
+}
+
+// WorkflowNode struct, a single job of a workflow
+type WorkflowNode struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	Job       Job      `json:"job"`
+
+	JID    int64  `json:"jid,omitempty"`    // set once the node is submitted
+	Status string `json:"status,omitempty"` // waiting, skipped or the status of its job
+}
+
+var (
+	workflowNodeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	workflowRefPattern      = regexp.MustCompile(`\$\{([A-Za-z0-9_-]+)\.output\}`)
+)
+
+// References returns the names of the nodes whose output is used as this node's input
+func (n *WorkflowNode) References() []string {
+	var refs []string
+	for _, match := range workflowRefPattern.FindAllStringSubmatch(strings.Join(n.Job.InputList(), ","), -1) {
+		refs = append(refs, match[1])
+	}
+
+	return refs
+}
+
+// Upstream returns the names of all the nodes this node waits for, explicit or referenced
+func (n *WorkflowNode) Upstream() []string {
+	seen := make(map[string]bool)
+	var upstream []string
+	for _, name := range append(append([]string{}, n.DependsOn...), n.References()...) {
+		if !seen[name] {
+			seen[name] = true
+			upstream = append(upstream, name)
+		}
+	}
+
+	return upstream
+}
+
+// StaticInputs returns the node's inputs which reference no other node
+func (n *WorkflowNode) StaticInputs() []string {
+	var inputs []string
+	for _, input := range n.Job.InputList() {
+		if !workflowRefPattern.MatchString(input) {
+			inputs = append(inputs, input)
+		}
+	}
+
+	return inputs
+}
+
+// ResolveInputs returns the node's inputs, each with its output references replaced by the given outputs
+func (n *WorkflowNode) ResolveInputs(outputs map[string]string) []string {
+	inputs := n.Job.InputList()
+	for i, input := range inputs {
+		inputs[i] = workflowRefPattern.ReplaceAllStringFunc(input, func(ref string) string {
+			name := workflowRefPattern.FindStringSubmatch(ref)[1]
+
+			return outputs[name]
+		})
+	}
+
+	return inputs
+}
+
+// Validate method checks that the workflow is a well formed DAG
+func (w *Workflow) Validate() error {
+	if len(w.Nodes) == 0 {
+		return errors.New("workflow must have at least one node")
+	}
+
+	index := make(map[string]int, len(w.Nodes))
+	for i, node := range w.Nodes {
+		if !workflowNodeNamePattern.MatchString(node.Name) {
+			return fmt.Errorf("invalid node name %q: only letters, digits, '_' and '-' are allowed", node.Name)
+		}
+		if _, exists := index[node.Name]; exists {
+			return fmt.Errorf("duplicate node name %q", node.Name)
+		}
+		index[node.Name] = i
+	}
+
+	// Kahn's algorithm, whatever is left unvisited is part of a cycle
+	inDegree := make([]int, len(w.Nodes))
+	downstream := make([][]int, len(w.Nodes))
+	for i, node := range w.Nodes {
+		for _, name := range node.Upstream() {
+			j, exists := index[name]
+			if !exists {
+				return fmt.Errorf("node %q depends on unknown node %q", node.Name, name)
+			}
+			if j == i {
+				return fmt.Errorf("node %q depends on itself", node.Name)
+			}
+			inDegree[i]++
+			downstream[j] = append(downstream[j], i)
+		}
+	}
+
+	ready := make([]int, 0, len(w.Nodes))
+	for i, d := range inDegree {
+		if d == 0 {
+			ready = append(ready, i)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, j := range downstream[i] {
+			inDegree[j]--
+			if inDegree[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if visited != len(w.Nodes) {
+		return errors.New("workflow dependencies contain a cycle")
+	}
+
+	return nil
+}
+
+// JobArray struct, a single submission expanded into many child jobs out of a job template
+/*
+the children are generated out of exactly one of:
+	- inputs: a child per input
+	- params: a child per combination (cartesian product) of the parameter values
+	- range:  a child per index of the range
+
+the output of the template (as well as its inputs, description, params and env values)
+is templated per child, the following placeholders are replaced:
+
+	{index}   the index of the child, its position (from 0) or its value in the range
+	{input}   the input of the child (inputs only)
+	{name}    the base name of the input of the child, without its extension (inputs only)
+	{<param>} the value of a parameter for the child (params only)
+
+	{"inputs": ["bucket/a.csv", "bucket/b.csv"], "job": {"output": "bucket/out/{name}.csv", ...}}
+
+parameters also reach the children as environment variables (and "<param>=<value>" params),
+the index as ARRAY_INDEX.
+*/
+type JobArray struct {
+	AID int64 `json:"aid,omitempty"`
+	UID int   `json:"uid"`
+	GID int   `json:"gid,omitempty"`
+
+	Name string `json:"name,omitempty"`
+	Job  Job    `json:"job"` // the template of every child
+
+	Inputs []string            `json:"inputs,omitempty"`
+	Params map[string][]string `json:"params,omitempty"`
+	Range  *ArrayRange         `json:"range,omitempty"`
+
+	Size        int    `json:"size,omitempty"`   // amount of children
+	Status      string `json:"status,omitempty"` // running, completed, failed, canceled or rejected
+	CreatedAt   string `json:"createdAt,omitempty"`
+	CompletedAt string `json:"completedAt,omitempty"`
+
+	Children []JobArrayChild `json:"children,omitempty"`
+}
+
+// ArrayRange struct, the indexes from start to end (inclusive) by step
+type ArrayRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	Step  int `json:"step,omitempty"` // 1 by default
+}
+
+// JobArrayChild struct, a single job of an array
+type JobArrayChild struct {
+	Index  int    `json:"index"`
+	JID    int64  `json:"jid"`
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+}
+
+// JobArraySummary struct, the aggregate state of an array
+type JobArraySummary struct {
+	AID      int64          `json:"aid"`
+	Name     string         `json:"name,omitempty"`
+	Status   string         `json:"status"`
+	Size     int            `json:"size"`
+	Finished int            `json:"finished"` // children that reached a final status
+	Statuses map[string]int `json:"statuses"` // amount of children per status
+}
+
+var (
+	arrayParamPattern       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	arrayPlaceholderPattern = regexp.MustCompile(`\{[A-Za-z_][A-Za-z0-9_]*\}`)
+)
+
+// Validate method checks that the array expands into at least one and at most maxSize children
+func (a *JobArray) Validate(maxSize int) error {
+	sources := 0
+	if len(a.Inputs) > 0 {
+		sources++
+	}
+	if len(a.Params) > 0 {
+		sources++
+	}
+	if a.Range != nil {
+		sources++
+	}
+	if sources != 1 {
+		return errors.New("job array must provide exactly one of inputs, params or range")
+	}
+
+	for name, values := range a.Params {
+		if !arrayParamPattern.MatchString(name) {
+			return fmt.Errorf("invalid parameter name %q: only letters, digits and '_' are allowed", name)
+		}
+		if name == "index" || name == "input" || name == "name" {
+			return fmt.Errorf("parameter name %q is reserved", name)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("parameter %q has no values", name)
+		}
+	}
+	if a.Range != nil {
+		if a.Range.Step == 0 {
+			a.Range.Step = 1
+		}
+		if a.Range.Step < 0 || a.Range.End < a.Range.Start {
+			return errors.New("range must go from start up to end by a positive step")
+		}
+	}
+
+	size := a.size()
+	if size > maxSize {
+		return fmt.Errorf("job array expands into %d jobs, at most %d allowed", size, maxSize)
+	}
+	if size == 0 {
+		return errors.New("job array expands into no job")
+	}
+
+	return nil
+}
+
+// size returns the amount of children the (validated) array expands into
+func (a *JobArray) size() int {
+	switch {
+	case len(a.Inputs) > 0:
+		return len(a.Inputs)
+	case len(a.Params) > 0:
+		size := 1
+		for _, values := range a.Params {
+			size *= len(values)
+			if size > math.MaxInt32 {
+				return math.MaxInt32
+			}
+		}
+
+		return size
+	case a.Range != nil:
+		return (a.Range.End-a.Range.Start)/a.Range.Step + 1
+	default:
+		return 0
+	}
+}
+
+// Index method returns the index of the i-th child, its value in the range if any
+func (a *JobArray) Index(i int) int {
+	if a.Range != nil {
+		return a.Range.Start + i*a.Range.Step
+	}
+
+	return i
+}
+
+// Expand method generates the children of a (validated) array, in order,
+// every child must end up with its own output
+func (a *JobArray) Expand() ([]Job, error) {
+	names := make([]string, 0, len(a.Params))
+	for name := range a.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	size := a.size()
+	jobs := make([]Job, 0, size)
+	outputs := make(map[string]int, size)
+	for i := range size {
+		values := map[string]string{"index": strconv.Itoa(a.Index(i))}
+		switch {
+		case len(a.Inputs) > 0:
+			input := strings.TrimSpace(a.Inputs[i])
+			base := path.Base(input)
+			values["input"] = input
+			values["name"] = strings.TrimSuffix(base, path.Ext(base))
+		case len(a.Params) > 0:
+			// the last parameter varies the fastest
+			rest := i
+			for k := len(names) - 1; k >= 0; k-- {
+				v := a.Params[names[k]]
+				values[names[k]] = v[rest%len(v)]
+				rest /= len(v)
+			}
+		}
+
+		job := a.childJob(values, names)
+		for _, input := range job.InputList() {
+			if !IsValidPattern(input) {
+				return nil, fmt.Errorf("job %s: input %q contains invalid characters", values["index"], input)
+			}
+		}
+		if job.Output == "" || !IsValidPath(job.Output) {
+			return nil, fmt.Errorf("job %s: output %q contains invalid characters", values["index"], job.Output)
+		}
+		if prev, exists := outputs[job.Output]; exists {
+			return nil, fmt.Errorf("jobs %d and %d would both write %s, template the output (e.g. with {index})",
+				a.Index(prev), a.Index(i), job.Output)
+		}
+		outputs[job.Output] = i
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// childJob templates a copy of the array's job with the values of a child
+func (a *JobArray) childJob(values map[string]string, params []string) Job {
+	replace := func(s string) string {
+		return arrayPlaceholderPattern.ReplaceAllStringFunc(s, func(p string) string {
+			if v, ok := values[p[1:len(p)-1]]; ok {
+				return v
+			}
+
+			return p
+		})
+	}
+
+	job := a.Job
+	job.UID, job.GID = a.UID, a.GID
+	job.Output = replace(strings.TrimSpace(job.Output))
+	if input, ok := values["input"]; ok {
+		job.Inputs = []string{input}
+	} else {
+		job.Inputs = nil
+		for _, input := range a.Job.InputList() {
+			job.Inputs = append(job.Inputs, replace(input))
+		}
+	}
+	job.Input = strings.Join(job.Inputs, ",")
+	job.Description = replace(job.Description)
+	if job.Description == "" {
+		job.Description = fmt.Sprintf("array %s[%s]", a.Name, values["index"])
+	}
+
+	job.Params = nil
+	for _, p := range a.Job.Params {
+		job.Params = append(job.Params, replace(p))
+	}
+	job.Env = make(map[string]string, len(a.Job.Env)+len(params)+1)
+	for k, v := range a.Job.Env {
+		job.Env[k] = replace(v)
+	}
+	for _, name := range params {
+		job.Params = append(job.Params, name+"="+values[name])
+		job.Env[name] = values[name]
+	}
+	job.Env["ARRAY_INDEX"] = values["index"]
+
+	return job
+}
+
+// Schedule struct, a recurring job: the job template is submitted every time the cron expression matches
+type Schedule struct {
+	SID int64 `json:"sid"`
+	UID int   `json:"uid"`
+	GID int   `json:"gid,omitempty"`
+
+	Name    string `json:"name"`
+	Cron    string `json:"cron"`
+	Job     Job    `json:"job"`
+	Enabled bool   `json:"enabled"`
+
+	LastRun   string `json:"lastRun,omitempty"`
+	NextRun   string `json:"nextRun,omitempty"`
+	CreatedAt string `json:"createdAt,omitempty"`
+
+	Runs []ScheduleRun `json:"runs,omitempty"`
+}
+
+// ScheduleRun struct, a job produced by a schedule
+type ScheduleRun struct {
+	JID         int64  `json:"jid"`
+	ScheduledAt string `json:"scheduledAt"`
+	Status      string `json:"status"`
+}
+
+// Validate method checks the schedule's name and cron expression
+func (s *Schedule) Validate() error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("schedule must have a name")
+	}
+	_, err := ParseCron(s.Cron)
+
+	return err
+}
+
+// JobTemplate struct, a named job definition that jobs are submitted out of
+/*
+templates are versioned: every edit saves a new version and a job records the
+version it was submitted out of, so editing a template never changes what an older run did.
+
+a template is owned by a user, and usable by the members of its group if shared.
+*/
+type JobTemplate struct {
+	TID     int64 `json:"tid,omitempty"`
+	Version int   `json:"version,omitempty"`
+	UID     int   `json:"uid"`
+	GID     int   `json:"gid,omitempty"`
+	Shared  bool  `json:"shared,omitempty"` // usable by the members of GID
+
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Job         Job    `json:"job"`
+
+	CreatedAt string `json:"createdAt,omitempty"` // when this version was saved
+}
+
+// TemplateSubmission struct, a job to submit out of a template, with the given fields overridden
+type TemplateSubmission struct {
+	TID     int64 `json:"tid"`
+	Version int   `json:"version,omitempty"` // the latest one by default
+	UID     int   `json:"uid"`
+	GID     int   `json:"gid,omitempty"`
+
+	Overrides Job `json:"overrides"`
+}
+
+// Validate method checks the template's name and that it describes something to run
+func (t *JobTemplate) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("template must have a name")
+	}
+	if !IsValidUTF8String(t.Name) || len(t.Name) > 64 {
+		return errors.New("template name must be at most 64 valid characters")
+	}
+	if strings.TrimSpace(t.Job.Logic) == "" {
+		return errors.New("template must provide logic")
+	}
+
+	return nil
+}
+
+// Usable method tells if the given AccessClaim may read the template and submit jobs out of it:
+// its owner, root, or a member of its group if the template is shared
+func (t *JobTemplate) Usable(ac AccessClaim) bool {
+	uid := strings.TrimSpace(ac.UID)
+	if uid == "0" || uid == strconv.Itoa(t.UID) {
+		return true
+	}
+	if !t.Shared {
+		return false
+	}
+	for _, gid := range strings.Split(ac.Gids, ",") {
+		if strings.TrimSpace(gid) == strconv.Itoa(t.GID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Instantiate method returns the job of the template with the set fields of overrides applied
+/*
+strings and numbers override when non empty/zero, inputs, params and retry replace the template's,
+env values are merged (overriding per key), noCache can only be turned on.
+*/
+func (t *JobTemplate) Instantiate(overrides Job) Job {
+	job := t.Job
+	str := func(dst *string, v string) {
+		if strings.TrimSpace(v) != "" {
+			*dst = v
+		}
+	}
+	num := func(dst *int, v int) {
+		if v != 0 {
+			*dst = v
+		}
+	}
+
+	str(&job.Description, overrides.Description)
+	str(&job.Output, overrides.Output)
+	str(&job.Logic, overrides.Logic)
+	str(&job.LogicBody, overrides.LogicBody)
+	str(&job.LogicHeaders, overrides.LogicHeaders)
+	str(&job.InputFormat, overrides.InputFormat)
+	str(&job.OutputFormat, overrides.OutputFormat)
+	str(&job.MemoryRequest, overrides.MemoryRequest)
+	str(&job.CPURequest, overrides.CPURequest)
+	str(&job.MemoryLimit, overrides.MemoryLimit)
+	str(&job.CPULimit, overrides.CPULimit)
+	str(&job.EphimeralStorageRequest, overrides.EphimeralStorageRequest)
+	str(&job.EphimeralStorageLimit, overrides.EphimeralStorageLimit)
+	num(&job.Parallelism, overrides.Parallelism)
+	num(&job.Priority, overrides.Priority)
+	num(&job.Timeout, overrides.Timeout)
+
+	if inputs := overrides.InputList(); len(inputs) > 0 {
+		job.Inputs = inputs
+		job.Input = strings.Join(inputs, ",")
+	}
+	if len(overrides.Params) > 0 {
+		job.Params = overrides.Params
+	}
+	if overrides.Retry != nil {
+		job.Retry = overrides.Retry
+	}
+	job.NoCache = job.NoCache || overrides.NoCache
+	job.Interactive = job.Interactive || overrides.Interactive
+	if len(overrides.Webhooks) > 0 {
+		job.Webhooks = overrides.Webhooks
+	}
+
+	if len(overrides.Env) > 0 {
+		env := make(map[string]string, len(t.Job.Env)+len(overrides.Env))
+		for k, v := range t.Job.Env {
+			env[k] = v
+		}
+		for k, v := range overrides.Env {
+			env[k] = v
+		}
+		job.Env = env
+	}
+
+	job.TemplateID, job.TemplateVersion = t.TID, t.Version
+	if job.Description == "" {
+		job.Description = fmt.Sprintf("template %s v%d", t.Name, t.Version)
+	}
+
+	return job
 }
 
 // APIResponse aims to unite the type of responses of microservices , bricking the "Response Model"
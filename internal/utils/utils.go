@@ -26,6 +26,9 @@
 // Slices:
 //   - Contains
 //
+// Resource Quantities:
+//   - ParseCPUQuantity, ParseMemoryQuantity: Parse job cpu/memory limits.
+//
 // Validation Helpers:
 //   - HasInvalidCharacters: Checks for invalid characters in a string.
 //   - IsNumeric, IsAlphanumeric, IsAlphanumericPlus: Validates string content.
@@ -405,6 +408,13 @@ func IsValidPath(s string) bool {
 	return re.MatchString(s)
 }
 
+// IsValidPattern function checks if the given string is a valid path, glob characters (*?[]) allowed
+func IsValidPattern(s string) bool {
+	re := regexp.MustCompile(`^[a-zA-Z0-9._\-/*?\[\]]+$`)
+
+	return re.MatchString(s)
+}
+
 // IsAlphanumeric function checks if the given string matches the regex of numericals and letter characters
 func IsAlphanumeric(s string) bool {
 	re := regexp.MustCompile(`^[a-zA-Z0-9]+$`)
@@ -595,6 +605,54 @@ func parseCPU(s string) (float64, error) {
 	return strconv.ParseFloat(s, 64)
 }
 
+// ParseCPUQuantity parses a cpu amount given in cores ("0.5") or millicores ("500m"), 0 if empty
+func ParseCPUQuantity(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	divisor := 1.0
+	if m, found := strings.CutSuffix(s, "m"); found {
+		s, divisor = m, 1000
+	}
+	cores, err := strconv.ParseFloat(s, 64)
+	if err != nil || cores < 0 {
+		return 0, fmt.Errorf("invalid cpu quantity %q", s)
+	}
+
+	return cores / divisor, nil
+}
+
+// ParseMemoryQuantity parses a memory amount ("512Mi", "1Gi", "256M", plain bytes) into bytes, 0 if empty
+func ParseMemoryQuantity(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9},
+	}
+	factor := 1.0
+	for _, unit := range units {
+		if v, found := strings.CutSuffix(s, unit.suffix); found {
+			s, factor = v, unit.factor
+
+			break
+		}
+	}
+	// validation appends Mi to an empty amount
+	if s == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid memory quantity %q", s)
+	}
+
+	return int64(value * factor), nil
+}
+
 func GenerateRandomStringAll(length int) (string, error) {
 	byteLength := (length * 6 / 8) + 1 // because base64 encodes 6 bits per character
 	bytes := make([]byte, byteLength)
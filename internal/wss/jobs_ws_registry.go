@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -20,8 +21,9 @@ import (
 )
 
 var (
-	address    = "0.0.0.0:8082"
-	jobLogPath = "data/logs/jobs/"
+	address       = "0.0.0.0:8082"
+	jobLogPath    = "data/logs/jobs/"
+	serviceSecret []byte // shared with uspace, authenticates the executors and signs the attach tokens
 
 	// Registry maps jobIDs to their socket servers
 	registry = struct {
@@ -48,6 +50,8 @@ const (
 	Consumer Role = "consumer"
 	// JackOfAllTrades both
 	JackOfAllTrades Role = "jack"
+	// Stdin the executor of an interactive job, receives what its owner sends (service only)
+	Stdin Role = "stdin"
 )
 
 // Client represents a WebSocket connection
@@ -56,13 +60,17 @@ type Client struct {
 	Conn *websocket.Conn
 	Role Role
 	Send chan []byte
+
+	Owner bool // a producer holding an attach token, its messages are the input of the job
 }
 
 // SocketServer manages clients for a specific job
 type SocketServer struct {
 	Producers  map[*Client]bool
 	Consumers  map[*Client]bool
+	Stdins     map[*Client]bool
 	Broadcast  chan []byte
+	Input      chan []byte
 	Register   chan *Client
 	Unregister chan *Client
 	sync.Mutex
@@ -90,7 +98,9 @@ func NewSocketServer(jid string) *SocketServer {
 		Logger:     logger,
 		Producers:  make(map[*Client]bool),
 		Consumers:  make(map[*Client]bool),
+		Stdins:     make(map[*Client]bool),
 		Broadcast:  make(chan []byte),
+		Input:      make(chan []byte),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 	}
@@ -117,8 +127,14 @@ func (s *SocketServer) Start() {
 			s.Lock()
 			if client.Role == Producer {
 				s.Producers[client] = true
+				if client.Owner {
+					// gets the output of the job back
+					s.Consumers[client] = true
+				}
 			} else if client.Role == Consumer {
 				s.Consumers[client] = true
+			} else if client.Role == Stdin {
+				s.Stdins[client] = true
 			} else {
 				s.Producers[client] = true
 				s.Consumers[client] = true
@@ -128,8 +144,11 @@ func (s *SocketServer) Start() {
 			s.Lock()
 			if client.Role == Producer {
 				delete(s.Producers, client)
+				delete(s.Consumers, client)
 			} else if client.Role == Consumer {
 				delete(s.Consumers, client)
+			} else if client.Role == Stdin {
+				delete(s.Stdins, client)
 			} else {
 				delete(s.Producers, client)
 				delete(s.Consumers, client)
@@ -148,6 +167,17 @@ func (s *SocketServer) Start() {
 				}
 			}
 			s.Unlock()
+		case msg := <-s.Input:
+			s.Lock()
+			for stdin := range s.Stdins {
+				select {
+				case stdin.Send <- msg:
+				default:
+					// the job is not reading its input, drop it rather than blocking everyone
+					s.Logger.Printf("stdin full, input dropped")
+				}
+			}
+			s.Unlock()
 		}
 	}
 }
@@ -162,11 +192,32 @@ func HandleWSsession(c *gin.Context) {
 		return
 	}
 	role := Role(roleStr)
-	if role != Producer && role != Consumer && role != JackOfAllTrades {
+	if role != Producer && role != Consumer && role != JackOfAllTrades && role != Stdin {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
 
 		return
 	}
+	if role == Stdin && (len(serviceSecret) == 0 || c.GetHeader("X-Service-Secret") != string(serviceSecret)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "stdin is reserved to the executors"})
+
+		return
+	}
+	// only the owner of the job may feed it, as vouched for by uspace (see the job attach endpoint)
+	var owner bool
+	if token := c.Query("token"); token != "" {
+		jid, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || role != Producer {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a token is only given to a producer of a job"})
+
+			return
+		}
+		_, owner = ut.VerifyJobAttach(serviceSecret, jid, token, time.Now())
+		if !owner {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired token"})
+
+			return
+		}
+	}
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		return
@@ -176,6 +227,8 @@ func HandleWSsession(c *gin.Context) {
 		Conn: conn,
 		Role: role,
 		Send: make(chan []byte, 256),
+
+		Owner: owner,
 	}
 	server := getOrCreateServer(id)
 	server.Register <- client
@@ -206,6 +259,12 @@ func HandleWSsessionClose(c *gin.Context) {
 			delete(server.Producers, client)
 		}
 		for client := range server.Consumers {
+			if client.Owner {
+				// closed along with the producers
+				delete(server.Consumers, client)
+
+				continue
+			}
 			err := client.Conn.Close()
 			if err != nil {
 				log.Printf("failed to close connection: %v", err)
@@ -213,6 +272,14 @@ func HandleWSsessionClose(c *gin.Context) {
 			close(client.Send)
 			delete(server.Consumers, client)
 		}
+		for client := range server.Stdins {
+			err := client.Conn.Close()
+			if err != nil {
+				log.Printf("failed to close connection: %v", err)
+			}
+			close(client.Send)
+			delete(server.Stdins, client)
+		}
 		delete(registry.servers, jobID)
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "successfully deleted socket server"})
@@ -237,6 +304,12 @@ func broadcastMessages(client *Client, server *SocketServer) {
 			msg = []byte(fmt.Sprintf("[%s]: %s", client.Conn.RemoteAddr().String(), string(msg)))
 		}
 
+		if client.Owner {
+			server.Logger.Printf("owner input: %s", msg)
+			server.Input <- msg
+
+			continue
+		}
 		if client.Role == Producer || client.Role == JackOfAllTrades {
 			server.Logger.Printf("producer broadcasting: %s", msg)
 			server.Broadcast <- msg
@@ -262,6 +335,7 @@ func writeMessages(client *Client) {
 func Serve(cfg ut.EnvConfig) {
 	address = cfg.WssAddress
 	jobLogPath = cfg.WssLogsPath
+	serviceSecret = cfg.ServiceSecretKey
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -45,11 +45,14 @@ package fslite
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -309,7 +312,15 @@ func (fsl *FsLite) Insert(t any) (context.CancelFunc, error) {
 		}
 
 		if fsl.config.FslLocality {
-			outFile, err := os.Create(fsliteDataPath + "/" + resource.Vname + "/" + resource.Name)
+			dst := fsliteDataPath + "/" + resource.Vname + "/" + resource.Name
+			// objects may be named under a prefix, e.g. logs/job-1.log
+			err = os.MkdirAll(filepath.Dir(dst), 0o755)
+			if err != nil {
+				log.Printf("[FSL_insert] failed to create the dir of the output file")
+
+				return nil, err
+			}
+			outFile, err := os.Create(dst)
 			if err != nil {
 				log.Printf("[FSL_insert] failed to create a new output file (to save)")
 
@@ -427,7 +438,7 @@ func (fsl *FsLite) SelectObjects(how map[string]any) (any, error) {
 // Stat returns file information for a resource if locality is enabled.
 // Returns an error if locality is disabled or if the resource cannot be found.
 func (fsl *FsLite) Stat(t any) (any, error) {
-	if fsl.config.FslLocality {
+	if !fsl.config.FslLocality {
 		return nil, errors.New("cannot use stat if locality is turned off")
 	}
 	resource, ok := t.(ut.Resource)
@@ -440,6 +451,35 @@ func (fsl *FsLite) Stat(t any) (any, error) {
 	return os.Stat(fsliteDataPath + "/" + resource.Vname + "/" + resource.Name)
 }
 
+// Checksum returns the sha256 (hex encoded) of the content of a resource stored on disk.
+func (fsl *FsLite) Checksum(t any) (string, error) {
+	resource, ok := t.(ut.Resource)
+	if !ok {
+		log.Printf("[FSL_checksum] failed to cast to designated struct")
+
+		return "", errors.New("failed to cast to designated struct")
+	}
+
+	file, err := os.Open(fsliteDataPath + "/" + resource.Vname + "/" + resource.Name)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			log.Printf("failed to close the file: %v", err)
+		}
+	}()
+
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the resource: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // Remove deletes a resource (file/object) from the database and, if locality is enabled, from disk.
 func (fsl *FsLite) Remove(t any) error {
 	resource, ok := t.(ut.Resource)
@@ -536,19 +576,23 @@ func (fsl *FsLite) Update(t map[string]string) error {
 }
 
 // Download prepares a resource for download by opening the file and attaching a reader to the resource struct.
+// The resource is given either as a ut.Resource, replaced by the prepared one, or as a *ut.Resource, filled in place.
 // Returns an error if locality is disabled or if the file cannot be found.
 func (fsl *FsLite) Download(t *any) (context.CancelFunc, error) {
-	if fsl.config.FslLocality {
+	if !fsl.config.FslLocality {
 		return nil, errors.New("cannot download if locality is off")
 	}
-	v := *t
-	resource, ok := v.(ut.Resource)
-	if !ok {
+	var resourcePtr *ut.Resource
+	switch v := (*t).(type) {
+	case ut.Resource:
+		resourcePtr = &v
+	case *ut.Resource:
+		resourcePtr = v
+	default:
 		log.Printf("[FSL_download] failed to cast to ut.Resource")
 
 		return nil, errors.New("failed to cast to ut.Resource")
 	}
-	resourcePtr := &resource
 	db, err := fsl.dbh.GetConn()
 	if err != nil {
 		log.Printf("[FSL_download] failed to retrieve database connection: %v", err)
@@ -572,9 +616,11 @@ func (fsl *FsLite) Download(t *any) (context.CancelFunc, error) {
 	resourcePtr.Size = stat.Size()
 	resourcePtr.Reader = file
 
-	*t = *resourcePtr
+	if _, ok := (*t).(ut.Resource); ok {
+		*t = *resourcePtr
+	}
 
-	return nil, nil
+	return func() {}, nil
 }
 
 // Copy duplicates a resource (file/object) from a source to a destination, both in the database and on disk if locality is enabled.
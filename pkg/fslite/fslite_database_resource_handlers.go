@@ -422,7 +422,9 @@ func getResourcesByName(db *sql.DB, name string) ([]ut.Resource, error) {
 func getResourceByNameAndVolume(db *sql.DB, name, volume string) (ut.Resource, error) {
 	var resource ut.Resource
 
-	err := db.QueryRow("SELECT * FROM resources WHERE name = ? AND vname = ? LIMIT 1", name, volume).
+	// resources uploaded under a target are named after it, with a leading slash
+	name = strings.TrimPrefix(name, "/")
+	err := db.QueryRow("SELECT * FROM resources WHERE name IN (?, ?) AND vname = ? LIMIT 1", name, "/"+name, volume).
 		Scan(resource.PtrFields()...)
 	if err != nil {
 		log.Printf("[FSL_DB_getResByNameVol] error scanning resource: %v", err)
@@ -0,0 +1,41 @@
+package coding_test
+
+import (
+	"testing"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	// a friday
+	from := time.Date(2025, time.January, 10, 10, 30, 20, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2025, time.January, 10, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, time.January, 10, 10, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2025, time.January, 11, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, time.January, 11, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2025, time.January, 13, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, time.January, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		cron, err := ut.ParseCron(tt.expr)
+		assert.NoError(t, err)
+		assert.Equal(t, cron.Next(from), tt.next)
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *"} {
+		_, err := ut.ParseCron(expr)
+		assert.Error(t, err)
+	}
+}
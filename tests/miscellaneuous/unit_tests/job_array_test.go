@@ -0,0 +1,83 @@
+package coding_test
+
+import (
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestJobArrayInputs(t *testing.T) {
+	array := ut.JobArray{
+		Name:   "sweep",
+		Inputs: []string{"bucket/data/a.csv", "bucket/data/b.tar.gz"},
+		Job:    ut.Job{Logic: "python", Output: "bucket/out/{index}-{name}.csv"},
+	}
+	assert.NoError(t, array.Validate(10))
+
+	jobs, err := array.Expand()
+	assert.NoError(t, err)
+	assert.Equal(t, len(jobs), 2)
+	assert.DeepEqual(t, jobs[0].Inputs, []string{"bucket/data/a.csv"})
+	assert.Equal(t, jobs[0].Output, "bucket/out/0-a.csv")
+	assert.Equal(t, jobs[1].Output, "bucket/out/1-b.tar.csv")
+	assert.Equal(t, jobs[1].Env["ARRAY_INDEX"], "1")
+	assert.Equal(t, jobs[1].Description, "array sweep[1]")
+}
+
+func TestJobArrayParams(t *testing.T) {
+	array := ut.JobArray{
+		Params: map[string][]string{"lr": {"0.1", "0.01"}, "depth": {"2", "4", "8"}},
+		Job: ut.Job{
+			Input:  "bucket/train.csv",
+			Output: "bucket/models/{depth}-{lr}.bin",
+			Params: []string{"--seed=1"},
+		},
+	}
+	assert.NoError(t, array.Validate(6))
+	assert.Error(t, array.Validate(5))
+
+	jobs, err := array.Expand()
+	assert.NoError(t, err)
+	assert.Equal(t, len(jobs), 6)
+	// the last parameter (by name) varies the fastest
+	assert.Equal(t, jobs[0].Output, "bucket/models/2-0.1.bin")
+	assert.Equal(t, jobs[1].Output, "bucket/models/2-0.01.bin")
+	assert.Equal(t, jobs[5].Output, "bucket/models/8-0.01.bin")
+	assert.DeepEqual(t, jobs[5].Params, []string{"--seed=1", "depth=8", "lr=0.01"})
+	assert.Equal(t, jobs[5].Env["depth"], "8")
+	assert.Equal(t, jobs[5].Input, "bucket/train.csv")
+}
+
+func TestJobArrayRange(t *testing.T) {
+	array := ut.JobArray{
+		Range: &ut.ArrayRange{Start: 1, End: 9, Step: 4},
+		Job:   ut.Job{Input: "bucket/part-{index}.csv", Output: "bucket/out/{index}.csv"},
+	}
+	assert.NoError(t, array.Validate(10))
+
+	jobs, err := array.Expand()
+	assert.NoError(t, err)
+	assert.Equal(t, len(jobs), 3)
+	assert.Equal(t, array.Index(2), 9)
+	assert.Equal(t, jobs[2].Input, "bucket/part-9.csv")
+	assert.Equal(t, jobs[2].Output, "bucket/out/9.csv")
+}
+
+func TestJobArrayInvalid(t *testing.T) {
+	// no source, or more than one
+	assert.Error(t, (&ut.JobArray{}).Validate(10))
+	assert.Error(t, (&ut.JobArray{Inputs: []string{"a"}, Range: &ut.ArrayRange{End: 1}}).Validate(10))
+	// bad parameters and ranges
+	assert.Error(t, (&ut.JobArray{Params: map[string][]string{"a-b": {"1"}}}).Validate(10))
+	assert.Error(t, (&ut.JobArray{Params: map[string][]string{"index": {"1"}}}).Validate(10))
+	assert.Error(t, (&ut.JobArray{Params: map[string][]string{"a": {}}}).Validate(10))
+	assert.Error(t, (&ut.JobArray{Range: &ut.ArrayRange{Start: 2, End: 1}}).Validate(10))
+
+	// every child must write its own output
+	array := ut.JobArray{Range: &ut.ArrayRange{End: 1}, Job: ut.Job{Input: "bucket/in", Output: "bucket/out.csv"}}
+	assert.NoError(t, array.Validate(10))
+	_, err := array.Expand()
+	assert.Error(t, err)
+}
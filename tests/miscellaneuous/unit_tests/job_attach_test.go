@@ -0,0 +1,35 @@
+package coding_test
+
+import (
+	"testing"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestJobAttachToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	token := ut.SignJobAttach(secret, 42, 1000, now.Add(time.Minute))
+
+	uid, ok := ut.VerifyJobAttach(secret, 42, token, now)
+	assert.True(t, ok)
+	assert.Equal(t, uid, 1000)
+
+	// another job, another key, expired
+	_, ok = ut.VerifyJobAttach(secret, 43, token, now)
+	assert.False(t, ok)
+	_, ok = ut.VerifyJobAttach([]byte("other"), 42, token, now)
+	assert.False(t, ok)
+	_, ok = ut.VerifyJobAttach(secret, 42, token, now.Add(2*time.Minute))
+	assert.False(t, ok)
+
+	// the uid and expiry are signed
+	forged := "0" + token[len("1000"):]
+	_, ok = ut.VerifyJobAttach(secret, 42, forged, now)
+	assert.False(t, ok)
+	_, ok = ut.VerifyJobAttach(secret, 42, "garbage", now)
+	assert.False(t, ok)
+}
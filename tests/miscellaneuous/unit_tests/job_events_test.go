@@ -0,0 +1,42 @@
+package coding_test
+
+import (
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestJobTransitionAllowed(t *testing.T) {
+	allowed := [][2]string{
+		{"", "pending"},
+		{"pending", "queued"},
+		{"pending", "rejected"},
+		{"queued", "running"},
+		{"queued", "cached"},
+		{"running", "completed"},
+		{"running", "retrying"},
+		{"running", "queued"}, // lost, re-queued on recovery
+		{"retrying", "queued"},
+		{"canceled", "canceled"},
+	}
+	for _, tr := range allowed {
+		assert.True(t, ut.JobTransitionAllowed(tr[0], tr[1]))
+	}
+
+	illegal := [][2]string{
+		{"", "running"},
+		{"pending", "running"},
+		{"pending", "completed"},
+		{"queued", "completed"},
+		{"retrying", "running"},
+		{"completed", "running"},
+		{"canceled", "running"},
+		{"failed", "queued"},
+		{"rejected", "queued"},
+	}
+	for _, tr := range illegal {
+		assert.False(t, ut.JobTransitionAllowed(tr[0], tr[1]))
+	}
+}
@@ -0,0 +1,38 @@
+package coding_test
+
+import (
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestJobInputList(t *testing.T) {
+	job := ut.Job{Input: " bucket/a.csv, ,bucket/data/*.csv,bucket/dir/"}
+	assert.DeepEqual(t, job.InputList(), []string{"bucket/a.csv", "bucket/data/*.csv", "bucket/dir/"})
+
+	// a list takes precedence
+	job.Inputs = []string{"bucket/b.csv"}
+	assert.DeepEqual(t, job.InputList(), []string{"bucket/b.csv"})
+
+	assert.Equal(t, len((&ut.Job{}).InputList()), 0)
+}
+
+func TestJobValidateInputs(t *testing.T) {
+	job := ut.Job{
+		Logic:     "python",
+		LogicBody: "def run(data): return data",
+		Inputs:    []string{"bucket/a.csv", " bucket/data/*.csv "},
+		Output:    "bucket/out.csv",
+	}
+	assert.NoError(t, job.ValidateForm(1, 1024, 4, 4, 60, 1000))
+	assert.Equal(t, job.Input, "bucket/a.csv,bucket/data/*.csv")
+	assert.DeepEqual(t, job.Inputs, []string{"bucket/a.csv", "bucket/data/*.csv"})
+
+	job.Inputs = []string{"bucket/a b.csv"}
+	assert.Error(t, job.ValidateForm(1, 1024, 4, 4, 60, 1000))
+
+	job.Inputs, job.Input = nil, ""
+	assert.Error(t, job.ValidateForm(1, 1024, 4, 4, 60, 1000))
+}
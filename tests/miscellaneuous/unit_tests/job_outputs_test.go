@@ -0,0 +1,34 @@
+package coding_test
+
+import (
+	"strings"
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestJobOutputIsDir(t *testing.T) {
+	assert.True(t, (&ut.Job{Output: " bucket/plots/ "}).OutputIsDir())
+	assert.False(t, (&ut.Job{Output: "bucket/plots/a.png"}).OutputIsDir())
+	assert.False(t, (&ut.Job{}).OutputIsDir())
+}
+
+func TestJobValidateOutputDir(t *testing.T) {
+	job := ut.Job{
+		Logic:     "python",
+		LogicBody: "def run(data): return data",
+		Inputs:    []string{"bucket/a.csv"},
+		Output:    "bucket/plots/2024/",
+	}
+	assert.NoError(t, job.ValidateForm(1, 1024, 4, 4, 60, 1000))
+	assert.Equal(t, job.Output, "bucket/plots/2024/")
+	assert.True(t, job.OutputIsDir())
+
+	// the root of a volume still gets an object of a random name
+	job.Output = "bucket/"
+	assert.NoError(t, job.ValidateForm(1, 1024, 4, 4, 60, 1000))
+	assert.True(t, strings.HasPrefix(job.Output, "bucket/"))
+	assert.False(t, job.OutputIsDir())
+}
@@ -0,0 +1,60 @@
+package coding_test
+
+import (
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestJobTemplateInstantiate(t *testing.T) {
+	template := ut.JobTemplate{
+		TID:     3,
+		Version: 2,
+		Name:    "word-count",
+		Job: ut.Job{
+			Logic:     "python",
+			LogicBody: "print(1)",
+			Input:     "bucket/a.txt",
+			Output:    "bucket/out.txt",
+			Timeout:   5,
+			CPULimit:  "1",
+			Env:       map[string]string{"MODE": "fast", "LANG": "en"},
+		},
+	}
+
+	job := template.Instantiate(ut.Job{
+		Inputs:  []string{"bucket/b.txt", "bucket/c.txt"},
+		Timeout: 10,
+		Env:     map[string]string{"MODE": "slow"},
+	})
+	assert.Equal(t, job.Input, "bucket/b.txt,bucket/c.txt")
+	assert.Equal(t, job.Output, "bucket/out.txt")
+	assert.Equal(t, job.Timeout, 10)
+	assert.Equal(t, job.CPULimit, "1")
+	assert.Equal(t, job.LogicBody, "print(1)")
+	assert.DeepEqual(t, job.Env, map[string]string{"MODE": "slow", "LANG": "en"})
+	assert.Equal(t, job.TemplateID, int64(3))
+	assert.Equal(t, job.TemplateVersion, 2)
+	assert.Equal(t, job.Description, "template word-count v2")
+
+	// the template itself is left untouched
+	assert.Equal(t, template.Job.Env["MODE"], "fast")
+}
+
+func TestJobTemplateUsable(t *testing.T) {
+	template := ut.JobTemplate{UID: 1000, GID: 2000, Name: "t", Job: ut.Job{Logic: "python"}}
+	assert.NoError(t, template.Validate())
+
+	assert.True(t, template.Usable(ut.AccessClaim{UID: "1000", Gids: "1000"}))
+	assert.True(t, template.Usable(ut.AccessClaim{UID: "0", Gids: "0"}))
+	assert.False(t, template.Usable(ut.AccessClaim{UID: "1001", Gids: "1001,2000"}))
+
+	template.Shared = true
+	assert.True(t, template.Usable(ut.AccessClaim{UID: "1001", Gids: "1001,2000"}))
+	assert.False(t, template.Usable(ut.AccessClaim{UID: "1002", Gids: "1002"}))
+
+	assert.Error(t, (&ut.JobTemplate{Name: " ", Job: ut.Job{Logic: "python"}}).Validate())
+	assert.Error(t, (&ut.JobTemplate{Name: "t"}).Validate())
+}
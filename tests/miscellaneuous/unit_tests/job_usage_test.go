@@ -0,0 +1,36 @@
+package coding_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"kyri56xcaesar/kuspace/internal/uspace/docker"
+
+	"github.com/zeebo/assert"
+)
+
+func TestContainerStats(t *testing.T) {
+	sample := `{
+		"cpu_stats": {"cpu_usage": {"total_usage": 2500000000}},
+		"memory_stats": {"usage": 1048576, "max_usage": 4194304},
+		"blkio_stats": {"io_service_bytes_recursive": [
+			{"major": 8, "minor": 0, "op": "Read", "value": 4096},
+			{"major": 8, "minor": 0, "op": "Write", "value": 8192},
+			{"major": 8, "minor": 16, "op": "read", "value": 1024},
+			{"major": 8, "minor": 0, "op": "Total", "value": 13312}
+		]}
+	}`
+	var stats docker.ContainerStats
+	assert.NoError(t, json.Unmarshal([]byte(sample), &stats))
+	assert.Equal(t, stats.CPUStats.CPUUsage.TotalUsage, uint64(2500000000))
+	assert.Equal(t, stats.Memory(), uint64(4194304))
+
+	read, written := stats.IO()
+	assert.Equal(t, read, uint64(5120))
+	assert.Equal(t, written, uint64(8192))
+
+	// cgroup v2 reports no max_usage
+	var v2 docker.ContainerStats
+	assert.NoError(t, json.Unmarshal([]byte(`{"memory_stats": {"usage": 2048}}`), &v2))
+	assert.Equal(t, v2.Memory(), uint64(2048))
+}
@@ -0,0 +1,31 @@
+package coding_test
+
+import (
+	"testing"
+	"time"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var none *ut.RetryPolicy
+	assert.False(t, none.Retries(ut.FailureImage, 1))
+
+	policy := &ut.RetryPolicy{MaxAttempts: 3, Backoff: 10, MaxBackoff: 30}
+	// transient failures by default
+	assert.True(t, policy.Retries(ut.FailureImage, 1))
+	assert.True(t, policy.Retries(ut.FailureEvicted, 2))
+	assert.False(t, policy.Retries(ut.FailureEvicted, 3))
+	assert.False(t, policy.Retries(ut.FailureError, 1))
+
+	policy.On = []string{ut.FailureOOM}
+	assert.True(t, policy.Retries(ut.FailureOOM, 1))
+	assert.False(t, policy.Retries(ut.FailureImage, 1))
+
+	assert.Equal(t, policy.Delay(1), 10*time.Second)
+	assert.Equal(t, policy.Delay(2), 20*time.Second)
+	assert.Equal(t, policy.Delay(3), 30*time.Second)
+	assert.Equal(t, (&ut.RetryPolicy{Backoff: 1}).Delay(100), time.Hour)
+}
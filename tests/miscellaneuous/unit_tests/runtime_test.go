@@ -0,0 +1,58 @@
+package coding_test
+
+import (
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestRuntimeValidate(t *testing.T) {
+	r := ut.Runtime{Name: " Julia ", Aliases: []string{"JL"}, Image: "julia", Extension: ".jl", Command: "julia {{.Script}}"}
+	assert.NoError(t, r.Validate())
+	assert.Equal(t, r.Names(), []string{"julia", "jl"})
+	assert.Equal(t, r.ScriptName(), "script.jl")
+
+	// a registry port is not a tag
+	r.Image = "registry.local:5000/julia"
+	assert.NoError(t, r.Validate())
+
+	invalid := []ut.Runtime{
+		{Name: "", Image: "julia", Extension: "jl", Command: "julia {{.Script}}"},
+		{Name: "julia:1", Image: "julia", Extension: "jl", Command: "julia {{.Script}}"},
+		{Name: "julia", Image: "julia:1.10", Extension: "jl", Command: "julia {{.Script}}"},
+		{Name: "julia", Image: "julia", Extension: "", Command: "julia {{.Script}}"},
+		{Name: "julia", Image: "julia", Extension: "jl", Command: " "},
+		{Name: "julia", Image: "julia", Extension: "jl", Command: "julia {{.Script"},
+		{Name: "julia", Image: "julia", Extension: "jl", Command: "julia {{.Script}}", Skeleton: "{{.Missing}}"},
+	}
+	for _, r := range invalid {
+		assert.Error(t, r.Validate())
+	}
+}
+
+func TestRuntimeRender(t *testing.T) {
+	r := ut.Runtime{
+		Name:      "python",
+		Image:     "python",
+		Extension: "py",
+		Skeleton:  "{{.Logic}}\nfor path in [{{.Inputs}}]: pass\nopen('{{.Output}}', 'w')\n",
+		Command:   "python3 {{.Script}}",
+	}
+	code, command, err := r.Render(ut.RuntimeScript{
+		Logic:  "def run(data): return data",
+		Inputs: ut.QuotedPaths([]string{"/input/a.csv", "/input/b c.csv"}),
+		Output: "/output/out.csv",
+		Script: "/script.py",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, code, "def run(data): return data\nfor path in [\"/input/a.csv\", \"/input/b c.csv\"]: pass\nopen('/output/out.csv', 'w')\n")
+	assert.Equal(t, command, "python3 /script.py")
+
+	// without a skeleton the code is run as is
+	r.Skeleton = ""
+	code, _, err = r.Render(ut.RuntimeScript{Logic: "print(1)", Script: "/script.py"})
+	assert.NoError(t, err)
+	assert.Equal(t, code, "print(1)")
+}
@@ -0,0 +1,72 @@
+package coding_test
+
+import (
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	hook := ut.Webhook{URL: " https://example.com/hook ", Events: []string{"Completed", " failed"}}
+	assert.NoError(t, hook.Validate())
+	assert.Equal(t, hook.URL, "https://example.com/hook")
+	assert.DeepEqual(t, hook.Events, []string{"completed", "failed"})
+	assert.True(t, hook.Wants("failed"))
+	assert.False(t, hook.Wants("running"))
+
+	all := ut.Webhook{URL: "http://hooks.example.com:9000/"}
+	assert.NoError(t, all.Validate())
+	assert.True(t, all.Wants("queued"))
+
+	assert.Error(t, (&ut.Webhook{URL: "ftp://example.com"}).Validate())
+	assert.Error(t, (&ut.Webhook{URL: "/relative"}).Validate())
+	assert.Error(t, (&ut.Webhook{URL: "https://example.com", Events: []string{"retrying"}}).Validate())
+}
+
+func TestWebhookDestination(t *testing.T) {
+	for _, url := range []string{
+		"http://localhost:9000/", "http://api.localhost/", "http://127.0.0.1/", "http://[::1]:8080/",
+		"http://169.254.169.254/latest/meta-data/", "http://10.0.0.7/", "http://192.168.1.1/",
+		"http://100.100.100.200/", "http://0.0.0.0/", "http://[::ffff:127.0.0.1]/", "http://[fe80::1]/",
+	} {
+		assert.Error(t, (&ut.Webhook{URL: url}).Validate())
+	}
+	assert.NoError(t, (&ut.Webhook{URL: "https://93.184.216.34/hook"}).Validate())
+
+	// unless allowed by name, ip or cidr
+	assert.NoError(t, (&ut.Webhook{URL: "http://localhost:9000/"}).Validate("localhost"))
+	assert.NoError(t, (&ut.Webhook{URL: "http://10.0.0.7/"}).Validate("", " 10.0.0.0/8"))
+	assert.NoError(t, (&ut.Webhook{URL: "http://192.168.1.1/"}).Validate("192.168.1.1"))
+	assert.Error(t, (&ut.Webhook{URL: "http://192.168.1.2/"}).Validate("192.168.1.1"))
+
+	assert.True(t, ut.WebhookHostAllowed("Hooks.Internal.", []string{"hooks.internal"}))
+	assert.False(t, ut.WebhookHostAllowed("hooks.internal", []string{""}))
+}
+
+func TestWebhookEvent(t *testing.T) {
+	for status, want := range map[string]string{
+		"queued":    "queued",
+		"running":   "running",
+		"completed": "completed",
+		"cached":    "completed",
+		"failed":    "failed",
+		"timeout":   "failed",
+		"canceled":  "canceled",
+	} {
+		event, ok := ut.WebhookEvent(status)
+		assert.True(t, ok)
+		assert.Equal(t, event, want)
+	}
+	for _, status := range []string{"pending", "retrying", "rejected"} {
+		_, ok := ut.WebhookEvent(status)
+		assert.False(t, ok)
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// HMAC-SHA256 test case 2 of RFC 4231
+	assert.Equal(t, ut.SignWebhook([]byte("Jefe"), []byte("what do ya want for nothing?")),
+		"sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843")
+}
@@ -0,0 +1,59 @@
+package coding_test
+
+import (
+	"testing"
+
+	ut "kyri56xcaesar/kuspace/internal/utils"
+
+	"github.com/zeebo/assert"
+)
+
+func TestWorkflowValidate(t *testing.T) {
+	w := ut.Workflow{
+		Name: "pipeline",
+		Nodes: []ut.WorkflowNode{
+			{Name: "extract", Job: ut.Job{Input: "bucket/raw.txt", Output: "bucket/extracted.txt"}},
+			{Name: "transform", Job: ut.Job{Input: "${extract.output}", Output: "bucket/transformed.txt"}},
+			{Name: "load", DependsOn: []string{"extract"}, Job: ut.Job{Input: "${transform.output}"}},
+		},
+	}
+	assert.NoError(t, w.Validate())
+	assert.DeepEqual(t, w.Nodes[2].Upstream(), []string{"extract", "transform"})
+
+	w.Nodes[0].DependsOn = []string{"load"}
+	assert.Error(t, w.Validate())
+
+	w.Nodes[0].DependsOn = []string{"missing"}
+	assert.Error(t, w.Validate())
+
+	w.Nodes[0].DependsOn = nil
+	w.Nodes[1].Name = "extract"
+	assert.Error(t, w.Validate())
+
+	assert.Error(t, (&ut.Workflow{Name: "empty"}).Validate())
+}
+
+func TestWorkflowResolveInputs(t *testing.T) {
+	node := ut.WorkflowNode{Name: "merge", Job: ut.Job{Input: "${a.output},${b.output}"}}
+
+	assert.DeepEqual(t, node.References(), []string{"a", "b"})
+	assert.DeepEqual(t, node.ResolveInputs(map[string]string{
+		"a": "bucket/a.out",
+		"b": "bucket/b.out",
+	}), []string{"bucket/a.out", "bucket/b.out"})
+
+	// every input is resolved on its own, the list is kept as is
+	node.Job = ut.Job{Inputs: []string{"${a.output}", "bucket/lookup.csv", "${b.output}*.csv"}}
+	assert.DeepEqual(t, node.ResolveInputs(map[string]string{
+		"a": "bucket/plots/",
+		"b": "bucket/parts/",
+	}), []string{"bucket/plots/", "bucket/lookup.csv", "bucket/parts/*.csv"})
+	assert.DeepEqual(t, node.Job.Inputs, []string{"${a.output}", "bucket/lookup.csv", "${b.output}*.csv"})
+}
+
+func TestWorkflowStaticInputs(t *testing.T) {
+	node := ut.WorkflowNode{Name: "merge", Job: ut.Job{Inputs: []string{"${a.output}", "bucket/lookup.csv"}}}
+
+	assert.DeepEqual(t, node.StaticInputs(), []string{"bucket/lookup.csv"})
+	assert.Equal(t, len((&ut.WorkflowNode{Job: ut.Job{Input: "${a.output}"}}).StaticInputs()), 0)
+}
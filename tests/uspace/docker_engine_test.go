@@ -0,0 +1,255 @@
+package uspace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+
+	"kyri56xcaesar/kuspace/internal/uspace/docker"
+)
+
+// stubEngine mimics the parts of the docker Engine API used by the docker executor
+type stubEngine struct {
+	mu         sync.Mutex
+	images     map[string]bool
+	containers map[string]*stubContainer
+	pulls      []string
+}
+
+type stubContainer struct {
+	config docker.ContainerConfig
+	exit   int
+}
+
+func frame(stream byte, s string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(s)))
+
+	return append(header, s...)
+}
+
+func (e *stubEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": msg})
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+docker.APIVersion)
+	if path == "/images/create" {
+		image := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+		e.pulls = append(e.pulls, image)
+		if strings.HasPrefix(image, "missing") {
+			_, _ = w.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"error":"manifest unknown"}` + "\n"))
+
+			return
+		}
+		e.images[image] = true
+		_, _ = w.Write([]byte(`{"status":"Downloaded newer image"}` + "\n"))
+
+		return
+	}
+	if path == "/containers/create" {
+		name := r.URL.Query().Get("name")
+		var config docker.ContainerConfig
+		_ = json.NewDecoder(r.Body).Decode(&config)
+		if !e.images[config.Image] {
+			fail(http.StatusNotFound, "No such image: "+config.Image)
+
+			return
+		}
+		if _, ok := e.containers[name]; ok {
+			fail(http.StatusConflict, "name already in use")
+
+			return
+		}
+		e.containers[name] = &stubContainer{config: config}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"Id": name})
+
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "/containers/"), "/")
+	c, ok := e.containers[parts[0]]
+	if !ok {
+		fail(http.StatusNotFound, "No such container: "+parts[0])
+
+		return
+	}
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch {
+	case r.Method == http.MethodDelete:
+		delete(e.containers, parts[0])
+		w.WriteHeader(http.StatusNoContent)
+	case action == "start":
+		// an "exit" command fails with code 3
+		if len(c.config.Cmd) > 0 && c.config.Cmd[0] == "exit" {
+			c.exit = 3
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "kill":
+		// containers of the stub exit as soon as they start
+		fail(http.StatusConflict, "container is not running")
+	case action == "wait":
+		_ = json.NewEncoder(w).Encode(map[string]any{"StatusCode": c.exit})
+	case action == "json":
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"State": map[string]any{"Status": "exited", "ExitCode": c.exit, "OOMKilled": c.config.HostConfig.Memory == 1},
+		})
+	case action == "logs":
+		_, _ = w.Write(frame(1, "hello\n"))
+		_, _ = w.Write(frame(2, "oops\n"))
+		_, _ = w.Write(frame(1, "world\n"))
+	default:
+		fail(http.StatusNotFound, "page not found")
+	}
+}
+
+// newStubEngine serves a stub engine on a unix socket, returns the host to reach it
+func newStubEngine(t *testing.T) (*stubEngine, string) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+
+	engine := &stubEngine{
+		images:     map[string]bool{"python:latest": true},
+		containers: map[string]*stubContainer{},
+	}
+	srv := httptest.NewUnstartedServer(engine)
+	_ = srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return engine, "unix://" + socket
+}
+
+func TestDockerEngineLifecycle(t *testing.T) {
+	engine, host := newStubEngine(t)
+	client, err := docker.NewClient(host)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	config := docker.ContainerConfig{
+		Image: "python:latest",
+		Cmd:   []string{"python", "/script.py"},
+		HostConfig: docker.HostConfig{
+			Binds:      []string{"/tmp/in:/input/in"},
+			NanoCPUs:   500000000,
+			Memory:     64 << 20,
+			MemorySwap: 64 << 20,
+		},
+	}
+	id, err := client.CreateContainer(ctx, "uspace-job-1", config)
+	assert.NoError(t, err)
+	assert.Equal(t, id, "uspace-job-1")
+	assert.DeepEqual(t, engine.containers[id].config, config)
+
+	// the name is taken
+	_, err = client.CreateContainer(ctx, "uspace-job-1", config)
+	assert.True(t, errors.Is(err, docker.ErrConflict))
+
+	assert.NoError(t, client.StartContainer(ctx, id))
+	code, err := client.WaitContainer(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, code, 0)
+
+	state, err := client.InspectContainer(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, state.Status, "exited")
+	assert.False(t, state.OOMKilled)
+
+	// not running anymore
+	err = client.KillContainer(ctx, id)
+	assert.True(t, errors.Is(err, docker.ErrConflict))
+
+	logs, err := client.ContainerLogs(ctx, id, true, time.Time{})
+	assert.NoError(t, err)
+	var stdout, stderr bytes.Buffer
+	assert.NoError(t, docker.Demux(logs, &stdout, &stderr))
+	assert.NoError(t, logs.Close())
+	assert.Equal(t, stdout.String(), "hello\nworld\n")
+	assert.Equal(t, stderr.String(), "oops\n")
+
+	assert.NoError(t, client.RemoveContainer(ctx, id))
+	// removing it again is not an error
+	assert.NoError(t, client.RemoveContainer(ctx, id))
+	_, err = client.InspectContainer(ctx, id)
+	assert.True(t, errors.Is(err, docker.ErrNotFound))
+}
+
+func TestDockerEngineExitCodes(t *testing.T) {
+	_, host := newStubEngine(t)
+	client, err := docker.NewClient(host)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	id, err := client.CreateContainer(ctx, "uspace-job-2", docker.ContainerConfig{
+		Image:      "python:latest",
+		Cmd:        []string{"exit"},
+		HostConfig: docker.HostConfig{Memory: 1},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, client.StartContainer(ctx, id))
+	code, err := client.WaitContainer(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, code, 3)
+
+	state, err := client.InspectContainer(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, state.ExitCode, 3)
+	assert.True(t, state.OOMKilled)
+}
+
+func TestDockerEnginePullImage(t *testing.T) {
+	engine, host := newStubEngine(t)
+	client, err := docker.NewClient(host)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	config := docker.ContainerConfig{Image: "node:20"}
+	_, err = client.CreateContainer(ctx, "uspace-job-3", config)
+	assert.True(t, errors.Is(err, docker.ErrNotFound))
+
+	assert.NoError(t, client.PullImage(ctx, "node:20"))
+	_, err = client.CreateContainer(ctx, "uspace-job-3", config)
+	assert.NoError(t, err)
+
+	// failures are reported within the stream
+	err = client.PullImage(ctx, "missing")
+	assert.Error(t, err)
+	assert.DeepEqual(t, engine.pulls, []string{"node:20", "missing:latest"})
+}
+
+func TestDockerDemux(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stream := append(frame(2, "err"), frame(1, "out")...)
+	assert.NoError(t, docker.Demux(bytes.NewReader(stream), &stdout, &stderr))
+	assert.Equal(t, stdout.String(), "out")
+	assert.Equal(t, stderr.String(), "err")
+
+	// truncated frame
+	err := docker.Demux(bytes.NewReader(frame(1, "out")[:10]), io.Discard, io.Discard)
+	assert.Error(t, err)
+}
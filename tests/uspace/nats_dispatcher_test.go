@@ -0,0 +1,219 @@
+package uspace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/zeebo/assert"
+
+	"kyri56xcaesar/kuspace/internal/uspace"
+	ut "kyri56xcaesar/kuspace/internal/utils"
+)
+
+const (
+	testServiceSecret = "nats-test-service-secret"
+	testAccessTarget  = "0:default:/ 0:0"
+	testNatsStream    = "KUSPACE_JOBS"
+	testNatsConsumer  = "kuspace-workers"
+)
+
+// newTestNatsService starts a uspace service dispatching over an embedded nats server,
+// executing one job at a time in the sandbox
+func newTestNatsService(t *testing.T) (*gin.Engine, string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, l.Close())
+	natsURL := fmt.Sprintf("nats://127.0.0.1:%d", port)
+
+	for key, value := range map[string]string{
+		"API_GIN_MODE":               "release",
+		"JWT_SECRET_KEY":             "nats-test-jwt-secret",
+		"SERVICE_SECRET_KEY":         testServiceSecret,
+		"STORAGE_SYSTEM":             "local",
+		"LOCAL_VOLUMES_DEFAULT_PATH": "volumes",
+		"MINIO_DEFAULT_BUCKET":       "default",
+		"DB_FSL_PATH":                filepath.Join(dir, "db") + "/",
+		"DB_JOBS_PATH":               filepath.Join(dir, "db") + "/",
+		"J_DISPATCHER":               "nats",
+		"J_NATS_EMBEDDED":            "true",
+		"J_NATS_URL":                 natsURL,
+		"J_NATS_STORE_PATH":          filepath.Join(dir, "nats"),
+		"J_NATS_ACK_WAIT":            "2",
+		"J_EXECUTOR":                 "sandbox",
+		"J_SANDBOX_PATH":             filepath.Join(dir, "sandbox"),
+		"J_SANDBOX_CGROUP":           filepath.Join(dir, "cgroup"),
+		"J_MAX_WORKERS":              "1",
+		"J_LOGS_PATH":                filepath.Join(dir, "logs") + "/",
+		"J_WS_ADDRESS":               "127.0.0.1:1",
+		"J_CACHE":                    "false",
+	} {
+		t.Setenv(key, value)
+	}
+
+	gin.SetMode(gin.TestMode)
+	srv := uspace.NewUService(filepath.Join(dir, "uspace.conf"))
+	srv.RegisterRoutes()
+
+	return srv.Engine, natsURL
+}
+
+func serveRequest(t *testing.T, router *gin.Engine, method, target string, body *bytes.Buffer, contentType string) (int, map[string]any) {
+	t.Helper()
+	if body == nil {
+		body = &bytes.Buffer{}
+	}
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("X-Service-Secret", testServiceSecret)
+	req.Header.Set("Access-Target", testAccessTarget)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp map[string]any
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	return w.Code, resp
+}
+
+func uploadTestInput(t *testing.T, router *gin.Engine, name, content string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("files", name)
+	assert.NoError(t, err)
+	_, err = fw.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, mw.Close())
+
+	code, resp := serveRequest(t, router, http.MethodPost, "/api/v1/resource/upload", body, mw.FormDataContentType())
+	assert.Equal(t, code, http.StatusOK)
+	assert.Nil(t, resp["error"])
+}
+
+// submitTestJob submits a perl job sleeping for the given seconds before writing its output
+func submitTestJob(t *testing.T, router *gin.Engine, seconds int) int {
+	t.Helper()
+	job := ut.Job{
+		UID:       0,
+		Input:     "default/",
+		Output:    fmt.Sprintf("default/out-%d.txt", seconds),
+		Logic:     "perl",
+		LogicBody: fmt.Sprintf("sleep %d; open(my $f, '>', 'output/out-%d.txt') or die; print $f \"done\\n\"; close($f);", seconds, seconds),
+	}
+	data, err := json.Marshal(job)
+	assert.NoError(t, err)
+
+	code, resp := serveRequest(t, router, http.MethodPost, "/api/v1/job", bytes.NewBuffer(data), "application/json")
+	assert.Equal(t, code, http.StatusOK)
+	jid, ok := resp["jid"].(float64)
+	assert.True(t, ok)
+
+	return int(jid)
+}
+
+func testJobStatus(t *testing.T, router *gin.Engine, jid int) string {
+	t.Helper()
+	code, resp := serveRequest(t, router, http.MethodGet, "/api/v1/job?jids="+strconv.Itoa(jid), nil, "")
+	assert.Equal(t, code, http.StatusOK)
+	content, _ := resp["content"].(map[string]any)
+	status, _ := content["status"].(string)
+
+	return status
+}
+
+func waitForJobStatus(t *testing.T, router *gin.Engine, jid int, status string) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		current := testJobStatus(t, router, jid)
+		if current == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for job %d to be %s, it is %s", jid, status, current)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func waitForStreamMessages(t *testing.T, stream jetstream.Stream, msgs uint64) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info, err := stream.Info(context.Background())
+		assert.NoError(t, err)
+		if info.State.Msgs == msgs {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d messages in the stream, it holds %d", msgs, info.State.Msgs)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestNatsDispatcher(t *testing.T) {
+	router, natsURL := newTestNatsService(t)
+	uploadTestInput(t, router, "in.txt", "input\n")
+
+	conn, err := nats.Connect(natsURL)
+	assert.NoError(t, err)
+	defer conn.Close()
+	js, err := jetstream.New(conn)
+	assert.NoError(t, err)
+	stream, err := js.Stream(context.Background(), testNatsStream)
+	assert.NoError(t, err)
+
+	// publish -> consume -> execute, a job running past the ack wait is kept alive by the heartbeat
+	jid := submitTestJob(t, router, 5)
+	waitForJobStatus(t, router, jid, "running")
+	waitForJobStatus(t, router, jid, "completed")
+
+	// acknowledged once finished, never redelivered
+	waitForStreamMessages(t, stream, 0)
+	consumer, err := stream.Consumer(context.Background(), testNatsConsumer)
+	assert.NoError(t, err)
+	info, err := consumer.Info(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, info.NumRedelivered, 0)
+	assert.Equal(t, info.NumAckPending, 0)
+
+	// the only worker is taken, the next job waits in the stream
+	running := submitTestJob(t, router, 60)
+	waitForJobStatus(t, router, running, "running")
+	waiting := submitTestJob(t, router, 1)
+	waitForStreamMessages(t, stream, 2)
+	assert.Equal(t, testJobStatus(t, router, waiting), "queued")
+
+	// a job still in the stream is deleted from it
+	code, _ := serveRequest(t, router, http.MethodDelete, "/api/v1/job?jid="+strconv.Itoa(waiting), nil, "")
+	assert.Equal(t, code, http.StatusOK)
+	waitForStreamMessages(t, stream, 1)
+	assert.Equal(t, testJobStatus(t, router, waiting), "canceled")
+
+	// a running job is canceled by the instance holding it, through the broadcast
+	code, _ = serveRequest(t, router, http.MethodDelete, "/api/v1/job?jid="+strconv.Itoa(running), nil, "")
+	assert.Equal(t, code, http.StatusOK)
+	waitForJobStatus(t, router, running, "canceled")
+	waitForStreamMessages(t, stream, 0)
+}
@@ -244,7 +244,7 @@ document.addEventListener("DOMContentLoaded", () => {
           button.textContent = resourceName;
           button.type = "button";
           button.addEventListener("click", () => {
-            job_input.value = resourceName;
+            addJobInput(job_input, resourceName);
             document.getElementById("select-resource-btn-job").parentNode.querySelector(".modal").classList.add("hidden");
           });
           resourceList.appendChild(button);
@@ -264,7 +264,7 @@ document.addEventListener("DOMContentLoaded", () => {
         button.textContent = resourceName;
         button.type = "button";
         button.addEventListener("click", () => {
-          job_input.value = resourceName;
+          addJobInput(job_input, resourceName);
           document.getElementById("select-resource-btn-job").parentNode.querySelector(".modal").classList.add("hidden");
         });
         resourceList.appendChild(button);
@@ -750,6 +750,15 @@ function setupSearchBar(jobSearchDiv, cacheJobResults) {
   });
 }
 
+// a job takes several inputs, comma separated: every selected resource is added to the list
+function addJobInput(jobInput, resourceName) {
+  const inputs = jobInput.value.split(",").map((i) => i.trim()).filter((i) => i !== "");
+  if (!inputs.includes(resourceName)) {
+    inputs.push(resourceName);
+  }
+  jobInput.value = inputs.join(",");
+}
+
 function modJobModal(div, parentDiv) {
   if (!div || !parentDiv) {
     return
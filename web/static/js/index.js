@@ -546,10 +546,43 @@ document.addEventListener('htmx:beforeRequest', function(event) {
     event.preventDefault();
     document.getElementById("generated-hash").innerText = '';
   } else if (triggeringElement.id === 'job-create-form') {
-    
+    // the job is dry-run first, submitted for real once found valid
+    if (triggeringElement.dataset.validated === 'true') {
+      delete triggeringElement.dataset.validated;
+      return;
+    }
+    event.preventDefault();
+    validateJob(triggeringElement, event.detail.requestConfig);
   }
 });
 
+// validateJob dry-runs the job of the submission form, submitting it only if no problems were found
+function validateJob(form, requestConfig) {
+  const feedback = form.querySelector('.feedback');
+  const button = form.querySelector('button[type="submit"]');
+  const body = new URLSearchParams(requestConfig?.formData || new FormData(form));
+
+  fetch('/api/v1/verified/jobs/validate', { method: 'POST', body: body })
+    .then(response => response.json())
+    .then(data => {
+      if (data.valid) {
+        form.dataset.validated = 'true';
+        htmx.trigger(form, 'submit');
+        return;
+      }
+      const problems = data.problems || [{ field: 'job', message: data.error || 'failed to validate the job' }];
+      feedback.textContent = problems.map(p => p.field + ': ' + p.message).join('; ');
+      button.classList.add('error-highlight');
+      setTimeout(() => {
+        button.classList.remove('error-highlight');
+        feedback.textContent = '';
+      }, 8000);
+    })
+    .catch(err => {
+      console.error('failed to validate the job: ', err);
+    });
+}
+
 document.addEventListener('htmx:afterRequest', function (event) {
   const triggeringElement = event.detail.elt;
 